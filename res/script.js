@@ -44,3 +44,31 @@ document.addEventListener("click", function (event) {
     }
   }
 }, false);
+
+// Subscribe this device to push notifications, using the VAPID public key given
+// by the form's data-key attribute.
+async function enablePush(event, form) {
+  event.preventDefault();
+  if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+    alert("This browser does not support push notifications.");
+    return;
+  }
+  try {
+    let reg = await navigator.serviceWorker.register("/assets/sw.js", { scope: "/" });
+    let sub = await reg.pushManager.subscribe({
+      userVisibleOnly: true,
+      applicationServerKey: form.dataset.key,
+    });
+    let resp = await fetch("/settings/push", {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify(sub),
+    });
+    if (!resp.ok) {
+      throw new Error("server responded with status " + resp.status);
+    }
+    location.reload();
+  } catch (err) {
+    alert("Cannot enable push notifications: " + err.message);
+  }
+}
@@ -0,0 +1,30 @@
+// Service worker which shows TaskCollect push notifications. Each push message
+// is a JSON notification with a title, body and path.
+
+self.addEventListener("push", function (event) {
+  if (!event.data) {
+    return;
+  }
+  let n = event.data.json();
+  event.waitUntil(self.registration.showNotification(n.title, {
+    body: n.body,
+    icon: "/assets/icons/icon-192.png",
+    tag: n.path,
+    data: { path: n.path || "/" },
+  }));
+});
+
+// Open the page of a notification when it is clicked, reusing an open tab if
+// there is one.
+self.addEventListener("notificationclick", function (event) {
+  event.notification.close();
+  let url = new URL(event.notification.data.path, self.location.origin).href;
+  event.waitUntil(clients.matchAll({ type: "window" }).then(function (windows) {
+    for (let w of windows) {
+      if (w.url == url && "focus" in w) {
+        return w.focus();
+      }
+    }
+    return clients.openWindow(url);
+  }));
+});
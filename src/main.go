@@ -9,12 +9,6 @@ import (
 
 var wflag bool
 
-var schools = []string{
-	"example",
-	"gihs",
-	"uofa",
-}
-
 func init() {
 	flag.BoolVar(&wflag, "w", false, "run without TLS, on port 8080")
 }
@@ -22,7 +16,6 @@ func init() {
 func main() {
 	flag.Parse()
 	server.Announce(version)
-	server.Enrol(schools)
 	err := server.Configure()
 	if err != nil {
 		logger.Fatal(err)
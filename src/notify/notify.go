@@ -0,0 +1,57 @@
+// Package notify delivers notifications to TaskCollect users through
+// pluggable transports: email over SMTP, Web Push, and outgoing HTTP webhooks.
+package notify
+
+import (
+	"context"
+	"time"
+)
+
+// Notification is a message about an event concerning a user, such as a new
+// task or a returned grade.
+type Notification struct {
+	// the type of event, such as "new-task" or "due"
+	Event string `json:"event"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	// the path of the page showing the subject of the notification, if any
+	Path string    `json:"path,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// Subscription is a Web Push subscription of a browser, in the form given by
+// the PushSubscription.toJSON method of the Push API.
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		// the subscriber's P-256 public key, base64url encoded
+		P256dh string `json:"p256dh"`
+		// the subscriber's authentication secret, base64url encoded
+		Auth string `json:"auth"`
+	} `json:"keys"`
+}
+
+// Hook is an outgoing HTTP webhook.
+type Hook struct {
+	URL string
+	// if set, used to sign request bodies
+	Secret string
+}
+
+// Recipient holds the destinations to which notifications for a user are
+// delivered. Each transport only delivers to its own kind of destination.
+type Recipient struct {
+	Email         string
+	Subscriptions []Subscription
+	Hooks         []Hook
+}
+
+// Transport delivers notifications to one kind of destination. A transport
+// whose destination is missing from a recipient does nothing.
+type Transport interface {
+	// Name returns the name by which users choose the transport, such as
+	// "email".
+	Name() string
+	// Send delivers n to the recipient.
+	Send(ctx context.Context, to Recipient, n Notification) error
+}
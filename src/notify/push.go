@@ -0,0 +1,394 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	path "path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// recordSize is the record size of encrypted push messages. Each message is
+// sent as a single record, which limits the size of its payload.
+const recordSize = 4096
+
+// DefaultPushTTL is the time for which push services keep undelivered
+// notifications, unless set otherwise.
+const DefaultPushTTL = 24 * time.Hour
+
+// VAPID is a key pair with which an application server identifies itself to
+// push services, as described in RFC 8292.
+type VAPID struct {
+	key *ecdsa.PrivateKey
+}
+
+// GenerateVAPID returns a new random VAPID key pair.
+func GenerateVAPID() (*VAPID, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.New(err, "cannot generate VAPID key")
+	}
+	return &VAPID{key}, nil
+}
+
+// LoadVAPID loads a PEM encoded VAPID private key from the file at the given
+// path. If the file does not exist, a new key pair is generated and written to
+// it.
+func LoadVAPID(keypath string) (*VAPID, error) {
+	data, err := os.ReadFile(keypath)
+	if os.IsNotExist(err) {
+		v, err := GenerateVAPID()
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		der, err := x509.MarshalECPrivateKey(v.key)
+		if err != nil {
+			return nil, errors.New(err, "cannot marshal VAPID key")
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		err = os.MkdirAll(path.Dir(keypath), os.ModePerm)
+		if err != nil {
+			return nil, errors.New(err, "cannot create VAPID key directory")
+		}
+		err = os.WriteFile(keypath, data, 0600)
+		if err != nil {
+			return nil, errors.New(err, "cannot write VAPID key")
+		}
+		return v, nil
+	} else if err != nil {
+		return nil, errors.New(err, "cannot read VAPID key")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New(nil, "VAPID key is not PEM encoded")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New(err, "cannot parse VAPID key")
+	}
+	if key.Curve != elliptic.P256() {
+		return nil, errors.New(nil, "VAPID key must use the P-256 curve")
+	}
+	return &VAPID{key}, nil
+}
+
+// PublicKey returns the uncompressed public key of v, base64url encoded, as is
+// passed to PushManager.subscribe as the applicationServerKey.
+func (v *VAPID) PublicKey() string {
+	pub, err := v.key.PublicKey.ECDH()
+	if err != nil {
+		// P-256 keys are always valid ECDH keys
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(pub.Bytes())
+}
+
+// authorization returns the Authorization header value identifying v to the
+// push service at the given endpoint, valid until exp.
+func (v *VAPID) authorization(endpoint, subject string, exp time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", errors.New(err, "invalid push endpoint")
+	}
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, err := json.Marshal(map[string]any{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": exp.Unix(),
+		"sub": subject,
+	})
+	if err != nil {
+		return "", errors.New(err, "cannot marshal VAPID claims")
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, v.key, hash[:])
+	if err != nil {
+		return "", errors.New(err, "cannot sign VAPID claims")
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+	return "vapid t=" + token + ", k=" + v.PublicKey(), nil
+}
+
+// ExpiredError records the push subscriptions to which a notification could
+// not be delivered because they have expired or been unsubscribed, and which
+// should therefore be forgotten. Err is the first other error encountered, if
+// any.
+type ExpiredError struct {
+	Endpoints []string
+	Err       error
+}
+
+func (e ExpiredError) Error() string {
+	msg := "expired push subscriptions: " + strings.Join(e.Endpoints, ", ")
+	if e.Err != nil {
+		msg += "; " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e ExpiredError) Parent() error {
+	return e.Err
+}
+
+// Expired reports whether err records expired push subscriptions, returning
+// the ExpiredError if so.
+func Expired(err error) (ExpiredError, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case ExpiredError:
+			return e, true
+		case interface{ Parent() error }:
+			err = e.Parent()
+		default:
+			return ExpiredError{}, false
+		}
+	}
+	return ExpiredError{}, false
+}
+
+// Push delivers notifications to browsers with the Web Push protocol (RFC
+// 8030), encrypting each as described in RFC 8291 and identifying the server
+// with VAPID.
+type Push struct {
+	Keys *VAPID
+	// a mailto: or https: URL with which push services can contact the
+	// server's operator
+	Subject string
+	// the time for which undelivered notifications are kept; DefaultPushTTL
+	// if zero
+	TTL time.Duration
+	// the client used to send requests; if nil, messages are only sent to
+	// endpoints allowed by CheckEndpoint, with a client which also refuses to
+	// connect to the addresses it disallows
+	Client *http.Client
+}
+
+// pushClient is the client used by Push if none is set. Since endpoints are
+// supplied by users, it checks each address it connects to, so that a hostname
+// resolving to a disallowed address cannot be used to reach internal services,
+// and it follows no redirects.
+var pushClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 30 * time.Second,
+			Control: checkDial,
+		}).DialContext,
+		TLSHandshakeTimeout: 10 * time.Second,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Timeout: time.Minute,
+}
+
+// CheckEndpoint returns an error if push messages may not be sent to endpoint,
+// which must be an https URL whose host is not a loopback, link-local or
+// private address.
+func CheckEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return errors.New(err, "invalid push endpoint")
+	}
+	if u.Scheme != "https" {
+		return errors.New(nil, "push endpoint does not use https")
+	}
+	host := u.Hostname()
+	if host == "" {
+		return errors.New(nil, "push endpoint has no host")
+	}
+	if strings.EqualFold(strings.TrimSuffix(host, "."), "localhost") {
+		return errors.New(nil, "push endpoint is on a disallowed host: %s", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !allowed(ip) {
+		return errors.New(nil, "push endpoint is on a disallowed address: %s", host)
+	}
+	return nil
+}
+
+// allowed reports whether push messages may be sent to ip.
+func allowed(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsPrivate() && !ip.IsUnspecified()
+}
+
+// checkDial refuses connections by pushClient to disallowed addresses.
+func checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.New(err, "invalid push service address")
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !allowed(ip) {
+		return errors.New(nil, "push service is on a disallowed address: %s", host)
+	}
+	return nil
+}
+
+func (p *Push) Name() string {
+	return "push"
+}
+
+// Send pushes n, as JSON, to each of the recipient's subscriptions. If any of
+// the subscriptions have expired, an ExpiredError is returned.
+func (p *Push) Send(ctx context.Context, to Recipient, n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return errors.New(err, "cannot marshal notification")
+	}
+	var expired []string
+	var first error
+	for _, sub := range to.Subscriptions {
+		gone, err := p.push(ctx, sub, payload)
+		if gone {
+			expired = append(expired, sub.Endpoint)
+		} else if err != nil && first == nil {
+			first = err
+		}
+	}
+	if len(expired) > 0 {
+		return ExpiredError{Endpoints: expired, Err: first}
+	}
+	return first
+}
+
+// push sends an encrypted payload to sub, reporting whether sub has expired.
+func (p *Push) push(ctx context.Context, sub Subscription, payload []byte) (bool, error) {
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+	ttl := p.TTL
+	if ttl == 0 {
+		ttl = DefaultPushTTL
+	}
+	auth, err := p.Keys.authorization(sub.Endpoint, p.Subject, time.Now().Add(12*time.Hour))
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.New(err, "invalid push endpoint")
+	}
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl/time.Second)))
+	client := p.Client
+	if client == nil {
+		err = CheckEndpoint(sub.Endpoint)
+		if err != nil {
+			return false, errors.Wrap(err)
+		}
+		client = pushClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, errors.New(err, "cannot send push message")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode == 404 || resp.StatusCode == 410:
+		return true, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return false, errors.New(nil, "push service responded with status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// hkdf derives a key of the given length (at most 32 bytes) from ikm, as
+// described in RFC 5869.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	prk := mac.Sum(nil)
+	mac = hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:length]
+}
+
+// encrypt encrypts payload for sub with the aes128gcm content coding, as a
+// single record, as described in RFC 8291.
+func encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	uaKey, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Keys.P256dh, "="))
+	if err != nil {
+		return nil, errors.New(err, "invalid subscription key")
+	}
+	secret, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(sub.Keys.Auth, "="))
+	if err != nil {
+		return nil, errors.New(err, "invalid subscription secret")
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaKey)
+	if err != nil {
+		return nil, errors.New(err, "invalid subscription key")
+	}
+	if len(payload)+17 > recordSize {
+		return nil, errors.New(nil, "push message too large")
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.New(err, "cannot generate push message key")
+	}
+	asKey := asPrivate.PublicKey().Bytes()
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, errors.New(err, "cannot derive push message key")
+	}
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, errors.New(err, "cannot generate salt")
+	}
+
+	info := append([]byte("WebPush: info\x00"), uaKey...)
+	info = append(info, asKey...)
+	ikm := hkdf(secret, shared, info, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, errors.New(err, "cannot create push message cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.New(err, "cannot create push message cipher")
+	}
+	// The payload is followed by the padding delimiter of the last record.
+	plaintext := append(append([]byte{}, payload...), 2)
+
+	header := make([]byte, 0, 21+len(asKey))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asKey)))
+	header = append(header, asKey...)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
@@ -0,0 +1,296 @@
+package notify
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	path "path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test case 1 of RFC 5869, truncated to the length derived by hkdf.
+func TestHKDF(t *testing.T) {
+	ikm, _ := hex.DecodeString("0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b0b")
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf"
+	if got := hex.EncodeToString(hkdf(salt, ikm, info, 32)); got != want {
+		t.Errorf("hkdf = %s, want %s", got, want)
+	}
+}
+
+// newSubscriber returns a subscription to the given endpoint, along with the
+// subscriber's private key and authentication secret.
+func newSubscriber(t *testing.T, endpoint string) (Subscription, *ecdh.PrivateKey, []byte) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	var sub Subscription
+	sub.Endpoint = endpoint
+	sub.Keys.P256dh = base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	sub.Keys.Auth = base64.RawURLEncoding.EncodeToString(secret)
+	return sub, key, secret
+}
+
+// decrypt decrypts a push message body as the user agent would.
+func decrypt(t *testing.T, body []byte, key *ecdh.PrivateKey, secret []byte) []byte {
+	t.Helper()
+	if len(body) < 21 {
+		t.Fatalf("body too short: %d bytes", len(body))
+	}
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != recordSize {
+		t.Errorf("record size = %d, want %d", rs, recordSize)
+	}
+	idlen := int(body[20])
+	asKey := body[21 : 21+idlen]
+	asPublic, err := ecdh.P256().NewPublicKey(asKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := key.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := append([]byte("WebPush: info\x00"), key.PublicKey().Bytes()...)
+	info = append(info, asKey...)
+	ikm := hkdf(secret, shared, info, 32)
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, body[21+idlen:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext[len(plaintext)-1] != 2 {
+		t.Fatalf("missing padding delimiter")
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+// verifyVAPID checks that an Authorization header carries a valid VAPID token
+// for the given audience, signed by the key with the given public key.
+func verifyVAPID(t *testing.T, auth, aud, pub string) {
+	t.Helper()
+	var token, k string
+	for _, param := range strings.Split(strings.TrimPrefix(auth, "vapid "), ", ") {
+		if v, ok := strings.CutPrefix(param, "t="); ok {
+			token = v
+		} else if v, ok := strings.CutPrefix(param, "k="); ok {
+			k = v
+		}
+	}
+	if k != pub {
+		t.Errorf("k = %q, want %q", k, pub)
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed token: %q", token)
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(k)
+	x, y := elliptic.Unmarshal(elliptic.P256(), raw)
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, hash[:], r, s) {
+		t.Errorf("invalid token signature")
+	}
+	b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	json.Unmarshal(b, &claims)
+	if claims.Aud != aud {
+		t.Errorf("aud = %q, want %q", claims.Aud, aud)
+	}
+	if claims.Sub != "mailto:admin@example.com" {
+		t.Errorf("sub = %q", claims.Sub)
+	}
+	if time.Unix(claims.Exp, 0).Before(time.Now()) {
+		t.Errorf("token has expired")
+	}
+}
+
+func TestPushSend(t *testing.T) {
+	keys, err := GenerateVAPID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		got, _ = io.ReadAll(r.Body)
+		w.WriteHeader(201)
+	}))
+	defer srv.Close()
+
+	sub, key, secret := newSubscriber(t, srv.URL+"/push/abc")
+	p := &Push{Keys: keys, Subject: "mailto:admin@example.com", TTL: time.Hour, Client: srv.Client()}
+	n := Notification{Event: "graded", Title: "Task graded", Body: "Essay: A", Path: "/tasks/x/1"}
+	err = p.Send(context.Background(), Recipient{Subscriptions: []Subscription{sub}}, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ce := header.Get("Content-Encoding"); ce != "aes128gcm" {
+		t.Errorf("Content-Encoding = %q", ce)
+	}
+	if ttl := header.Get("TTL"); ttl != "3600" {
+		t.Errorf("TTL = %q, want 3600", ttl)
+	}
+	verifyVAPID(t, header.Get("Authorization"), srv.URL, keys.PublicKey())
+	var sent Notification
+	err = json.Unmarshal(decrypt(t, got, key, secret), &sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent.Title != n.Title || sent.Body != n.Body || sent.Path != n.Path {
+		t.Errorf("sent %+v, want %+v", sent, n)
+	}
+}
+
+func TestPushExpired(t *testing.T) {
+	keys, err := GenerateVAPID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/gone") {
+			w.WriteHeader(410)
+		} else {
+			w.WriteHeader(201)
+		}
+	}))
+	defer srv.Close()
+
+	live, _, _ := newSubscriber(t, srv.URL+"/live")
+	gone, _, _ := newSubscriber(t, srv.URL+"/gone")
+	p := &Push{Keys: keys, Subject: "mailto:admin@example.com", Client: srv.Client()}
+	to := Recipient{Subscriptions: []Subscription{live, gone}}
+	err = p.Send(context.Background(), to, Notification{Title: "Test"})
+	expired, ok := Expired(err)
+	if !ok {
+		t.Fatalf("err = %v, want ExpiredError", err)
+	}
+	if len(expired.Endpoints) != 1 || expired.Endpoints[0] != gone.Endpoint {
+		t.Errorf("expired = %v, want [%s]", expired.Endpoints, gone.Endpoint)
+	}
+	if expired.Err != nil {
+		t.Errorf("unexpected error: %v", expired.Err)
+	}
+}
+
+func TestLoadVAPID(t *testing.T) {
+	keypath := path.Join(t.TempDir(), "vapid.pem")
+	v1, err := LoadVAPID(keypath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := LoadVAPID(keypath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1.PublicKey() != v2.PublicKey() {
+		t.Errorf("reloaded key differs from generated key")
+	}
+	raw, _ := base64.RawURLEncoding.DecodeString(v1.PublicKey())
+	if len(raw) != 65 || raw[0] != 4 {
+		t.Errorf("public key is not an uncompressed P-256 point")
+	}
+}
+
+func TestCheckEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		ok       bool
+	}{
+		{"https://fcm.googleapis.com/fcm/send/abc", true},
+		{"https://updates.push.services.mozilla.com/wpush/v2/abc", true},
+		{"https://93.184.215.14/push", true},
+		{"http://fcm.googleapis.com/fcm/send/abc", false},
+		{"ftp://fcm.googleapis.com/abc", false},
+		{"https:///push", false},
+		{"https://localhost/push", false},
+		{"https://LOCALHOST./push", false},
+		{"https://127.0.0.1/push", false},
+		{"https://[::1]:8443/push", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://[fe80::1]/push", false},
+		{"https://10.0.0.5/push", false},
+		{"https://172.16.3.4/push", false},
+		{"https://192.168.1.1/push", false},
+		{"https://[fd00::1]/push", false},
+		{"https://0.0.0.0/push", false},
+		{"://bad", false},
+	}
+	for _, test := range tests {
+		err := CheckEndpoint(test.endpoint)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("CheckEndpoint(%q) = %v, want allowed %v", test.endpoint, err, test.ok)
+		}
+	}
+}
+
+func TestCheckDial(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:443", "[::1]:443", "10.1.2.3:443", "169.254.169.254:80"} {
+		if err := checkDial("tcp", address, nil); err == nil {
+			t.Errorf("dial to %s was allowed", address)
+		}
+	}
+	if err := checkDial("tcp", "93.184.215.14:443", nil); err != nil {
+		t.Errorf("dial to public address was refused: %v", err)
+	}
+}
+
+func TestPushDisallowed(t *testing.T) {
+	keys, err := GenerateVAPID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	called := false
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(201)
+	}))
+	defer srv.Close()
+
+	// Without a client of its own, Push refuses to reach the loopback
+	// server, whether by its address or by a name which resolves to it.
+	p := &Push{Keys: keys, Subject: "mailto:admin@example.com"}
+	for _, endpoint := range []string{srv.URL, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)} {
+		sub, _, _ := newSubscriber(t, endpoint+"/push")
+		err = p.Send(context.Background(), Recipient{Subscriptions: []Subscription{sub}}, Notification{Title: "Test"})
+		if err == nil {
+			t.Errorf("push to %s succeeded", endpoint)
+		}
+	}
+	_, err = pushClient.Get(srv.URL)
+	if err == nil {
+		t.Errorf("push client connected to loopback address")
+	}
+	if called {
+		t.Errorf("loopback server received a push message")
+	}
+}
@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// SMTP delivers notifications by email through an SMTP server. The connection
+// is upgraded with STARTTLS if the server supports it.
+type SMTP struct {
+	// the host and port of the server
+	Addr string
+	// the address from which emails are sent
+	From string
+	// if set, used to authenticate with the server
+	Username string
+	Password string
+	// the base URL prepended to the path of each notification
+	BaseURL string
+}
+
+func (s *SMTP) Name() string {
+	return "email"
+}
+
+// Send emails n to the recipient's email address.
+func (s *SMTP) Send(ctx context.Context, to Recipient, n Notification) error {
+	if to.Email == "" {
+		return nil
+	}
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return errors.New(err, "invalid sender address")
+	}
+	rcpt, err := mail.ParseAddress(to.Email)
+	if err != nil {
+		return errors.New(err, "invalid recipient address")
+	}
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return errors.New(err, "invalid SMTP server address")
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return errors.New(err, "cannot connect to SMTP server")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return errors.New(err, "cannot start SMTP session")
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return errors.New(err, "cannot start TLS")
+		}
+	}
+	if s.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, host))
+		if err != nil {
+			return errors.New(err, "cannot authenticate with SMTP server")
+		}
+	}
+	err = c.Mail(from.Address)
+	if err != nil {
+		return errors.New(err, "sender rejected")
+	}
+	err = c.Rcpt(rcpt.Address)
+	if err != nil {
+		return errors.New(err, "recipient rejected")
+	}
+	w, err := c.Data()
+	if err != nil {
+		return errors.New(err, "cannot send email")
+	}
+	_, err = w.Write(s.message(from, rcpt, n))
+	if err != nil {
+		return errors.New(err, "cannot send email")
+	}
+	err = w.Close()
+	if err != nil {
+		return errors.New(err, "email rejected")
+	}
+	return c.Quit()
+}
+
+// message returns the email, with headers, which notifies rcpt of n.
+func (s *SMTP) message(from, rcpt *mail.Address, n Notification) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: " + from.String() + "\r\n")
+	buf.WriteString("To: " + rcpt.String() + "\r\n")
+	buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", n.Title) + "\r\n")
+	buf.WriteString("Date: " + n.Time.Format(time.RFC1123Z) + "\r\n")
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+	qp := quotedprintable.NewWriter(&buf)
+	qp.Write([]byte(n.Body + "\r\n"))
+	if n.Path != "" {
+		qp.Write([]byte("\r\n" + s.BaseURL + n.Path + "\r\n"))
+	}
+	qp.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// email is an email received by a stand-in SMTP server.
+type email struct {
+	from string
+	to   []string
+	data string
+}
+
+// serveSMTP runs a minimal SMTP server on a local port, sending each email it
+// receives to the returned channel.
+func serveSMTP(t *testing.T) (string, <-chan email) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	ch := make(chan email, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleSMTP(conn, ch)
+		}
+	}()
+	return l.Addr().String(), ch
+}
+
+func handleSMTP(conn net.Conn, ch chan<- email) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		io.WriteString(conn, line+"\r\n")
+	}
+	var msg email
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.data = data.String()
+			ch <- msg
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	addr, ch := serveSMTP(t)
+	s := &SMTP{
+		Addr:    addr,
+		From:    "TaskCollect <taskcollect@example.com>",
+		BaseURL: "https://taskcollect.example.com",
+	}
+	n := Notification{
+		Event: "due",
+		Title: "Due soon: Essay — draft",
+		Body:  "English is due tomorrow, 09:00.",
+		Path:  "/tasks/example/1",
+		Time:  time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.Send(ctx, Recipient{Email: "student@example.com"}, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got email
+	select {
+	case got = <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email received")
+	}
+	if got.from != "taskcollect@example.com" {
+		t.Errorf("sender = %q", got.from)
+	}
+	if len(got.to) != 1 || got.to[0] != "student@example.com" {
+		t.Errorf("recipients = %v", got.to)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != n.Title {
+		t.Errorf("subject = %q, want %q", subject, n.Title)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(msg.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), n.Body) {
+		t.Errorf("body %q does not contain %q", body, n.Body)
+	}
+	if !strings.Contains(string(body), "https://taskcollect.example.com/tasks/example/1") {
+		t.Errorf("body %q does not link to the task", body)
+	}
+}
+
+func TestSMTPNoEmail(t *testing.T) {
+	// No connection is made when the recipient has no email address.
+	s := &SMTP{Addr: "127.0.0.1:1", From: "taskcollect@example.com"}
+	err := s.Send(context.Background(), Recipient{}, Notification{Title: "Test"})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// Webhook delivers notifications by POSTing them as JSON to the recipient's
+// webhooks. If a hook has a secret, the body is signed with HMAC-SHA256 and the
+// signature sent, hex encoded, in the X-TaskCollect-Signature header as
+// "sha256=<signature>".
+type Webhook struct {
+	// the client used to send requests; http.DefaultClient if nil
+	Client *http.Client
+}
+
+func (wh *Webhook) Name() string {
+	return "webhook"
+}
+
+// Send posts n to each of the recipient's webhooks, returning the first error
+// encountered. Every webhook is attempted regardless of errors.
+func (wh *Webhook) Send(ctx context.Context, to Recipient, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return errors.New(err, "cannot marshal notification")
+	}
+	var first error
+	for _, hook := range to.Hooks {
+		err = wh.post(ctx, hook, body)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (wh *Webhook) post(ctx context.Context, hook Hook, body []byte) error {
+	client := wh.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return errors.New(err, "invalid webhook")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TaskCollect")
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-TaskCollect-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New(err, "cannot send webhook")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(nil, "webhook responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSend(t *testing.T) {
+	var body []byte
+	var sig string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(400)
+			return
+		}
+		body, _ = io.ReadAll(r.Body)
+		sig = r.Header.Get("X-TaskCollect-Signature")
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+
+	n := Notification{
+		Event: "new-task",
+		Title: "New task: Essay",
+		Body:  "English",
+		Path:  "/tasks/example/1",
+		Time:  time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC),
+	}
+	wh := &Webhook{}
+	to := Recipient{Hooks: []Hook{{URL: srv.URL, Secret: "s3cret"}}}
+	err := wh.Send(context.Background(), to, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Notification
+	err = json.Unmarshal(body, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != n {
+		t.Errorf("sent %+v, want %+v", got, n)
+	}
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); sig != want {
+		t.Errorf("signature = %q, want %q", sig, want)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	signed := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, signed = r.Header["X-Taskcollect-Signature"]
+	}))
+	defer srv.Close()
+
+	to := Recipient{Hooks: []Hook{{URL: srv.URL}}}
+	err := (&Webhook{}).Send(context.Background(), to, Notification{Title: "Test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed {
+		t.Errorf("request signed without a secret")
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	calls := 0
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(500)
+	}))
+	defer failing.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	defer working.Close()
+
+	to := Recipient{Hooks: []Hook{{URL: failing.URL}, {URL: working.URL}}}
+	err := (&Webhook{}).Send(context.Background(), to, Notification{Title: "Test"})
+	if err == nil {
+		t.Errorf("error from failing webhook not returned")
+	}
+	if calls != 2 {
+		t.Errorf("%d webhooks called, want 2", calls)
+	}
+}
@@ -0,0 +1,401 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/logger"
+	"main/site"
+)
+
+// The maximum number of days which can be requested from /api/v1/lessons.
+const maxLessonDays = 366
+
+// Write v to w as JSON with the given status code.
+func writeJson(w http.ResponseWriter, statusCode int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot encode JSON response"))
+	}
+}
+
+// Write an API error message to w with the given status code.
+func writeApiError(w http.ResponseWriter, statusCode int, format string, a ...any) {
+	writeJson(w, statusCode, apiError{Error: fmt.Sprintf(format, a...)})
+}
+
+// Write an API error message to w for a request which failed because of err,
+// with the status code of the failure. The kind of failure, and whether the
+// request may succeed if made again later, are also given.
+func writeApiFailure(w http.ResponseWriter, err error, format string, a ...any) {
+	f := failureOf(err)
+	for _, header := range f.headers() {
+		w.Header().Set(header[0], header[1])
+	}
+	writeJson(w, f.status, apiError{
+		Error: fmt.Sprintf(format, a...),
+		Kind:  f.kind,
+		Retry: f.retry,
+	})
+}
+
+// Parse the date range for /api/v1/lessons. Both dates are formatted as
+// YYYY-MM-DD and interpreted in the user's timezone; both are inclusive. If
+// either date is missing, the current school week is used.
+func lessonRange(r *http.Request, user site.User) (time.Time, time.Time, error) {
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" || to == "" {
+		now := midnight(time.Now().In(user.Timezone))
+		weekday := now.Weekday()
+		if weekday == time.Saturday {
+			return now.AddDate(0, 0, 2), now.AddDate(0, 0, 6), nil
+		}
+		return now.AddDate(0, 0, 1-int(weekday)), now.AddDate(0, 0, 5-int(weekday)), nil
+	}
+	start, err := time.ParseInLocation("2006-01-02", from, user.Timezone)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New(err, "invalid start date")
+	}
+	end, err := time.ParseInLocation("2006-01-02", to, user.Timezone)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New(err, "invalid end date")
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, errors.New(nil, "end date is before start date")
+	}
+	if end.Sub(start) > maxLessonDays*24*time.Hour {
+		return time.Time{}, time.Time{}, errors.New(nil, "date range exceeds %d days", maxLessonDays)
+	}
+	return start, end, nil
+}
+
+// Parse the format in which the descriptions of tasks and resources and the
+// bodies of messages are served, given by the "format" query parameter: "html"
+// (the default) for sanitised HTML, "text" for plain text, or "markdown".
+func descFormat(r *http.Request) (string, error) {
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		return "html", nil
+	case "html", "text", "markdown":
+		return format, nil
+	}
+	return "", errors.New(nil, "invalid description format %q", format)
+}
+
+// servable reports whether the results of a multiplexed call may be served
+// despite err, which is the case if they are partial or stale (see
+// site.Usable). Any failed platforms are then listed in the X-Failed-Platforms
+// response header, and the age of stale results in seconds is given in the Age
+// header, so that clients can tell that the results are incomplete or old.
+func servable(w http.ResponseWriter, err error) bool {
+	if !site.Usable(err) {
+		return false
+	} else if err == nil {
+		return true
+	}
+	if stale, ok := site.Stale(err); ok {
+		logger.Debug(errors.New(err, "serving cached results"))
+		w.Header().Set("Age", strconv.Itoa(int(stale.Age/time.Second)))
+	} else {
+		logger.Debug(errors.New(err, "serving incomplete results"))
+	}
+	failed, _ := site.Partial(err)
+	for _, platform := range failed.Platforms() {
+		w.Header().Add("X-Failed-Platforms", platform)
+	}
+	return true
+}
+
+func serveActivity(w http.ResponseWriter, user site.User) {
+	uid := site.Uid{School: user.School, Username: user.Username}
+	activity, err := creds.Store.Activity(uid)
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot fetch activity feed"))
+		writeApiFailure(w, err, "cannot fetch activity feed")
+		return
+	}
+	data := []apiChange{}
+	for _, change := range activity.Changes {
+		data = append(data, toApiChange(change, activity.Seen))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveClasses(ctx context.Context, w http.ResponseWriter, user site.User, school *site.School) {
+	classes, err := school.Classes(ctx, user)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch class list"))
+		writeApiFailure(w, err, "cannot fetch class list")
+		return
+	}
+	data := []apiClass{}
+	for _, class := range classes {
+		data = append(data, toApiClass(class))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveGraded(ctx context.Context, w http.ResponseWriter, user site.User, school *site.School, format string) {
+	tasks, err := school.Graded(ctx, user)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch graded tasks"))
+		writeApiFailure(w, err, "cannot fetch graded tasks")
+		return
+	}
+	data := []apiTask{}
+	for _, task := range tasks {
+		data = append(data, toApiTask(task, format, user))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveLessons(w http.ResponseWriter, r *http.Request, user site.User, school *site.School) {
+	start, end, err := lessonRange(r, user)
+	if err != nil {
+		writeApiError(w, 400, "%s", err)
+		return
+	}
+	lessons, err := school.Lessons(r.Context(), user, start, end)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch lessons"))
+		writeApiFailure(w, err, "cannot fetch lessons")
+		return
+	}
+	data := []apiLesson{}
+	for _, lesson := range lessons {
+		data = append(data, toApiLesson(lesson))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveMessages(ctx context.Context, w http.ResponseWriter, user site.User, school *site.School, format string) {
+	messages, err := school.Messages(ctx, user)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch messages"))
+		writeApiFailure(w, err, "cannot fetch messages")
+		return
+	}
+	data := []apiMessage{}
+	for _, msg := range messages {
+		data = append(data, toApiMessage(msg, format, user))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveReports(ctx context.Context, w http.ResponseWriter, user site.User, school *site.School) {
+	reports, err := school.Reports(ctx, user)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch reports"))
+		writeApiFailure(w, err, "cannot fetch reports")
+		return
+	}
+	data := []apiReport{}
+	for _, report := range reports {
+		data = append(data, toApiReport(report))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveResources(ctx context.Context, w http.ResponseWriter, user site.User, school *site.School, format string) {
+	classes, err := school.Classes(ctx, user)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch class list"))
+		writeApiFailure(w, err, "cannot fetch class list")
+		return
+	}
+	resources, err := school.Resources(ctx, user, classes...)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch resources list"))
+		writeApiFailure(w, err, "cannot fetch resources list")
+		return
+	}
+	data := []apiResource{}
+	for _, res := range resources {
+		data = append(data, toApiResource(res, format, user))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveResource(ctx context.Context, w http.ResponseWriter, user site.User, school *site.School, platform, id, format string) {
+	res, err := school.Resource(ctx, user, platform, id)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch resource"))
+		writeApiFailure(w, err, "cannot fetch resource")
+		return
+	}
+	writeJson(w, 200, toApiResource(res, format, user))
+}
+
+// Search the user's tasks, resources and messages. The search is given by the
+// query parameters described by parseSearch.
+func serveSearch(w http.ResponseWriter, r *http.Request, user site.User, school *site.School) {
+	q, err := parseSearch(r.URL.Query(), user)
+	if err != nil {
+		writeApiError(w, 400, "%s", err)
+		return
+	}
+	if q.Text == "" {
+		writeApiError(w, 400, "no search text")
+		return
+	}
+	idx, err := userIndex(r.Context(), user, school)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot build search index"))
+		writeApiFailure(w, err, "cannot build search index")
+		return
+	}
+	data := []apiSearchResult{}
+	for _, hit := range idx.search(q) {
+		data = append(data, toApiSearchResult(hit, q.Text))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveTasks(ctx context.Context, w http.ResponseWriter, user site.User, school *site.School, format string) {
+	classes, err := school.Classes(ctx, user)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch class list"))
+		writeApiFailure(w, err, "cannot fetch class list")
+		return
+	}
+	tasks, err := school.Tasks(ctx, user, classes...)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch tasks list"))
+		writeApiFailure(w, err, "cannot fetch tasks list")
+		return
+	}
+	data := []apiTask{}
+	for _, task := range tasks {
+		data = append(data, toApiTask(task, format, user))
+	}
+	writeJson(w, 200, data)
+}
+
+func serveTask(ctx context.Context, w http.ResponseWriter, user site.User, school *site.School, platform, id, format string) {
+	task, err := school.Task(ctx, user, platform, id)
+	if !servable(w, err) {
+		logger.Debug(errors.New(err, "cannot fetch task"))
+		writeApiFailure(w, err, "cannot fetch task")
+		return
+	}
+	writeJson(w, 200, toApiTask(task, format, user))
+}
+
+// Submit a task, upload work to it, or remove work from it. Uploaded files are
+// sent as multipart form data; files to remove are named by the repeated "file"
+// form value.
+func serveTaskCmd(w http.ResponseWriter, r *http.Request, user site.User, school *site.School, platform, id, cmd, format string) {
+	ctx := r.Context()
+	_, err := creds.Authorize(r, scopeSubmit)
+	if err != nil {
+		writeApiError(w, 403, "token does not permit %s", cmd)
+		return
+	}
+	switch cmd {
+	case "submit":
+		err = school.Submit(ctx, user, platform, id)
+	case "upload":
+		err = school.UploadWork(ctx, user, platform, id, r)
+	case "remove":
+		err = r.ParseForm()
+		if err != nil {
+			writeApiError(w, 400, "cannot parse form")
+			return
+		}
+		files := r.Form["file"]
+		if len(files) == 0 {
+			writeApiError(w, 400, "no files to remove")
+			return
+		}
+		err = school.RemoveWork(ctx, user, platform, id, files)
+	default:
+		writeApiError(w, 404, "no such endpoint: /api/v1/tasks/%s/%s/%s", platform, id, cmd)
+		return
+	}
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot %s work", cmd))
+		writeApiFailure(w, err, "cannot %s work", cmd)
+		return
+	}
+	serveTask(ctx, w, user, school, platform, id, format)
+}
+
+// Handle version 1 of the JSON API (located under "/api/v1/"). Requests are
+// authenticated with either a session cookie or a bearer token; tokens with
+// read-only scope cannot be used to modify tasks.
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user, err := creds.Lookup(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="TaskCollect"`)
+		writeApiError(w, 401, "not authenticated")
+		return
+	}
+
+	res := strings.TrimPrefix(r.URL.EscapedPath(), "/api/v1/")
+	parts := strings.Split(strings.TrimSuffix(res, "/"), "/")
+
+	// Only task commands modify state; every other endpoint is read-only.
+	method := "GET"
+	if len(parts) == 4 && parts[0] == "tasks" {
+		method = "POST"
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeApiError(w, 405, "method not allowed")
+		return
+	}
+
+	school, ok := schools[user.School]
+	if !ok {
+		logger.Debug(errors.New(nil, "unsupported school"))
+		writeApiError(w, 500, "unsupported school")
+		return
+	}
+
+	format, err := descFormat(r)
+	if err != nil {
+		writeApiError(w, 400, "%s", err)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "activity":
+		serveActivity(w, user)
+	case len(parts) == 1 && parts[0] == "classes":
+		serveClasses(ctx, w, user, school)
+	case len(parts) == 1 && parts[0] == "graded":
+		serveGraded(ctx, w, user, school, format)
+	case len(parts) == 1 && parts[0] == "lessons":
+		serveLessons(w, r, user, school)
+	case len(parts) == 1 && parts[0] == "messages":
+		serveMessages(ctx, w, user, school, format)
+	case len(parts) == 1 && parts[0] == "reports":
+		serveReports(ctx, w, user, school)
+	case len(parts) == 1 && parts[0] == "resources":
+		serveResources(ctx, w, user, school, format)
+	case len(parts) == 3 && parts[0] == "resources":
+		serveResource(ctx, w, user, school, parts[1], parts[2], format)
+	case len(parts) == 1 && parts[0] == "search":
+		serveSearch(w, r, user, school)
+	case len(parts) == 1 && parts[0] == "tasks":
+		serveTasks(ctx, w, user, school, format)
+	case len(parts) == 3 && parts[0] == "tasks":
+		serveTask(ctx, w, user, school, parts[1], parts[2], format)
+	case len(parts) == 4 && parts[0] == "tasks":
+		serveTaskCmd(w, r, user, school, parts[1], parts[2], parts[3], format)
+	default:
+		writeApiError(w, 404, "no such endpoint: /api/v1/%s", res)
+	}
+}
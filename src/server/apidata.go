@@ -0,0 +1,290 @@
+package server
+
+import (
+	"net/mail"
+	"time"
+
+	"main/site"
+)
+
+// JSON representations of platform data served by the API. Field names mirror
+// those of the corresponding site types.
+
+type apiError struct {
+	Error string `json:"error"`
+	Kind  string `json:"kind,omitempty"`
+	Retry bool   `json:"retry,omitempty"`
+}
+
+type apiLink struct {
+	URL  string `json:"url"`
+	Name string `json:"name"`
+}
+
+type apiChange struct {
+	Kind     string     `json:"kind"`
+	Detected time.Time  `json:"detected"`
+	New      bool       `json:"new"`
+	Name     string     `json:"name"`
+	Class    string     `json:"class"`
+	Time     *time.Time `json:"time,omitempty"`
+	Previous *time.Time `json:"previous,omitempty"`
+	From     string     `json:"from,omitempty"`
+	To       string     `json:"to,omitempty"`
+	Platform string     `json:"platform,omitempty"`
+	Id       string     `json:"id,omitempty"`
+}
+
+type apiClass struct {
+	Name     string `json:"name"`
+	Link     string `json:"link"`
+	Platform string `json:"platform"`
+	Id       string `json:"id"`
+}
+
+type apiGrade struct {
+	Class string  `json:"class"`
+	Grade string  `json:"grade"`
+	Score float64 `json:"score"`
+}
+
+type apiLesson struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Class    string    `json:"class"`
+	Room     string    `json:"room"`
+	Teacher  string    `json:"teacher"`
+	Notice   string    `json:"notice"`
+	Platform string    `json:"platform"`
+}
+
+type apiAddress struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+}
+
+type apiMessage struct {
+	From     apiAddress   `json:"from"`
+	To       []apiAddress `json:"to"`
+	Cc       []apiAddress `json:"cc,omitempty"`
+	Sent     time.Time    `json:"sent"`
+	Subject  string       `json:"subject"`
+	Body     string       `json:"body"`
+	Read     bool         `json:"read"`
+	Platform string       `json:"platform"`
+	Id       string       `json:"id"`
+}
+
+type apiReport struct {
+	Name     string     `json:"name"`
+	Grades   []apiGrade `json:"grades"`
+	Released time.Time  `json:"released"`
+	Platform string     `json:"platform"`
+	Id       string     `json:"id"`
+}
+
+type apiResource struct {
+	Name     string    `json:"name"`
+	Class    string    `json:"class"`
+	Link     string    `json:"link"`
+	Desc     string    `json:"desc,omitempty"`
+	Posted   time.Time `json:"posted"`
+	ResLinks []apiLink `json:"resLinks,omitempty"`
+	Platform string    `json:"platform"`
+	Id       string    `json:"id"`
+}
+
+type apiSpan struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+type apiSearchResult struct {
+	Kind     string     `json:"kind"`
+	Name     []apiSpan  `json:"name"`
+	Class    string     `json:"class,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
+	Snippet  []apiSpan  `json:"snippet,omitempty"`
+	Score    float64    `json:"score"`
+	Path     string     `json:"path"`
+	Platform string     `json:"platform"`
+	Id       string     `json:"id"`
+}
+
+type apiTask struct {
+	Name      string     `json:"name"`
+	Class     string     `json:"class"`
+	Link      string     `json:"link"`
+	Desc      string     `json:"desc,omitempty"`
+	Due       *time.Time `json:"due,omitempty"`
+	Posted    *time.Time `json:"posted,omitempty"`
+	ResLinks  []apiLink  `json:"resLinks,omitempty"`
+	Upload    bool       `json:"upload"`
+	WorkLinks []apiLink  `json:"workLinks,omitempty"`
+	Submitted bool       `json:"submitted"`
+	Graded    bool       `json:"graded"`
+	Grade     string     `json:"grade,omitempty"`
+	Score     float64    `json:"score,omitempty"`
+	Comment   string     `json:"comment,omitempty"`
+	Platform  string     `json:"platform"`
+	Id        string     `json:"id"`
+}
+
+func optTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func toApiLinks(links [][2]string) []apiLink {
+	var converted []apiLink
+	for _, link := range links {
+		converted = append(converted, apiLink{URL: link[0], Name: link[1]})
+	}
+	return converted
+}
+
+func toApiChange(change Change, seen time.Time) apiChange {
+	return apiChange{
+		Kind:     change.Kind,
+		Detected: change.Detected,
+		New:      change.Detected.After(seen),
+		Name:     change.Name,
+		Class:    change.Class,
+		Time:     optTime(change.Time),
+		Previous: optTime(change.Previous),
+		From:     change.From,
+		To:       change.To,
+		Platform: change.Platform,
+		Id:       change.Id,
+	}
+}
+
+func toApiClass(class site.Class) apiClass {
+	return apiClass{
+		Name:     class.Name,
+		Link:     class.Link,
+		Platform: class.Platform,
+		Id:       class.Id,
+	}
+}
+
+func toApiLesson(lesson site.Lesson) apiLesson {
+	return apiLesson{
+		Start:    lesson.Start,
+		End:      lesson.End,
+		Class:    lesson.Class,
+		Room:     lesson.Room,
+		Teacher:  lesson.Teacher,
+		Notice:   lesson.Notice,
+		Platform: lesson.Platform,
+	}
+}
+
+func toApiAddresses(addrs []mail.Address) []apiAddress {
+	converted := []apiAddress{}
+	for _, addr := range addrs {
+		converted = append(converted, apiAddress{Name: addr.Name, Address: addr.Address})
+	}
+	return converted
+}
+
+// toApiMessage converts msg for the API, serving its body sanitised in the
+// given format (see descFormat).
+func toApiMessage(msg site.Message, format string, user site.User) apiMessage {
+	return apiMessage{
+		From:     apiAddress{Name: msg.From.Name, Address: msg.From.Address},
+		To:       toApiAddresses(msg.To),
+		Cc:       toApiAddresses(msg.Cc),
+		Sent:     msg.Sent,
+		Subject:  msg.Subject,
+		Body:     genDescFormat(msg.Body, msg.Platform, format, user),
+		Read:     msg.Read,
+		Platform: msg.Platform,
+		Id:       msg.Id,
+	}
+}
+
+func toApiReport(report site.Report) apiReport {
+	converted := apiReport{
+		Name:     report.Name,
+		Grades:   []apiGrade{},
+		Released: report.Released,
+		Platform: report.Platform,
+		Id:       report.Id,
+	}
+	for _, grade := range report.Grades {
+		converted.Grades = append(converted.Grades, apiGrade{
+			Class: grade.Class,
+			Grade: grade.Grade,
+			Score: grade.Score,
+		})
+	}
+	return converted
+}
+
+// toApiResource converts res for the API, serving its description in the given
+// format (see descFormat).
+func toApiResource(res site.Resource, format string, user site.User) apiResource {
+	return apiResource{
+		Name:     res.Name,
+		Class:    res.Class,
+		Link:     res.Link,
+		Desc:     genDescFormat(res.Desc, res.Platform, format, user),
+		Posted:   res.Posted,
+		ResLinks: toApiLinks(res.ResLinks),
+		Platform: res.Platform,
+		Id:       res.Id,
+	}
+}
+
+func toApiSpans(spans []searchSpan) []apiSpan {
+	data := []apiSpan{}
+	for _, span := range spans {
+		data = append(data, apiSpan{Text: span.Text, Match: span.Match})
+	}
+	return data
+}
+
+func toApiSearchResult(hit searchHit, q string) apiSearchResult {
+	result := apiSearchResult{
+		Kind:     hit.Doc.Kind,
+		Name:     toApiSpans(highlight(hit.Doc.Name, q)),
+		Class:    hit.Doc.Class,
+		Score:    hit.Score,
+		Path:     searchPath(hit.Doc),
+		Platform: hit.Doc.Platform,
+		Id:       hit.Doc.Id,
+	}
+	if !hit.Doc.Time.IsZero() {
+		result.Time = &hit.Doc.Time
+	}
+	if snippet := hitSnippet(hit, q); snippet != "" {
+		result.Snippet = toApiSpans(highlight(snippet, q))
+	}
+	return result
+}
+
+// toApiTask converts task for the API, serving its description in the given
+// format (see descFormat).
+func toApiTask(task site.Task, format string, user site.User) apiTask {
+	return apiTask{
+		Name:      task.Name,
+		Class:     task.Class,
+		Link:      task.Link,
+		Desc:      genDescFormat(task.Desc, task.Platform, format, user),
+		Due:       optTime(task.Due),
+		Posted:    optTime(task.Posted),
+		ResLinks:  toApiLinks(task.ResLinks),
+		Upload:    task.Upload,
+		WorkLinks: toApiLinks(task.WorkLinks),
+		Submitted: task.Submitted,
+		Graded:    task.Graded,
+		Grade:     task.Grade,
+		Score:     task.Score,
+		Comment:   task.Comment,
+		Platform:  task.Platform,
+		Id:        task.Id,
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"main/site"
+)
+
+func TestToApiMessage(t *testing.T) {
+	msg := site.Message{
+		Subject:  "Excursion",
+		Body:     `<p onclick="steal()">Bring a hat.<script>alert(1)</script><img src="https://tracker.example.com/pixel.png"></p>`,
+		Platform: "daymap",
+		Id:       "101",
+	}
+	tests := []struct {
+		format, want string
+	}{
+		{"html", `<p>Bring a hat.<a href="https://tracker.example.com/pixel.png" rel="noopener noreferrer">[Image]</a></p>`},
+		{"text", "Bring a hat.[Image] (https://tracker.example.com/pixel.png)"},
+		{"markdown", "Bring a hat.[\\[Image\\]](https://tracker.example.com/pixel.png)"},
+	}
+	for _, test := range tests {
+		got := toApiMessage(msg, test.format, site.User{}).Body
+		if strings.Contains(got, "script") || strings.Contains(got, "alert") || strings.Contains(got, "onclick") {
+			t.Errorf("%s: body %q contains unsafe markup", test.format, got)
+		}
+		if got != test.want {
+			t.Errorf("%s: got body %q, want %q", test.format, got, test.want)
+		}
+	}
+}
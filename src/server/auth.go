@@ -1,9 +1,13 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
 	"sync"
@@ -16,166 +20,402 @@ import (
 	"main/site"
 )
 
+// Creds holds the sessions and users known to the server. Sessions expire
+// after the expiry time set when they are created; expired sessions are
+// rejected on lookup and removed from the store by Sweep.
+//
+// Sessions are stored under a digest of their token rather than the token
+// itself, so that the contents of the store cannot be used to authenticate.
 type Creds struct {
-	Tokens map[string]site.Uid
-	Users  map[site.Uid]site.User
-	Mutex  sync.Mutex
+	Store Store
+
+	// serialises updates to users, so that platform tokens saved by
+	// SetToken are not lost to concurrent updates
+	mu sync.Mutex
 }
 
-func extract(cookie string) (string, error) {
-	// TODO: simplify token extraction
-	var token string
+// Session scopes, in increasing order of privilege. Login sessions created by
+// the web interface have scopeSession, while API tokens have either scopeRead
+// or scopeSubmit. Calendar feed tokens have scopeFeed, which only permits
+// access to the user's iCalendar feed.
+const (
+	scopeFeed    = "feed"
+	scopeRead    = "read"
+	scopeSubmit  = "submit"
+	scopeSession = "session"
+)
+
+// sessionLifetime is how long a login session lasts.
+const sessionLifetime = 3 * 24 * time.Hour
 
-	start := strings.Index(cookie, "token=")
-	if start == -1 {
-		return "", errors.New(nil, "no token in session cookie")
-	}
+var scopeRank = map[string]int{
+	scopeFeed:    1,
+	scopeRead:    2,
+	scopeSubmit:  3,
+	scopeSession: 4,
+}
+
+// permits reports whether a session with scope have may be used for an action
+// which requires scope want.
+func permits(have, want string) bool {
+	return scopeRank[have] != 0 && scopeRank[have] >= scopeRank[want]
+}
 
-	start += 6
-	end := strings.Index(cookie[start:], ";")
+// digest returns the key under which the session identified by token is
+// stored.
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
 
-	if end == -1 {
-		token = cookie[start:]
-	} else {
-		token = cookie[start:end]
+// newToken returns a new random session token.
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", errors.New(err, "cannot generate token")
 	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
 
+// bearer returns the token from the value of an Authorization header using the
+// Bearer scheme.
+func bearer(header string) (string, error) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", errors.New(nil, "no bearer token in authorization header")
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", errors.New(nil, "empty bearer token")
+	}
 	return token, nil
 }
 
-func (creds *Creds) LookupToken(cookie string) (site.User, error) {
-	token, err := extract(cookie)
+// token returns the token presented by request r, taken from its Authorization
+// header if present, or its session cookie otherwise.
+func token(r *http.Request) (string, error) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		return bearer(header)
+	}
+	cookie, err := r.Cookie("token")
+	if err != nil {
+		return "", errors.New(err, "no token in session cookie")
+	}
+	return cookie.Value, nil
+}
+
+// Authorize returns the user authenticated by request r, provided that the
+// session or API token presented by r has at least the given scope.
+func (creds *Creds) Authorize(r *http.Request, scope string) (site.User, error) {
+	token, err := token(r)
 	if err != nil {
 		return site.User{}, errors.New(err, "cannot lookup token")
 	}
-	user := creds.Users[creds.Tokens[token]]
-	if user.Username == "" {
-		return site.User{}, errors.New(nil, "no user with matching token: %s", token)
+	return creds.authorize(token, scope)
+}
+
+// authorize returns the user authenticated by token, provided that the token
+// has at least the given scope.
+func (creds *Creds) authorize(token, scope string) (site.User, error) {
+	session, err := creds.session(token)
+	if err != nil {
+		return site.User{}, errors.Wrap(err)
+	}
+	if !permits(session.Scope, scope) {
+		return site.User{}, errors.New(nil, "session scope %q does not permit %q", session.Scope, scope)
+	}
+	user, err := creds.Store.User(session.Uid)
+	if err != nil {
+		return site.User{}, errors.New(err, "cannot lookup token")
 	}
 	return user, nil
 }
 
+// session returns the unexpired session identified by token.
+func (creds *Creds) session(token string) (Session, error) {
+	session, err := creds.Store.Session(digest(token))
+	if err != nil {
+		return Session{}, errors.New(err, "cannot lookup token")
+	}
+	if !session.Expiry.IsZero() && time.Now().After(session.Expiry) {
+		return Session{}, errors.New(nil, "session has expired")
+	}
+	return session, nil
+}
+
+// Lookup returns the user authenticated by request r. Any session or API token
+// may be used to authenticate, regardless of its scope.
+func (creds *Creds) Lookup(r *http.Request) (site.User, error) {
+	return creds.Authorize(r, scopeRead)
+}
+
+// LookupFeed returns the user authenticated by the calendar feed token in the
+// query string of request r. Calendar apps cannot set headers on the requests
+// they make, so the feed token is passed in the URL instead. Only feed tokens
+// are accepted, so that tokens with wider scopes never appear in URLs, where
+// they may be logged or shared along with the feed.
+func (creds *Creds) LookupFeed(r *http.Request) (site.User, error) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		return site.User{}, errors.New(nil, "no feed token in query")
+	}
+	session, err := creds.session(token)
+	if err != nil {
+		return site.User{}, errors.Wrap(err)
+	}
+	if session.Scope != scopeFeed {
+		return site.User{}, errors.New(nil, "session scope %q is not %q", session.Scope, scopeFeed)
+	}
+	user, err := creds.Store.User(session.Uid)
+	if err != nil {
+		return site.User{}, errors.New(err, "cannot lookup token")
+	}
+	return user, nil
+}
+
+// Mint creates a new named API token for the user with the given uid. The
+// token never expires, and is only returned by Mint; it cannot be recovered
+// from the store afterwards.
+func (creds *Creds) Mint(uid site.Uid, name, scope string) (string, error) {
+	if scope != scopeRead && scope != scopeSubmit {
+		return "", errors.New(nil, "invalid token scope %q", scope)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New(nil, "token name must not be empty")
+	}
+	return creds.mint(uid, name, scope)
+}
+
+// MintFeed creates a new calendar feed token for the user with the given uid,
+// replacing any existing feed token.
+func (creds *Creds) MintFeed(uid site.Uid) (string, error) {
+	_, id, err := creds.Feed(uid)
+	if err == nil {
+		err = creds.Store.DeleteSession(id)
+		if err != nil {
+			return "", errors.New(err, "cannot replace feed token")
+		}
+	}
+	return creds.mint(uid, "Calendar feed", scopeFeed)
+}
+
+// Feed returns the calendar feed token belonging to the user with the given
+// uid, along with its digest.
+func (creds *Creds) Feed(uid site.Uid) (Session, string, error) {
+	sessions, err := creds.Store.Sessions(uid)
+	if err != nil {
+		return Session{}, "", errors.New(err, "cannot find feed token")
+	}
+	for id, session := range sessions {
+		if session.Scope == scopeFeed {
+			return session, id, nil
+		}
+	}
+	return Session{}, "", errors.New(nil, "no feed token")
+}
+
+func (creds *Creds) mint(uid site.Uid, name, scope string) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", errors.New(err, "cannot mint token")
+	}
+	session := Session{
+		Uid:     uid,
+		Name:    name,
+		Scope:   scope,
+		Created: time.Now(),
+	}
+	err = creds.Store.SetSession(digest(token), session)
+	if err != nil {
+		return "", errors.New(err, "cannot mint token")
+	}
+	return token, nil
+}
+
+// Tokens returns the API tokens belonging to the user with the given uid,
+// keyed by the digest of each token. Calendar feed tokens are not included.
+func (creds *Creds) Tokens(uid site.Uid) (map[string]Session, error) {
+	sessions, err := creds.Store.Sessions(uid)
+	if err != nil {
+		return nil, errors.New(err, "cannot list tokens")
+	}
+	for id, session := range sessions {
+		if session.Scope == scopeSession || session.Scope == scopeFeed {
+			delete(sessions, id)
+		}
+	}
+	return sessions, nil
+}
+
+// Revoke deletes the API or feed token with the given digest, provided that it
+// belongs to the user with the given uid.
+func (creds *Creds) Revoke(uid site.Uid, id string) error {
+	session, err := creds.Store.Session(id)
+	if err != nil || session.Uid != uid || session.Scope == scopeSession {
+		return errors.New(err, "no such token")
+	}
+	err = creds.Store.DeleteSession(id)
+	if err != nil {
+		return errors.New(err, "cannot revoke token")
+	}
+	return nil
+}
+
 func (creds *Creds) LookupUid(school, username string) (site.User, error) {
-	uid := site.Uid{school, username}
-	user := creds.Users[uid]
-	if user.Username == "" {
-		return site.User{}, errors.New(nil, `no user with matching uid: {"%s", "%s"}`, school, username)
+	uid := site.Uid{School: school, Username: username}
+	return creds.Store.User(uid)
+}
+
+// fallback returns the cached user matching the given credentials, for use when
+// the user cannot be authenticated by any of their school's platforms.
+func (creds *Creds) fallback(school, username, password string) (site.User, error) {
+	user, err := creds.LookupUid(school, username)
+	if err != nil {
+		return site.User{}, errors.Wrap(err)
+	}
+	plain, err := user.Unseal()
+	if err != nil {
+		return site.User{}, errors.Wrap(err)
+	}
+	if subtle.ConstantTimeCompare([]byte(plain.Password), []byte(password)) != 1 {
+		return site.User{}, errors.New(nil, "password does not match cached user")
 	}
 	return user, nil
 }
 
-func (creds *Creds) Update(token string, user site.User) {
-	uid := site.Uid{user.School, user.Username}
-	creds.Mutex.Lock()
-	if token != "" {
-		creds.Tokens[token] = uid
+func (creds *Creds) Update(token string, user site.User, expiry time.Time) error {
+	uid := site.Uid{School: user.School, Username: user.Username}
+	creds.mu.Lock()
+	err := creds.Store.SetUser(user)
+	creds.mu.Unlock()
+	if err != nil {
+		return errors.New(err, "cannot update user")
+	}
+	if token == "" {
+		return nil
 	}
-	creds.Users[uid] = user
-	creds.Mutex.Unlock()
+	session := Session{
+		Uid:     uid,
+		Scope:   scopeSession,
+		Created: time.Now(),
+		Expiry:  expiry,
+	}
+	err = creds.Store.SetSession(digest(token), session)
+	if err != nil {
+		return errors.New(err, "cannot update session")
+	}
+	return nil
 }
 
-func auth(school, email, username, password string) (site.User, error) {
-	var err error
+// SetToken replaces the sealed token for platform of the user identified by
+// uid. It is called by a school's platform multiplexer when it authenticates
+// the user again after their session with the platform has expired.
+func (creds *Creds) SetToken(uid site.Uid, platform, token string) error {
+	creds.mu.Lock()
+	defer creds.mu.Unlock()
+	user, err := creds.Store.User(uid)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	tokens := make(map[string]string, len(user.SiteTokens))
+	for k, v := range user.SiteTokens {
+		tokens[k] = v
+	}
+	tokens[platform] = token
+	user.SiteTokens = tokens
+	err = creds.Store.SetUser(user)
+	if err != nil {
+		return errors.New(err, "cannot update user")
+	}
+	return nil
+}
 
-	if school == "gihs" && !strings.HasPrefix(strings.ToUpper(username), `CURRIC\`) {
-		username = `CURRIC\` + username
-	} else if school == "gihs" {
-		username = strings.ToUpper(username)
+// auth authenticates a user of the given school with the school's platforms,
+// returning the user's details on success.
+func auth(ctx context.Context, schoolId, email, username, password string) (site.User, error) {
+	school, ok := schools[schoolId]
+	if !ok {
+		return site.User{}, errors.New(nil, "unsupported school: %s", schoolId)
 	}
 
+	username = school.Username(username)
 	if slices.Has([]string{username, password}, "") {
 		return site.User{}, errors.New(nil, "username or password is empty")
 	}
 
 	user := site.User{
-		School:     school,
+		School:     schoolId,
 		Email:      email,
-		DispName:   username,
+		DispName:   school.DispName(username),
 		Username:   username,
 		Password:   password,
+		Timezone:   school.Timezone,
 		SiteTokens: make(map[string]string),
 	}
-
-	switch school {
-	case "gihs":
-		user.Timezone, err = time.LoadLocation("Australia/Adelaide")
-		if err != nil {
-			return site.User{}, errors.New(err, "cannot load timezone")
-		}
-		user.DispName = strings.TrimPrefix(username, `CURRIC\`)
-		err = schools["gihs"].Auth(&user)
-		if err != nil {
-			return site.User{}, errors.Wrap(err)
-		}
-	case "uofa":
-		user.Timezone, err = time.LoadLocation("Australia/Adelaide")
-		if err != nil {
-			return site.User{}, errors.New(err, "cannot load timezone")
-		}
-		err = schools["uofa"].Auth(&user)
-		if err != nil {
-			return site.User{}, errors.Wrap(err)
-		}
-	case "example":
-		user.Timezone = time.UTC
-		err = schools["example"].Auth(&user)
-		if err != nil {
-			return site.User{}, errors.Wrap(err)
-		}
-	default:
-		return site.User{}, errors.New(nil, "unsupported school: %s", school)
+	err := school.Auth(ctx, &user)
+	if err != nil {
+		return site.User{}, errors.Wrap(err)
 	}
-
 	return user, nil
 }
 
-func (creds *Creds) Expire(token string, expiry time.Time) {
-	time.Sleep(time.Until(expiry))
-	creds.Mutex.Lock()
-	delete(creds.Tokens, token)
-	creds.Mutex.Unlock()
+// Sweep periodically removes expired sessions from the store, waiting for the
+// given interval between each sweep. Sweep never returns.
+func (creds *Creds) Sweep(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		err := creds.Store.Sweep(time.Now())
+		if err != nil {
+			logger.Error(errors.New(err, "cannot sweep expired sessions"))
+		}
+	}
 }
 
-func (creds *Creds) Login(query url.Values) (string, error) {
+func (creds *Creds) Login(ctx context.Context, query url.Values) (string, error) {
 	school := query.Get("school")
 	email := query.Get("email")
 	username := query.Get("user")
 	password := query.Get("password")
 
-	user, err := auth(school, email, username, password)
+	user, err := auth(ctx, school, email, username, password)
 	if err != nil {
-		logger.Debug(err)
-		user, err = creds.LookupUid(school, username)
-		if err != nil {
+		cached, ferr := creds.fallback(school, username, password)
+		if ferr != nil {
+			logger.Debug(ferr)
 			return "", errors.New(err, "login failed")
 		}
+		logger.Debug(err)
+		user = cached
 	}
 
-	buf := make([]byte, 32)
-	_, err = rand.Read(buf)
+	token, err := newToken()
 	if err != nil {
 		return "", errors.New(err, "login failed")
 	}
 
-	token := base64.StdEncoding.EncodeToString(buf)
-	expiry := time.Now().UTC().AddDate(0, 0, 3)
+	expiry := time.Now().UTC().Add(sessionLifetime)
 	cookie := fmt.Sprintf(
-		"token=%s; Expires=%s",
-		token, expiry.Format(time.RFC1123),
+		"token=%s; Expires=%s; HttpOnly; SameSite=Lax",
+		token, expiry.Format(http.TimeFormat),
 	)
 
-	go creds.Expire(token, expiry)
-	creds.Update(token, user)
+	err = creds.Update(token, user, expiry)
+	if err != nil {
+		return "", errors.New(err, "login failed")
+	}
 	return cookie, nil
 }
 
-func (creds *Creds) Logout(cookie string) error {
-	token, err := extract(cookie)
+func (creds *Creds) Logout(r *http.Request) error {
+	token, err := token(r)
+	if err != nil {
+		return errors.New(err, "cannot logout user")
+	}
+	err = creds.Store.DeleteSession(digest(token))
 	if err != nil {
 		return errors.New(err, "cannot logout user")
 	}
-	creds.Mutex.Lock()
-	delete(creds.Tokens, token)
-	creds.Mutex.Unlock()
 	return nil
 }
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"main/site"
+)
+
+func TestLookupFeed(t *testing.T) {
+	store := newMemStore()
+	setStore(t, store)
+	uid := site.Uid{School: "school", Username: "student"}
+	err := store.SetUser(site.User{School: uid.School, Username: uid.Username, Timezone: time.UTC})
+	if err != nil {
+		t.Fatal(err)
+	}
+	feed, err := creds.MintFeed(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := creds.Mint(uid, "Reader", scopeRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := "session-token"
+	err = store.SetSession(digest(session), Session{Uid: uid, Scope: scopeSession})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := "expired-token"
+	err = store.SetSession(digest(expired), Session{Uid: uid, Scope: scopeFeed, Expiry: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"feed", feed, true},
+		{"read", read, false},
+		{"session", session, false},
+		{"expired", expired, false},
+		{"unknown", "unknown-token", false},
+		{"missing", "", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/timetable.ics?token="+url.QueryEscape(test.token), nil)
+		user, err := creds.LookupFeed(r)
+		if test.ok && (err != nil || user.Username != uid.Username) {
+			t.Errorf("%s: got %q, %v, want the feed's user", test.name, user.Username, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: token was accepted", test.name)
+		}
+	}
+
+	// The feed token permits nothing else.
+	if _, err := creds.authorize(feed, scopeRead); err == nil {
+		t.Errorf("feed token was accepted for reading")
+	}
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/site"
+)
+
+// Kinds of changes detected between syncs.
+const (
+	changeNewTask         = "new-task"
+	changeDueMoved        = "due-moved"
+	changeGraded          = "graded"
+	changeNewResource     = "new-resource"
+	changeRoomChanged     = "room-changed"
+	changeLessonCancelled = "lesson-cancelled"
+)
+
+// maxChanges is the number of changes kept in each user's activity feed.
+const maxChanges = 200
+
+// Change is a change to a user's tasks, graded tasks, resources or lessons,
+// detected by comparing the data from two syncs.
+type Change struct {
+	Kind     string    `json:"kind"`
+	Detected time.Time `json:"detected"`
+	// the task or resource which changed; empty for lessons
+	Platform string `json:"platform,omitempty"`
+	Id       string `json:"id,omitempty"`
+	Name     string `json:"name"`
+	Class    string `json:"class"`
+	// the due date of a task, or the start of a lesson
+	Time time.Time `json:"time"`
+	// the previous due date of a task whose due date moved
+	Previous time.Time `json:"previous"`
+	// the previous and new grade of a graded task, or room of a lesson
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// itemState is the state of a task, resource or lesson as of a sync, holding
+// only the fields whose changes are detected.
+type itemState struct {
+	Platform string    `json:"platform,omitempty"`
+	Id       string    `json:"id,omitempty"`
+	Name     string    `json:"name"`
+	Class    string    `json:"class"`
+	Time     time.Time `json:"time"`
+	Grade    string    `json:"grade,omitempty"`
+	Room     string    `json:"room,omitempty"`
+}
+
+// Activity is the activity feed of a user, together with the state of their
+// data as of the last sync of each type, against which the next sync is
+// compared.
+type Activity struct {
+	// the most recent changes, newest first
+	Changes []Change `json:"changes"`
+	// when the user last viewed their activity feed
+	Seen time.Time `json:"seen"`
+	// the state of each type of synced data, keyed by item
+	States map[string]map[string]itemState `json:"states"`
+	// the period covered by the last sync of lessons
+	LessonsFrom time.Time `json:"lessonsFrom"`
+	LessonsTo   time.Time `json:"lessonsTo"`
+	// the due date of each task, keyed by platform and ID, of which the user
+	// has been reminded
+	Reminded map[string]time.Time `json:"reminded,omitempty"`
+}
+
+// guards reads and updates of activity feeds, which may be updated by several
+// syncs for the same user at once
+var activityMutex sync.Mutex
+
+func taskStates(tasks []site.Task) map[string]itemState {
+	states := make(map[string]itemState)
+	for _, task := range tasks {
+		states[task.Platform+"/"+task.Id] = itemState{
+			Platform: task.Platform,
+			Id:       task.Id,
+			Name:     task.Name,
+			Class:    task.Class,
+			Time:     task.Due,
+			Grade:    gradeStr(task),
+		}
+	}
+	return states
+}
+
+func resourceStates(resources []site.Resource) map[string]itemState {
+	states := make(map[string]itemState)
+	for _, res := range resources {
+		states[res.Platform+"/"+res.Id] = itemState{
+			Platform: res.Platform,
+			Id:       res.Id,
+			Name:     res.Name,
+			Class:    res.Class,
+			Time:     res.Posted,
+		}
+	}
+	return states
+}
+
+// Lessons have no identifiers, so each is identified by its class and start.
+func lessonStates(lessons []site.Lesson) map[string]itemState {
+	states := make(map[string]itemState)
+	for _, lesson := range lessons {
+		key := lesson.Class + "@" + strconv.FormatInt(lesson.Start.Unix(), 10)
+		states[key] = itemState{
+			Name:  lesson.Class,
+			Class: lesson.Class,
+			Time:  lesson.Start,
+			Room:  lesson.Room,
+		}
+	}
+	return states
+}
+
+// diffStates returns the changes between the old and new state of a type of
+// data, detected at now. For lessons, only lessons between from and to, which
+// must be covered by both states, are compared.
+func diffStates(kind string, old, new map[string]itemState, from, to, now time.Time) []Change {
+	var changes []Change
+	change := func(kind string, item itemState) Change {
+		return Change{
+			Kind:     kind,
+			Detected: now,
+			Platform: item.Platform,
+			Id:       item.Id,
+			Name:     item.Name,
+			Class:    item.Class,
+			Time:     item.Time,
+		}
+	}
+	switch kind {
+	case syncTasks:
+		for key, item := range new {
+			prev, ok := old[key]
+			if !ok {
+				changes = append(changes, change(changeNewTask, item))
+			} else if !item.Time.IsZero() && !item.Time.Equal(prev.Time) {
+				c := change(changeDueMoved, item)
+				c.Previous = prev.Time
+				changes = append(changes, c)
+			}
+		}
+	case syncGraded:
+		for key, item := range new {
+			prev, ok := old[key]
+			if !ok || prev.Grade != item.Grade {
+				c := change(changeGraded, item)
+				c.From, c.To = prev.Grade, item.Grade
+				changes = append(changes, c)
+			}
+		}
+	case syncResources:
+		for key, item := range new {
+			if _, ok := old[key]; !ok {
+				changes = append(changes, change(changeNewResource, item))
+			}
+		}
+	case syncLessons:
+		for key, prev := range old {
+			if prev.Time.Before(from) || !prev.Time.Before(to) {
+				continue
+			}
+			item, ok := new[key]
+			if !ok {
+				changes = append(changes, change(changeLessonCancelled, prev))
+			} else if item.Room != prev.Room {
+				c := change(changeRoomChanged, item)
+				c.From, c.To = prev.Room, item.Room
+				changes = append(changes, c)
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if !changes[i].Time.Equal(changes[j].Time) {
+			return changes[i].Time.After(changes[j].Time)
+		}
+		return changes[i].Name < changes[j].Name
+	})
+	return changes
+}
+
+// recordChanges compares the state of a type of data just synced for the user
+// with the given uid against the state from their previous sync of it, adding
+// any changes to their activity feed, and returns the changes. Lessons are
+// synced for the period from start to end. The first sync of each type of data
+// only records its state.
+func recordChanges(uid site.Uid, kind string, states map[string]itemState, start, end time.Time) ([]Change, error) {
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+	activity, err := creds.Store.Activity(uid)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	if activity.States == nil {
+		activity.States = make(map[string]map[string]itemState)
+	}
+	var changes []Change
+	if old, ok := activity.States[kind]; ok {
+		// Lessons can only be compared over the period covered by both
+		// syncs.
+		from, to := start, end
+		if kind == syncLessons {
+			from = maxTime(start, activity.LessonsFrom)
+			to = minTime(end, activity.LessonsTo)
+		}
+		changes = diffStates(kind, old, states, from, to, time.Now())
+		activity.Changes = append(changes, activity.Changes...)
+		if len(activity.Changes) > maxChanges {
+			activity.Changes = activity.Changes[:maxChanges]
+		}
+	}
+	activity.States[kind] = states
+	if kind == syncLessons {
+		activity.LessonsFrom, activity.LessonsTo = start, end
+	}
+	err = creds.Store.SetActivity(uid, activity)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return changes, nil
+}
+
+// seeActivity returns the activity feed of the user with the given uid, and
+// marks it as seen.
+func seeActivity(uid site.Uid) (Activity, error) {
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+	activity, err := creds.Store.Activity(uid)
+	if err != nil {
+		return Activity{}, errors.Wrap(err)
+	}
+	seen := activity
+	seen.Seen = time.Now()
+	err = creds.Store.SetActivity(uid, seen)
+	if err != nil {
+		return Activity{}, errors.Wrap(err)
+	}
+	return activity, nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
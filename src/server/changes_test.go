@@ -0,0 +1,154 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"main/site"
+)
+
+func TestDiffStates(t *testing.T) {
+	now := time.Date(2024, 5, 6, 12, 0, 0, 0, time.UTC)
+	due := now.AddDate(0, 0, 7)
+	moved := due.AddDate(0, 0, 2)
+	from, to := now.AddDate(0, 0, -1), now.AddDate(0, 0, 5)
+	essay := itemState{Platform: "daymap", Id: "1", Name: "Essay", Class: "English", Time: due}
+	lab := itemState{Platform: "daymap", Id: "2", Name: "Lab report", Class: "Chemistry", Time: due}
+	maths := itemState{Name: "Maths", Class: "Maths", Time: now, Room: "B12"}
+	late := itemState{Name: "Maths", Class: "Maths", Time: to, Room: "B12"}
+
+	with := func(item itemState, f func(*itemState)) itemState {
+		f(&item)
+		return item
+	}
+	states := func(items ...itemState) map[string]itemState {
+		m := make(map[string]itemState)
+		for _, item := range items {
+			m[item.Platform+"/"+item.Id+"/"+item.Name] = item
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		kind     string
+		old, new map[string]itemState
+		want     []string
+	}{
+		{"tasks unchanged", syncTasks, states(essay, lab), states(essay, lab), nil},
+		{"tasks added", syncTasks, states(essay), states(essay, lab), []string{changeNewTask + " Lab report"}},
+		{"tasks removed", syncTasks, states(essay, lab), states(essay), nil},
+		{"tasks due moved", syncTasks, states(essay), states(with(essay, func(i *itemState) { i.Time = moved })), []string{changeDueMoved + " Essay"}},
+		{"tasks due cleared", syncTasks, states(essay), states(with(essay, func(i *itemState) { i.Time = time.Time{} })), nil},
+		{"graded unchanged", syncGraded, states(with(essay, func(i *itemState) { i.Grade = "A" })), states(with(essay, func(i *itemState) { i.Grade = "A" })), nil},
+		{"graded added", syncGraded, states(), states(with(essay, func(i *itemState) { i.Grade = "A" })), []string{changeGraded + " Essay"}},
+		{"graded removed", syncGraded, states(with(essay, func(i *itemState) { i.Grade = "A" })), states(), nil},
+		{"graded changed", syncGraded, states(with(essay, func(i *itemState) { i.Grade = "B" })), states(with(essay, func(i *itemState) { i.Grade = "A" })), []string{changeGraded + " Essay"}},
+		{"resources unchanged", syncResources, states(essay), states(essay), nil},
+		{"resources added", syncResources, states(essay), states(essay, lab), []string{changeNewResource + " Lab report"}},
+		{"resources removed", syncResources, states(essay, lab), states(lab), nil},
+		{"resources renamed", syncResources, states(essay), states(with(essay, func(i *itemState) { i.Name = "Draft" })), []string{changeNewResource + " Draft"}},
+		{"lessons unchanged", syncLessons, states(maths), states(maths), nil},
+		{"lessons added", syncLessons, states(), states(maths), nil},
+		{"lessons removed", syncLessons, states(maths), states(), []string{changeLessonCancelled + " Maths"}},
+		{"lessons room changed", syncLessons, states(maths), states(with(maths, func(i *itemState) { i.Room = "C3" })), []string{changeRoomChanged + " Maths"}},
+		{"lessons outside period", syncLessons, states(late), states(), nil},
+	}
+	for _, test := range tests {
+		changes := diffStates(test.kind, test.old, test.new, from, to, now)
+		var got []string
+		for _, c := range changes {
+			got = append(got, c.Kind+" "+c.Name)
+			if !c.Detected.Equal(now) {
+				t.Errorf("%s: change detected at %v, want %v", test.name, c.Detected, now)
+			}
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got changes %q, want %q", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got changes %q, want %q", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestDiffStatesDetails(t *testing.T) {
+	now := time.Now()
+	due := now.AddDate(0, 0, 7)
+	old := map[string]itemState{
+		"daymap/1": {Platform: "daymap", Id: "1", Name: "Essay", Time: due, Grade: "B"},
+	}
+	new := map[string]itemState{
+		"daymap/1": {Platform: "daymap", Id: "1", Name: "Essay", Time: due.AddDate(0, 0, 1), Grade: "A"},
+	}
+	changes := diffStates(syncTasks, old, new, time.Time{}, time.Time{}, now)
+	if len(changes) != 1 || !changes[0].Previous.Equal(due) || changes[0].Id != "1" {
+		t.Errorf("due moved: got %+v, want previous due date %v", changes, due)
+	}
+	changes = diffStates(syncGraded, old, new, time.Time{}, time.Time{}, now)
+	if len(changes) != 1 || changes[0].From != "B" || changes[0].To != "A" {
+		t.Errorf("graded: got %+v, want grade from B to A", changes)
+	}
+}
+
+func TestRecordChanges(t *testing.T) {
+	setStore(t, newMemStore())
+	uid := site.Uid{School: "school", Username: "student"}
+	essay := itemState{Platform: "daymap", Id: "1", Name: "Essay"}
+	lab := itemState{Platform: "daymap", Id: "2", Name: "Lab report"}
+
+	// The first sync only records the state.
+	changes, err := recordChanges(uid, syncTasks, map[string]itemState{"daymap/1": essay}, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("first sync: got %d changes, want none", len(changes))
+	}
+
+	changes, err = recordChanges(uid, syncTasks, map[string]itemState{"daymap/1": essay, "daymap/2": lab}, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Kind != changeNewTask || changes[0].Id != "2" {
+		t.Errorf("second sync: got %+v, want new task 2", changes)
+	}
+
+	// An unchanged sync adds nothing to the feed.
+	changes, err = recordChanges(uid, syncTasks, map[string]itemState{"daymap/1": essay, "daymap/2": lab}, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("unchanged sync: got %d changes, want none", len(changes))
+	}
+	activity, err := creds.Store.Activity(uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activity.Changes) != 1 || len(activity.States[syncTasks]) != 2 {
+		t.Errorf("activity has %d changes and %d task states, want 1 and 2", len(activity.Changes), len(activity.States[syncTasks]))
+	}
+
+	// Lessons are only compared over the period covered by both syncs.
+	monday := time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)
+	lessons := map[string]itemState{
+		"a": {Name: "Maths", Time: monday.Add(9 * time.Hour)},
+		"b": {Name: "Physics", Time: monday.AddDate(0, 0, 4).Add(9 * time.Hour)},
+	}
+	_, err = recordChanges(uid, syncLessons, lessons, monday, monday.AddDate(0, 0, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, err = recordChanges(uid, syncLessons, map[string]itemState{}, monday, monday.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Name != "Maths" || changes[0].Kind != changeLessonCancelled {
+		t.Errorf("lessons: got %+v, want Maths cancelled", changes)
+	}
+}
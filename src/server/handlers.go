@@ -1,41 +1,56 @@
 package server
 
 import (
+	"encoding/json"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	path "path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"git.sr.ht/~kvo/go-std/errors"
 
 	"main/logger"
+	"main/notify"
 	"main/site"
 )
 
 // Handle things like submission and file uploads/removals.
 func handleTask(r *http.Request, user site.User, platform, id, cmd string) (int, pageData, [][2]string) {
+	ctx := r.Context()
 	data := pageData{}
 
 	res := r.URL.EscapedPath()
 	statusCode := 200
 	var headers [][2]string
 
+	if cmd == "submit" || cmd == "upload" || cmd == "remove" {
+		_, err := creds.Authorize(r, scopeSubmit)
+		if err != nil {
+			logger.Debug(errors.New(err, "cannot modify task"))
+			data = statusForbiddenData
+			statusCode = 403
+			return statusCode, data, headers
+		}
+	}
+
 	if cmd == "submit" {
 		school, ok := schools[user.School]
 		if !ok {
-			logger.Debug(errors.New(nil, "unsupported platform"))
+			logger.Debug(errors.New(nil, "unsupported school"))
 			data = statusServerErrorData
 			statusCode = 500
 			return statusCode, data, headers
 		}
-		err := school.Submit(user, platform, id)
+		err := school.Submit(ctx, user, platform, id)
 		if err != nil {
 			logger.Debug(errors.New(err, "cannot submit task"))
-			data = statusServerErrorData
-			statusCode = 500
-			return statusCode, data, headers
+			f := failureOf(err)
+			return f.status, f.page(), f.headers()
 		}
 		index := strings.Index(res, "/submit")
 		headers = [][2]string{{"Location", res[:index]}}
@@ -43,17 +58,16 @@ func handleTask(r *http.Request, user site.User, platform, id, cmd string) (int,
 	} else if cmd == "upload" {
 		school, ok := schools[user.School]
 		if !ok {
-			logger.Debug(errors.New(nil, "unsupported platform"))
+			logger.Debug(errors.New(nil, "unsupported school"))
 			data = statusServerErrorData
 			statusCode = 500
 			return statusCode, data, headers
 		}
-		err := school.UploadWork(user, platform, id, r)
+		err := school.UploadWork(ctx, user, platform, id, r)
 		if err != nil {
 			logger.Debug(errors.New(err, "cannot upload work"))
-			data = statusServerErrorData
-			statusCode = 500
-			return statusCode, data, headers
+			f := failureOf(err)
+			return f.status, f.page(), f.headers()
 		}
 		index := strings.Index(res, "/upload")
 		headers = [][2]string{{"Location", res[:index]}}
@@ -65,17 +79,16 @@ func handleTask(r *http.Request, user site.User, platform, id, cmd string) (int,
 		}
 		school, ok := schools[user.School]
 		if !ok {
-			logger.Debug(errors.New(nil, "unsupported platform"))
+			logger.Debug(errors.New(nil, "unsupported school"))
 			data = statusServerErrorData
 			statusCode = 500
 			return statusCode, data, headers
 		}
-		err := school.RemoveWork(user, platform, id, filenames)
+		err := school.RemoveWork(ctx, user, platform, id, filenames)
 		if err != nil {
 			logger.Debug(errors.New(err, "cannot remove worklink"))
-			data = statusServerErrorData
-			statusCode = 500
-			return statusCode, data, headers
+			f := failureOf(err)
+			return f.status, f.page(), f.headers()
 		}
 		index := strings.Index(res, "/remove")
 		headers = [][2]string{{"Location", res[:index]}}
@@ -111,20 +124,20 @@ func handleTaskReq(r *http.Request, user site.User) (int, pageData, [][2]string)
 	if index == -1 {
 		school, ok := schools[user.School]
 		if !ok {
-			logger.Debug(errors.New(nil, "unsupported platform"))
+			logger.Debug(errors.New(nil, "unsupported school"))
 			data = statusServerErrorData
 			statusCode = 500
 			return statusCode, data, headers
 		}
-		assignment, err := school.Task(user, platform, taskId)
-		if err != nil {
+		assignment, err := school.Task(r.Context(), user, platform, taskId)
+		if !site.Usable(err) {
 			logger.Debug(errors.New(err, "cannot fetch task"))
-			data = statusServerErrorData
-			statusCode = 500
-			return statusCode, data, headers
+			f := failureOf(err)
+			return f.status, f.page(), f.headers()
 		}
 
 		data = genTaskPage(assignment, user)
+		data.partial(err)
 	} else {
 		taskCmd := taskId[index+1:]
 		taskId = taskId[:index]
@@ -150,6 +163,19 @@ func genPage(w http.ResponseWriter, data pageData) {
 	}
 }
 
+// Respond to a request which failed because of err with an error page
+// explaining the failure to the user.
+func failPage(w http.ResponseWriter, err error, user site.User) {
+	f := failureOf(err)
+	for _, header := range f.headers() {
+		w.Header().Set(header[0], header[1])
+	}
+	w.WriteHeader(f.status)
+	data := f.page()
+	data.User = userData{Name: user.DispName}
+	genPage(w, data)
+}
+
 // Responds to the client with the requested resources.
 func dispatchAsset(w http.ResponseWriter, fullPath string, mimeType string) {
 	w.Header().Set("Content-Type", mimeType+`, charset="utf-8"`)
@@ -221,6 +247,12 @@ func assetHandler(w http.ResponseWriter, r *http.Request) {
 		fullPath := path.Join(respath, "styles.css")
 		dispatchAsset(w, fullPath, "text/css")
 
+	} else if res == "/sw.js" {
+		// The service worker receives push notifications for every page.
+		w.Header().Set("Service-Worker-Allowed", "/")
+		fullPath := path.Join(respath, "sw.js")
+		dispatchAsset(w, fullPath, "text/javascript")
+
 	} else if res == "/script.js" {
 		w.Header().Set("Cache-Control", "max-age=3600")
 		fullPath := path.Join(respath, "script.js")
@@ -251,17 +283,23 @@ func assetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Handle login requests. If the user is already logged in, redirect to the timetable view.
+// Handle login requests. If the user is already logged in, redirect to the dashboard.
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 	redirect := r.URL.Query().Get("redirect")
 
-	_, err := creds.LookupToken(r.Header.Get("Cookie"))
+	_, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
 
 	data := loginPageData
+	for _, school := range site.SortSchools(schools) {
+		data.Body.LoginData.Schools = append(
+			data.Body.LoginData.Schools,
+			schoolItem{Id: school.Id, Name: school.Name},
+		)
+	}
 	if strings.HasPrefix(redirect, "/") {
 		data.Body.LoginData.Redirect = "?" + r.URL.RawQuery
 	}
@@ -271,11 +309,16 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(401)
 			data.Body.LoginData.Failed = true
 			genPage(w, data)
+		} else if r.URL.Query().Get("auth") == "unavailable" {
+			w.Header().Set("Retry-After", retryAfter)
+			w.WriteHeader(503)
+			data.Body.LoginData.Unavailable = true
+			genPage(w, data)
 		} else {
 			genPage(w, data)
 		}
 	} else if !strings.HasPrefix(redirect, "/") {
-		w.Header().Set("Location", "/timetable")
+		w.Header().Set("Location", "/")
 		w.WriteHeader(302)
 	} else {
 		w.Header().Set("Location", redirect)
@@ -283,11 +326,11 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Handle authentication requests. If the user is already logged in, redirect to the timetable view.
+// Handle authentication requests. If the user is already logged in, redirect to the dashboard.
 func authHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 
-	_, err := creds.LookupToken(r.Header.Get("Cookie"))
+	_, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
@@ -299,18 +342,22 @@ func authHandler(w http.ResponseWriter, r *http.Request) {
 		// If err != nil, the "else" section of the next if/else block will
 		// execute, which returns the "could not authenticate user" error.
 		if err == nil {
-			cookie, err = creds.Login(r.PostForm)
+			cookie, err = creds.Login(r.Context(), r.PostForm)
 		}
 
 		redirect := r.URL.Query().Get("redirect")
 		if !strings.HasPrefix(redirect, "/") {
-			redirect = "/timetable"
+			redirect = "/"
 		}
 
 		if err == nil {
 			w.Header().Set("Location", redirect)
 			w.Header().Set("Set-Cookie", cookie)
 			w.WriteHeader(302)
+		} else if site.Kind(err) == site.ErrUnavailable {
+			logger.Debug(errors.New(err, "auth failed"))
+			w.Header().Set("Location", "/login?auth=unavailable")
+			w.WriteHeader(302)
 		} else {
 			logger.Debug(errors.New(err, "auth failed"))
 			w.Header().Set("Location", "/login?auth=failed")
@@ -326,13 +373,13 @@ func authHandler(w http.ResponseWriter, r *http.Request) {
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 
-	user, err := creds.LookupToken(r.Header.Get("Cookie"))
+	user, err := creds.Authorize(r, scopeSession)
 	if err != nil {
 		validAuth = false
 	}
 
 	if validAuth {
-		err = creds.Logout(r.Header.Get("Cookie"))
+		err = creds.Logout(r)
 		if err == nil {
 			w.Header().Set("Location", "/login")
 			w.WriteHeader(302)
@@ -354,7 +401,7 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 func resourceHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 
-	user, err := creds.LookupToken(r.Header.Get("Cookie"))
+	user, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
@@ -380,24 +427,22 @@ func resourceHandler(w http.ResponseWriter, r *http.Request) {
 
 		school, ok := schools[user.School]
 		if !ok {
-			logger.Debug(errors.New(nil, "unsupported platform"))
+			logger.Debug(errors.New(nil, "unsupported school"))
 			w.WriteHeader(500)
 			data := statusServerErrorData
 			data.User = userData{Name: user.DispName}
 			genPage(w, data)
 			return
 		}
-		res, err := school.Resource(user, platform, resId)
-		if err != nil {
+		res, err := school.Resource(r.Context(), user, platform, resId)
+		if !site.Usable(err) {
 			logger.Debug(errors.New(err, "cannot fetch task"))
-			w.WriteHeader(500)
-			data := statusServerErrorData
-			data.User = userData{Name: user.DispName}
-			genPage(w, data)
+			failPage(w, err, user)
 			return
 		}
 
 		respBody = genResPage(res, user)
+		respBody.partial(err)
 		w.WriteHeader(statusCode)
 		genPage(w, respBody)
 	} else {
@@ -407,11 +452,112 @@ func resourceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// inlineTypes are the types of attachments which browsers may display rather
+// than download. Other types, such as HTML, could run scripts with access to
+// TaskCollect if displayed.
+var inlineTypes = []string{
+	"application/pdf",
+	"audio/",
+	"image/gif",
+	"image/jpeg",
+	"image/png",
+	"image/webp",
+	"text/plain",
+	"video/",
+}
+
+// Handle attachments streamed from platforms (located under "/files/").
+func fileHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := creds.Lookup(r)
+	if err != nil {
+		redirect := "/login?redirect=" + url.QueryEscape(r.URL.String())
+		w.Header().Set("Location", redirect)
+		w.WriteHeader(302)
+		return
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(405)
+		return
+	}
+
+	platform, id, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/files/"), "/")
+	if ok {
+		id, err = url.PathUnescape(id)
+	}
+	if !ok || err != nil || id == "" {
+		w.WriteHeader(404)
+		data := statusNotFoundData
+		data.User = userData{Name: user.DispName}
+		genPage(w, data)
+		return
+	}
+
+	school, ok := schools[user.School]
+	if !ok {
+		logger.Debug(errors.New(nil, "unsupported school"))
+		w.WriteHeader(500)
+		data := statusServerErrorData
+		data.User = userData{Name: user.DispName}
+		genPage(w, data)
+		return
+	}
+	file, err := school.File(r.Context(), user, platform, id, r.Header.Get("Range"))
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot fetch file"))
+		if rangeErr, ok := site.OutOfRange(err); ok && rangeErr.Size >= 0 {
+			w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(rangeErr.Size, 10))
+		}
+		failPage(w, err, user)
+		return
+	}
+	defer file.Body.Close()
+
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	disposition := "attachment"
+	for _, t := range inlineTypes {
+		if strings.HasPrefix(mimeType, t) {
+			disposition = "inline"
+		}
+	}
+	if d := mime.FormatMediaType(disposition, map[string]string{"filename": file.Name}); d != "" {
+		disposition = d
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private")
+	if file.Ranges {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	if file.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+	}
+	statusCode := 200
+	if file.Range != "" {
+		w.Header().Set("Content-Range", file.Range)
+		statusCode = 206
+	}
+	w.WriteHeader(statusCode)
+	if r.Method == "HEAD" {
+		return
+	}
+	_, err = io.Copy(w, file.Body)
+	if err != nil {
+		logger.Debug(errors.New(err, "could not stream file"))
+	}
+}
+
 // Handle individual task pages (located under "/tasks/").
 func taskHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 
-	user, err := creds.LookupToken(r.Header.Get("Cookie"))
+	user, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
@@ -436,24 +582,16 @@ func taskHandler(w http.ResponseWriter, r *http.Request) {
 func tasksHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 
-	user, err := creds.LookupToken(r.Header.Get("Cookie"))
+	user, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
 
 	if validAuth {
-		webpageData, err := genRes("/tasks", user)
-		if errors.Is(err, errors.New(nil, "cannot find resource")) {
-			w.WriteHeader(404)
-			data := statusNotFoundData
-			data.User = userData{Name: user.DispName}
-			genPage(w, data)
-		} else if err != nil {
+		webpageData, err := genRes(r.Context(), "/tasks", user)
+		if err != nil {
 			logger.Debug(errors.New(err, "failed to generate resources"))
-			w.WriteHeader(500)
-			data := statusServerErrorData
-			data.User = userData{Name: user.DispName}
-			genPage(w, data)
+			failPage(w, err, user)
 		} else {
 			w.Header().Set("Cache-Control", "max-age=2400")
 			genPage(w, webpageData)
@@ -469,24 +607,16 @@ func tasksHandler(w http.ResponseWriter, r *http.Request) {
 func timetableHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 
-	user, err := creds.LookupToken(r.Header.Get("Cookie"))
+	user, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
 
 	if validAuth {
-		webpageData, err := genRes("/timetable", user)
-		if errors.Is(err, errors.New(nil, "cannot find resource")) {
-			w.WriteHeader(404)
-			data := statusNotFoundData
-			data.User = userData{Name: user.DispName}
-			genPage(w, data)
-		} else if err != nil {
+		webpageData, err := genRes(r.Context(), "/timetable", user)
+		if err != nil {
 			logger.Debug(errors.New(err, "failed to generate resources"))
-			w.WriteHeader(500)
-			data := statusServerErrorData
-			data.User = userData{Name: user.DispName}
-			genPage(w, data)
+			failPage(w, err, user)
 		} else {
 			w.Header().Set("Cache-Control", "max-age=2400")
 			genPage(w, webpageData)
@@ -502,26 +632,98 @@ func timetableHandler(w http.ResponseWriter, r *http.Request) {
 func gradesHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 
-	user, err := creds.LookupToken(r.Header.Get("Cookie"))
+	user, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
 
 	if validAuth {
-		webpageData, err := genRes("/grades", user)
-		if errors.Is(err, errors.New(nil, "cannot find resource")) {
-			w.WriteHeader(404)
-			data := statusNotFoundData
-			data.User = userData{Name: user.DispName}
-			genPage(w, data)
-		} else if err != nil {
+		webpageData, err := genRes(r.Context(), "/grades", user)
+		if err != nil {
 			logger.Debug(errors.New(err, "failed to generate resources"))
-			w.WriteHeader(500)
-			data := statusServerErrorData
+			failPage(w, err, user)
+		} else {
+			w.Header().Set("Cache-Control", "max-age=2400")
+			genPage(w, webpageData)
+		}
+	} else {
+		redirect := "/login?redirect=" + url.QueryEscape(r.URL.String())
+		w.Header().Set("Location", redirect)
+		w.WriteHeader(302)
+	}
+}
+
+// Handle the "/messages" page
+func messagesHandler(w http.ResponseWriter, r *http.Request) {
+	validAuth := true
+
+	user, err := creds.Lookup(r)
+	if err != nil {
+		validAuth = false
+	}
+
+	if validAuth {
+		webpageData, err := genRes(r.Context(), "/messages", user)
+		if err != nil {
+			logger.Debug(errors.New(err, "failed to generate resources"))
+			failPage(w, err, user)
+		} else {
+			genPage(w, webpageData)
+		}
+	} else {
+		redirect := "/login?redirect=" + url.QueryEscape(r.URL.String())
+		w.Header().Set("Location", redirect)
+		w.WriteHeader(302)
+	}
+}
+
+// Handle the "/activity" page
+func activityHandler(w http.ResponseWriter, r *http.Request) {
+	validAuth := true
+
+	user, err := creds.Lookup(r)
+	if err != nil {
+		validAuth = false
+	}
+
+	if validAuth {
+		webpageData, err := genRes(r.Context(), "/activity", user)
+		if err != nil {
+			logger.Debug(errors.New(err, "failed to generate resources"))
+			failPage(w, err, user)
+		} else {
+			genPage(w, webpageData)
+		}
+	} else {
+		redirect := "/login?redirect=" + url.QueryEscape(r.URL.String())
+		w.Header().Set("Location", redirect)
+		w.WriteHeader(302)
+	}
+}
+
+// Handle the "/search" page
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	validAuth := true
+
+	user, err := creds.Lookup(r)
+	if err != nil {
+		validAuth = false
+	}
+
+	if validAuth {
+		q, err := parseSearch(r.URL.Query(), user)
+		if err != nil {
+			w.WriteHeader(400)
+			data := statusBadRequestData
 			data.User = userData{Name: user.DispName}
 			genPage(w, data)
+			return
+		}
+		webpageData, err := genSearchPage(r.Context(), q, user)
+		if err != nil {
+			logger.Debug(errors.New(err, "failed to generate resources"))
+			failPage(w, err, user)
 		} else {
-			w.Header().Set("Cache-Control", "max-age=2400")
 			genPage(w, webpageData)
 		}
 	} else {
@@ -531,30 +733,112 @@ func gradesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Handle the "/reports" page, and downloads of individual reports from
+// "/reports/{platform}/{id}.pdf" and "/reports/{platform}/{id}.csv".
+func reportsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	validAuth := true
+
+	user, err := creds.Lookup(r)
+	if err != nil {
+		validAuth = false
+	}
+
+	if !validAuth {
+		redirect := "/login?redirect=" + url.QueryEscape(r.URL.String())
+		w.Header().Set("Location", redirect)
+		w.WriteHeader(302)
+		return
+	}
+
+	res := r.URL.EscapedPath()
+	if res == "/reports" || res == "/reports/" {
+		webpageData, err := genRes(ctx, "/reports", user)
+		if err != nil {
+			logger.Debug(errors.New(err, "failed to generate resources"))
+			failPage(w, err, user)
+		} else {
+			w.Header().Set("Cache-Control", "max-age=2400")
+			genPage(w, webpageData)
+		}
+		return
+	}
+
+	notFound := func() {
+		w.WriteHeader(404)
+		data := statusNotFoundData
+		data.User = userData{Name: user.DispName}
+		genPage(w, data)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(res, "/reports/"), "/")
+	if len(parts) != 2 {
+		notFound()
+		return
+	}
+	platform := parts[0]
+	id, ext, found := strings.Cut(parts[1], ".")
+	if !found || (ext != "pdf" && ext != "csv") {
+		notFound()
+		return
+	}
+
+	school, ok := schools[user.School]
+	if !ok {
+		logger.Debug(errors.New(nil, "unsupported school"))
+		w.WriteHeader(500)
+		genPage(w, statusServerErrorData)
+		return
+	}
+	reports, err := school.Reports(ctx, user)
+	if !site.Usable(err) {
+		logger.Debug(errors.New(err, "cannot fetch reports"))
+		failPage(w, err, user)
+		return
+	}
+	report, err := findReport(reports, platform, id)
+	if err != nil {
+		notFound()
+		return
+	}
+
+	var b strings.Builder
+	if ext == "pdf" {
+		err = ReportPDF(report, user, &b)
+		w.Header().Set("Content-Type", "application/pdf")
+	} else {
+		err = ReportCSV(report, &b)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	}
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot generate report"))
+		w.Header().Del("Content-Type")
+		w.WriteHeader(500)
+		genPage(w, statusServerErrorData)
+		return
+	}
+	filename := reportFilename(report) + "." + ext
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=2400")
+	io.WriteString(w, b.String())
+}
+
 // Handle the "/images"
 
 // Handle the "/res" page
 func resHandler(w http.ResponseWriter, r *http.Request) {
 	validAuth := true
 
-	user, err := creds.LookupToken(r.Header.Get("Cookie"))
+	user, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
 
 	if validAuth {
-		webpageData, err := genRes("/res", user)
-		if errors.Is(err, errors.New(nil, "cannot find resource")) {
-			w.WriteHeader(404)
-			data := statusNotFoundData
-			data.User = userData{Name: user.DispName}
-			genPage(w, data)
-		} else if err != nil {
+		webpageData, err := genRes(r.Context(), "/res", user)
+		if err != nil {
 			logger.Debug(errors.New(err, "failed to generate resources"))
-			w.WriteHeader(500)
-			data := statusServerErrorData
-			data.User = userData{Name: user.DispName}
-			genPage(w, data)
+			failPage(w, err, user)
 		} else {
 			w.Header().Set("Cache-Control", "max-age=2400")
 			genPage(w, webpageData)
@@ -566,11 +850,175 @@ func resHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Handle the "/settings" page, where users can mint, list and revoke API tokens,
+// and subscribe devices to push notifications.
+// Only login sessions may access this page; API tokens cannot be used to mint
+// further tokens.
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	validAuth := true
+
+	user, err := creds.Authorize(r, scopeSession)
+	if err != nil {
+		validAuth = false
+	}
+
+	if !validAuth {
+		redirect := "/login?redirect=" + url.QueryEscape(r.URL.String())
+		w.Header().Set("Location", redirect)
+		w.WriteHeader(302)
+		return
+	}
+
+	uid := site.Uid{School: user.School, Username: user.Username}
+	res := r.URL.EscapedPath()
+	var newName, newToken, feedURL string
+	failed := false
+
+	if res != "/settings" && r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(405)
+		return
+	}
+
+	switch res {
+	case "/settings":
+	case "/settings/tokens":
+		newName = r.PostFormValue("name")
+		newToken, err = creds.Mint(uid, newName, r.PostFormValue("scope"))
+		if err != nil {
+			logger.Debug(errors.New(err, "cannot mint token"))
+			failed = true
+		}
+	case "/settings/feed":
+		token, err := creds.MintFeed(uid)
+		if err != nil {
+			logger.Debug(errors.New(err, "cannot mint feed token"))
+			failPage(w, err, user)
+			return
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		feedURL = scheme + "://" + r.Host + "/timetable.ics?token=" + url.QueryEscape(token)
+	case "/settings/tokens/revoke":
+		err = creds.Revoke(uid, r.PostFormValue("id"))
+		if err != nil {
+			logger.Debug(errors.New(err, "cannot revoke token"))
+		}
+		w.Header().Set("Location", "/settings")
+		w.WriteHeader(302)
+		return
+	case "/settings/push":
+		// The subscription is posted as JSON by the settings page script.
+		var sub notify.Subscription
+		err = json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&sub)
+		if err != nil || notifications == nil || notifications.push == nil {
+			w.WriteHeader(400)
+			return
+		}
+		err = notify.CheckEndpoint(sub.Endpoint)
+		if err != nil {
+			logger.Debug(errors.New(err, "rejected push subscription"))
+			w.WriteHeader(400)
+			return
+		}
+		err = addSubscription(uid, sub)
+		if err != nil {
+			logger.Debug(errors.New(err, "cannot add push subscription"))
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(204)
+		return
+	case "/settings/push/remove":
+		err = creds.Store.SetSubscriptions(uid, nil)
+		if err != nil {
+			logger.Debug(errors.New(err, "cannot remove push subscriptions"))
+		}
+		w.Header().Set("Location", "/settings")
+		w.WriteHeader(302)
+		return
+	default:
+		w.WriteHeader(404)
+		data := statusNotFoundData
+		data.User = userData{Name: user.DispName}
+		genPage(w, data)
+		return
+	}
+
+	data, err := genSettingsPage(user)
+	if err != nil {
+		logger.Debug(errors.New(err, "failed to generate settings page"))
+		failPage(w, err, user)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if !failed {
+		data.Body.SettingsData.NewName = newName
+		data.Body.SettingsData.NewToken = newToken
+		data.Body.SettingsData.FeedURL = feedURL
+	} else {
+		w.WriteHeader(400)
+		data.Body.SettingsData.Failed = true
+	}
+	genPage(w, data)
+}
+
+// Handle the "/timetable.ics" calendar feed. Calendar apps authenticate with
+// the feed token in the query string; otherwise, any session or API token may
+// be used. The feed covers the previous week and the next eight weeks unless
+// a range is given by the "from" and "to" query parameters, and includes task
+// due dates if the "tasks" query parameter is "todo" or "event".
+func icsHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := creds.LookupFeed(r)
+	if err != nil {
+		user, err = creds.Lookup(r)
+	}
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="TaskCollect"`)
+		w.WriteHeader(401)
+		return
+	}
+
+	query := r.URL.Query()
+	tasks := query.Get("tasks")
+	if tasks != icalTasksNone && tasks != icalTasksTodo && tasks != icalTasksEvent {
+		w.WriteHeader(400)
+		return
+	}
+
+	today := midnight(time.Now().In(user.Timezone))
+	start, end := today.AddDate(0, 0, -7), today.AddDate(0, 0, 8*7)
+	if query.Has("from") || query.Has("to") {
+		start, end, err = lessonRange(r, user)
+		if err != nil {
+			logger.Debug(errors.New(err, "invalid calendar range"))
+			w.WriteHeader(400)
+			return
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+
+	var b strings.Builder
+	err = TimetableIcal(r.Context(), user, start, end, tasks, &b)
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot generate calendar"))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="timetable.ics"`)
+	w.Header().Set("Cache-Control", "private, max-age=900")
+	io.WriteString(w, b.String())
+}
+
 func rootHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	res := r.URL.EscapedPath()
 	validAuth := true
 
-	user, err := creds.LookupToken(r.Header.Get("Cookie"))
+	user, err := creds.Lookup(r)
 	if err != nil {
 		validAuth = false
 	}
@@ -584,13 +1032,18 @@ func rootHandler(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Location", redirect)
 		w.WriteHeader(302)
 	} else if validAuth && res == "/timetable.png" {
-		err := TimetablePNG(user, w)
+		err := TimetablePNG(ctx, user, w)
 		if err != nil {
 			logger.Error(err)
 		}
 	} else if validAuth && res == "/" {
-		w.Header().Set("Location", "/timetable")
-		w.WriteHeader(302)
+		webpageData, err := genRes(ctx, "/", user)
+		if err != nil {
+			logger.Debug(errors.New(err, "failed to generate resources"))
+			failPage(w, err, user)
+		} else {
+			genPage(w, webpageData)
+		}
 	} else if validAuth && res != "/" {
 		// Logged in, and the requested URL is not handled by anything else (it's a 404)
 		w.WriteHeader(404)
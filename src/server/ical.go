@@ -0,0 +1,174 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The maximum length of a content line in octets, excluding the line break, as
+// specified by RFC 5545.
+const icalLineLen = 75
+
+const (
+	icalUtcTime   = "20060102T150405Z"
+	icalLocalTime = "20060102T150405"
+	icalDate      = "20060102"
+)
+
+// calendar is an iCalendar (RFC 5545) document being written.
+type calendar struct {
+	b strings.Builder
+}
+
+// prop writes a content line with the given name and value, folding it across
+// several lines if it is too long. The value is written as is.
+func (c *calendar) prop(name, value string) {
+	line := name + ":" + value
+	n := 0
+	for len(line) > 0 {
+		max := icalLineLen
+		if n > 0 {
+			// continuation lines begin with a space
+			c.b.WriteByte(' ')
+			max--
+		}
+		i := len(line)
+		if i > max {
+			i = max
+			for i > 0 && !utf8.RuneStart(line[i]) {
+				i--
+			}
+		}
+		c.b.WriteString(line[:i])
+		c.b.WriteString("\r\n")
+		line = line[i:]
+		n++
+	}
+}
+
+// text writes a content line with the given name and TEXT value, escaping the
+// value as needed.
+func (c *calendar) text(name, value string) {
+	c.prop(name, icalEscape(value))
+}
+
+// time writes a content line with the given name and DATE-TIME value. Times in
+// UTC are written in UTC form; all others are written as local times with a
+// reference to the time zone of their location.
+func (c *calendar) time(name string, t time.Time) {
+	if t.Location() == time.UTC {
+		c.prop(name, t.Format(icalUtcTime))
+		return
+	}
+	c.prop(name+";TZID="+t.Location().String(), t.Format(icalLocalTime))
+}
+
+// date writes a content line with the given name and DATE value.
+func (c *calendar) date(name string, t time.Time) {
+	c.prop(name+";VALUE=DATE", t.Format(icalDate))
+}
+
+func (c *calendar) String() string {
+	return c.b.String()
+}
+
+// icalEscape escapes s for use as a TEXT value.
+func icalEscape(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+		"\r", "",
+	)
+	return r.Replace(s)
+}
+
+// icalUid returns a deterministic unique identifier derived from parts, so that
+// calendar apps recognise the same item across repeated fetches of a feed.
+func icalUid(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:16]) + "@taskcollect"
+}
+
+// offsetStr formats a UTC offset in seconds as a UTC-OFFSET value.
+func offsetStr(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	s := fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset/60%60)
+	if offset%60 != 0 {
+		s += fmt.Sprintf("%02d", offset%60)
+	}
+	return s
+}
+
+// transitions returns the instants from start to end at which the UTC offset of
+// loc changes.
+func transitions(loc *time.Location, start, end time.Time) []time.Time {
+	var found []time.Time
+	offsetAt := func(sec int64) int {
+		_, offset := time.Unix(sec, 0).In(loc).Zone()
+		return offset
+	}
+	// No zone changes its offset more than once a day, so checking each day
+	// and then searching for the exact second of the change is sufficient.
+	const day = 24 * 60 * 60
+	prev := offsetAt(start.Unix())
+	for lo := start.Unix(); lo < end.Unix(); lo += day {
+		hi := lo + day
+		if offsetAt(hi) == prev {
+			continue
+		}
+		a, b := lo, hi
+		for b-a > 1 {
+			mid := a + (b-a)/2
+			if offsetAt(mid) == prev {
+				a = mid
+			} else {
+				b = mid
+			}
+		}
+		found = append(found, time.Unix(b, 0).In(loc))
+		prev = offsetAt(hi)
+	}
+	return found
+}
+
+// vtimezone writes a VTIMEZONE component describing loc from start to end.
+// Observances are derived from the offset transitions of loc within that
+// period rather than from recurrence rules, which Go does not expose.
+func (c *calendar) vtimezone(loc *time.Location, start, end time.Time) {
+	observance := func(t time.Time, from int) {
+		name, offset := t.Zone()
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		c.prop("BEGIN", kind)
+		// DTSTART is given in the local time in effect before the onset
+		c.prop("DTSTART", t.In(time.FixedZone("", from)).Format(icalLocalTime))
+		c.prop("TZOFFSETFROM", offsetStr(from))
+		c.prop("TZOFFSETTO", offsetStr(offset))
+		c.text("TZNAME", name)
+		c.prop("END", kind)
+	}
+
+	c.prop("BEGIN", "VTIMEZONE")
+	c.prop("TZID", loc.String())
+	first := midnight(start.In(loc))
+	_, offset := first.Zone()
+	observance(first, offset)
+	for _, t := range transitions(loc, first, end) {
+		observance(t, offset)
+		_, offset = t.Zone()
+	}
+	c.prop("END", "VTIMEZONE")
+}
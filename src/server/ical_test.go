@@ -0,0 +1,133 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestICalFolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"short", "Essay"},
+		{"exact", strings.Repeat("x", icalLineLen-len("SUMMARY:"))},
+		{"long", strings.Repeat("abcdefghij", 20)},
+		{"multibyte", strings.Repeat("é", 100)},
+		{"mixed", "a" + strings.Repeat("日本語", 40)},
+	}
+	for _, test := range tests {
+		var c calendar
+		c.prop("SUMMARY", test.value)
+		out := c.String()
+		if !strings.HasSuffix(out, "\r\n") {
+			t.Errorf("%s: output %q does not end with a line break", test.name, out)
+			continue
+		}
+		lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+		for i, line := range lines {
+			if len(line) > icalLineLen {
+				t.Errorf("%s: line %d is %d octets long", test.name, i, len(line))
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("%s: line %d splits a character: %q", test.name, i, line)
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Errorf("%s: continuation line %d does not begin with a space", test.name, i)
+			}
+		}
+		if want := len("SUMMARY:"+test.value) <= icalLineLen; want != (len(lines) == 1) {
+			t.Errorf("%s: folded into %d lines", test.name, len(lines))
+		}
+		if got := strings.ReplaceAll(out, "\r\n ", ""); got != "SUMMARY:"+test.value+"\r\n" {
+			t.Errorf("%s: unfolded to %q", test.name, got)
+		}
+	}
+}
+
+func TestICalEscape(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"Essay", "Essay"},
+		{`C:\Work`, `C:\\Work`},
+		{"Maths; Science, English", `Maths\; Science\, English`},
+		{"one\r\ntwo\nthree\rfour", `one\ntwo\nthreefour`},
+		{`\n`, `\\n`},
+		{"Room: 12", "Room: 12"},
+	}
+	for _, test := range tests {
+		if got := icalEscape(test.s); got != test.want {
+			t.Errorf("icalEscape(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestICalTime(t *testing.T) {
+	var c calendar
+	c.time("DTSTART", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+	c.time("DTSTART", time.Date(2026, 3, 2, 9, 0, 0, 0, time.FixedZone("Test", 3600)))
+	c.date("DTSTART", time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))
+	want := "DTSTART:20260302T090000Z\r\n" +
+		"DTSTART;TZID=Test:20260302T090000\r\n" +
+		"DTSTART;VALUE=DATE:20260302\r\n"
+	if got := c.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestVTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("Australia/Adelaide")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Daylight saving time ends on 5 April 2026.
+	var c calendar
+	c.vtimezone(loc, time.Date(2026, 3, 1, 12, 0, 0, 0, loc), time.Date(2026, 5, 1, 0, 0, 0, 0, loc))
+	want := strings.Join([]string{
+		"BEGIN:VTIMEZONE",
+		"TZID:Australia/Adelaide",
+		"BEGIN:DAYLIGHT",
+		"DTSTART:20260301T000000",
+		"TZOFFSETFROM:+1030",
+		"TZOFFSETTO:+1030",
+		"TZNAME:ACDT",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"DTSTART:20260405T030000",
+		"TZOFFSETFROM:+1030",
+		"TZOFFSETTO:+0930",
+		"TZNAME:ACST",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+	}, "\r\n") + "\r\n"
+	if got := c.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Zones without transitions have a single observance.
+	c = calendar{}
+	c.vtimezone(time.FixedZone("Fixed", -90*60), time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC))
+	if got := strings.Count(c.String(), "BEGIN:STANDARD"); got != 1 || !strings.Contains(c.String(), "TZOFFSETTO:-0130") {
+		t.Errorf("fixed zone:\n%s", c.String())
+	}
+}
+
+func TestOffsetStr(t *testing.T) {
+	tests := []struct {
+		offset int
+		want   string
+	}{
+		{0, "+0000"},
+		{37800, "+1030"},
+		{-5400, "-0130"},
+		{-(4*3600 + 56*60 + 2), "-045602"},
+	}
+	for _, test := range tests {
+		if got := offsetStr(test.offset); got != test.want {
+			t.Errorf("offsetStr(%d) = %q, want %q", test.offset, got, test.want)
+		}
+	}
+}
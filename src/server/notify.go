@@ -0,0 +1,281 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+	"git.sr.ht/~kvo/go-std/slices"
+
+	"main/logger"
+	"main/notify"
+	"main/site"
+)
+
+// eventDue is the notification event for unsubmitted tasks which are due soon.
+// All other events are the kinds of changes detected between syncs.
+const eventDue = "due"
+
+// defaultDueWithin is the time before an unsubmitted task is due at which
+// users are reminded of it, unless set otherwise in their rule.
+const defaultDueWithin = 24 * time.Hour
+
+// notifyTimeout is the time allowed for each notification to be delivered.
+const notifyTimeout = 30 * time.Second
+
+// notifier notifies users of the changes detected by syncs, and of tasks due
+// soon, according to the rules in their notification preferences.
+type notifier struct {
+	transports []notify.Transport
+	// the transport delivering Web Push notifications, if enabled
+	push *notify.Push
+}
+
+// newNotifier returns a notifier with the transports configured by cfg. The
+// VAPID key used for Web Push is loaded from, or created at, keypath.
+func newNotifier(cfg notifyConfig, keypath string) (*notifier, error) {
+	n := &notifier{}
+	if cfg.Smtp.Addr != "" {
+		n.transports = append(n.transports, &notify.SMTP{
+			Addr:     cfg.Smtp.Addr,
+			From:     cfg.Smtp.From,
+			Username: cfg.Smtp.Username,
+			Password: cfg.Smtp.Password,
+			BaseURL:  strings.TrimSuffix(cfg.BaseURL, "/"),
+		})
+	}
+	if cfg.Push.Enabled {
+		keys, err := notify.LoadVAPID(keypath)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		n.push = &notify.Push{
+			Keys:    keys,
+			Subject: cfg.Push.Subject,
+			TTL:     time.Duration(cfg.Push.Ttl) * time.Second,
+		}
+		n.transports = append(n.transports, n.push)
+	}
+	if cfg.Webhooks {
+		n.transports = append(n.transports, &notify.Webhook{})
+	}
+	return n, nil
+}
+
+// names returns the names of the transports enabled for n.
+func (n *notifier) names() []string {
+	var names []string
+	for _, t := range n.transports {
+		names = append(names, t.Name())
+	}
+	return names
+}
+
+// notify notifies user of each change matching one of their rules.
+func (n *notifier) notify(user site.User, changes []Change) {
+	for _, change := range changes {
+		var via []string
+		matched := false
+		for _, rule := range user.Notify.Rules {
+			if rule.Event == change.Kind {
+				matched = true
+				via = addVia(via, rule.Via)
+			}
+		}
+		if matched {
+			n.send(user, via, changeNotification(change, user))
+		}
+	}
+}
+
+// remind notifies user of each unsubmitted task which is due within the time
+// set by one of their "due" rules. Users are reminded of each task once, unless
+// its due date changes.
+func (n *notifier) remind(user site.User, tasks []site.Task) {
+	now := time.Now()
+	due := make(map[string][]string)
+	matched := make(map[string]bool)
+	for _, rule := range user.Notify.Rules {
+		if rule.Event != eventDue {
+			continue
+		}
+		within := defaultDueWithin
+		if rule.Within > 0 {
+			within = time.Duration(rule.Within) * time.Hour
+		}
+		for _, task := range tasks {
+			if task.Submitted || task.Due.IsZero() || !task.Due.After(now) || task.Due.Sub(now) > within {
+				continue
+			}
+			key := task.Platform + "/" + task.Id
+			matched[key] = true
+			due[key] = addVia(due[key], rule.Via)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+
+	uid := site.Uid{School: user.School, Username: user.Username}
+	var reminders []site.Task
+	activityMutex.Lock()
+	activity, err := creds.Store.Activity(uid)
+	if err != nil {
+		activityMutex.Unlock()
+		logger.Debug(errors.New(err, "cannot fetch activity feed"))
+		return
+	}
+	// Forget reminders of tasks which are past due.
+	reminded := make(map[string]time.Time)
+	for key, t := range activity.Reminded {
+		if t.After(now) {
+			reminded[key] = t
+		}
+	}
+	for _, task := range tasks {
+		key := task.Platform + "/" + task.Id
+		if !matched[key] || reminded[key].Equal(task.Due) {
+			continue
+		}
+		reminded[key] = task.Due
+		reminders = append(reminders, task)
+	}
+	activity.Reminded = reminded
+	err = creds.Store.SetActivity(uid, activity)
+	activityMutex.Unlock()
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot update activity feed"))
+		return
+	}
+
+	for _, task := range reminders {
+		n.send(user, due[task.Platform+"/"+task.Id], notify.Notification{
+			Event: eventDue,
+			Title: "Due soon: " + task.Name,
+			Body:  joinNonEmpty(" — ", task.Class, "Due "+genDueStr(task.Due, user)),
+			Path:  "/tasks/" + task.Platform + "/" + task.Id,
+			Time:  now,
+		})
+	}
+}
+
+// send delivers msg to user through each of the named transports, or through
+// every transport if via is empty. Expired push subscriptions are forgotten.
+func (n *notifier) send(user site.User, via []string, msg notify.Notification) {
+	uid := site.Uid{School: user.School, Username: user.Username}
+	user, err := user.Unseal()
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot unseal user"))
+		return
+	}
+	to := notify.Recipient{Email: user.Notify.Email}
+	if to.Email == "" {
+		to.Email = user.Email
+	}
+	for _, hook := range user.Notify.Webhooks {
+		to.Hooks = append(to.Hooks, notify.Hook{URL: hook.URL, Secret: hook.Secret})
+	}
+	to.Subscriptions, err = creds.Store.Subscriptions(uid)
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot fetch push subscriptions"))
+	}
+
+	for _, t := range n.transports {
+		if len(via) > 0 && !slices.Has(via, t.Name()) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		err = t.Send(ctx, to, msg)
+		cancel()
+		if expired, ok := notify.Expired(err); ok {
+			forgetSubscriptions(uid, expired.Endpoints)
+			err = expired.Err
+		}
+		if err != nil {
+			logger.Debug(errors.New(err, "cannot send %s notification", t.Name()))
+		}
+	}
+}
+
+// changeNotification returns the notification of change sent to user.
+func changeNotification(change Change, user site.User) notify.Notification {
+	item := genChange(change, user)
+	msg := notify.Notification{
+		Event: change.Kind,
+		Title: item.Label + ": " + item.Name,
+		Body:  joinNonEmpty(" — ", item.Class, item.Detail),
+		Time:  change.Detected,
+	}
+	if item.Page != "" {
+		msg.Path = "/" + item.Page + "/" + item.Platform + "/" + item.Id
+	} else {
+		msg.Path = "/timetable"
+	}
+	return msg
+}
+
+// addSubscription adds a Web Push subscription for the user with the given
+// uid, replacing any with the same endpoint.
+func addSubscription(uid site.Uid, sub notify.Subscription) error {
+	subs, err := creds.Store.Subscriptions(uid)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	kept := []notify.Subscription{sub}
+	for _, s := range subs {
+		if s.Endpoint != sub.Endpoint {
+			kept = append(kept, s)
+		}
+	}
+	return creds.Store.SetSubscriptions(uid, kept)
+}
+
+// forgetSubscriptions removes the Web Push subscriptions with the given
+// endpoints from the user with the given uid.
+func forgetSubscriptions(uid site.Uid, endpoints []string) {
+	subs, err := creds.Store.Subscriptions(uid)
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot fetch push subscriptions"))
+		return
+	}
+	var kept []notify.Subscription
+	for _, sub := range subs {
+		if !slices.Has(endpoints, sub.Endpoint) {
+			kept = append(kept, sub)
+		}
+	}
+	err = creds.Store.SetSubscriptions(uid, kept)
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot update push subscriptions"))
+	}
+}
+
+// addVia adds the transports named by a matching rule to those by which a
+// notification is sent. A rule naming no transports sends by all of them, as
+// does an empty result.
+func addVia(via, rule []string) []string {
+	if len(rule) == 0 {
+		return []string{}
+	}
+	if via != nil && len(via) == 0 {
+		return via
+	}
+	for _, name := range rule {
+		if !slices.Has(via, name) {
+			via = append(via, name)
+		}
+	}
+	return via
+}
+
+// joinNonEmpty joins the non-empty strings in elems with sep.
+func joinNonEmpty(sep string, elems ...string) string {
+	var parts []string
+	for _, elem := range elems {
+		if elem != "" {
+			parts = append(parts, elem)
+		}
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,10 @@
+package server
+
+// Platform packages register themselves with the site package when imported,
+// making them available to the schools defined in the school definitions file.
+import (
+	_ "main/site/daymap"
+	_ "main/site/example"
+	_ "main/site/myadelaide"
+	_ "main/site/saml"
+)
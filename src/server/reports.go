@@ -0,0 +1,226 @@
+package server
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/site"
+)
+
+// Page dimensions and layout of generated PDF reports, in points.
+const (
+	pdfWidth    = 595 // A4
+	pdfHeight   = 842
+	pdfMargin   = 56
+	pdfLeading  = 18
+	pdfFontSize = 11
+)
+
+// findReport returns the report with the given platform and id from reports.
+func findReport(reports []site.Report, platform, id string) (site.Report, error) {
+	for _, report := range reports {
+		if report.Platform == platform && report.Id == id {
+			return report, nil
+		}
+	}
+	return site.Report{}, errors.Raise(site.ErrNotFound)
+}
+
+// reportFilename returns the name under which a report is downloaded, without
+// an extension.
+func reportFilename(report site.Report) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == ' ', r == '-', r == '_':
+			return '-'
+		}
+		return -1
+	}, report.Name)
+	if name == "" {
+		return "report"
+	}
+	return name
+}
+
+// scoreStr formats a score as a percentage, or returns an empty string if the
+// score is zero (that is, no score was given).
+func scoreStr(score float64) string {
+	if score == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64) + "%"
+}
+
+// csvCell returns s as the text of a CSV cell. Text which a spreadsheet would
+// take as a formula is prefixed with an apostrophe, so that it is shown as is.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// ReportCSV writes report to w as CSV, with one row for each class.
+func ReportCSV(report site.Report, w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Class", "Grade", "Score"})
+	for _, grade := range report.Grades {
+		score := ""
+		if grade.Score != 0 {
+			score = strconv.FormatFloat(grade.Score, 'f', -1, 64)
+		}
+		cw.Write([]string{csvCell(grade.Class), csvCell(grade.Grade), score})
+	}
+	cw.Flush()
+	err := cw.Error()
+	if err != nil {
+		return errors.New(err, "cannot write report CSV")
+	}
+	return nil
+}
+
+// winAnsi maps the characters of WinAnsiEncoding outside Latin-1 to their
+// codes. Characters from U+00A0 to U+00FF have the same codes as in Latin-1.
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86,
+	'‡': 0x87, 'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c,
+	'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95,
+	'–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// pdfEscape escapes s for use in a PDF literal string shown in a font with
+// WinAnsiEncoding. Characters outside ASCII are written as octal escapes of
+// their codes in that encoding, which covers Latin-1 and common punctuation;
+// characters it does not cover, and control characters, are replaced with '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		code, ok := winAnsi[r]
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= ' ' && r <= '~':
+			b.WriteRune(r)
+		case ok:
+			fmt.Fprintf(&b, "\\%03o", code)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// pdfLine is a line of text on a page of a PDF report.
+type pdfLine struct {
+	x, y int
+	bold bool
+	text string
+}
+
+// ReportPDF writes report to w as a PDF document listing the grade and score
+// for each class. The document uses only the standard Helvetica fonts, so no
+// fonts need to be embedded.
+func ReportPDF(report site.Report, user site.User, w io.Writer) error {
+	var pages [][]pdfLine
+	var page []pdfLine
+	y := pdfHeight - pdfMargin
+	add := func(x int, bold bool, text string) {
+		page = append(page, pdfLine{x, y, bold, text})
+	}
+	newline := func() {
+		y -= pdfLeading
+		if y < pdfMargin {
+			pages = append(pages, page)
+			page = nil
+			y = pdfHeight - pdfMargin
+		}
+	}
+
+	add(pdfMargin, true, report.Name)
+	newline()
+	add(pdfMargin, false, user.DispName)
+	newline()
+	add(pdfMargin, false, "Released "+report.Released.In(user.Timezone).Format("Monday, 2 January 2006"))
+	newline()
+	newline()
+	add(pdfMargin, true, "Class")
+	add(pdfMargin+300, true, "Grade")
+	add(pdfMargin+380, true, "Score")
+	newline()
+	for _, grade := range report.Grades {
+		add(pdfMargin, false, grade.Class)
+		add(pdfMargin+300, false, grade.Grade)
+		add(pdfMargin+380, false, scoreStr(grade.Score))
+		newline()
+	}
+	if page != nil {
+		pages = append(pages, page)
+	}
+
+	// Objects 1 to 4 are the catalog, page tree and fonts; each page is then
+	// followed by its content stream.
+	var objs []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	objs = append(objs,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	)
+	for i, lines := range pages {
+		var content strings.Builder
+		for _, line := range lines {
+			font := "F1"
+			if line.bold {
+				font = "F2"
+			}
+			fmt.Fprintf(&content,
+				"BT /%s %d Tf %d %d Td (%s) Tj ET\n",
+				font, pdfFontSize, line.x, line.y, pdfEscape(line.text),
+			)
+		}
+		objs = append(objs,
+			fmt.Sprintf(
+				"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+					"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pdfWidth, pdfHeight, 6+2*i,
+			),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objs))
+	for i, obj := range objs {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+
+	_, err := w.Write(b.Bytes())
+	if err != nil {
+		return errors.New(err, "cannot write report PDF")
+	}
+	return nil
+}
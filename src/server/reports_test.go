@@ -0,0 +1,128 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"main/site"
+)
+
+func TestPdfEscape(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"English 11A", "English 11A"},
+		{`Maths (Methods) \ Specialist`, `Maths \(Methods\) \\ Specialist`},
+		{"Français", `Fran\347ais`},
+		{"Café – Ms O’Brien", `Caf\351 \226 Ms O\222Brien`},
+		{"€5 • ™", `\2005 \225 \231`},
+		{"Line\nbreak\ttab", "Line?break?tab"},
+		{"日本語 Ω", "??? ?"},
+		{" ÿ", `\240\377`},
+	}
+	for _, test := range tests {
+		if got := pdfEscape(test.s); got != test.want {
+			t.Errorf("pdfEscape(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+// textPattern matches the text shown in a PDF report, with its font and
+// position.
+var textPattern = regexp.MustCompile(`BT /(F\d) \d+ Tf (\d+) (\d+) Td \((.*)\) Tj ET`)
+
+func TestReportPDF(t *testing.T) {
+	user := site.User{DispName: "Sam Student", Timezone: time.UTC}
+	report := site.Report{
+		Name:     "Semester 1 Report",
+		Released: time.Date(2026, 6, 26, 0, 0, 0, 0, time.UTC),
+	}
+	for i := 0; i < 60; i++ {
+		report.Grades = append(report.Grades, site.Grade{
+			Class: fmt.Sprintf("Class %d", i),
+			Grade: "A",
+			Score: 95,
+		})
+	}
+	var b bytes.Buffer
+	err := ReportPDF(report, user, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf := b.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Fatalf("not a PDF document:\n%s", pdf)
+	}
+
+	// Five header lines and sixty grades at 18pt leading fill two pages.
+	pages := strings.Split(pdf, "/Type /Page ")[1:]
+	if len(pages) != 2 || !strings.Contains(pdf, "/Count 2") {
+		t.Fatalf("got %d pages, want 2", len(pages))
+	}
+	var classes []string
+	for i, page := range pages {
+		for _, m := range textPattern.FindAllStringSubmatch(page, -1) {
+			x, _ := strconv.Atoi(m[2])
+			y, _ := strconv.Atoi(m[3])
+			if x < pdfMargin || x > pdfWidth-pdfMargin || y < pdfMargin || y > pdfHeight-pdfMargin {
+				t.Errorf("page %d: %q at (%d, %d) is outside the margins", i+1, m[4], x, y)
+			}
+			if x == pdfMargin && m[1] == "F1" && strings.HasPrefix(m[4], "Class ") {
+				classes = append(classes, m[4])
+			}
+		}
+	}
+	if len(classes) != 60 || classes[0] != "Class 0" || classes[59] != "Class 59" {
+		t.Errorf("got classes %v, want Class 0 to Class 59 in order", classes)
+	}
+	for _, want := range []string{"(Semester 1 Report)", "(Sam Student)", "(Released Friday, 26 June 2026)", "(95%)"} {
+		if !strings.Contains(pdf, want) {
+			t.Errorf("report does not contain %s", want)
+		}
+	}
+
+	// Every object is where the cross-reference table says it is.
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(m[1])
+	if !strings.HasPrefix(pdf[xref:], "xref\n") {
+		t.Fatalf("startxref %d does not point to the cross-reference table", xref)
+	}
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(pdf[xref:], -1)
+	for i, offset := range offsets {
+		n, _ := strconv.Atoi(offset[1])
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !strings.HasPrefix(pdf[n:], want) {
+			t.Errorf("object %d is not at offset %d", i+1, n)
+		}
+	}
+	if want := 4 + 2*len(pages); len(offsets) != want {
+		t.Errorf("got %d objects, want %d", len(offsets), want)
+	}
+}
+
+func TestReportCSV(t *testing.T) {
+	report := site.Report{Grades: []site.Grade{
+		{Class: "English 11A", Grade: "A", Score: 95},
+		{Class: `=HYPERLINK("http://example.com","Maths")`, Grade: "+B"},
+		{Class: "@SUM(1,1)", Grade: "-C", Score: 62.5},
+	}}
+	var b bytes.Buffer
+	err := ReportCSV(report, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Class,Grade,Score\n" +
+		"English 11A,A,95\n" +
+		`"'=HYPERLINK(""http://example.com"",""Maths"")",'+B,` + "\n" +
+		`"'@SUM(1,1)",'-C,62.5` + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("got CSV:\n%s\nwant:\n%s", got, want)
+	}
+}
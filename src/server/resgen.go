@@ -1,17 +1,19 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"html"
 	"html/template"
 	"image/color"
-	"net/http"
+	"net/mail"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
-	htm "git.sr.ht/~kvo/go-format/html"
-	"git.sr.ht/~kvo/go-format/text"
 	"git.sr.ht/~kvo/go-std/errors"
+	"git.sr.ht/~kvo/go-std/slices"
 
 	"main/logger"
 	"main/site"
@@ -24,6 +26,24 @@ var gradeColors = []color.RGBA{
 	{0x03, 0x6e, 0x05, 0xff}, // Green, #036e05
 }
 
+// age describes a duration in whole minutes, hours or days, such as "5
+// minutes" or "1 day".
+func age(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	n, unit := int(d/time.Minute), "minute"
+	if d >= 48*time.Hour {
+		n, unit = int(d/(24*time.Hour)), "day"
+	} else if d >= 2*time.Hour {
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
 func genDueStr(due time.Time, user site.User) string {
 	var dueDate string
 	now := time.Now().In(user.Timezone)
@@ -104,7 +124,50 @@ func genPostStr(posted time.Time, user site.User) string {
 	return postDate
 }
 
-// Generate a single task and format it in HTML (for the list of tasks)
+// genAddrs formats a list of message recipients for display.
+func genAddrs(addrs []mail.Address) string {
+	names := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr.Name != "" {
+			names = append(names, addr.Name)
+		} else {
+			names = append(names, addr.Address)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func genMessage(msg site.Message, user site.User) messageItem {
+	return messageItem{
+		Id:       msg.Id,
+		Platform: msg.Platform,
+		From:     genAddrs([]mail.Address{msg.From}),
+		To:       genAddrs(msg.To),
+		Cc:       genAddrs(msg.Cc),
+		Sent:     genPostStr(msg.Sent, user),
+		Subject:  msg.Subject,
+		Body:     genDesc(msg.Body, msg.Platform, user),
+		Read:     msg.Read,
+	}
+}
+
+func genReport(report site.Report, user site.User) reportItem {
+	item := reportItem{
+		Id:       report.Id,
+		Platform: report.Platform,
+		Name:     report.Name,
+		Released: genPostStr(report.Released, user),
+	}
+	for _, grade := range report.Grades {
+		item.Grades = append(item.Grades, reportGrade{
+			Class: grade.Class,
+			Grade: grade.Grade,
+			Score: scoreStr(grade.Score),
+		})
+	}
+	return item
+}
+
 func genTask(assignment site.Task, noteType string, user site.User) taskItem {
 	task := taskItem{
 		Id:       assignment.Id,
@@ -120,19 +183,129 @@ func genTask(assignment site.Task, noteType string, user site.User) taskItem {
 	case "posted":
 		task.Posted = genPostStr(assignment.Posted, user)
 	case "grade":
-		task.Grade = "N/A"
-		if assignment.Grade != "" && assignment.Score == 0.0 {
-			task.Grade = assignment.Grade
-		} else if assignment.Grade != "" && assignment.Score != 0.0 {
-			task.Grade = fmt.Sprintf("%s (%.f%%)", assignment.Grade, assignment.Score)
-		} else if assignment.Score != 0.0 {
-			task.Grade = fmt.Sprintf("%.f%%", assignment.Score)
+		task.Grade = gradeStr(assignment)
+		if task.Grade == "" {
+			task.Grade = "N/A"
 		}
 	}
 
 	return task
 }
 
+// lessonTimeStr formats the start of a lesson in the user's timezone.
+func lessonTimeStr(start time.Time, user site.User) string {
+	return start.In(user.Timezone).Format("Monday 2 January, 15:04")
+}
+
+// genChange generates the description of a change shown in the activity feed.
+func genChange(change Change, user site.User) changeItem {
+	item := changeItem{
+		Name:     change.Name,
+		Class:    change.Class,
+		Detected: genPostStr(change.Detected, user),
+		Platform: change.Platform,
+		Id:       change.Id,
+	}
+	switch change.Kind {
+	case changeNewTask:
+		item.Label = "New task"
+		item.Page = "tasks"
+		if !change.Time.IsZero() {
+			item.Detail = "Due " + genDueStr(change.Time, user)
+		}
+	case changeDueMoved:
+		item.Label = "Due date changed"
+		item.Page = "tasks"
+		item.Detail = "Now due " + genDueStr(change.Time, user)
+		if !change.Previous.IsZero() {
+			item.Detail += ", instead of " + genDueStr(change.Previous, user)
+		}
+	case changeGraded:
+		item.Label = "Task graded"
+		item.Page = "tasks"
+		if change.To != "" {
+			item.Detail = "Grade: " + change.To
+		}
+		if change.From != "" {
+			item.Detail += " (previously " + change.From + ")"
+		}
+	case changeNewResource:
+		item.Label = "New resource"
+		item.Page = "res"
+	case changeRoomChanged:
+		item.Label = "Room changed"
+		item.Detail = fmt.Sprintf(
+			"%s: now in %s, instead of %s",
+			lessonTimeStr(change.Time, user), change.To, change.From,
+		)
+	case changeLessonCancelled:
+		item.Label = "Lesson cancelled"
+		item.Detail = lessonTimeStr(change.Time, user)
+	}
+	if item.Name == item.Class {
+		item.Class = ""
+	}
+	return item
+}
+
+// gradeStr formats the grade and score of a task, or returns an empty string if
+// it has neither.
+func gradeStr(task site.Task) string {
+	if task.Grade != "" && task.Score == 0.0 {
+		return task.Grade
+	} else if task.Grade != "" && task.Score != 0.0 {
+		return fmt.Sprintf("%s (%.f%%)", task.Grade, task.Score)
+	} else if task.Score != 0.0 {
+		return fmt.Sprintf("%.f%%", task.Score)
+	}
+	return ""
+}
+
+// Generate the link to an attachment of the given platform, which is streamed
+// through TaskCollect if the platform supports it.
+func genFileLink(link, platform string, user site.User) string {
+	school, ok := schools[user.School]
+	if !ok {
+		return link
+	}
+	id, ok := school.FileId(platform, link)
+	if !ok {
+		return link
+	}
+	return "/files/" + platform + "/" + url.PathEscape(id)
+}
+
+// descSanitizer returns the sanitizer for the descriptions of items from the
+// given platform, which rewrites links to attachments to be streamed through
+// TaskCollect. Descriptions are already sanitised by the platform multiplexer,
+// which resolves their relative links.
+func descSanitizer(platform string, user site.User) site.Sanitizer {
+	return site.Sanitizer{
+		Rewrite: func(link string) string {
+			return genFileLink(link, platform, user)
+		},
+	}
+}
+
+// genDesc returns the description of an item from the given platform as safe
+// HTML.
+func genDesc(desc, platform string, user site.User) template.HTML {
+	return template.HTML(descSanitizer(platform, user).HTML(desc))
+}
+
+// genDescFormat returns the description of an item from the given platform in
+// the given format: "html", "text" or "markdown".
+func genDescFormat(desc, platform, format string, user site.User) string {
+	s := descSanitizer(platform, user)
+	switch format {
+	case "text":
+		return s.Text(desc)
+	case "markdown":
+		return s.Markdown(desc)
+	}
+	return s.HTML(desc)
+}
+
 // Generate the HTML page for viewing a single task
 func genTaskPage(assignment site.Task, user site.User) pageData {
 	data := pageData{
@@ -166,34 +339,7 @@ func genTaskPage(assignment site.Task, user site.User) pageData {
 	}
 
 	if assignment.Desc != "" {
-		var err error
-		isHtml := strings.HasPrefix(http.DetectContentType([]byte(assignment.Desc)), "text/html")
-		isLt := strings.HasPrefix(assignment.Desc, "<")
-		if isHtml || isLt {
-			r := strings.NewReader(assignment.Desc)
-			var b strings.Builder
-			var n *htm.Node
-			n, err = htm.Parse(r)
-			if err == nil {
-				err = text.Render(&b, n)
-			}
-			if err == nil {
-				taskDesc := html.EscapeString(b.String())
-				taskDesc = strings.ReplaceAll(taskDesc, "\t", "&emsp;")
-				taskDesc = strings.ReplaceAll(taskDesc, "\n", "<br>")
-				data.Body.TaskData.Desc = template.HTML(taskDesc)
-			}
-		}
-		if err != nil {
-			logger.Debug(err)
-		}
-		if err != nil || !isHtml && !isLt {
-			// Escape strings for conversion to safe HTML.
-			taskDesc := strings.ReplaceAll(assignment.Desc, "<br/>", "")
-			taskDesc = html.EscapeString(taskDesc)
-			taskDesc = strings.ReplaceAll(taskDesc, "\n", "<br>")
-			data.Body.TaskData.Desc = template.HTML(taskDesc)
-		}
+		data.Body.TaskData.Desc = genDesc(assignment.Desc, assignment.Platform, user)
 	}
 
 	if assignment.ResLinks != nil && len(assignment.ResLinks) != 0 {
@@ -203,7 +349,7 @@ func genTaskPage(assignment site.Task, user site.User) pageData {
 		for i := 0; i < len(assignment.ResLinks); i++ {
 			url := assignment.ResLinks[i][0]
 			name := assignment.ResLinks[i][1]
-			data.Body.TaskData.ResLinks[url] = name
+			data.Body.TaskData.ResLinks[genFileLink(url, assignment.Platform, user)] = name
 		}
 	}
 
@@ -214,7 +360,7 @@ func genTaskPage(assignment site.Task, user site.User) pageData {
 		for i := 0; i < len(assignment.WorkLinks); i++ {
 			url := assignment.WorkLinks[i][0]
 			name := assignment.WorkLinks[i][1]
-			data.Body.TaskData.WorkLinks[url] = name
+			data.Body.TaskData.WorkLinks[genFileLink(url, assignment.Platform, user)] = name
 		}
 	}
 
@@ -286,34 +432,7 @@ func genResPage(res site.Resource, user site.User) pageData {
 	}
 
 	if res.Desc != "" {
-		var err error
-		isHtml := strings.HasPrefix(http.DetectContentType([]byte(res.Desc)), "text/html")
-		isLt := strings.HasPrefix(res.Desc, "<")
-		if isHtml || isLt {
-			r := strings.NewReader(res.Desc)
-			var b strings.Builder
-			var n *htm.Node
-			n, err = htm.Parse(r)
-			if err == nil {
-				err = text.Render(&b, n)
-			}
-			if err == nil {
-				resDesc := html.EscapeString(b.String())
-				resDesc = strings.ReplaceAll(resDesc, "\t", "&emsp;")
-				resDesc = strings.ReplaceAll(resDesc, "\n", "<br>")
-				data.Body.ResourceData.Desc = template.HTML(resDesc)
-			}
-		}
-		if err != nil {
-			logger.Debug(err)
-		}
-		if err != nil || !isHtml && !isLt {
-			// Escape strings for conversion to safe HTML.
-			resDesc := strings.ReplaceAll(res.Desc, "<br/>", "")
-			resDesc = html.EscapeString(resDesc)
-			resDesc = strings.ReplaceAll(resDesc, "\n", "<br>")
-			data.Body.ResourceData.Desc = template.HTML(resDesc)
-		}
+		data.Body.ResourceData.Desc = genDesc(res.Desc, res.Platform, user)
 	}
 
 	if res.ResLinks != nil {
@@ -323,7 +442,7 @@ func genResPage(res site.Resource, user site.User) pageData {
 		for i := 0; i < len(res.ResLinks); i++ {
 			url := res.ResLinks[i][0]
 			name := res.ResLinks[i][1]
-			data.Body.ResourceData.ResLinks[url] = name
+			data.Body.ResourceData.ResLinks[genFileLink(url, res.Platform, user)] = name
 		}
 	}
 
@@ -349,17 +468,94 @@ func genHtmlResLink(className string, res []site.Resource, user site.User) resCl
 	return class
 }
 
+// partial records in data the platforms which failed during a multiplexed call,
+// and the age of any cached results returned instead, so that the page can be
+// shown with a notice about them, provided that the results are usable (see
+// site.Usable). Otherwise, err is returned.
+func (data *pageData) partial(err error) error {
+	if !site.Usable(err) {
+		return err
+	} else if err == nil {
+		return nil
+	}
+	if stale, ok := site.Stale(err); ok {
+		logger.Debug(errors.New(err, "showing cached results"))
+		data.Stale = max(data.Stale, stale.Age)
+	} else {
+		logger.Debug(errors.New(err, "showing incomplete results"))
+	}
+	failed, _ := site.Partial(err)
+	for _, platform := range failed.Platforms() {
+		if !slices.Has(data.Failed, platform) {
+			data.Failed = append(data.Failed, platform)
+		}
+	}
+	return nil
+}
+
 // Generate resources and components for the webpage
-func genRes(resURL string, user site.User) (pageData, error) {
+func genRes(ctx context.Context, resURL string, user site.User) (pageData, error) {
 	var data pageData
 	data.User.Name = user.DispName
 
-	if resURL == "/timetable" {
+	if resURL == "/" {
+		data.PageType = "main"
+		data.Head.Title = "Home"
+		data.Body.MainData.Heading = "Today"
+
+		school, ok := schools[user.School]
+		if !ok {
+			return statusServerErrorData, errors.New(nil, "unsupported school")
+		}
+
+		// Lessons are supplementary to due tasks, so the dashboard is still
+		// shown if they cannot be retrieved.
+		today := midnight(time.Now().In(user.Timezone))
+		lessons, err := school.Lessons(ctx, user, today, today.AddDate(0, 0, 1))
+		if err != nil {
+			logger.Error(errors.New(err, "cannot fetch lessons"))
+		}
+		for _, lesson := range lessons {
+			start, end := lesson.Start.In(user.Timezone), lesson.End.In(user.Timezone)
+			if !start.Before(today.AddDate(0, 0, 1)) {
+				continue
+			}
+			data.Body.MainData.Lessons = append(data.Body.MainData.Lessons, ttLesson{
+				Class:         lesson.Class,
+				FormattedTime: start.Format("15:04") + "–" + end.Format("15:04"),
+				Room:          lesson.Room,
+				Teacher:       lesson.Teacher,
+				Notice:        lesson.Notice,
+			})
+		}
+
+		tasks, err := getDueTasks(ctx, user)
+		if err := data.partial(err); err != nil {
+			return data, errors.Wrap(err)
+		}
+		groups := []struct{ key, name string }{
+			{"overdue", "Overdue"},
+			{"today", "Due today"},
+			{"week", "Due this week"},
+			{"later", "Due later"},
+		}
+		for _, group := range groups {
+			dueTasks := taskType{
+				Name:     group.name,
+				NoteType: "dueDate",
+			}
+			for _, task := range tasks[group.key] {
+				dueTasks.Tasks = append(dueTasks.Tasks, genTask(task, "dueDate", user))
+			}
+			data.Body.MainData.TaskTypes = append(data.Body.MainData.TaskTypes, dueTasks)
+		}
+
+	} else if resURL == "/timetable" {
 		data.PageType = "timetable"
 		data.Head.Title = "Timetable"
 
-		timetable, err := TimetableHTML(user)
-		if err != nil {
+		timetable, err := TimetableHTML(ctx, user)
+		if err := data.partial(err); err != nil {
 			return data, errors.New(err, "failed to generate timetable")
 		}
 
@@ -370,7 +566,10 @@ func genRes(resURL string, user site.User) (pageData, error) {
 		data.Head.Title = "Tasks"
 		data.Body.TasksData.Heading = "Tasks"
 
-		tasks := getTasks(user)
+		tasks, err := getTasks(ctx, user)
+		if err := data.partial(err); err != nil {
+			return data, errors.Wrap(err)
+		}
 		activeTasks := taskType{
 			Name:     "Active tasks",
 			NoteType: "dueDate",
@@ -428,7 +627,10 @@ func genRes(resURL string, user site.User) (pageData, error) {
 		data.Head.Title = "Resources"
 		data.Body.ResData.Heading = "Resources"
 
-		classes, resources := getResources(user)
+		classes, resources, err := getResources(ctx, user)
+		if err := data.partial(err); err != nil {
+			return data, errors.Wrap(err)
+		}
 		for _, class := range classes {
 			data.Body.ResData.Classes = append(data.Body.ResData.Classes, genHtmlResLink(
 				class,
@@ -443,10 +645,10 @@ func genRes(resURL string, user site.User) (pageData, error) {
 
 		school, ok := schools[user.School]
 		if !ok {
-			return statusServerErrorData, errors.New(nil, "unsupported platform")
+			return statusServerErrorData, errors.New(nil, "unsupported school")
 		}
-		tasks, err := school.Graded(user)
-		if err != nil {
+		tasks, err := school.Graded(ctx, user)
+		if err := data.partial(err); err != nil {
 			return data, errors.New(err, "cannot fetch graded tasks")
 		}
 
@@ -457,9 +659,163 @@ func genRes(resURL string, user site.User) (pageData, error) {
 			)
 		}
 
+	} else if resURL == "/messages" {
+		data.PageType = "messages"
+		data.Head.Title = "Messages"
+		data.Body.MessagesData.Heading = "Messages"
+
+		school, ok := schools[user.School]
+		if !ok {
+			return statusServerErrorData, errors.New(nil, "unsupported school")
+		}
+		messages, err := school.Messages(ctx, user)
+		if err := data.partial(err); err != nil {
+			return data, errors.New(err, "cannot fetch messages")
+		}
+
+		for _, msg := range messages {
+			item := genMessage(msg, user)
+			if msg.Read {
+				data.Body.MessagesData.Read = append(data.Body.MessagesData.Read, item)
+			} else {
+				data.Body.MessagesData.Unread = append(data.Body.MessagesData.Unread, item)
+			}
+		}
+
+	} else if resURL == "/activity" {
+		data.PageType = "activity"
+		data.Head.Title = "What's new"
+		data.Body.ActivityData.Heading = "What's new"
+
+		uid := site.Uid{School: user.School, Username: user.Username}
+		activity, err := seeActivity(uid)
+		if err != nil {
+			return data, errors.New(err, "cannot fetch activity feed")
+		}
+		for _, change := range activity.Changes {
+			item := genChange(change, user)
+			if change.Detected.After(activity.Seen) {
+				data.Body.ActivityData.New = append(data.Body.ActivityData.New, item)
+			} else {
+				data.Body.ActivityData.Earlier = append(data.Body.ActivityData.Earlier, item)
+			}
+		}
+
+	} else if resURL == "/reports" {
+		data.PageType = "reports"
+		data.Head.Title = "Reports"
+		data.Body.ReportsData.Heading = "Reports"
+
+		school, ok := schools[user.School]
+		if !ok {
+			return statusServerErrorData, errors.New(nil, "unsupported school")
+		}
+		reports, err := school.Reports(ctx, user)
+		if err := data.partial(err); err != nil {
+			return data, errors.New(err, "cannot fetch reports")
+		}
+
+		for _, report := range reports {
+			data.Body.ReportsData.Reports = append(
+				data.Body.ReportsData.Reports,
+				genReport(report, user),
+			)
+		}
+
 	} else {
-		return data, errors.New(nil, "cannot find resource")
+		return data, errors.Raise(site.ErrNotFound)
+	}
+
+	return data, nil
+}
+
+// genNotifySettings describes the notification preferences of user.
+func genNotifySettings(user site.User) (notifySettings, error) {
+	settings := notifySettings{
+		Enabled:  true,
+		Via:      notifications.names(),
+		Webhooks: len(user.Notify.Webhooks),
+	}
+	for _, rule := range user.Notify.Rules {
+		desc := rule.Event
+		if rule.Event == eventDue {
+			within := defaultDueWithin
+			if rule.Within > 0 {
+				within = time.Duration(rule.Within) * time.Hour
+			}
+			desc = fmt.Sprintf("due within %d hours", int(within/time.Hour))
+		}
+		if len(rule.Via) > 0 {
+			desc += " (by " + strings.Join(rule.Via, ", ") + ")"
+		}
+		settings.Rules = append(settings.Rules, desc)
+	}
+	if slices.Has(settings.Via, "email") {
+		settings.Email = user.Notify.Email
+		if settings.Email == "" {
+			settings.Email = user.Email
+		}
 	}
+	if notifications.push != nil {
+		uid := site.Uid{School: user.School, Username: user.Username}
+		subs, err := creds.Store.Subscriptions(uid)
+		if err != nil {
+			return notifySettings{}, errors.Wrap(err)
+		}
+		settings.PushKey = notifications.push.Keys.PublicKey()
+		settings.Devices = len(subs)
+	}
+	return settings, nil
+}
 
+func genSettingsPage(user site.User) (pageData, error) {
+	data := pageData{
+		PageType: "settings",
+		Head: headData{
+			Title: "Settings",
+		},
+		User: userData{
+			Name: user.DispName,
+		},
+	}
+
+	uid := site.Uid{School: user.School, Username: user.Username}
+	tokens, err := creds.Tokens(uid)
+	if err != nil {
+		return pageData{}, errors.Wrap(err)
+	}
+
+	items := []tokenItem{}
+	for id, token := range tokens {
+		items = append(items, tokenItem{
+			Id:      id,
+			Name:    token.Name,
+			Scope:   token.Scope,
+			Created: token.Created.In(user.Timezone).Format("2 Jan 2006, 15:04"),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Name < items[j].Name
+	})
+	data.Body.SettingsData.Tokens = items
+
+	if notifications != nil {
+		settings, err := genNotifySettings(user)
+		if err != nil {
+			return pageData{}, errors.Wrap(err)
+		}
+		data.Body.SettingsData.Notify = settings
+	}
+
+	feed, id, err := creds.Feed(uid)
+	if err == nil {
+		data.Body.SettingsData.HasFeed = true
+		data.Body.SettingsData.Feed = tokenItem{
+			Id:      id,
+			Name:    feed.Name,
+			Scope:   feed.Scope,
+			Created: feed.Created.In(user.Timezone).Format("2 Jan 2006, 15:04"),
+		}
+	}
 	return data, nil
 }
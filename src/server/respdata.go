@@ -1,7 +1,12 @@
 package server
 
 import (
+	"fmt"
 	"html/template"
+	"net/http"
+	"time"
+
+	"main/site"
 )
 
 // Primary (page, head, body)
@@ -11,6 +16,11 @@ type pageData struct {
 	Head     headData
 	Body     bodyData
 	User     userData
+	// the platforms which could not be reached when generating the page
+	Failed []string
+	// the age of the oldest cached results shown on the page, if any could
+	// not be fetched again
+	Stale time.Duration
 }
 
 type headData struct {
@@ -19,6 +29,7 @@ type headData struct {
 }
 
 type bodyData struct {
+	MainData      mainData
 	ErrorData     errData
 	LoginData     loginData
 	TimetableData timetableData
@@ -27,25 +38,48 @@ type bodyData struct {
 	ResData       resData
 	TasksData     tasksData
 	TaskData      taskData
+	SettingsData  settingsData
+	MessagesData  messagesData
+	ReportsData   reportsData
+	ActivityData  activityData
+	SearchData    searchData
 }
 
 type userData struct {
 	Name string
 }
 
+// Main page (dashboard)
+
+type mainData struct {
+	Heading   string
+	Lessons   []ttLesson
+	TaskTypes []taskType
+}
+
 // Error Page
 
 type errData struct {
-	Heading  string
-	Message  string
+	Heading string
+	Message string
+	// what the user can do about the error, such as whether to try again
+	Hint     string
 	InfoLink string
 }
 
 // Login page
 
 type loginData struct {
-	Failed   bool
-	Redirect string
+	Failed bool
+	// whether the school's platforms could not be reached to log in
+	Unavailable bool
+	Redirect    string
+	Schools     []schoolItem
+}
+
+type schoolItem struct {
+	Id   string
+	Name string
 }
 
 // Timetable
@@ -58,10 +92,13 @@ type timetableData struct {
 type ttDay struct {
 	Day     string
 	Lessons []ttLesson
+	AllDay  []ttEvent
+	Events  []ttEvent
 }
 
 type ttLesson struct {
 	Class         string
+	Platform      string
 	FormattedTime string
 	Duration      string
 	Height        float64
@@ -71,6 +108,22 @@ type ttLesson struct {
 	Notice        string
 	Color         string
 	BGColor       string
+	// for lessons which overlap lessons from other platforms, a description
+	// of the clash, and the half of the day ("left" or "right") in which the
+	// lesson is shown
+	Clash string
+	Side  string
+}
+
+type ttEvent struct {
+	Name          string
+	Category      string
+	Location      string
+	FormattedTime string
+	Height        float64
+	TopOffset     float64
+	Color         string
+	BGColor       string
 }
 
 // Resources (/res page)
@@ -167,6 +220,134 @@ type gradesData struct {
 	Tasks   []taskItem
 }
 
+// Messages
+
+type messageItem struct {
+	Id       string
+	Platform string
+	From     string
+	To       string
+	Cc       string
+	Sent     string
+	Subject  string
+	Body     template.HTML
+	Read     bool
+}
+
+type messagesData struct {
+	Heading string
+	Unread  []messageItem
+	Read    []messageItem
+}
+
+// Activity feed ("What's new")
+
+type changeItem struct {
+	Label    string
+	Name     string
+	Class    string
+	Detail   string
+	Detected string
+	// the page ("tasks" or "res") showing the changed item, if any
+	Page     string
+	Platform string
+	Id       string
+}
+
+type activityData struct {
+	Heading string
+	// changes detected since the user last viewed the page, and before
+	New     []changeItem
+	Earlier []changeItem
+}
+
+// Search
+
+type searchResult struct {
+	Kind     string
+	Name     template.HTML
+	Path     string
+	Class    string
+	Platform string
+	Time     string
+	Snippet  template.HTML
+}
+
+type searchData struct {
+	Heading string
+	// the search, as entered in the search form
+	Query     string
+	Kind      string
+	Class     string
+	Platform  string
+	From      string
+	To        string
+	Classes   []string
+	Platforms []string
+	Searched  bool
+	Results   []searchResult
+}
+
+// Reports
+
+type reportGrade struct {
+	Class string
+	Grade string
+	Score string
+}
+
+type reportItem struct {
+	Id       string
+	Platform string
+	Name     string
+	Released string
+	Grades   []reportGrade
+}
+
+type reportsData struct {
+	Heading string
+	Reports []reportItem
+}
+
+// Settings
+
+type tokenItem struct {
+	Id      string
+	Name    string
+	Scope   string
+	Created string
+}
+
+type settingsData struct {
+	Tokens []tokenItem
+	// the name and value of a newly minted token, shown only once
+	NewName  string
+	NewToken string
+	Failed   bool
+	// the calendar feed token, if any, and a newly minted feed URL
+	HasFeed bool
+	Feed    tokenItem
+	FeedURL string
+	Notify  notifySettings
+}
+
+// notifySettings describes a user's notification preferences, which are set in
+// their config file, and the ways in which they can be notified.
+type notifySettings struct {
+	Enabled bool
+	// each of the user's rules, described
+	Rules []string
+	// the transports enabled on the server
+	Via []string
+	// the address to which notifications are emailed, if email is enabled
+	Email    string
+	Webhooks int
+	// the VAPID public key used to subscribe devices to push notifications,
+	// if push notifications are enabled
+	PushKey string
+	Devices int
+}
+
 var loginPageData = pageData{
 	PageType: "login",
 	Head: headData{
@@ -181,6 +362,19 @@ var loginPageData = pageData{
 
 // TODO: Create a function for fetching these status codes then constructing the pageData
 
+var statusBadRequestData = pageData{
+	PageType: "error",
+	Head: headData{
+		Title: "400 Bad Request",
+	},
+	Body: bodyData{
+		ErrorData: errData{
+			Heading: "400 Bad Request",
+			Message: "The request could not be understood by the server.",
+		},
+	},
+}
+
 var statusNotFoundData = pageData{
 	PageType: "error",
 	Head: headData{
@@ -219,3 +413,110 @@ var statusForbiddenData = pageData{
 		},
 	},
 }
+
+// A failure describes the response to a request which failed because of a kind
+// of platform error (see site.Kind).
+type failure struct {
+	status int
+	// identifies the kind of failure in API errors
+	kind    string
+	message string
+	hint    string
+	// whether the request may succeed if made again later
+	retry bool
+}
+
+// retryAfter is the number of seconds after which clients are told to retry
+// requests which may then succeed.
+const retryAfter = "60"
+
+var failures = map[error]failure{
+	site.ErrNotFound: {
+		status:  404,
+		kind:    "not_found",
+		message: "The requested item could not be found. It may have been removed, or you may no longer have access to it.",
+	},
+	site.ErrUnauthenticated: {
+		status:  403,
+		kind:    "unauthenticated",
+		message: "Your school's platform did not accept your username or password.",
+		hint:    "If you have changed your password, log out and log in again with your new password.",
+	},
+	site.ErrExpired: {
+		status:  403,
+		kind:    "session_expired",
+		message: "Your session with your school's platform has expired, and TaskCollect could not log in again.",
+		hint:    "Log out and log in again.",
+	},
+	site.ErrUnavailable: {
+		status:  503,
+		kind:    "unavailable",
+		message: "Your school's platform could not be reached, or did not respond in time.",
+		hint:    "Try again in a few minutes.",
+		retry:   true,
+	},
+	site.ErrParse: {
+		status:  502,
+		kind:    "parse_failure",
+		message: "Your school's platform responded in a way that TaskCollect could not understand. The platform may have changed.",
+		hint:    "If this keeps happening, please report it to the TaskCollect developers.",
+	},
+	site.ErrUnsupported: {
+		status:  501,
+		kind:    "unsupported",
+		message: "The platform does not support what was requested.",
+	},
+	site.ErrRejected: {
+		status:  422,
+		kind:    "upload_rejected",
+		message: "Your school's platform did not accept the uploaded files.",
+		hint:    "Check that the platform accepts files of their type and size, then upload them again.",
+	},
+	site.ErrRange: {
+		status:  416,
+		kind:    "range_not_satisfiable",
+		message: "The requested part of the file does not exist.",
+	},
+}
+
+var serverFailure = failure{
+	status:  500,
+	kind:    "internal",
+	message: "The server encountered an unexpected error and cannot continue.",
+}
+
+// failureOf returns the failure describing the response to a request which
+// failed because of err.
+func failureOf(err error) failure {
+	f, ok := failures[site.Kind(err)]
+	if !ok {
+		return serverFailure
+	}
+	return f
+}
+
+// page returns the error page explaining f.
+func (f failure) page() pageData {
+	heading := fmt.Sprintf("%d %s", f.status, http.StatusText(f.status))
+	return pageData{
+		PageType: "error",
+		Head: headData{
+			Title: heading,
+		},
+		Body: bodyData{
+			ErrorData: errData{
+				Heading: heading,
+				Message: f.message,
+				Hint:    f.hint,
+			},
+		},
+	}
+}
+
+// headers returns the response headers for f.
+func (f failure) headers() [][2]string {
+	if !f.retry {
+		return nil
+	}
+	return [][2]string{{"Retry-After", retryAfter}}
+}
@@ -0,0 +1,614 @@
+package server
+
+import (
+	"context"
+	"html"
+	"html/template"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"git.sr.ht/~kvo/go-std/errors"
+	"git.sr.ht/~kvo/go-std/slices"
+
+	"main/site"
+)
+
+// The kinds of items which can be searched for.
+const (
+	searchTask     = "task"
+	searchResource = "resource"
+	searchMessage  = "message"
+)
+
+// indexTTL is the time for which a user's search index is kept before it is
+// rebuilt from their cached platform data.
+const indexTTL = 10 * time.Minute
+
+// maxSearchResults is the maximum number of results returned by a search.
+const maxSearchResults = 50
+
+// snippetLen is the approximate length, in bytes, of the part of an item's text
+// shown with each search result.
+const snippetLen = 200
+
+// The fields of an item which are indexed, and the weight given to matches in
+// each when ranking results.
+const (
+	fieldName = iota
+	fieldFiles
+	fieldText
+)
+
+var fieldWeights = [...]float64{
+	fieldName:  4,
+	fieldFiles: 2,
+	fieldText:  1,
+}
+
+// searchDoc is an item which can be found by searching: a task, resource or
+// message.
+type searchDoc struct {
+	Kind     string
+	Name     string
+	Class    string
+	Platform string
+	Id       string
+	// the time the item was posted or sent
+	Time time.Time
+	// the plain text of the item's description or body
+	Text string
+	// the names of the item's attachments
+	Files []string
+}
+
+// posting records the occurrences of a term in a field of a document.
+type posting struct {
+	doc   int
+	field int
+	count int
+}
+
+// searchIndex is an inverted index of a user's tasks, resources and messages.
+type searchIndex struct {
+	docs  []searchDoc
+	terms map[string][]posting
+	// the keys of terms in lexical order, for prefix matching
+	sorted []string
+	built  time.Time
+}
+
+// searchQuery is a full-text search, with optional filters. Results must
+// contain every term in Text, or a word beginning with it.
+type searchQuery struct {
+	Text     string
+	Kind     string
+	Class    string
+	Platform string
+	// if not zero, results must be from within [From, To)
+	From time.Time
+	To   time.Time
+}
+
+// searchHit is a document matching a search.
+type searchHit struct {
+	Doc   searchDoc
+	Score float64
+}
+
+// searchSpan is part of a string shown in search results, which is highlighted
+// if it matches the search.
+type searchSpan struct {
+	Text  string
+	Match bool
+}
+
+var (
+	indexMutex sync.Mutex
+	indexes    = make(map[site.Uid]*searchIndex)
+)
+
+// searchToken is a word in a string, with its byte offsets.
+type searchToken struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits s into words, which are lowercased to form their terms.
+func tokenize(s string) []searchToken {
+	var tokens []searchToken
+	start := -1
+	for i, r := range s {
+		word := unicode.IsLetter(r) || unicode.IsNumber(r)
+		if word && start == -1 {
+			start = i
+		} else if !word && start != -1 {
+			tokens = append(tokens, searchToken{strings.ToLower(s[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, searchToken{strings.ToLower(s[start:]), start, len(s)})
+	}
+	return tokens
+}
+
+// newSearchIndex returns an index of docs.
+func newSearchIndex(docs []searchDoc) *searchIndex {
+	idx := &searchIndex{
+		docs:  docs,
+		terms: make(map[string][]posting),
+		built: time.Now(),
+	}
+	for i, doc := range docs {
+		fields := [...]string{
+			fieldName:  doc.Name,
+			fieldFiles: strings.Join(doc.Files, "\n"),
+			fieldText:  doc.Text,
+		}
+		for field, s := range fields {
+			counts := make(map[string]int)
+			for _, tok := range tokenize(s) {
+				counts[tok.term]++
+			}
+			for term, count := range counts {
+				idx.terms[term] = append(idx.terms[term], posting{i, field, count})
+			}
+		}
+	}
+	for term := range idx.terms {
+		idx.sorted = append(idx.sorted, term)
+	}
+	sort.Strings(idx.sorted)
+	return idx
+}
+
+// queryTerms returns the distinct terms of the search text s.
+func queryTerms(s string) []string {
+	var terms []string
+	for _, tok := range tokenize(s) {
+		if !slices.Has(terms, tok.term) {
+			terms = append(terms, tok.term)
+		}
+	}
+	return terms
+}
+
+// search returns the documents in idx matching q, ranked by relevance and then
+// by time, most recent first. Matches of whole words rank above matches of
+// their prefixes, and rarer terms above common ones.
+func (idx *searchIndex) search(q searchQuery) []searchHit {
+	qterms := queryTerms(q.Text)
+	if len(qterms) == 0 {
+		return nil
+	}
+	n := float64(len(idx.docs))
+	scores := make(map[int]float64)
+	matched := make(map[int]int)
+	for _, qterm := range qterms {
+		found := make(map[int]bool)
+		i := sort.SearchStrings(idx.sorted, qterm)
+		for ; i < len(idx.sorted) && strings.HasPrefix(idx.sorted[i], qterm); i++ {
+			term := idx.sorted[i]
+			postings := idx.terms[term]
+			docs := make(map[int]bool)
+			for _, p := range postings {
+				docs[p.doc] = true
+			}
+			idf := math.Log(1 + n/float64(len(docs)))
+			if term != qterm {
+				idf /= 2
+			}
+			for _, p := range postings {
+				scores[p.doc] += fieldWeights[p.field] * (1 + math.Log(float64(p.count))) * idf
+				found[p.doc] = true
+			}
+		}
+		for doc := range found {
+			matched[doc]++
+		}
+	}
+
+	var hits []searchHit
+	for i, count := range matched {
+		doc := idx.docs[i]
+		if count < len(qterms) || !q.matches(doc) {
+			continue
+		}
+		hits = append(hits, searchHit{doc, scores[i]})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Doc.Time.After(hits[j].Doc.Time)
+	})
+	if len(hits) > maxSearchResults {
+		hits = hits[:maxSearchResults]
+	}
+	return hits
+}
+
+// matches reports whether doc satisfies the filters of q.
+func (q searchQuery) matches(doc searchDoc) bool {
+	if q.Kind != "" && doc.Kind != q.Kind {
+		return false
+	}
+	if q.Class != "" && !strings.EqualFold(doc.Class, q.Class) {
+		return false
+	}
+	if q.Platform != "" && doc.Platform != q.Platform {
+		return false
+	}
+	if !q.From.IsZero() && doc.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !doc.Time.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// highlight splits s into spans, marking the words which begin with any of the
+// terms of the search text q.
+func highlight(s, q string) []searchSpan {
+	qterms := queryTerms(q)
+	var spans []searchSpan
+	last := 0
+	for _, tok := range tokenize(s) {
+		if !matchesTerm(qterms, tok.term) {
+			continue
+		}
+		if tok.start > last {
+			spans = append(spans, searchSpan{Text: s[last:tok.start]})
+		}
+		spans = append(spans, searchSpan{Text: s[tok.start:tok.end], Match: true})
+		last = tok.end
+	}
+	if last < len(s) {
+		spans = append(spans, searchSpan{Text: s[last:]})
+	}
+	return spans
+}
+
+// snippet returns the part of the text s around the first word matching the
+// search text q, of about snippetLen bytes, with line breaks collapsed.
+func snippet(s, q string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= snippetLen {
+		return s
+	}
+	qterms := queryTerms(q)
+	first := 0
+	for _, tok := range tokenize(s) {
+		if matchesTerm(qterms, tok.term) {
+			first = tok.start
+			break
+		}
+	}
+	start := max(0, first-snippetLen/4)
+	end := min(len(s), start+snippetLen)
+	start = max(0, end-snippetLen)
+	// Cut at spaces, so that words are not split.
+	if start > 0 {
+		if i := strings.IndexByte(s[start:first], ' '); i != -1 {
+			start += i + 1
+		}
+		for start < len(s) && !utf8.RuneStart(s[start]) {
+			start++
+		}
+	}
+	if end < len(s) {
+		if i := strings.LastIndexByte(s[first:end], ' '); i != -1 {
+			end = first + i
+		}
+		for end > start && !utf8.RuneStart(s[end]) {
+			end--
+		}
+	}
+	text := s[start:end]
+	if start > 0 {
+		text = "…" + text
+	}
+	if end < len(s) {
+		text += "…"
+	}
+	return text
+}
+
+// matchesTerm reports whether term begins with any of the query terms.
+func matchesTerm(qterms []string, term string) bool {
+	for _, qterm := range qterms {
+		if strings.HasPrefix(term, qterm) {
+			return true
+		}
+	}
+	return false
+}
+
+// genSpans formats spans as HTML, with matches marked.
+func genSpans(spans []searchSpan) template.HTML {
+	var b strings.Builder
+	for _, span := range spans {
+		if span.Match {
+			b.WriteString("<mark>" + html.EscapeString(span.Text) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(span.Text))
+		}
+	}
+	return template.HTML(b.String())
+}
+
+// searchPath returns the path of the page showing doc.
+func searchPath(doc searchDoc) string {
+	switch doc.Kind {
+	case searchTask:
+		return "/tasks/" + doc.Platform + "/" + doc.Id
+	case searchResource:
+		return "/res/" + doc.Platform + "/" + doc.Id
+	default:
+		return "/messages#msg-" + doc.Platform + "-" + doc.Id
+	}
+}
+
+// searchDocs returns the searchable items of user, fetched from the platforms
+// of their school, or their cache where possible. The error is that of the
+// first fetch which did not succeed entirely; results are returned alongside
+// it if they are usable (see site.Usable).
+//
+// Items are indexed as they are listed, without fetching each one, which would
+// take a request per item. Descriptions and attachments are therefore only
+// searched on platforms which give them in their lists; Daymap gives them only
+// for single tasks and resources, so only their names are searched.
+func searchDocs(ctx context.Context, user site.User, school *site.School) ([]searchDoc, error) {
+	var docs []searchDoc
+	var first error
+	usable := func(err error) bool {
+		if err != nil && first == nil {
+			first = err
+		}
+		return site.Usable(err)
+	}
+
+	classes, err := school.Classes(ctx, user)
+	if !usable(err) {
+		return nil, errors.New(err, "cannot fetch class list")
+	}
+	tasks, err := school.Tasks(ctx, user, classes...)
+	if !usable(err) {
+		return nil, errors.New(err, "cannot fetch tasks list")
+	}
+	for _, task := range tasks {
+		doc := searchDoc{
+			Kind:     searchTask,
+			Name:     task.Name,
+			Class:    task.Class,
+			Platform: task.Platform,
+			Id:       task.Id,
+			Time:     task.Posted,
+		}
+		if doc.Time.IsZero() {
+			doc.Time = task.Due
+		}
+		doc.Text = site.Sanitizer{}.Text(task.Desc)
+		for _, link := range append(task.ResLinks, task.WorkLinks...) {
+			doc.Files = append(doc.Files, link[1])
+		}
+		docs = append(docs, doc)
+	}
+
+	resources, err := school.Resources(ctx, user, classes...)
+	if !usable(err) {
+		return nil, errors.New(err, "cannot fetch resources list")
+	}
+	for _, res := range resources {
+		doc := searchDoc{
+			Kind:     searchResource,
+			Name:     res.Name,
+			Class:    res.Class,
+			Platform: res.Platform,
+			Id:       res.Id,
+			Time:     res.Posted,
+		}
+		doc.Text = site.Sanitizer{}.Text(res.Desc)
+		for _, link := range res.ResLinks {
+			doc.Files = append(doc.Files, link[1])
+		}
+		docs = append(docs, doc)
+	}
+
+	messages, err := school.Messages(ctx, user)
+	if !usable(err) {
+		return nil, errors.New(err, "cannot fetch messages")
+	}
+	for _, msg := range messages {
+		doc := searchDoc{
+			Kind:     searchMessage,
+			Name:     msg.Subject,
+			Platform: msg.Platform,
+			Id:       msg.Id,
+			Time:     msg.Sent,
+		}
+		doc.Text = site.Sanitizer{}.Text(msg.Body)
+		docs = append(docs, doc)
+	}
+	return docs, first
+}
+
+// userIndex returns the search index of user, building it if it is missing or
+// older than indexTTL. Indexes built from partial or stale results are not
+// kept, and are returned with the error of the fetch which produced them.
+func userIndex(ctx context.Context, user site.User, school *site.School) (*searchIndex, error) {
+	uid := site.Uid{School: user.School, Username: user.Username}
+	now := time.Now()
+	indexMutex.Lock()
+	idx, ok := indexes[uid]
+	indexMutex.Unlock()
+	if ok && now.Sub(idx.built) < indexTTL {
+		return idx, nil
+	}
+
+	docs, err := searchDocs(ctx, user, school)
+	if !site.Usable(err) {
+		return nil, errors.Wrap(err)
+	}
+	idx = newSearchIndex(docs)
+	if err != nil {
+		return idx, err
+	}
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	for id, old := range indexes {
+		if now.Sub(old.built) >= indexTTL {
+			delete(indexes, id)
+		}
+	}
+	indexes[uid] = idx
+	return idx, nil
+}
+
+// forgetIndex discards the search index of the user with the given uid, so that
+// it is rebuilt from fresh data when they next search.
+func forgetIndex(uid site.Uid) {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	delete(indexes, uid)
+}
+
+// parseSearch returns the search given by the query parameters of a request:
+// "q" is the search text, "type" the kind of item, "class" and "platform" the
+// class and platform of the item, and "from" and "to" the inclusive range of
+// dates, formatted as YYYY-MM-DD in the user's timezone, within which the item
+// was posted or sent.
+func parseSearch(params map[string][]string, user site.User) (searchQuery, error) {
+	get := func(key string) string {
+		if v := params[key]; len(v) > 0 {
+			return strings.TrimSpace(v[0])
+		}
+		return ""
+	}
+	q := searchQuery{
+		Text:     get("q"),
+		Kind:     get("type"),
+		Class:    get("class"),
+		Platform: get("platform"),
+	}
+	switch q.Kind {
+	case "", searchTask, searchResource, searchMessage:
+	default:
+		return searchQuery{}, errors.New(nil, "invalid type: %s", q.Kind)
+	}
+	var err error
+	if from := get("from"); from != "" {
+		q.From, err = time.ParseInLocation("2006-01-02", from, user.Timezone)
+		if err != nil {
+			return searchQuery{}, errors.New(err, "invalid start date")
+		}
+	}
+	if to := get("to"); to != "" {
+		q.To, err = time.ParseInLocation("2006-01-02", to, user.Timezone)
+		if err != nil {
+			return searchQuery{}, errors.New(err, "invalid end date")
+		}
+		q.To = q.To.AddDate(0, 0, 1)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return searchQuery{}, errors.New(nil, "end date is before start date")
+	}
+	return q, nil
+}
+
+// searchLabels are the labels shown for each kind of search result.
+var searchLabels = map[string]string{
+	searchTask:     "Task",
+	searchResource: "Resource",
+	searchMessage:  "Message",
+}
+
+// hitSnippet returns the text shown with the search result hit for the search
+// text q: part of the item's text around the first match, or the names of the
+// matching attachments if its text does not match.
+func hitSnippet(hit searchHit, q string) string {
+	qterms := queryTerms(q)
+	for _, tok := range tokenize(hit.Doc.Text) {
+		if matchesTerm(qterms, tok.term) {
+			return snippet(hit.Doc.Text, q)
+		}
+	}
+	var files []string
+	for _, file := range hit.Doc.Files {
+		for _, tok := range tokenize(file) {
+			if matchesTerm(qterms, tok.term) {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+	if len(files) > 0 {
+		return strings.Join(files, ", ")
+	}
+	return snippet(hit.Doc.Text, q)
+}
+
+// genSearchPage generates the "/search" page for the search q. If the search
+// text is empty, only the search form is shown.
+func genSearchPage(ctx context.Context, q searchQuery, user site.User) (pageData, error) {
+	var data pageData
+	data.User.Name = user.DispName
+	data.PageType = "search"
+	data.Head.Title = "Search"
+	data.Body.SearchData.Heading = "Search"
+
+	school, ok := schools[user.School]
+	if !ok {
+		return statusServerErrorData, errors.New(nil, "unsupported school")
+	}
+	data.Body.SearchData.Platforms = school.Platforms
+	classes, err := school.Classes(ctx, user)
+	if site.Usable(err) {
+		for _, class := range classes {
+			if !slices.Has(data.Body.SearchData.Classes, class.Name) {
+				data.Body.SearchData.Classes = append(data.Body.SearchData.Classes, class.Name)
+			}
+		}
+	}
+
+	data.Body.SearchData.Query = q.Text
+	data.Body.SearchData.Kind = q.Kind
+	data.Body.SearchData.Class = q.Class
+	data.Body.SearchData.Platform = q.Platform
+	if !q.From.IsZero() {
+		data.Body.SearchData.From = q.From.Format("2006-01-02")
+	}
+	if !q.To.IsZero() {
+		data.Body.SearchData.To = q.To.AddDate(0, 0, -1).Format("2006-01-02")
+	}
+	if q.Text == "" {
+		return data, nil
+	}
+
+	idx, err := userIndex(ctx, user, school)
+	if err := data.partial(err); err != nil {
+		return data, errors.New(err, "cannot build search index")
+	}
+	data.Body.SearchData.Searched = true
+	for _, hit := range idx.search(q) {
+		result := searchResult{
+			Kind:     searchLabels[hit.Doc.Kind],
+			Name:     genSpans(highlight(hit.Doc.Name, q.Text)),
+			Path:     searchPath(hit.Doc),
+			Class:    hit.Doc.Class,
+			Platform: hit.Doc.Platform,
+			Snippet:  genSpans(highlight(hitSnippet(hit, q.Text), q.Text)),
+		}
+		if !hit.Doc.Time.IsZero() {
+			result.Time = genPostStr(hit.Doc.Time, user)
+		}
+		data.Body.SearchData.Results = append(data.Body.SearchData.Results, result)
+	}
+	return data, nil
+}
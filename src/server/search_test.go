@@ -0,0 +1,158 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"Essay", []string{"essay"}},
+		{"Year 12 Chemistry: lab-report.pdf", []string{"year", "12", "chemistry", "lab", "report", "pdf"}},
+		{"Über café naïve", []string{"über", "café", "naïve"}},
+		{"(x)", []string{"x"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, tok := range tokenize(test.s) {
+			got = append(got, tok.term)
+			if term := strings.ToLower(test.s[tok.start:tok.end]); term != tok.term {
+				t.Errorf("tokenize(%q): offsets give %q for term %q", test.s, term, tok.term)
+			}
+		}
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("tokenize(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	now := time.Now()
+	docs := []searchDoc{
+		{Kind: searchTask, Name: "Photosynthesis essay", Class: "Biology", Platform: "daymap", Id: "1", Time: now.AddDate(0, 0, -3)},
+		{Kind: searchResource, Name: "Week 3 notes", Class: "Biology", Platform: "daymap", Id: "2", Time: now.AddDate(0, 0, -2), Files: []string{"photosynthesis.pdf"}},
+		{Kind: searchMessage, Name: "Reminder", Platform: "daymap", Id: "3", Time: now.AddDate(0, 0, -1), Text: "Bring your photosynthesis worksheet."},
+		{Kind: searchTask, Name: "Photo essay", Class: "Art", Platform: "example", Id: "4", Time: now},
+		{Kind: searchTask, Name: "Lab report", Class: "Chemistry", Platform: "daymap", Id: "5", Time: now},
+	}
+	idx := newSearchIndex(docs)
+	ids := func(hits []searchHit) string {
+		var ids []string
+		for _, hit := range hits {
+			ids = append(ids, hit.Doc.Id)
+		}
+		return strings.Join(ids, " ")
+	}
+
+	tests := []struct {
+		name string
+		q    searchQuery
+		want string
+	}{
+		// Names rank above attachment names, which rank above text.
+		{"fields", searchQuery{Text: "photosynthesis"}, "1 2 3"},
+		// Whole words rank above prefixes.
+		{"prefix", searchQuery{Text: "photo"}, "4 1 2 3"},
+		// Every term must match.
+		{"all terms", searchQuery{Text: "photo essay"}, "4 1"},
+		{"no match", searchQuery{Text: "physics"}, ""},
+		{"empty", searchQuery{Text: " ? "}, ""},
+		{"kind", searchQuery{Text: "photo", Kind: searchTask}, "4 1"},
+		{"class", searchQuery{Text: "photo", Class: "biology"}, "1 2"},
+		{"platform", searchQuery{Text: "photo", Platform: "example"}, "4"},
+		{"from", searchQuery{Text: "photosynthesis", From: now.AddDate(0, 0, -2).Add(-time.Hour)}, "2 3"},
+		{"to", searchQuery{Text: "photosynthesis", To: now.AddDate(0, 0, -2)}, "1"},
+	}
+	for _, test := range tests {
+		if got := ids(idx.search(test.q)); got != test.want {
+			t.Errorf("%s: got results %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	// Results of equal relevance are ordered by time, most recent first.
+	idx = newSearchIndex([]searchDoc{
+		{Name: "Essay", Id: "old", Time: now.AddDate(0, 0, -1)},
+		{Name: "Essay", Id: "new", Time: now},
+	})
+	if got := ids(idx.search(searchQuery{Text: "essay"})); got != "new old" {
+		t.Errorf("equal relevance: got results %q, want \"new old\"", got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		s, q string
+		want string
+	}{
+		// Words beginning with a term are highlighted whole.
+		{"Photosynthesis essay", "photo", "[Photosynthesis] essay"},
+		{"Photosynthesis essay", "essay photo", "[Photosynthesis] [essay]"},
+		{"Lab report", "essay", "Lab report"},
+		{"", "essay", ""},
+		{"essay", "", "essay"},
+	}
+	for _, test := range tests {
+		var b strings.Builder
+		for _, span := range highlight(test.s, test.q) {
+			if span.Match {
+				b.WriteString("[" + span.Text + "]")
+			} else {
+				b.WriteString(span.Text)
+			}
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("highlight(%q, %q) = %q, want %q", test.s, test.q, got, test.want)
+		}
+	}
+}
+
+func TestGenSpans(t *testing.T) {
+	got := genSpans([]searchSpan{{Text: "<b>"}, {Text: "x&y", Match: true}})
+	if want := "&lt;b&gt;<mark>x&amp;y</mark>"; string(got) != want {
+		t.Errorf("genSpans = %q, want %q", got, want)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	short := "Bring your\n  worksheet."
+	if got := snippet(short, "worksheet"); got != "Bring your worksheet." {
+		t.Errorf("short text: got %q", got)
+	}
+
+	words := strings.Repeat("lorem ipsum ", 50)
+	long := words + "photosynthesis " + words
+	got := snippet(long, "photosynthesis")
+	if !strings.Contains(got, "photosynthesis") {
+		t.Errorf("snippet %q does not contain the match", got)
+	}
+	if !strings.HasPrefix(got, "…") || !strings.HasSuffix(got, "…") {
+		t.Errorf("snippet %q is not marked as cut at both ends", got)
+	}
+	text := strings.Trim(got, "…")
+	if len(text) > snippetLen {
+		t.Errorf("snippet is %d bytes long, want at most %d", len(text), snippetLen)
+	}
+	for _, word := range strings.Fields(text) {
+		if word != "lorem" && word != "ipsum" && word != "photosynthesis" {
+			t.Errorf("snippet %q splits the word %q", got, word)
+		}
+	}
+
+	// The start of the text is shown if nothing matches.
+	got = snippet(long, "chemistry")
+	if !strings.HasPrefix(got, "lorem ipsum") || !strings.HasSuffix(got, "…") {
+		t.Errorf("unmatched snippet %q does not show the start of the text", got)
+	}
+
+	// Multibyte characters are not split.
+	got = snippet(strings.Repeat("é", 300), "x")
+	if !strings.HasSuffix(got, "…") || !strings.HasPrefix(got, "é") {
+		t.Errorf("snippet %q splits a character", got)
+	}
+}
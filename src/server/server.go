@@ -8,20 +8,24 @@ import (
 	"net/http"
 	"os"
 	path "path/filepath"
+	"strings"
+	"time"
 	_ "time/tzdata"
 
 	"git.sr.ht/~kvo/go-std/errors"
 	"git.sr.ht/~kvo/go-std/slices"
 
 	"main/logger"
+	"main/notify"
 	"main/site"
 )
 
 var (
-	creds     Creds
-	respath   string
-	schools   = make(map[string]*site.Mux)
-	templates *template.Template
+	creds         Creds
+	notifications *notifier
+	respath       string
+	schools       map[string]*site.School
+	templates     *template.Template
 )
 
 func Announce(version string) {
@@ -30,7 +34,12 @@ func Announce(version string) {
 
 // TODO: refactor
 type config struct {
-	Logging loggingConfig `json:"logging"`
+	Logging  loggingConfig  `json:"logging"`
+	Sessions sessionsConfig `json:"sessions"`
+	Timeouts timeoutsConfig `json:"timeouts"`
+	Cache    cacheConfig    `json:"cache"`
+	Sync     syncConfig     `json:"sync"`
+	Notify   notifyConfig   `json:"notify"`
 }
 
 // TODO: refactor
@@ -39,15 +48,118 @@ type loggingConfig struct {
 	//LogFileOptions logFileOptions `json:"logFileOptions"`
 }
 
+// TODO: refactor
+type sessionsConfig struct {
+	UseSessionFile bool `json:"useSessionFile"`
+}
+
+// The time allowed for platforms to respond to requests, in seconds. Platforms
+// not listed in Platforms are allowed the Default time.
+type timeoutsConfig struct {
+	Default   int            `json:"default"`
+	Platforms map[string]int `json:"platforms"`
+}
+
+// The times for which platform data is cached, in seconds. Ttls maps types of
+// data (keys of site.DefaultTTLs) to the time for which they are fresh;
+// Revalidate and MaxStale are as described for site.Cache.
+type cacheConfig struct {
+	Ttls       map[string]int `json:"ttls"`
+	Revalidate int            `json:"revalidate"`
+	MaxStale   int            `json:"maxStale"`
+}
+
+// Background syncing of platform data for logged in users. Intervals maps
+// types of data ("tasks", "graded", "resources" and "lessons") to the time
+// between syncs in seconds, where zero disables syncing of that type of data.
+// Jitter is the fraction of each interval by which syncs are randomly moved.
+type syncConfig struct {
+	Enabled    bool             `json:"enabled"`
+	Intervals  map[string]int   `json:"intervals"`
+	Jitter     float64          `json:"jitter"`
+	RateLimits rateLimitsConfig `json:"rateLimits"`
+}
+
+// The number of syncs allowed to contact each platform per minute. Platforms
+// not listed in Platforms are allowed the Default number.
+type rateLimitsConfig struct {
+	Default   int            `json:"default"`
+	Platforms map[string]int `json:"platforms"`
+}
+
+// Notifications of changes detected by background syncs. BaseURL is the URL at
+// which users reach TaskCollect, used to link to pages from emails. Emails are
+// sent if an SMTP server is set, and notifications sent to webhooks if
+// Webhooks is set.
+type notifyConfig struct {
+	Enabled  bool       `json:"enabled"`
+	BaseURL  string     `json:"baseUrl"`
+	Smtp     smtpConfig `json:"smtp"`
+	Push     pushConfig `json:"push"`
+	Webhooks bool       `json:"webhooks"`
+}
+
+// The SMTP server through which notifications are emailed. Username and
+// Password are only required if the server requires authentication.
+type smtpConfig struct {
+	Addr     string `json:"addr"`
+	From     string `json:"from"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Web Push notifications. Subject is a mailto: or https: URL at which push
+// services can contact the server's operator, and Ttl the time in seconds for
+// which undelivered notifications are kept.
+type pushConfig struct {
+	Enabled bool   `json:"enabled"`
+	Subject string `json:"subject"`
+	Ttl     int    `json:"ttl"`
+}
+
 // TODO: refactor
 func getConfig(cfgPath string) (config, error) {
 	// gets stuff from config.json
 
 	// Default config
 	cfg := config{
-		loggingConfig{
+		Logging: loggingConfig{
 			UseLogFile: false,
 		},
+		Sessions: sessionsConfig{
+			UseSessionFile: true,
+		},
+		Timeouts: timeoutsConfig{
+			Default:   int(site.DefaultTimeout / time.Second),
+			Platforms: map[string]int{},
+		},
+		Cache: cacheConfig{
+			Ttls:       map[string]int{},
+			Revalidate: int(site.DefaultRevalidate / time.Second),
+			MaxStale:   int(site.DefaultMaxStale / time.Second),
+		},
+	}
+	for method, ttl := range site.DefaultTTLs {
+		cfg.Cache.Ttls[method] = int(ttl / time.Second)
+	}
+	cfg.Sync = syncConfig{
+		Enabled:   true,
+		Intervals: map[string]int{},
+		Jitter:    defaultSyncJitter,
+		RateLimits: rateLimitsConfig{
+			Default:   defaultRateLimit,
+			Platforms: map[string]int{},
+		},
+	}
+	for kind, interval := range defaultSyncIntervals {
+		cfg.Sync.Intervals[kind] = int(interval / time.Second)
+	}
+	cfg.Notify = notifyConfig{
+		Push: pushConfig{
+			Enabled: true,
+			Ttl:     int(notify.DefaultPushTTL / time.Second),
+		},
+		Webhooks: true,
 	}
 
 	jsonFile, err := os.OpenFile(cfgPath, os.O_RDONLY|os.O_CREATE, 0644)
@@ -100,12 +212,17 @@ func loadTmpl(respath string) error {
 		return errors.Wrap(err)
 	}
 	required := []string{
+		"body/activity",
 		"body/error",
 		"body/grades",
 		"body/login",
 		"body/main",
+		"body/messages",
+		"body/reports",
 		"body/resource",
 		"body/resources",
+		"body/search",
+		"body/settings",
 		"body/task",
 		"body/tasks",
 		"body/timetable",
@@ -150,14 +267,60 @@ func loadTmpl(respath string) error {
 		"sub": func(a, b int) int {
 			return a - b
 		},
+		"age": age,
 	}
 	templates = template.Must(template.New("").Funcs(funcMap).ParseFiles(files...))
 	return nil
 }
 
+// setTimeouts sets the time allowed for each platform of every school to
+// respond to requests. Non-positive times are ignored.
+func setTimeouts(cfg timeoutsConfig) {
+	for _, school := range schools {
+		if cfg.Default > 0 {
+			school.SetTimeout(time.Duration(cfg.Default) * time.Second)
+		}
+		for platform, secs := range cfg.Platforms {
+			if secs > 0 {
+				school.SetPlatformTimeout(platform, time.Duration(secs)*time.Second)
+			}
+		}
+	}
+}
+
+// setCaching sets the times for which every school caches platform data.
+// Negative times are ignored; a TTL of zero disables caching of that type of
+// data.
+func setCaching(cfg cacheConfig) {
+	for _, school := range schools {
+		for method, secs := range cfg.Ttls {
+			if secs >= 0 {
+				school.SetTTL(method, time.Duration(secs)*time.Second)
+			}
+		}
+		if cfg.Revalidate >= 0 {
+			school.SetRevalidate(time.Duration(cfg.Revalidate) * time.Second)
+		}
+		if cfg.MaxStale >= 0 {
+			school.SetMaxStale(time.Duration(cfg.MaxStale) * time.Second)
+		}
+	}
+}
+
+// sweepCaches periodically removes old results from the cache of every
+// school, waiting for the given interval between each sweep. sweepCaches never
+// returns.
+func sweepCaches(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		for _, school := range schools {
+			school.Sweep(time.Now())
+		}
+	}
+}
+
 func Configure() error {
-	creds.Tokens = make(map[string]site.Uid)
-	creds.Users = make(map[site.Uid]site.User)
+	creds.Store = newMemStore()
 
 	execpath, err := os.Executable()
 	if err != nil {
@@ -180,6 +343,44 @@ func Configure() error {
 		}
 		logger.Info("Log file set up successfully")
 	}
+	schools, err = site.LoadSchools(path.Join(respath, "schools.toml"))
+	if err != nil {
+		return errors.New(err, "cannot load school definitions")
+	}
+	setTimeouts(cfg.Timeouts)
+	setCaching(cfg.Cache)
+	for _, school := range schools {
+		school.OnReauth(creds.SetToken)
+	}
+	err = site.LoadKey(path.Join(respath, "secret.key"))
+	if err != nil {
+		return errors.New(err, "cannot load server key")
+	}
+	if cfg.Sessions.UseSessionFile {
+		store, err := newFileStore(path.Join(respath, "sessions.json"))
+		if err != nil {
+			logger.Error(errors.New(err, "cannot load session file"))
+			logger.Warn("Sessions will not persist across server restarts")
+		} else {
+			creds.Store = store
+			logger.Info("Loaded sessions from session file")
+		}
+	}
+	go creds.Sweep(time.Hour)
+	go sweepCaches(time.Hour)
+	if cfg.Sync.Enabled && cfg.Notify.Enabled {
+		notifications, err = newNotifier(cfg.Notify, path.Join(respath, "vapid.pem"))
+		if err != nil {
+			return errors.New(err, "cannot set up notifications")
+		}
+		logger.Info("Sending notifications by %s", strings.Join(notifications.names(), ", "))
+	} else if cfg.Notify.Enabled {
+		logger.Warn("Notifications require background syncing, which is disabled")
+	}
+	if cfg.Sync.Enabled {
+		go newScheduler(cfg.Sync).Run(time.Minute)
+		logger.Info("Syncing platform data in the background")
+	}
 
 	err = loadTmpl(respath)
 	if err != nil {
@@ -198,10 +399,21 @@ func Run(tls bool) error {
 	mux.HandleFunc("/assets/", assetHandler)
 	mux.HandleFunc("/res", resHandler)
 	mux.HandleFunc("/res/", resourceHandler)
+	mux.HandleFunc("/files/", fileHandler)
 	mux.HandleFunc("/tasks", tasksHandler)
 	mux.HandleFunc("/tasks/", taskHandler)
 	mux.HandleFunc("/timetable", timetableHandler)
+	mux.HandleFunc("/timetable.ics", icsHandler)
 	mux.HandleFunc("/grades", gradesHandler)
+	mux.HandleFunc("/messages", messagesHandler)
+	mux.HandleFunc("/activity", activityHandler)
+	mux.HandleFunc("/search", searchHandler)
+	mux.HandleFunc("/reports", reportsHandler)
+	mux.HandleFunc("/reports/", reportsHandler)
+	mux.HandleFunc("/settings", settingsHandler)
+	mux.HandleFunc("/settings/", settingsHandler)
+
+	mux.HandleFunc("/api/v1/", apiHandler)
 
 	mux.HandleFunc("/login", loginHandler)
 	mux.HandleFunc("/logout", logoutHandler)
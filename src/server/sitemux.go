@@ -1,17 +1,50 @@
 package server
 
 import (
+	"context"
 	"sort"
 	"time"
 
 	"git.sr.ht/~kvo/go-std/errors"
 
-	"main/logger"
 	"main/site"
 )
 
+// joinPartial combines the errors returned by several multiplexed calls. If any
+// of errs does not accompany usable results (see site.Usable), it is returned
+// as is; otherwise, the returned error names every platform that failed, and
+// gives the age of the oldest stale results.
+func joinPartial(errs ...error) error {
+	failed := site.PlatformError{}
+	var stale *site.StaleError
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		if !site.Usable(err) {
+			return err
+		}
+		if e, ok := site.Stale(err); ok && (stale == nil || e.Age > stale.Age) {
+			stale = &site.StaleError{Age: e.Age}
+		}
+		partial, _ := site.Partial(err)
+		for platform, err := range partial {
+			failed[platform] = err
+		}
+	}
+	var err error
+	if len(failed) > 0 {
+		err = failed
+	}
+	if stale != nil {
+		stale.Err = err
+		return stale
+	}
+	return err
+}
+
 // TODO: delete after v2
-func getTasks(user site.User) map[string][]site.Task {
+func getTasks(ctx context.Context, user site.User) (map[string][]site.Task, error) {
 	filtered := map[string][]site.Task{
 		"active":    {},
 		"notDue":    {},
@@ -20,18 +53,15 @@ func getTasks(user site.User) map[string][]site.Task {
 	}
 	school, ok := schools[user.School]
 	if !ok {
-		logger.Debug(errors.New(nil, "unsupported platform"))
-		return filtered
+		return filtered, errors.New(nil, "unsupported school")
 	}
-	classes, err := school.Classes(user)
-	if err != nil {
-		logger.Debug(errors.New(err, "cannot fetch class list"))
-		return filtered
+	classes, classErr := school.Classes(ctx, user)
+	if !site.Usable(classErr) {
+		return filtered, errors.New(classErr, "cannot fetch class list")
 	}
-	tasks, err := school.Tasks(user, classes...)
-	if err != nil {
-		logger.Debug(errors.New(err, "cannot fetch tasks list"))
-		return filtered
+	tasks, taskErr := school.Tasks(ctx, user, classes...)
+	if !site.Usable(taskErr) {
+		return filtered, errors.New(taskErr, "cannot fetch tasks list")
 	}
 	for _, task := range tasks {
 		if task.Graded {
@@ -58,27 +88,71 @@ func getTasks(user site.User) map[string][]site.Task {
 	sort.SliceStable(filtered["active"], func(i, j int) bool {
 		return filtered["active"][i].Due.Unix() < filtered["active"][j].Due.Unix()
 	})
-	return filtered
+	return filtered, joinPartial(classErr, taskErr)
+}
+
+// getDueTasks returns the user's active tasks, grouped by whether they are
+// overdue, due today, due later this week, or due after this week (see
+// groupDue). Each group is sorted by due date.
+func getDueTasks(ctx context.Context, user site.User) (map[string][]site.Task, error) {
+	school, ok := schools[user.School]
+	if !ok {
+		return groupDue(nil, time.Now()), errors.New(nil, "unsupported school")
+	}
+	tasks, err := school.DueTasks(ctx, user)
+	if !site.Usable(err) {
+		return groupDue(nil, time.Now()), errors.New(err, "cannot fetch active tasks list")
+	}
+	return groupDue(tasks, time.Now().In(user.Timezone)), err
+}
+
+// groupDue groups tasks by when they are due relative to now, keeping their
+// order. Weeks are calendar weeks, which end at midnight on Sunday night in the
+// location of now.
+func groupDue(tasks []site.Task, now time.Time) map[string][]site.Task {
+	grouped := map[string][]site.Task{
+		"overdue": {},
+		"today":   {},
+		"week":    {},
+		"later":   {},
+	}
+	today := midnight(now)
+	todayEnd := today.AddDate(0, 0, 1)
+	// days until next Monday, counting Sunday as the last day of the week
+	days := 8 - int(today.Weekday())
+	if today.Weekday() == time.Sunday {
+		days = 1
+	}
+	weekEnd := today.AddDate(0, 0, days)
+	for _, task := range tasks {
+		if task.Due.Before(now) {
+			grouped["overdue"] = append(grouped["overdue"], task)
+		} else if task.Due.Before(todayEnd) {
+			grouped["today"] = append(grouped["today"], task)
+		} else if task.Due.Before(weekEnd) {
+			grouped["week"] = append(grouped["week"], task)
+		} else {
+			grouped["later"] = append(grouped["later"], task)
+		}
+	}
+	return grouped
 }
 
 // TODO: refactor
-func getResources(user site.User) ([]string, map[string][]site.Resource) {
+func getResources(ctx context.Context, user site.User) ([]string, map[string][]site.Resource, error) {
 	var classList []string
 	resMap := make(map[string][]site.Resource)
 	school, ok := schools[user.School]
 	if !ok {
-		logger.Debug(errors.New(nil, "unsupported platform"))
-		return classList, resMap
+		return classList, resMap, errors.New(nil, "unsupported school")
 	}
-	classes, err := school.Classes(user)
-	if err != nil {
-		logger.Debug(errors.New(err, "cannot fetch class list"))
-		return classList, resMap
+	classes, classErr := school.Classes(ctx, user)
+	if !site.Usable(classErr) {
+		return classList, resMap, errors.New(classErr, "cannot fetch class list")
 	}
-	resources, err := school.Resources(user, classes...)
-	if err != nil {
-		logger.Debug(errors.New(err, "cannot fetch resources list"))
-		return classList, resMap
+	resources, resErr := school.Resources(ctx, user, classes...)
+	if !site.Usable(resErr) {
+		return classList, resMap, errors.New(resErr, "cannot fetch resources list")
 	}
 	for _, resource := range resources {
 		resMap[resource.Class] = append(resMap[resource.Class], resource)
@@ -92,5 +166,5 @@ func getResources(user site.User) ([]string, map[string][]site.Resource) {
 			return resMap[class][i].Posted.Unix() > resMap[class][j].Posted.Unix()
 		})
 	}
-	return classList, resMap
+	return classList, resMap, joinPartial(classErr, resErr)
 }
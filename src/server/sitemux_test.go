@@ -0,0 +1,82 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"main/site"
+)
+
+func TestGroupDue(t *testing.T) {
+	loc := time.FixedZone("ACST", 570*60)
+	at := func(day, hour int) time.Time {
+		return time.Date(2026, 3, day, hour, 0, 0, 0, loc)
+	}
+	// 2 March 2026 is a Monday.
+	tasks := []site.Task{
+		{Name: "last week", Due: at(1, 23)},
+		{Name: "monday", Due: at(2, 15)},
+		{Name: "tuesday", Due: at(3, 9)},
+		{Name: "friday", Due: at(6, 9)},
+		{Name: "sunday", Due: at(8, 23)},
+		{Name: "next monday", Due: at(9, 9)},
+		{Name: "next week", Due: at(12, 9)},
+	}
+	tests := []struct {
+		name string
+		now  time.Time
+		want map[string][]string
+	}{
+		{"monday", at(2, 10), map[string][]string{
+			"overdue": {"last week"},
+			"today":   {"monday"},
+			"week":    {"tuesday", "friday", "sunday"},
+			"later":   {"next monday", "next week"},
+		}},
+		{"thursday", at(5, 10), map[string][]string{
+			"overdue": {"last week", "monday", "tuesday"},
+			"today":   {},
+			"week":    {"friday", "sunday"},
+			"later":   {"next monday", "next week"},
+		}},
+		{"saturday", at(7, 10), map[string][]string{
+			"overdue": {"last week", "monday", "tuesday", "friday"},
+			"today":   {},
+			"week":    {"sunday"},
+			"later":   {"next monday", "next week"},
+		}},
+		{"sunday", at(8, 10), map[string][]string{
+			"overdue": {"last week", "monday", "tuesday", "friday"},
+			"today":   {"sunday"},
+			"week":    {},
+			"later":   {"next monday", "next week"},
+		}},
+		// Days and weeks end at midnight in the location of now, which
+		// is 9:30 on Monday morning here.
+		{"other zone", at(8, 10).UTC(), map[string][]string{
+			"overdue": {"last week", "monday", "tuesday", "friday"},
+			"today":   {"sunday", "next monday"},
+			"week":    {},
+			"later":   {"next week"},
+		}},
+	}
+	for _, test := range tests {
+		got := groupDue(tasks, test.now)
+		for group, want := range test.want {
+			var names []string
+			for _, task := range got[group] {
+				names = append(names, task.Name)
+			}
+			if len(names) != len(want) {
+				t.Errorf("%s: %s group is %q, want %q", test.name, group, names, want)
+				continue
+			}
+			for i := range want {
+				if names[i] != want[i] {
+					t.Errorf("%s: %s group is %q, want %q", test.name, group, names, want)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,452 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	path "path/filepath"
+	"sync"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/notify"
+	"main/site"
+)
+
+// Session represents an authenticated TaskCollect session. Both login sessions
+// and named API tokens are represented as sessions; they are distinguished by
+// their scope.
+type Session struct {
+	Uid site.Uid
+	// a user-chosen name for an API token; empty for login sessions
+	Name    string
+	Scope   string
+	Created time.Time
+	// the zero time for sessions which never expire
+	Expiry time.Time
+}
+
+// Store is a session store used by Creds to hold sessions and users. A Store
+// must be safe for concurrent use.
+type Store interface {
+	// Session returns the session identified by token.
+	Session(token string) (Session, error)
+	// SetSession adds or replaces the session identified by token.
+	SetSession(token string, session Session) error
+	// DeleteSession removes the session identified by token.
+	DeleteSession(token string) error
+	// Sessions returns all sessions belonging to the user with the given uid,
+	// keyed by token.
+	Sessions(uid site.Uid) (map[string]Session, error)
+	// Active returns the uids of all users with a login session which has
+	// not expired by now.
+	Active(now time.Time) ([]site.Uid, error)
+	// User returns the user with the given uid.
+	User(uid site.Uid) (site.User, error)
+	// SetUser adds or replaces user.
+	SetUser(user site.User) error
+	// Activity returns the activity feed of the user with the given uid,
+	// which is empty if none has been set.
+	Activity(uid site.Uid) (Activity, error)
+	// SetActivity replaces the activity feed of the user with the given uid.
+	SetActivity(uid site.Uid, activity Activity) error
+	// Subscriptions returns the Web Push subscriptions of the user with the
+	// given uid.
+	Subscriptions(uid site.Uid) ([]notify.Subscription, error)
+	// SetSubscriptions replaces the Web Push subscriptions of the user with
+	// the given uid.
+	SetSubscriptions(uid site.Uid, subs []notify.Subscription) error
+	// Sweep removes all sessions which have expired by now. Sessions with a
+	// zero expiry time are never removed.
+	Sweep(now time.Time) error
+}
+
+// memStore is an in-memory session store. Its contents are lost when the
+// server exits.
+type memStore struct {
+	sessions   map[string]Session
+	users      map[site.Uid]site.User
+	activities map[site.Uid]Activity
+	subs       map[site.Uid][]notify.Subscription
+	mutex      sync.Mutex
+}
+
+func newMemStore() *memStore {
+	return &memStore{
+		sessions:   make(map[string]Session),
+		users:      make(map[site.Uid]site.User),
+		activities: make(map[site.Uid]Activity),
+		subs:       make(map[site.Uid][]notify.Subscription),
+	}
+}
+
+func (s *memStore) Session(token string) (Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	session, ok := s.sessions[token]
+	if !ok {
+		return Session{}, errors.New(nil, "no session with matching token: %s", token)
+	}
+	return session, nil
+}
+
+func (s *memStore) SetSession(token string, session Session) error {
+	s.mutex.Lock()
+	s.sessions[token] = session
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memStore) DeleteSession(token string) error {
+	s.mutex.Lock()
+	delete(s.sessions, token)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memStore) Sessions(uid site.Uid) (map[string]Session, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sessions := make(map[string]Session)
+	for token, session := range s.sessions {
+		if session.Uid == uid {
+			sessions[token] = session
+		}
+	}
+	return sessions, nil
+}
+
+func (s *memStore) Active(now time.Time) ([]site.Uid, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var uids []site.Uid
+	seen := make(map[site.Uid]bool)
+	for _, session := range s.sessions {
+		if session.Scope != scopeSession || seen[session.Uid] {
+			continue
+		}
+		if !session.Expiry.IsZero() && now.After(session.Expiry) {
+			continue
+		}
+		seen[session.Uid] = true
+		uids = append(uids, session.Uid)
+	}
+	return uids, nil
+}
+
+func (s *memStore) User(uid site.Uid) (site.User, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	user, ok := s.users[uid]
+	if !ok {
+		return site.User{}, errors.New(nil, `no user with matching uid: {"%s", "%s"}`, uid.School, uid.Username)
+	}
+	return user, nil
+}
+
+func (s *memStore) SetUser(user site.User) error {
+	uid := site.Uid{School: user.School, Username: user.Username}
+	s.mutex.Lock()
+	s.users[uid] = user
+	s.mutex.Unlock()
+	return nil
+}
+
+// copyActivity returns a copy of activity whose States may be modified without
+// affecting activity. The state of each type of data, like Reminded, is only
+// ever replaced, so it is not copied itself.
+func copyActivity(activity Activity) Activity {
+	states := make(map[string]map[string]itemState, len(activity.States))
+	for kind, state := range activity.States {
+		states[kind] = state
+	}
+	activity.States = states
+	return activity
+}
+
+func (s *memStore) Activity(uid site.Uid) (Activity, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return copyActivity(s.activities[uid]), nil
+}
+
+func (s *memStore) SetActivity(uid site.Uid, activity Activity) error {
+	s.mutex.Lock()
+	s.activities[uid] = copyActivity(activity)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memStore) Subscriptions(uid site.Uid) ([]notify.Subscription, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]notify.Subscription(nil), s.subs[uid]...), nil
+}
+
+func (s *memStore) SetSubscriptions(uid site.Uid, subs []notify.Subscription) error {
+	s.mutex.Lock()
+	if len(subs) == 0 {
+		delete(s.subs, uid)
+	} else {
+		s.subs[uid] = append([]notify.Subscription(nil), subs...)
+	}
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *memStore) Sweep(now time.Time) error {
+	s.mutex.Lock()
+	for token, session := range s.sessions {
+		if !session.Expiry.IsZero() && now.After(session.Expiry) {
+			delete(s.sessions, token)
+		}
+	}
+	s.mutex.Unlock()
+	return nil
+}
+
+// userRecord is the on-disk representation of a site.User. Users are sealed
+// while held by Creds, so the password, platform tokens, HOTP keys and webhook
+// secrets in a userRecord are always encrypted with the server key.
+type userRecord struct {
+	Timezone   string                     `json:"timezone"`
+	School     string                     `json:"school"`
+	DispName   string                     `json:"dispName"`
+	Email      string                     `json:"email"`
+	Username   string                     `json:"username"`
+	Password   string                     `json:"password"`
+	SiteTokens map[string]string          `json:"siteTokens"`
+	Config     map[string]site.UserConfig `json:"config"`
+	Notify     site.NotifyConfig          `json:"notify"`
+}
+
+// activityRecord is the on-disk representation of a user's activity feed.
+type activityRecord struct {
+	School   string   `json:"school"`
+	Username string   `json:"username"`
+	Activity Activity `json:"activity"`
+}
+
+// subscriptionRecord is the on-disk representation of a user's Web Push
+// subscriptions.
+type subscriptionRecord struct {
+	School        string                `json:"school"`
+	Username      string                `json:"username"`
+	Subscriptions []notify.Subscription `json:"subscriptions"`
+}
+
+// snapshot is the on-disk representation of a fileStore.
+type snapshot struct {
+	Sessions      map[string]Session   `json:"sessions"`
+	Users         []userRecord         `json:"users"`
+	Activities    []activityRecord     `json:"activities"`
+	Subscriptions []subscriptionRecord `json:"subscriptions"`
+}
+
+// fileStore is a session store which keeps its contents in memory and writes a
+// snapshot of them to a file on every change, so that sessions survive a server
+// restart.
+type fileStore struct {
+	mem  *memStore
+	path string
+	// guards writes to the snapshot file
+	mutex sync.Mutex
+}
+
+// newFileStore returns a fileStore backed by the file at the given path. If the
+// file exists, its contents are loaded into the store.
+func newFileStore(path string) (*fileStore, error) {
+	s := &fileStore{
+		mem:  newMemStore(),
+		path: path,
+	}
+	err := s.load()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return s, nil
+}
+
+func (s *fileStore) load() error {
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New(err, "cannot read session file")
+	}
+	var snap snapshot
+	err = json.Unmarshal(b, &snap)
+	if err != nil {
+		return errors.New(err, "cannot parse session file")
+	}
+	migrated := false
+	now := time.Now().UTC()
+	for token, session := range snap.Sessions {
+		// Login sessions saved before sessions had scopes are stored
+		// under their token, rather than its digest. Those without an
+		// expiry are given a full session lifetime from now, so that
+		// they do not last forever.
+		if session.Scope == "" {
+			token = digest(token)
+			session.Scope = scopeSession
+			if session.Expiry.IsZero() {
+				session.Expiry = now.Add(sessionLifetime)
+			}
+			migrated = true
+		}
+		s.mem.sessions[token] = session
+	}
+	for _, record := range snap.Users {
+		tz, err := time.LoadLocation(record.Timezone)
+		if err != nil {
+			return errors.New(err, "cannot load timezone for user %s", record.Username)
+		}
+		user := site.User{
+			Timezone:   tz,
+			School:     record.School,
+			DispName:   record.DispName,
+			Email:      record.Email,
+			Username:   record.Username,
+			Password:   record.Password,
+			SiteTokens: record.SiteTokens,
+			Config:     record.Config,
+			Notify:     record.Notify,
+		}
+		if user.SiteTokens == nil {
+			user.SiteTokens = make(map[string]string)
+		}
+		uid := site.Uid{School: user.School, Username: user.Username}
+		s.mem.users[uid] = user
+	}
+	for _, record := range snap.Activities {
+		uid := site.Uid{School: record.School, Username: record.Username}
+		s.mem.activities[uid] = record.Activity
+	}
+	for _, record := range snap.Subscriptions {
+		uid := site.Uid{School: record.School, Username: record.Username}
+		s.mem.subs[uid] = record.Subscriptions
+	}
+	err = s.mem.Sweep(time.Now())
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if migrated {
+		// The unhashed tokens of migrated sessions are not kept on disk.
+		return s.save()
+	}
+	return nil
+}
+
+// save writes a snapshot of the store's contents to its file. The snapshot is
+// written to a temporary file first so that a crash cannot corrupt it.
+func (s *fileStore) save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var snap snapshot
+	s.mem.mutex.Lock()
+	snap.Sessions = make(map[string]Session, len(s.mem.sessions))
+	for token, session := range s.mem.sessions {
+		snap.Sessions[token] = session
+	}
+	for _, user := range s.mem.users {
+		record := userRecord{
+			Timezone:   user.Timezone.String(),
+			School:     user.School,
+			DispName:   user.DispName,
+			Email:      user.Email,
+			Username:   user.Username,
+			Password:   user.Password,
+			SiteTokens: user.SiteTokens,
+			Config:     user.Config,
+			Notify:     user.Notify,
+		}
+		snap.Users = append(snap.Users, record)
+	}
+	for uid, activity := range s.mem.activities {
+		snap.Activities = append(snap.Activities, activityRecord{
+			School:   uid.School,
+			Username: uid.Username,
+			Activity: activity,
+		})
+	}
+	for uid, subs := range s.mem.subs {
+		snap.Subscriptions = append(snap.Subscriptions, subscriptionRecord{
+			School:        uid.School,
+			Username:      uid.Username,
+			Subscriptions: subs,
+		})
+	}
+	b, err := json.Marshal(snap)
+	s.mem.mutex.Unlock()
+	if err != nil {
+		return errors.New(err, "cannot marshal session file")
+	}
+	err = os.MkdirAll(path.Dir(s.path), os.ModePerm)
+	if err != nil {
+		return errors.New(err, "cannot create session file directory")
+	}
+	tmp := s.path + ".tmp"
+	err = os.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return errors.New(err, "cannot write session file")
+	}
+	err = os.Rename(tmp, s.path)
+	if err != nil {
+		return errors.New(err, "cannot replace session file")
+	}
+	return nil
+}
+
+func (s *fileStore) Session(token string) (Session, error) {
+	return s.mem.Session(token)
+}
+
+func (s *fileStore) SetSession(token string, session Session) error {
+	s.mem.SetSession(token, session)
+	return s.save()
+}
+
+func (s *fileStore) DeleteSession(token string) error {
+	s.mem.DeleteSession(token)
+	return s.save()
+}
+
+func (s *fileStore) Sessions(uid site.Uid) (map[string]Session, error) {
+	return s.mem.Sessions(uid)
+}
+
+func (s *fileStore) Active(now time.Time) ([]site.Uid, error) {
+	return s.mem.Active(now)
+}
+
+func (s *fileStore) User(uid site.Uid) (site.User, error) {
+	return s.mem.User(uid)
+}
+
+func (s *fileStore) SetUser(user site.User) error {
+	s.mem.SetUser(user)
+	return s.save()
+}
+
+func (s *fileStore) Activity(uid site.Uid) (Activity, error) {
+	return s.mem.Activity(uid)
+}
+
+func (s *fileStore) SetActivity(uid site.Uid, activity Activity) error {
+	s.mem.SetActivity(uid, activity)
+	return s.save()
+}
+
+func (s *fileStore) Subscriptions(uid site.Uid) ([]notify.Subscription, error) {
+	return s.mem.Subscriptions(uid)
+}
+
+func (s *fileStore) SetSubscriptions(uid site.Uid, subs []notify.Subscription) error {
+	s.mem.SetSubscriptions(uid, subs)
+	return s.save()
+}
+
+func (s *fileStore) Sweep(now time.Time) error {
+	s.mem.Sweep(now)
+	return s.save()
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"os"
+	path "path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// legacySessions is a session file written before sessions had scopes, whose
+// sessions are stored under their token.
+const legacySessions = `{
+	"sessions": {
+		"legacy-token": {
+			"Uid": {"School": "school", "Username": "student"},
+			"Expiry": "0001-01-01T00:00:00Z"
+		}
+	},
+	"users": [
+		{"timezone": "UTC", "school": "school", "username": "student"}
+	]
+}`
+
+// setStore makes store the session store of creds for the rest of the test,
+// restoring the previous store when the test ends.
+func setStore(t *testing.T, store Store) {
+	t.Helper()
+	old := creds.Store
+	creds.Store = store
+	t.Cleanup(func() { creds.Store = old })
+}
+
+func TestFileStoreMigration(t *testing.T) {
+	file := path.Join(t.TempDir(), "sessions.json")
+	err := os.WriteFile(file, []byte(legacySessions), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := newFileStore(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setStore(t, store)
+	user, err := creds.authorize("legacy-token", scopeSession)
+	if err != nil {
+		t.Fatalf("legacy session was rejected: %v", err)
+	}
+	if user.Username != "student" {
+		t.Errorf("got user %q, want student", user.Username)
+	}
+	session, err := creds.session("legacy-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lifetime := time.Until(session.Expiry); lifetime <= 0 || lifetime > sessionLifetime {
+		t.Errorf("legacy session expires in %s, want at most %s", lifetime, sessionLifetime)
+	}
+	uids, err := store.Active(time.Now())
+	if err != nil || len(uids) != 1 {
+		t.Errorf("got active users %v, %v, want the legacy session's user", uids, err)
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "legacy-token") {
+		t.Errorf("session file still holds the unhashed token")
+	}
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/logger"
+	"main/site"
+)
+
+// Types of data pulled from platforms by the sync scheduler.
+const (
+	syncTasks     = "tasks"
+	syncGraded    = "graded"
+	syncResources = "resources"
+	syncLessons   = "lessons"
+)
+
+// defaultSyncIntervals is the time between syncs of each type of data, unless
+// set otherwise in config.json.
+var defaultSyncIntervals = map[string]time.Duration{
+	syncTasks:     15 * time.Minute,
+	syncGraded:    time.Hour,
+	syncResources: time.Hour,
+	syncLessons:   6 * time.Hour,
+}
+
+// defaultSyncJitter is the fraction of each sync interval by which syncs are
+// randomly brought forward or delayed, so that the syncs of users who logged
+// in at the same time are spread out.
+const defaultSyncJitter = 0.1
+
+// defaultRateLimit is the number of syncs per minute allowed to contact each
+// platform, unless set otherwise in config.json.
+const defaultRateLimit = 30
+
+// A syncJob is a type of data to be synced for a user.
+type syncJob struct {
+	uid  site.Uid
+	kind string
+}
+
+// scheduler periodically pulls data from the platforms of each user with an
+// active login session, so that pages can be rendered from cached data. Syncs
+// refresh the same cached results as are shown on each page, which are kept
+// fresh until the next sync regardless of their TTL, and are paced so that no
+// platform is sent more syncs than its rate limit allows.
+type scheduler struct {
+	intervals map[string]time.Duration
+	jitter    float64
+	limiter   *rateLimiter
+	mutex     sync.Mutex
+	next      map[syncJob]time.Time
+	running   map[syncJob]bool
+}
+
+// newScheduler returns a scheduler configured by cfg.
+func newScheduler(cfg syncConfig) *scheduler {
+	s := &scheduler{
+		intervals: make(map[string]time.Duration),
+		jitter:    defaultSyncJitter,
+		limiter:   newRateLimiter(defaultRateLimit),
+		next:      make(map[syncJob]time.Time),
+		running:   make(map[syncJob]bool),
+	}
+	for kind, d := range defaultSyncIntervals {
+		s.intervals[kind] = d
+	}
+	for kind, secs := range cfg.Intervals {
+		if _, ok := s.intervals[kind]; !ok {
+			logger.Warn("Ignoring sync interval for unknown data type %q", kind)
+			continue
+		}
+		if secs > 0 {
+			s.intervals[kind] = time.Duration(secs) * time.Second
+		} else {
+			delete(s.intervals, kind)
+		}
+	}
+	if cfg.Jitter >= 0 && cfg.Jitter < 1 {
+		s.jitter = cfg.Jitter
+	}
+	if cfg.RateLimits.Default > 0 {
+		s.limiter = newRateLimiter(cfg.RateLimits.Default)
+	}
+	for platform, limit := range cfg.RateLimits.Platforms {
+		if limit > 0 {
+			s.limiter.set(platform, limit)
+		}
+	}
+	return s
+}
+
+// Run checks for due syncs at every tick, starting each in the background.
+// Run never returns.
+func (s *scheduler) Run(tick time.Duration) {
+	for {
+		s.schedule(time.Now())
+		time.Sleep(tick)
+	}
+}
+
+// schedule starts the syncs which are due by now, and schedules the first syncs
+// of users who have logged in since the last call. Users whose sessions have
+// ended are forgotten.
+func (s *scheduler) schedule(now time.Time) {
+	uids, err := creds.Store.Active(now)
+	if err != nil {
+		logger.Error(errors.New(err, "cannot list active users"))
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	active := make(map[syncJob]bool)
+	for _, uid := range uids {
+		for kind, interval := range s.intervals {
+			job := syncJob{uid, kind}
+			active[job] = true
+			next, ok := s.next[job]
+			if !ok {
+				// Spread out the first syncs of users who were already
+				// logged in when the server started.
+				s.next[job] = now.Add(time.Duration(rand.Float64() * s.jitter * float64(interval)))
+				continue
+			}
+			if s.running[job] || now.Before(next) {
+				continue
+			}
+			s.running[job] = true
+			go s.run(job)
+		}
+	}
+	for job := range s.next {
+		if !active[job] {
+			delete(s.next, job)
+		}
+	}
+}
+
+// run syncs job and schedules its next sync.
+func (s *scheduler) run(job syncJob) {
+	err := s.sync(job)
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot sync %s", job.kind))
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.running, job)
+	s.next[job] = time.Now().Add(s.delay(s.intervals[job.kind]))
+}
+
+// delay returns the time until the next sync of data synced at the given
+// interval, which is randomly moved by up to the scheduler's jitter.
+func (s *scheduler) delay(interval time.Duration) time.Duration {
+	jitter := (2*rand.Float64() - 1) * s.jitter * float64(interval)
+	return interval + time.Duration(jitter)
+}
+
+// keep returns the time for which the results of a sync of the given type of
+// data are kept fresh, which is the longest delay until the next sync. If the
+// next sync is late, the results are still returned while being fetched again
+// in the background (see site.Cache).
+func (s *scheduler) keep(kind string) time.Duration {
+	interval := s.intervals[kind]
+	return interval + time.Duration(s.jitter*float64(interval))
+}
+
+// sync pulls the data of the given job from the platforms of its user's school,
+// replacing any cached results, and records any changes since the previous
+// sync in the user's activity feed. Changes are only detected from complete,
+// freshly fetched results. If notifications are enabled, the user is then
+// notified of the changes, and of tasks due soon, according to their rules.
+func (s *scheduler) sync(job syncJob) error {
+	user, err := creds.Store.User(job.uid)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	school, ok := schools[user.School]
+	if !ok {
+		return errors.New(nil, "unsupported school: %s", user.School)
+	}
+	// The time allowed for the sync only starts once the rate limits allow
+	// it, since many syncs may be waiting for the same platform.
+	for _, platform := range school.Platforms {
+		s.limiter.wait(platform)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), site.RequestTimeout)
+	defer cancel()
+	refresh := site.KeepFor(site.Refresh(ctx), s.keep(job.kind))
+
+	var states map[string]itemState
+	var tasks []site.Task
+	var start, end time.Time
+	switch job.kind {
+	case syncTasks:
+		// Classes are fetched as for the tasks page, so that the synced
+		// tasks replace the ones cached for the page.
+		classes, classErr := school.Classes(ctx, user)
+		if !site.Usable(classErr) {
+			return errors.Wrap(classErr)
+		}
+		tasks, err = school.Tasks(refresh, user, classes...)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		if classErr != nil {
+			return incomplete(classErr)
+		}
+		states = taskStates(tasks)
+	case syncGraded:
+		tasks, err := school.Graded(refresh, user)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		states = taskStates(tasks)
+	case syncResources:
+		classes, classErr := school.Classes(ctx, user)
+		if !site.Usable(classErr) {
+			return errors.Wrap(classErr)
+		}
+		resources, err := school.Resources(refresh, user, classes...)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		if classErr != nil {
+			return incomplete(classErr)
+		}
+		states = resourceStates(resources)
+	case syncLessons:
+		start, end = schoolWeek(user)
+		lessons, err := school.Lessons(refresh, user, start, end)
+		if err != nil {
+			return errors.Wrap(err)
+		}
+		states = lessonStates(lessons)
+		// Lessons are fetched up to the end of the last day.
+		end = end.AddDate(0, 0, 1)
+	default:
+		return errors.New(nil, "unknown data type: %s", job.kind)
+	}
+	if job.kind == syncTasks || job.kind == syncResources {
+		forgetIndex(job.uid)
+	}
+	changes, err := recordChanges(job.uid, job.kind, states, start, end)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	if notifications != nil {
+		notifications.notify(user, changes)
+		if job.kind == syncTasks {
+			notifications.remind(user, tasks)
+		}
+	}
+	return nil
+}
+
+// incomplete returns the error with which a sync fails when the class list it
+// used was incomplete or stale because of err. The synced data is still cached,
+// but changes are not recorded, since the items of classes missing from the
+// list would be dropped from the recorded state, and then reported as new once
+// the list is complete again.
+func incomplete(err error) error {
+	return errors.New(err, "not recording changes from incomplete class list")
+}
+
+// rateLimiter paces the syncs sent to each platform so that no more than a set
+// number are started each minute.
+type rateLimiter struct {
+	mutex sync.Mutex
+	// the time between syncs for platforms not in gaps
+	gap  time.Duration
+	gaps map[string]time.Duration
+	// the time reserved for the most recent sync of each platform
+	last map[string]time.Time
+}
+
+// newRateLimiter returns a rateLimiter which allows limit syncs per minute to
+// each platform.
+func newRateLimiter(limit int) *rateLimiter {
+	return &rateLimiter{
+		gap:  time.Minute / time.Duration(limit),
+		gaps: make(map[string]time.Duration),
+		last: make(map[string]time.Time),
+	}
+}
+
+// set allows limit syncs per minute to the given platform.
+func (l *rateLimiter) set(platform string, limit int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.gaps[platform] = time.Minute / time.Duration(limit)
+}
+
+// wait blocks until a sync may be sent to platform.
+func (l *rateLimiter) wait(platform string) {
+	l.mutex.Lock()
+	gap, ok := l.gaps[platform]
+	if !ok {
+		gap = l.gap
+	}
+	now := time.Now()
+	at := l.last[platform].Add(gap)
+	if at.Before(now) {
+		at = now
+	}
+	l.last[platform] = at
+	l.mutex.Unlock()
+	time.Sleep(at.Sub(now))
+}
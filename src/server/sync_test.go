@@ -0,0 +1,115 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"main/site"
+)
+
+func TestSyncDelay(t *testing.T) {
+	s := newScheduler(syncConfig{Jitter: 0.1})
+	for kind, interval := range defaultSyncIntervals {
+		lo := interval - interval/10
+		hi := interval + interval/10
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 100; i++ {
+			d := s.delay(interval)
+			if d < lo || d > hi {
+				t.Fatalf("%s: delay %s is outside [%s, %s]", kind, d, lo, hi)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("%s: delay is never moved", kind)
+		}
+		if keep := s.keep(kind); keep < hi {
+			t.Errorf("%s: synced data is kept for %s, but the next sync may be %s away", kind, keep, hi)
+		}
+		if keep := s.keep(kind); keep < site.DefaultTTLs[kind] {
+			t.Errorf("%s: synced data is kept for %s, less than its TTL", kind, keep)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	store := newMemStore()
+	setStore(t, store)
+	uid := site.Uid{School: "school", Username: "student"}
+	store.SetSession("token", Session{Uid: uid, Scope: scopeSession})
+	store.SetSession("api", Session{Uid: site.Uid{School: "school", Username: "api"}, Scope: scopeRead})
+
+	interval := time.Hour
+	s := newScheduler(syncConfig{
+		Intervals: map[string]int{
+			syncTasks:     int(interval / time.Second),
+			syncGraded:    0,
+			syncResources: 0,
+			syncLessons:   0,
+		},
+		Jitter: 0.1,
+	})
+	job := syncJob{uid, syncTasks}
+
+	// The first sync is scheduled within the jitter of the interval.
+	now := time.Now()
+	s.schedule(now)
+	s.mutex.Lock()
+	if len(s.next) != 1 {
+		t.Fatalf("scheduled %d jobs, want 1", len(s.next))
+	}
+	next, ok := s.next[job]
+	s.mutex.Unlock()
+	if !ok || next.Before(now) || next.After(now.Add(interval/10)) {
+		t.Fatalf("first sync at %v, want within %s of %v", next, interval/10, now)
+	}
+
+	// Once due, the sync is run, and the next one is scheduled an interval
+	// later, whether or not it succeeded.
+	s.schedule(next)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mutex.Lock()
+		running := s.running[job]
+		next = s.next[job]
+		s.mutex.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("sync did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if d := time.Until(next); d < interval-interval/10-time.Minute || d > interval+interval/10 {
+		t.Errorf("next sync in %s, want %s give or take %s", d, interval, interval/10)
+	}
+
+	// Users who have logged out are forgotten.
+	store.DeleteSession("token")
+	s.schedule(time.Now())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.next) != 0 {
+		t.Errorf("%d jobs remain scheduled after logout", len(s.next))
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(6000)
+	l.set("slow", 600)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.wait("slow")
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("3 syncs to slow platform took %s, want at least 200ms", d)
+	}
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		l.wait("fast")
+	}
+	if d := time.Since(start); d < 20*time.Millisecond || d >= 200*time.Millisecond {
+		t.Errorf("3 syncs to fast platform took %s, want between 20ms and 200ms", d)
+	}
+}
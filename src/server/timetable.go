@@ -1,7 +1,7 @@
 package server
 
 import (
-	"crypto/rand"
+	"context"
 	"fmt"
 	"image"
 	"image/color"
@@ -10,6 +10,7 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"git.sr.ht/~kvo/go-std/errors"
@@ -21,11 +22,10 @@ import (
 	"golang.org/x/image/font/gofont/goregular"
 	"golang.org/x/image/math/fixed"
 
+	"main/logger"
 	"main/site"
 )
 
-type UUID [16]byte
-
 var (
 	colors   = make(map[string]color.RGBA)
 	charcoal = color.RGBA{0x30, 0x30, 0x30, 0xff}
@@ -45,6 +45,31 @@ var palette = []color.RGBA{
 	{0x00, 0x38, 0x34, 0xff}, // Myrtle green
 }
 
+// The colour of calendar events which do not specify their own.
+var eventDefault = color.RGBA{0x37, 0x37, 0x3e, 0xff}
+
+// eventColor returns the background colour of event.
+func eventColor(event site.Event) color.RGBA {
+	if event.Color == nil {
+		return eventDefault
+	}
+	r, g, b, _ := event.Color.RGBA()
+	return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), 0xff}
+}
+
+// textColor returns the hex colour of text readable on the background c.
+func textColor(c color.RGBA) string {
+	luminance := (0.299*float32(c.R) + 0.587*float32(c.G) + 0.114*float32(c.B)) / 255
+	if luminance > 0.5 {
+		return "#000000"
+	}
+	return "#ffffff"
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
 func midnight(t time.Time) time.Time {
 	return time.Date(
 		t.Year(), t.Month(), t.Day(),
@@ -133,6 +158,132 @@ func mkblock(canvas *image.RGBA, lesson site.Lesson, day, y, h int) error {
 	return nil
 }
 
+// mkevent draws a timed calendar event over the right half of the lessons in
+// its day.
+func mkevent(canvas *image.RGBA, event site.Event, day, y, h int) error {
+	bg := eventColor(event)
+	bg.A = 0xe0
+	x, w := 20+(day*227)+94, 93
+	block := image.NewRGBA(image.Rect(0, 0, w, h))
+	fillrect(block, block.Bounds(), bg)
+	boldttf, err := freetype.ParseFont(gobold.TTF)
+	if err != nil {
+		return errors.New(nil, "cannot parse bold font")
+	}
+	regttf, err := freetype.ParseFont(goregular.TTF)
+	if err != nil {
+		return errors.New(nil, "cannot parse regular font")
+	}
+	headface := truetype.NewFace(boldttf, &truetype.Options{
+		Size:    12.0,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+	regface := truetype.NewFace(regttf, &truetype.Options{
+		Size:    10.0,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+	timeln := event.Start.Format("15:04") + "–" + event.End.Format("15:04")
+	imprint(block, event.Name, headface, image.Pt(4, 15))
+	imprint(block, timeln, regface, image.Pt(4, 28))
+	imprint(block, event.Location, regface, image.Pt(4, 40))
+	draw.Draw(canvas, image.Rect(x, y, x+w, y+h), block, image.Pt(0, 0), draw.Over)
+	return nil
+}
+
+// mkallday draws the names of the all-day events in a day in the band between
+// the day's heading and its first lesson.
+func mkallday(canvas *image.RGBA, events []site.Event, day int) error {
+	if len(events) == 0 {
+		return nil
+	}
+	x, w := 20+(day*227), 187
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = event.Name
+	}
+	block := image.NewRGBA(image.Rect(0, 0, w, 18))
+	fillrect(block, block.Bounds(), eventColor(events[0]))
+	boldttf, err := freetype.ParseFont(gobold.TTF)
+	if err != nil {
+		return errors.New(nil, "cannot parse bold font")
+	}
+	face := truetype.NewFace(boldttf, &truetype.Options{
+		Size:    11.0,
+		DPI:     72,
+		Hinting: font.HintingNone,
+	})
+	imprint(block, strings.Join(names, ", "), face, image.Pt(5, 13))
+	draw.Draw(canvas, image.Rect(x, 41, x+w, 59), block, image.Pt(0, 0), draw.Src)
+	return nil
+}
+
+// daySpan returns the part of event which falls on the day starting at the
+// midnight day, in the location of day. Events which cross midnight are
+// clamped to the day. If the event is all-day or covers the whole day, whole is
+// true.
+func daySpan(event site.Event, day time.Time) (start, end time.Time, whole bool) {
+	next := day.AddDate(0, 0, 1)
+	start = event.Start.In(day.Location())
+	end = event.End.In(day.Location())
+	if event.AllDay || (!start.After(day) && !end.Before(next)) {
+		return day, next, true
+	}
+	if start.Before(day) {
+		start = day
+	}
+	if end.After(next) {
+		end = next
+	}
+	return start, end, false
+}
+
+// mkevents draws calendar events over the lesson blocks on canvas.
+func mkevents(canvas *image.RGBA, events []site.Event, monday time.Time, days int) error {
+	minPerDay := float64(600)
+	pxPerMin := float64(800-80) / minPerDay
+	allday := make([][]site.Event, days)
+	for _, event := range events {
+		for day := 0; day < days; day++ {
+			start := monday.AddDate(0, 0, day)
+			next := start.AddDate(0, 0, 1)
+			if !event.End.After(start) || !event.Start.Before(next) {
+				continue
+			}
+			from, to, whole := daySpan(event, start)
+			if whole {
+				allday[day] = append(allday[day], event)
+				continue
+			}
+			ymins := (from.Hour()-8)*60 + from.Minute()
+			hmins := to.Sub(from).Minutes()
+			y := int(float64(ymins)*pxPerMin) + 60
+			h := int(float64(hmins) * pxPerMin)
+			if y < 60 {
+				h -= 60 - y
+				y = 60
+			}
+			if h <= 0 {
+				continue
+			}
+			part := event
+			part.Start, part.End = from, to
+			err := mkevent(canvas, part, day, y, h)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for day, events := range allday {
+		err := mkallday(canvas, events, day)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // TODO: scale y and h differently depending on day start/end times
 func mkblocks(canvas *image.RGBA, lessons []site.Lesson, monday time.Time, days int) error {
 	minPerDay := float64(600)
@@ -193,33 +344,35 @@ func mkcanvas(width, height int, monday time.Time, days int) (*image.RGBA, error
 	return canvas, nil
 }
 
+// schoolWeek returns midnight at the start of the first and last days of the
+// user's current school week, which is the following week on Saturdays.
+func schoolWeek(user site.User) (time.Time, time.Time) {
+	now := midnight(time.Now().In(user.Timezone))
+	weekday := now.Weekday()
+	if weekday == time.Saturday {
+		return now.AddDate(0, 0, 2), now.AddDate(0, 0, 6)
+	}
+	return now.AddDate(0, 0, 1-int(weekday)), now.AddDate(0, 0, 5-int(weekday))
+}
+
 // TODO: add function to calc end from start
 // TODO: allow user to specify start date
-func TimetablePNG(user site.User, w http.ResponseWriter) error {
+func TimetablePNG(ctx context.Context, user site.User, w http.ResponseWriter) error {
 	var days = 5
 	var width, height = 1135, 800
 	var classes []string
-	var start, end time.Time
-	var now = midnight(time.Now().In(user.Timezone))
-	var weekday = now.Weekday()
-
-	switch weekday {
-	case time.Saturday:
-		start = now.AddDate(0, 0, 2)
-		end = now.AddDate(0, 0, 6)
-	default:
-		start = now.AddDate(0, 0, 1-int(weekday))
-		end = now.AddDate(0, 0, 5-int(weekday))
-	}
+	start, end := schoolWeek(user)
 	school, ok := schools[user.School]
 	if !ok {
 		w.WriteHeader(500)
-		return errors.New(nil, "unsupported platform")
+		return errors.New(nil, "unsupported school")
 	}
-	lessons, err := school.Lessons(user, start, end)
-	if err != nil {
+	lessons, err := school.Lessons(ctx, user, start, end)
+	if !site.Usable(err) {
 		w.WriteHeader(500)
 		return errors.New(err, "cannot get lessons")
+	} else if err != nil {
+		logger.Debug(err)
 	}
 	for _, lesson := range lessons {
 		if !slices.Has(classes, lesson.Class) {
@@ -238,6 +391,14 @@ func TimetablePNG(user site.User, w http.ResponseWriter) error {
 		w.WriteHeader(500)
 		return errors.New(err, "cannot draw lesson blocks")
 	}
+	events, err := school.Events(ctx, user, start, end.AddDate(0, 0, 1))
+	if err != nil {
+		logger.Debug(errors.New(err, "cannot get events"))
+	}
+	if err := mkevents(canvas, events, start, days); err != nil {
+		w.WriteHeader(500)
+		return errors.New(err, "cannot draw events")
+	}
 	if err := png.Encode(w, canvas); err != nil {
 		w.WriteHeader(500)
 		return errors.New(err, "cannot render PNG timetable")
@@ -245,33 +406,34 @@ func TimetablePNG(user site.User, w http.ResponseWriter) error {
 	return nil
 }
 
-func TimetableHTML(user site.User) (timetableData, error) {
-	data := timetableData{}
-
-	var weekStartIdx, weekEndIdx int
-	now := midnight(time.Now().In(user.Timezone))
-
-	weekday := now.Weekday()
-
-	switch weekday {
-	case 6:
-		weekStartIdx = 2
-		weekEndIdx = 6
-	default:
-		weekStartIdx = 1 - int(weekday)
-		weekEndIdx = 5 - int(weekday)
+// clashes returns, for each of the given lessons, the indices of the lessons
+// from other platforms whose times overlap with it. The lessons must be sorted
+// by start time.
+func clashes(lessons []site.Lesson) [][]int {
+	clashes := make([][]int, len(lessons))
+	for i, a := range lessons {
+		for j := i + 1; j < len(lessons) && lessons[j].Start.Before(a.End); j++ {
+			if a.Platform != lessons[j].Platform && lessons[j].End.After(a.Start) {
+				clashes[i] = append(clashes[i], j)
+				clashes[j] = append(clashes[j], i)
+			}
+		}
 	}
+	return clashes
+}
 
-	weekStart := now.AddDate(0, 0, weekStartIdx)
-	weekEnd := now.AddDate(0, 0, weekEndIdx)
+func TimetableHTML(ctx context.Context, user site.User) (timetableData, error) {
+	data := timetableData{}
+	now := midnight(time.Now().In(user.Timezone))
+	weekStart, weekEnd := schoolWeek(user)
 
 	school, ok := schools[user.School]
 	if !ok {
-		return data, errors.New(nil, "unsupported platform")
+		return data, errors.New(nil, "unsupported school")
 	}
-	lessons, err := school.Lessons(user, weekStart, weekEnd)
-	if err != nil {
-		return data, errors.New(err, "failed to get lessons")
+	lessons, lessonErr := school.Lessons(ctx, user, weekStart, weekEnd)
+	if !site.Usable(lessonErr) {
+		return data, errors.New(lessonErr, "failed to get lessons")
 	}
 
 	const numOfDays = 5
@@ -314,7 +476,24 @@ func TimetableHTML(user site.User) (timetableData, error) {
 
 	dayStart := 800.0 // is 08:00
 
-	for _, lesson := range lessons {
+	// Lessons which clash are shown side by side. A lesson is shown on the
+	// right if it clashes with an earlier lesson shown on the left.
+	clashing := clashes(lessons)
+	sides := make([]string, len(lessons))
+	for i := range lessons {
+		if len(clashing[i]) == 0 {
+			continue
+		}
+		sides[i] = "left"
+		for _, j := range clashing[i] {
+			if j < i && sides[j] == "left" {
+				sides[i] = "right"
+				break
+			}
+		}
+	}
+
+	for i, lesson := range lessons {
 		for lesson.Start.After(monday.AddDate(0, 0, curDay+1)) {
 			curDay++
 		}
@@ -331,26 +510,29 @@ func TimetableHTML(user site.User) (timetableData, error) {
 		duration := endMins - startMins
 
 		c := colors[lesson.Class]
-		hexColor := fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
-
-		textColor := "#ffffff"
-		luminance := (0.299*float32(c.R) + 0.587*float32(c.G) + 0.114*float32(c.B)) / 255
-		if luminance > 0.5 {
-			textColor = "#000000"
-		}
 
 		topOffset := math.Round(float64(startMins)*10/6 - dayStart)
 		height := math.Round(float64(duration) * 10 / 6)
 
 		classInfo := ttLesson{
 			Class:     lesson.Class,
+			Platform:  lesson.Platform,
 			Height:    height,
 			TopOffset: topOffset,
 			Room:      lesson.Room,
 			Teacher:   lesson.Teacher,
 			Notice:    lesson.Notice,
-			Color:     textColor,
-			BGColor:   hexColor,
+			Color:     textColor(c),
+			BGColor:   hexColor(c),
+		}
+
+		if len(clashing[i]) > 0 {
+			var with []string
+			for _, j := range clashing[i] {
+				with = append(with, lessons[j].Class+" ("+lessons[j].Platform+")")
+			}
+			classInfo.Clash = "Clashes with " + strings.Join(with, ", ")
+			classInfo.Side = sides[i]
 		}
 
 		classInfo.FormattedTime = lesson.Start.Format("15:04") + "–" + lesson.End.Format("15:04")
@@ -361,74 +543,193 @@ func TimetableHTML(user site.User) (timetableData, error) {
 		data.Days[curDay].Lessons = append(data.Days[curDay].Lessons, classInfo)
 	}
 
+	// Events are supplementary to lessons, so the timetable is still shown if
+	// they cannot be retrieved.
+	events, err := school.Events(ctx, user, weekStart, weekEnd.AddDate(0, 0, 1))
+	if err != nil {
+		logger.Debug(errors.New(err, "failed to get events"))
+	}
+	for _, event := range events {
+		c := eventColor(event)
+		for i := range data.Days {
+			day := monday.AddDate(0, 0, i)
+			next := day.AddDate(0, 0, 1)
+			if !event.End.After(day) || !event.Start.Before(next) {
+				continue
+			}
+			item := ttEvent{
+				Name:     event.Name,
+				Category: event.Category,
+				Location: event.Location,
+				Color:    textColor(c),
+				BGColor:  hexColor(c),
+			}
+			start, end, whole := daySpan(event, day)
+			if whole {
+				data.Days[i].AllDay = append(data.Days[i].AllDay, item)
+				continue
+			}
+			startMins := start.Hour()*60 + start.Minute()
+			duration := end.Sub(start).Minutes()
+			item.TopOffset = math.Round(float64(startMins)*10/6 - dayStart)
+			item.Height = math.Round(duration * 10 / 6)
+			item.FormattedTime = start.Format("15:04") + "–" + end.Format("15:04")
+			data.Days[i].Events = append(data.Days[i].Events, item)
+		}
+	}
+
 	if time.Now().In(user.Timezone).Before(monday) {
 		data.CurrentDay = 0
 	} else {
 		data.CurrentDay = int(today)
 	}
 
-	return data, nil
+	return data, lessonErr
 }
 
-// Export the user calendar as a .ics file
-
-func TimetableIcal(user site.User, start, end time.Time, w http.ResponseWriter) error {
-	var err error
-	iCalString := ""
+// Task due dates can be included in the iCalendar feed either as to-dos or as
+// events, or left out altogether.
+const (
+	icalTasksNone  = ""
+	icalTasksTodo  = "todo"
+	icalTasksEvent = "event"
+)
 
+// TimetableIcal writes the user's lessons and calendar events from start to
+// end to w as an iCalendar document. If tasks is icalTasksTodo or icalTasksEvent, the due
+// dates of the user's tasks falling in the same period are included as VTODO
+// or VEVENT components.
+func TimetableIcal(ctx context.Context, user site.User, start, end time.Time, tasks string, w io.Writer) error {
 	school, ok := schools[user.School]
 	if !ok {
-		w.WriteHeader(500)
-		return errors.New(nil, "unsupported platform")
+		return errors.New(nil, "unsupported school")
+	}
+	lessons, err := school.Lessons(ctx, user, start, end)
+	if !site.Usable(err) {
+		return errors.New(err, "failed to get lessons")
+	} else if err != nil {
+		logger.Debug(err)
 	}
-	lessons, err := school.Lessons(user, start, end)
 
+	events, err := school.Events(ctx, user, start, end)
 	if err != nil {
-		w.WriteHeader(500)
-		return errors.New(err, "failed to get lessons")
+		logger.Debug(errors.New(err, "failed to get events"))
+	}
+
+	var due []site.Task
+	if tasks != icalTasksNone {
+		// Tasks from the platforms which can be reached are still included
+		// if others fail.
+		classes, err := school.Classes(ctx, user)
+		if !site.Usable(err) {
+			return errors.New(err, "failed to get class list")
+		} else if err != nil {
+			logger.Debug(err)
+		}
+		list, err := school.Tasks(ctx, user, classes...)
+		if !site.Usable(err) {
+			return errors.New(err, "failed to get tasks")
+		} else if err != nil {
+			logger.Debug(err)
+		}
+		for _, task := range list {
+			if !task.Due.Before(start) && task.Due.Before(end) {
+				due = append(due, task)
+			}
+		}
+	}
+
+	loc := user.Timezone
+	now := time.Now().UTC()
+	c := &calendar{}
+	c.prop("BEGIN", "VCALENDAR")
+	c.prop("VERSION", "2.0")
+	c.prop("PRODID", "-//TaskCollect//TaskCollect//EN")
+	c.prop("CALSCALE", "GREGORIAN")
+	c.prop("METHOD", "PUBLISH")
+	c.text("X-WR-CALNAME", "TaskCollect")
+	if loc != time.UTC {
+		c.text("X-WR-TIMEZONE", loc.String())
+		c.vtimezone(loc, start, end.AddDate(0, 0, 1))
 	}
-	//build the start of the string
-	iCalString += "BEGIN:VCALENDAR\n"
-	iCalString += "VERSION:2.0\n"
-	iCalString += "PRODID:taco/calendar\n"
-	iCalString += "CALSCALE:GREGORIAN\n"
-	iCalString += "METHOD:PUBLISH\n"
+
 	for _, lesson := range lessons {
-		uuid, err := GenerateUUID()
-		if err != nil {
-			w.WriteHeader(500)
-			return errors.New(err, "failed to generate UUID")
+		desc := lesson.Teacher
+		if lesson.Notice != "" {
+			desc = strings.TrimSpace(desc + "\n" + lesson.Notice)
+		}
+		c.prop("BEGIN", "VEVENT")
+		c.prop("UID", icalUid(lesson.Platform, lesson.Class, lesson.Start.UTC().Format(time.RFC3339)))
+		c.prop("DTSTAMP", now.Format(icalUtcTime))
+		c.time("DTSTART", lesson.Start.In(loc))
+		c.time("DTEND", lesson.End.In(loc))
+		c.text("SUMMARY", lesson.Class)
+		if desc != "" {
+			c.text("DESCRIPTION", desc)
 		}
+		if lesson.Room != "" {
+			c.text("LOCATION", lesson.Room)
+		}
+		c.prop("END", "VEVENT")
+	}
+
+	for _, event := range events {
+		c.prop("BEGIN", "VEVENT")
+		c.prop("UID", icalUid("event", event.Platform, event.Name, event.Start.UTC().Format(time.RFC3339)))
+		c.prop("DTSTAMP", now.Format(icalUtcTime))
+		if event.AllDay {
+			c.date("DTSTART", event.Start.In(loc))
+			c.date("DTEND", event.End.In(loc))
+		} else {
+			c.time("DTSTART", event.Start.In(loc))
+			c.time("DTEND", event.End.In(loc))
+		}
+		c.text("SUMMARY", event.Name)
+		if event.Location != "" {
+			c.text("LOCATION", event.Location)
+		}
+		if event.Category != "" {
+			c.text("CATEGORIES", event.Category)
+		}
+		c.prop("TRANSP", "TRANSPARENT")
+		c.prop("END", "VEVENT")
+	}
 
-		iCalString += "BEGIN:VEVENT\n"
-		iCalString += string(uuid[:]) + "\n"
-		iCalString += "DTSTAMP:" + time.Now().Format("20060102T150405Z") + "\n"
-		iCalString += "DTSTART:" + lesson.Start.Format("20060102T150405Z") + "\n"
-		iCalString += "DTEND:" + lesson.End.Format("20060102T150405Z") + "\n"
-		iCalString += "SUMMARY:" + lesson.Class + "\n"
-		iCalString += "DESCRIPTION:" + lesson.Teacher + "\n"
-		iCalString += "LOCATION:" + lesson.Room + "\n"
-		iCalString += "END:VEVENT\n"
+	for _, task := range due {
+		if tasks == icalTasksTodo {
+			c.prop("BEGIN", "VTODO")
+		} else {
+			c.prop("BEGIN", "VEVENT")
+		}
+		c.prop("UID", icalUid("task", task.Platform, task.Id))
+		c.prop("DTSTAMP", now.Format(icalUtcTime))
+		if tasks == icalTasksTodo {
+			c.time("DUE", task.Due.In(loc))
+			if task.Submitted {
+				c.prop("STATUS", "COMPLETED")
+			} else {
+				c.prop("STATUS", "NEEDS-ACTION")
+			}
+		} else {
+			c.time("DTSTART", task.Due.In(loc))
+			c.time("DTEND", task.Due.In(loc))
+		}
+		c.text("SUMMARY", task.Name)
+		c.text("CATEGORIES", task.Class)
+		if task.Link != "" {
+			c.prop("URL", task.Link)
+		}
+		if tasks == icalTasksTodo {
+			c.prop("END", "VTODO")
+		} else {
+			c.prop("END", "VEVENT")
+		}
 	}
-	iCalString += "END:VCALENDAR\n"
+	c.prop("END", "VCALENDAR")
 
-	_, err = io.WriteString(w, iCalString)
+	_, err = io.WriteString(w, c.String())
 	if err != nil {
-		w.WriteHeader(500)
 		return errors.New(err, "failed to write calendar file")
 	}
 	return nil
 }
-
-func GenerateUUID() (u *UUID, err error) {
-	// generates a version 4 UUID and returns the byte string
-	u = new(UUID)
-	_, err = rand.Read(u[:])
-	if err != nil {
-		return nil, errors.New(err, "failed to generate UUID")
-	}
-	u[8] = (u[8] | 0x40) & 0x7F
-	u[6] = (u[6] & 0xF) | (4 << 4)
-
-	return u, nil
-}
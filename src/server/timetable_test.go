@@ -0,0 +1,39 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"main/site"
+)
+
+func TestDaySpan(t *testing.T) {
+	loc := time.FixedZone("ACST", 570*60)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 3, day, hour, min, 0, 0, loc)
+	}
+	monday, tuesday := at(2, 0, 0), at(3, 0, 0)
+	tests := []struct {
+		name       string
+		event      site.Event
+		day        time.Time
+		start, end time.Time
+		whole      bool
+	}{
+		{"within", site.Event{Start: at(2, 9, 0), End: at(2, 10, 30)}, monday, at(2, 9, 0), at(2, 10, 30), false},
+		{"utc", site.Event{Start: at(2, 9, 0).UTC(), End: at(2, 10, 0).UTC()}, monday, at(2, 9, 0), at(2, 10, 0), false},
+		{"all day", site.Event{Start: monday, End: tuesday, AllDay: true}, monday, monday, tuesday, true},
+		{"whole day", site.Event{Start: at(1, 18, 0), End: at(3, 9, 0)}, monday, monday, tuesday, true},
+		{"overnight first day", site.Event{Start: at(2, 22, 0), End: at(3, 7, 0)}, monday, at(2, 22, 0), tuesday, false},
+		{"overnight second day", site.Event{Start: at(2, 22, 0), End: at(3, 7, 0)}, tuesday, tuesday, at(3, 7, 0), false},
+	}
+	for _, test := range tests {
+		start, end, whole := daySpan(test.event, test.day)
+		if !start.Equal(test.start) || !end.Equal(test.end) || whole != test.whole {
+			t.Errorf("%s: got %s to %s (%t), want %s to %s (%t)", test.name, start, end, whole, test.start, test.end, test.whole)
+		}
+		if start.Location() != loc {
+			t.Errorf("%s: got location %s, want %s", test.name, start.Location(), loc)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+package site
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTTLs is the time for which each type of data returned by a Cache is
+// considered fresh, unless set otherwise with SetTTL. Data which seldom
+// changes, such as classes and lessons, is kept longer than data which users
+// expect to see change, such as tasks and messages.
+var DefaultTTLs = map[string]time.Duration{
+	"classes":   time.Hour,
+	"duetasks":  5 * time.Minute,
+	"events":    time.Hour,
+	"graded":    15 * time.Minute,
+	"lessons":   time.Hour,
+	"messages":  5 * time.Minute,
+	"reports":   time.Hour,
+	"resource":  30 * time.Minute,
+	"resources": 30 * time.Minute,
+	"task":      5 * time.Minute,
+	"tasks":     5 * time.Minute,
+}
+
+// DefaultRevalidate is the time after data expires during which a Cache
+// returns it immediately while fetching a replacement in the background,
+// unless set otherwise with SetRevalidate.
+const DefaultRevalidate = 10 * time.Minute
+
+// refreshBackoff is the time after a failed background refresh before cached
+// results are fetched again in the background. It doubles with each further
+// failure, up to maxRefreshBackoff.
+const (
+	refreshBackoff    = 30 * time.Second
+	maxRefreshBackoff = 8 * time.Minute
+)
+
+// DefaultMaxStale is the maximum age of data returned by a Cache when it
+// cannot be fetched again, unless set otherwise with SetMaxStale.
+const DefaultMaxStale = 24 * time.Hour
+
+// StaleError is returned alongside results from a Cache which have expired,
+// but could not be replaced because fetching them again failed with Err. Age
+// is the time since the results were fetched.
+type StaleError struct {
+	Age time.Duration
+	Err error
+}
+
+func (e *StaleError) Error() string {
+	msg := fmt.Sprintf("results are %s old", e.Age.Round(time.Second))
+	if e.Err == nil {
+		return msg
+	}
+	return msg + ": " + e.Err.Error()
+}
+
+func (e *StaleError) Parent() error {
+	return e.Err
+}
+
+// Stale reports whether err indicates that the results returned alongside it
+// are usable, but out of date. If so, the StaleError giving their age is
+// returned.
+func Stale(err error) (*StaleError, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *StaleError:
+			return e, true
+		case interface{ Parent() error }:
+			err = e.Parent()
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Usable reports whether the results returned alongside err by a method of Mux
+// or Cache may be used, which is the case if err is nil or reports partial or
+// stale results.
+func Usable(err error) bool {
+	if err == nil {
+		return true
+	}
+	_, partial := Partial(err)
+	_, stale := Stale(err)
+	return partial || stale
+}
+
+type refreshKey struct{}
+
+// Refresh returns a copy of ctx which causes the methods of Cache to fetch
+// results again even if cached results are fresh. The fetched results are
+// cached as usual.
+func Refresh(ctx context.Context) context.Context {
+	return context.WithValue(ctx, refreshKey{}, true)
+}
+
+type keepKey struct{}
+
+// KeepFor returns a copy of ctx which causes results fetched by the methods of
+// Cache to be considered fresh for at least d, rather than only for the TTL of
+// their type of data. Background syncs use it so that the results they fetch
+// stay fresh until the next sync.
+func KeepFor(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, keepKey{}, d)
+}
+
+// keep returns the time for which results fetched with ctx are kept fresh,
+// given the TTL of their type of data.
+func keep(ctx context.Context, ttl time.Duration) time.Duration {
+	d, _ := ctx.Value(keepKey{}).(time.Duration)
+	return max(ttl, d)
+}
+
+// A cacheKey identifies a method call cached by a Cache.
+type cacheKey struct {
+	uid    Uid
+	method string
+	args   string
+}
+
+type cacheEntry struct {
+	value   any
+	fetched time.Time
+	// the time for which the entry is fresh, which is at least the TTL of
+	// its type of data (see KeepFor)
+	ttl time.Duration
+	// the time before which the entry is not refreshed in the background,
+	// which is set while a refresh is in progress and after one fails, and
+	// the number of background refreshes which have failed
+	retry    time.Time
+	failures int
+}
+
+// A flight is a fetch of results for a Cache, which is shared by all calls
+// that need the same results while it is in progress.
+type flight struct {
+	done  chan struct{}
+	value any
+	err   error
+	// the generation of the user's cached results when the fetch began
+	gen uint64
+	// the error of the context of the call which made the fetch, if it
+	// ended before the fetch completed
+	ended error
+}
+
+// Cache is a per-user cache of the results returned by the methods of its
+// embedded Mux. Results are cached by method and arguments, for the time given
+// by the TTL of their type of data, or longer if they were fetched with a
+// context from KeepFor. Once that time has passed, cached results continue to
+// be returned for a while as they are fetched again in the background. After
+// that, results are fetched before returning; if this fails, the cached results
+// are returned instead with a StaleError giving their age.
+//
+// Results are only cached when fetched without error, so incomplete results are
+// never cached. Concurrent calls needing the same results share a single
+// fetch. Submitting a task or uploading or removing work clears the user's
+// cached tasks.
+type Cache struct {
+	*Mux
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	flights map[cacheKey]*flight
+	// incremented for a user whenever their cached results are cleared, so
+	// that fetches begun beforehand are not cached
+	gens       map[Uid]uint64
+	ttls       map[string]time.Duration
+	revalidate time.Duration
+	maxStale   time.Duration
+}
+
+// Return a new instance of Cache, caching the results of m.
+func NewCache(m *Mux) *Cache {
+	c := &Cache{
+		Mux:        m,
+		entries:    make(map[cacheKey]*cacheEntry),
+		flights:    make(map[cacheKey]*flight),
+		gens:       make(map[Uid]uint64),
+		ttls:       make(map[string]time.Duration),
+		revalidate: DefaultRevalidate,
+		maxStale:   DefaultMaxStale,
+	}
+	for method, ttl := range DefaultTTLs {
+		c.ttls[method] = ttl
+	}
+	return c
+}
+
+// SetTTL sets the time for which results of the given type of data (a key of
+// DefaultTTLs) are considered fresh. A non-positive time disables caching of
+// that type of data.
+func (c *Cache) SetTTL(method string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttls[method] = d
+}
+
+// SetRevalidate sets the time after results expire during which they are
+// returned while being fetched again in the background.
+func (c *Cache) SetRevalidate(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revalidate = d
+}
+
+// SetMaxStale sets the maximum age of results returned when they cannot be
+// fetched again.
+func (c *Cache) SetMaxStale(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxStale = d
+}
+
+// Sweep removes cached results which are too old to be returned.
+func (c *Cache) Sweep(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		age := now.Sub(entry.fetched)
+		ttl := max(c.ttls[key.method], entry.ttl)
+		if age >= ttl+c.revalidate && age >= c.maxStale {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// forget removes the given user's cached results for each of methods. Results
+// which are being fetched are not cached, and later calls fetch them again.
+func (c *Cache) forget(user User, methods ...string) {
+	uid := Uid{user.School, user.Username}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gens[uid]++
+	for key := range c.entries {
+		if key.uid == uid && slices.Contains(methods, key.method) {
+			delete(c.entries, key)
+		}
+	}
+	for key := range c.flights {
+		if key.uid == uid && slices.Contains(methods, key.method) {
+			delete(c.flights, key)
+		}
+	}
+}
+
+// cached returns the result of fetch for the given user, method and arguments,
+// using the results cached by c where possible.
+func cached[T any](c *Cache, ctx context.Context, user User, method, args string, fetch func(context.Context) (T, error)) (T, error) {
+	key := cacheKey{Uid{user.School, user.Username}, method, args}
+	now := time.Now()
+
+	c.mu.Lock()
+	ttl := c.ttls[method]
+	if ttl <= 0 {
+		c.mu.Unlock()
+		return fetch(ctx)
+	}
+	entry, ok := c.entries[key]
+	if ok && ctx.Value(refreshKey{}) == nil {
+		age := now.Sub(entry.fetched)
+		fresh := max(ttl, entry.ttl)
+		if age < fresh {
+			c.mu.Unlock()
+			return entry.value.(T), nil
+		}
+		if age < fresh+c.revalidate {
+			if c.flights[key] == nil && !now.Before(entry.retry) {
+				// The refresh cannot take longer than this.
+				entry.retry = now.Add(RequestTimeout)
+				go refresh(c, key, entry, ttl, fetch)
+			}
+			c.mu.Unlock()
+			return entry.value.(T), nil
+		}
+	}
+
+	value, err := load(c, ctx, key, ttl, fetch)
+	if err == nil {
+		return value, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The entry may have been replaced while fetching.
+	entry, ok = c.entries[key]
+	if ok && now.Sub(entry.fetched) < c.maxStale {
+		return entry.value.(T), &StaleError{now.Sub(entry.fetched), err}
+	}
+	return value, err
+}
+
+// load returns the result of fetch, caching it under key if it is fetched
+// without error. If the same results are already being fetched, load waits for
+// that fetch instead, unless it failed because the call which made it ended,
+// in which case the results are fetched again with ctx. load must be called
+// with c.mu held, and releases it.
+func load[T any](c *Cache, ctx context.Context, key cacheKey, ttl time.Duration, fetch func(context.Context) (T, error)) (T, error) {
+	f, ok := c.flights[key]
+	if ok {
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+		if f.err != nil && f.ended != nil && ctx.Err() == nil {
+			c.mu.Lock()
+			return load(c, ctx, key, ttl, fetch)
+		}
+		return f.value.(T), f.err
+	}
+	f = &flight{done: make(chan struct{}), gen: c.gens[key.uid]}
+	c.flights[key] = f
+	c.mu.Unlock()
+
+	value, err := fetch(ctx)
+	c.mu.Lock()
+	f.value, f.err, f.ended = value, err, ctx.Err()
+	if c.flights[key] == f {
+		delete(c.flights, key)
+	}
+	// Results fetched before the user's cached results were cleared may
+	// be out of date.
+	if err == nil && f.gen == c.gens[key.uid] {
+		c.entries[key] = &cacheEntry{value: value, fetched: time.Now(), ttl: keep(ctx, ttl)}
+	}
+	c.mu.Unlock()
+	close(f.done)
+	return value, err
+}
+
+// refresh replaces entry, the results cached by c under key, with the result of
+// fetch. Since the request which caused the refresh may end before it does,
+// fetch is not called with that request's context. If fetch fails, the cached
+// results are kept, and are not refreshed again until a backoff has passed.
+func refresh[T any](c *Cache, key cacheKey, entry *cacheEntry, ttl time.Duration, fetch func(context.Context) (T, error)) {
+	ctx, cancel := context.WithTimeout(context.Background(), RequestTimeout)
+	defer cancel()
+	c.mu.Lock()
+	if c.flights[key] != nil {
+		// Another call started fetching the results first.
+		c.mu.Unlock()
+		return
+	}
+	_, err := load(c, ctx, key, ttl, fetch)
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.failures++
+	backoff := min(refreshBackoff<<min(entry.failures-1, 8), maxRefreshBackoff)
+	entry.retry = time.Now().Add(backoff)
+}
+
+// cachedList is like cached, but returns a copy of the cached list, so that
+// callers may modify it.
+func cachedList[T any](c *Cache, ctx context.Context, user User, method, args string, fetch func(context.Context) ([]T, error)) ([]T, error) {
+	list, err := cached(c, ctx, user, method, args, fetch)
+	return slices.Clone(list), err
+}
+
+// classArgs returns the cache arguments identifying a list of classes.
+func classArgs(classes []Class) string {
+	var ids []string
+	for _, class := range classes {
+		ids = append(ids, class.Platform+"/"+class.Id)
+	}
+	return strings.Join(ids, " ")
+}
+
+// timeArgs returns the cache arguments identifying a range of time.
+func timeArgs(start, end time.Time) string {
+	return strconv.FormatInt(start.Unix(), 10) + " " + strconv.FormatInt(end.Unix(), 10)
+}
+
+// Classes is like Mux.Classes, but uses cached results where possible.
+func (c *Cache) Classes(ctx context.Context, user User) ([]Class, error) {
+	return cachedList(c, ctx, user, "classes", "", func(ctx context.Context) ([]Class, error) {
+		return c.Mux.Classes(ctx, user)
+	})
+}
+
+// DueTasks is like Mux.DueTasks, but uses cached results where possible.
+func (c *Cache) DueTasks(ctx context.Context, user User) ([]Task, error) {
+	return cachedList(c, ctx, user, "duetasks", "", func(ctx context.Context) ([]Task, error) {
+		return c.Mux.DueTasks(ctx, user)
+	})
+}
+
+// Events is like Mux.Events, but uses cached results where possible.
+func (c *Cache) Events(ctx context.Context, user User, start, end time.Time) ([]Event, error) {
+	return cachedList(c, ctx, user, "events", timeArgs(start, end), func(ctx context.Context) ([]Event, error) {
+		return c.Mux.Events(ctx, user, start, end)
+	})
+}
+
+// Graded is like Mux.Graded, but uses cached results where possible.
+func (c *Cache) Graded(ctx context.Context, user User) ([]Task, error) {
+	return cachedList(c, ctx, user, "graded", "", func(ctx context.Context) ([]Task, error) {
+		return c.Mux.Graded(ctx, user)
+	})
+}
+
+// Lessons is like Mux.Lessons, but uses cached results where possible.
+func (c *Cache) Lessons(ctx context.Context, user User, start, end time.Time) ([]Lesson, error) {
+	return cachedList(c, ctx, user, "lessons", timeArgs(start, end), func(ctx context.Context) ([]Lesson, error) {
+		return c.Mux.Lessons(ctx, user, start, end)
+	})
+}
+
+// Messages is like Mux.Messages, but uses cached results where possible.
+func (c *Cache) Messages(ctx context.Context, user User) ([]Message, error) {
+	return cachedList(c, ctx, user, "messages", "", func(ctx context.Context) ([]Message, error) {
+		return c.Mux.Messages(ctx, user)
+	})
+}
+
+// Reports is like Mux.Reports, but uses cached results where possible.
+func (c *Cache) Reports(ctx context.Context, user User) ([]Report, error) {
+	return cachedList(c, ctx, user, "reports", "", func(ctx context.Context) ([]Report, error) {
+		return c.Mux.Reports(ctx, user)
+	})
+}
+
+// Resource is like Mux.Resource, but uses cached results where possible.
+func (c *Cache) Resource(ctx context.Context, user User, platform, id string) (Resource, error) {
+	return cached(c, ctx, user, "resource", platform+"/"+id, func(ctx context.Context) (Resource, error) {
+		return c.Mux.Resource(ctx, user, platform, id)
+	})
+}
+
+// Resources is like Mux.Resources, but uses cached results where possible.
+func (c *Cache) Resources(ctx context.Context, user User, classes ...Class) ([]Resource, error) {
+	return cachedList(c, ctx, user, "resources", classArgs(classes), func(ctx context.Context) ([]Resource, error) {
+		return c.Mux.Resources(ctx, user, classes...)
+	})
+}
+
+// Task is like Mux.Task, but uses cached results where possible.
+func (c *Cache) Task(ctx context.Context, user User, platform, id string) (Task, error) {
+	return cached(c, ctx, user, "task", platform+"/"+id, func(ctx context.Context) (Task, error) {
+		return c.Mux.Task(ctx, user, platform, id)
+	})
+}
+
+// Tasks is like Mux.Tasks, but uses cached results where possible.
+func (c *Cache) Tasks(ctx context.Context, user User, classes ...Class) ([]Task, error) {
+	return cachedList(c, ctx, user, "tasks", classArgs(classes), func(ctx context.Context) ([]Task, error) {
+		return c.Mux.Tasks(ctx, user, classes...)
+	})
+}
+
+// The types of data which may change when a task is submitted or its work
+// submissions are changed.
+var taskMethods = []string{"duetasks", "graded", "task", "tasks"}
+
+// RemoveWork is like Mux.RemoveWork, but also clears the user's cached tasks.
+func (c *Cache) RemoveWork(ctx context.Context, user User, platform, id string, filenames []string) error {
+	defer c.forget(user, taskMethods...)
+	return c.Mux.RemoveWork(ctx, user, platform, id, filenames)
+}
+
+// Submit is like Mux.Submit, but also clears the user's cached tasks.
+func (c *Cache) Submit(ctx context.Context, user User, platform, id string) error {
+	defer c.forget(user, taskMethods...)
+	return c.Mux.Submit(ctx, user, platform, id)
+}
+
+// UploadWork is like Mux.UploadWork, but also clears the user's cached tasks.
+func (c *Cache) UploadWork(ctx context.Context, user User, platform, id string, r *http.Request) error {
+	defer c.forget(user, taskMethods...)
+	return c.Mux.UploadWork(ctx, user, platform, id, r)
+}
@@ -0,0 +1,295 @@
+package site
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter returns a fetch function for cached which returns the number of
+// times it has been called.
+func counter() func(context.Context) (int, error) {
+	n := 0
+	return func(context.Context) (int, error) {
+		n++
+		return n, nil
+	}
+}
+
+func TestKeepFor(t *testing.T) {
+	c := NewCache(NewMux())
+	user := User{School: "school", Username: "student"}
+	fetch := counter()
+	ctx := KeepFor(Refresh(context.Background()), time.Hour)
+	if n, err := cached(c, ctx, user, "tasks", "", fetch); err != nil || n != 1 {
+		t.Fatalf("got %d, %v, want 1, nil", n, err)
+	}
+	// Results kept for longer than their TTL stay fresh after it.
+	key := cacheKey{Uid{user.School, user.Username}, "tasks", ""}
+	c.entries[key].fetched = time.Now().Add(-DefaultTTLs["tasks"] - DefaultRevalidate)
+	if n, err := cached(c, context.Background(), user, "tasks", "", fetch); err != nil || n != 1 {
+		t.Errorf("got %d, %v, want cached 1, nil", n, err)
+	}
+	c.SetMaxStale(0)
+	c.Sweep(time.Now())
+	if _, ok := c.entries[key]; !ok {
+		t.Errorf("kept results were swept")
+	}
+	c.Sweep(time.Now().Add(time.Hour))
+	if _, ok := c.entries[key]; ok {
+		t.Errorf("expired results were not swept")
+	}
+}
+
+// A fetcher is a fetch function for cached which returns the number of times
+// it has been called, or fails with err. If block is not nil, calls wait until
+// it is closed.
+type fetcher struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+	block chan struct{}
+}
+
+func (f *fetcher) fetch(context.Context) (int, error) {
+	f.mu.Lock()
+	f.calls++
+	n, err, block := f.calls, f.err, f.block
+	f.mu.Unlock()
+	if block != nil {
+		<-block
+	}
+	return n, err
+}
+
+func (f *fetcher) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+var errFetch = errors.New("fetch failed")
+
+var testUser = User{School: "school", Username: "student"}
+
+var testKey = cacheKey{Uid{testUser.School, testUser.Username}, "tasks", ""}
+
+// age makes the cached results of testKey seem to have been fetched d ago.
+func age(c *Cache, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[testKey].fetched = time.Now().Add(-d)
+}
+
+// waitFor waits until cond, called with c.mu held, returns true.
+func waitFor(t *testing.T, c *Cache, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		ok := cond()
+		c.mu.Unlock()
+		if ok {
+			return
+		}
+	}
+	t.Fatal("timed out")
+}
+
+func TestCacheFresh(t *testing.T) {
+	c := NewCache(NewMux())
+	f := new(fetcher)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 1 {
+			t.Errorf("got %d, %v, want 1, nil", n, err)
+		}
+	}
+	// Refresh fetches again even if the results are fresh.
+	if n, err := cached(c, Refresh(ctx), testUser, "tasks", "", f.fetch); err != nil || n != 2 {
+		t.Errorf("refreshed: got %d, %v, want 2, nil", n, err)
+	}
+	// Types of data with no TTL are not cached.
+	c.SetTTL("tasks", 0)
+	if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 3 {
+		t.Errorf("uncached: got %d, %v, want 3, nil", n, err)
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	c := NewCache(NewMux())
+	f := new(fetcher)
+	ctx := context.Background()
+	cached(c, ctx, testUser, "tasks", "", f.fetch)
+
+	// Stale results are returned while they are fetched again.
+	age(c, DefaultTTLs["tasks"]+time.Minute)
+	if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 1 {
+		t.Errorf("got %d, %v, want stale 1, nil", n, err)
+	}
+	waitFor(t, c, func() bool { return c.entries[testKey].value == 2 })
+	if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 2 {
+		t.Errorf("got %d, %v, want refreshed 2, nil", n, err)
+	}
+	if calls := f.count(); calls != 2 {
+		t.Errorf("fetched %d times, want 2", calls)
+	}
+}
+
+func TestCacheRefreshBackoff(t *testing.T) {
+	c := NewCache(NewMux())
+	f := new(fetcher)
+	ctx := context.Background()
+	cached(c, ctx, testUser, "tasks", "", f.fetch)
+
+	f.err = errFetch
+	age(c, DefaultTTLs["tasks"]+time.Minute)
+	if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 1 {
+		t.Errorf("got %d, %v, want stale 1, nil", n, err)
+	}
+	waitFor(t, c, func() bool { return c.entries[testKey].failures == 1 })
+
+	// After a failed refresh, the results are not refreshed again until
+	// the backoff has passed.
+	for i := 0; i < 3; i++ {
+		if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 1 {
+			t.Errorf("got %d, %v, want stale 1, nil", n, err)
+		}
+	}
+	if calls := f.count(); calls != 2 {
+		t.Errorf("fetched %d times, want 2", calls)
+	}
+	c.mu.Lock()
+	c.entries[testKey].retry = time.Now()
+	c.mu.Unlock()
+	cached(c, ctx, testUser, "tasks", "", f.fetch)
+	waitFor(t, c, func() bool { return c.entries[testKey].failures == 2 })
+	c.mu.Lock()
+	backoff := time.Until(c.entries[testKey].retry)
+	c.mu.Unlock()
+	if backoff <= refreshBackoff || backoff > 2*refreshBackoff {
+		t.Errorf("backoff after second failure is %s, want %s", backoff, 2*refreshBackoff)
+	}
+}
+
+func TestCacheExpired(t *testing.T) {
+	c := NewCache(NewMux())
+	f := new(fetcher)
+	ctx := context.Background()
+	cached(c, ctx, testUser, "tasks", "", f.fetch)
+
+	// Expired results are fetched again before returning.
+	age(c, DefaultTTLs["tasks"]+DefaultRevalidate)
+	if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 2 {
+		t.Errorf("got %d, %v, want 2, nil", n, err)
+	}
+
+	// If that fails, the expired results are returned with their age.
+	f.err = errFetch
+	age(c, time.Hour)
+	n, err := cached(c, ctx, testUser, "tasks", "", f.fetch)
+	stale, ok := Stale(err)
+	if n != 2 || !ok || stale.Err != errFetch || stale.Age < time.Hour {
+		t.Errorf("got %d, %v, want stale 2 with age 1h", n, err)
+	}
+
+	// Results older than the maximum age are not returned.
+	age(c, DefaultMaxStale)
+	if _, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != errFetch {
+		t.Errorf("got %v, want %v", err, errFetch)
+	}
+}
+
+func TestCacheShared(t *testing.T) {
+	c := NewCache(NewMux())
+	f := &fetcher{block: make(chan struct{})}
+	ctx := context.Background()
+
+	// Calls which need the same results while they are being fetched
+	// share the fetch.
+	var wg sync.WaitGroup
+	results := make([]int, 5)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], _ = cached(c, ctx, testUser, "tasks", "", f.fetch)
+		}()
+	}
+	waitFor(t, c, func() bool { return c.flights[testKey] != nil })
+	close(f.block)
+	wg.Wait()
+	if calls := f.count(); calls != 1 {
+		t.Errorf("fetched %d times, want 1", calls)
+	}
+	for i, n := range results {
+		if n != 1 {
+			t.Errorf("call %d got %d, want 1", i, n)
+		}
+	}
+}
+
+func TestCacheSharedCancel(t *testing.T) {
+	c := NewCache(NewMux())
+	f := new(fetcher)
+	// The first fetch lasts until the call which made it ends.
+	fetch := func(ctx context.Context) (int, error) {
+		n, _ := f.fetch(ctx)
+		if n == 1 {
+			<-ctx.Done()
+			return 0, ctx.Err()
+		}
+		return n, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := cached(c, ctx, testUser, "tasks", "", fetch)
+		first <- err
+	}()
+	waitFor(t, c, func() bool { return c.flights[testKey] != nil })
+	second := make(chan int)
+	go func() {
+		n, _ := cached(c, context.Background(), testUser, "tasks", "", fetch)
+		second <- n
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// A call sharing a fetch which failed because the call which made it
+	// was cancelled fetches the results itself.
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("cancelled call got %v, want %v", err, context.Canceled)
+	}
+	if n := <-second; n != 2 {
+		t.Errorf("second call got %d, want 2", n)
+	}
+}
+
+func TestCacheForget(t *testing.T) {
+	c := NewCache(NewMux())
+	f := new(fetcher)
+	ctx := context.Background()
+	cached(c, ctx, testUser, "tasks", "", f.fetch)
+	c.forget(testUser, "tasks")
+	if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 2 {
+		t.Errorf("got %d, %v, want 2, nil", n, err)
+	}
+
+	// Results being fetched when the cached results are cleared are
+	// returned, but not cached.
+	age(c, DefaultTTLs["tasks"]+time.Minute)
+	f.block = make(chan struct{})
+	cached(c, ctx, testUser, "tasks", "", f.fetch)
+	waitFor(t, c, func() bool { return c.flights[testKey] != nil })
+	c.forget(testUser, "tasks")
+	close(f.block)
+	if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 4 {
+		t.Errorf("got %d, %v, want 4, nil", n, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n, err := cached(c, ctx, testUser, "tasks", "", f.fetch); err != nil || n != 4 {
+		t.Errorf("got %d, %v, want cached 4, nil", n, err)
+	}
+}
@@ -9,19 +9,35 @@ import (
 	"github.com/BurntSushi/toml"
 )
 
-func readcfg(path string) (map[string]UserConfig, error) {
+// readcfg reads a user config file, which holds a table of settings for each
+// platform and the user's notification preferences in the [notify] table.
+func readcfg(path string) (map[string]UserConfig, NotifyConfig, error) {
 	config := make(map[string]UserConfig)
+	var notify NotifyConfig
 	file, err := os.Open(path)
 	if err != nil {
 		// user has empty config
-		return config, nil
+		return config, notify, nil
 	}
 	defer file.Close()
-	_, err = toml.NewDecoder(file).Decode(&config)
+	var tables map[string]toml.Primitive
+	md, err := toml.NewDecoder(file).Decode(&tables)
 	if err != nil {
-		return config, errors.New(err, "cannot parse user config: %s", path)
+		return config, notify, errors.New(err, "cannot parse user config: %s", path)
 	}
-	return config, nil
+	for name, table := range tables {
+		if name == "notify" {
+			err = md.PrimitiveDecode(table, &notify)
+		} else {
+			var cfg UserConfig
+			err = md.PrimitiveDecode(table, &cfg)
+			config[name] = cfg
+		}
+		if err != nil {
+			return config, notify, errors.New(err, "cannot parse user config: %s", path)
+		}
+	}
+	return config, notify, nil
 }
 
 func LoadConfig(user *User) error {
@@ -32,10 +48,11 @@ func LoadConfig(user *User) error {
 		return errors.New(err, "cannot get path to executable")
 	}
 	cfgpath := path.Join(path.Dir(execpath), "../../../cfg/user/", user.School, filename)
-	config, err := readcfg(cfgpath)
+	config, notify, err := readcfg(cfgpath)
 	if err != nil {
 		return errors.Wrap(err)
 	}
 	user.Config = config
+	user.Notify = notify
 	return nil
 }
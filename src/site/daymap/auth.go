@@ -2,6 +2,7 @@ package daymap
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"html"
@@ -33,7 +34,7 @@ type s7struct struct {
 //
 // fetch is vulnerable to obsoletion as the authentication mechanism for Daymap
 // frequently changes.
-func fetch(link, username, password string) (string, string, error) {
+func (p platform) fetch(ctx context.Context, link, username, password string) (string, string, error) {
 	// Stage 1 - Get a Daymap redirect to EdPass.
 
 	// A persistent cookie jar is required for the entire process.
@@ -43,9 +44,14 @@ func fetch(link, username, password string) (string, string, error) {
 		return "", "", errors.New(err, "cannot create stage 1 cookie jar")
 	}
 
-	client := &http.Client{Jar: jar}
+	client := &http.Client{Jar: jar, Timeout: site.RequestTimeout}
 
-	s1, err := client.Get(link)
+	s1req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return "", "", errors.New(err, "cannot create stage 1 request")
+	}
+
+	s1, err := client.Do(s1req)
 	if err != nil {
 		return "", "", errors.New(err, "stage 1 request failed")
 	}
@@ -107,7 +113,7 @@ func fetch(link, username, password string) (string, string, error) {
 
 	// Bake new cookies for stage 2.
 
-	s2dom, err := url.Parse("https://portal.edpass.sa.edu.au/")
+	s2dom, err := url.Parse(p.portal + "/")
 	if err != nil {
 		return "", "", errors.New(err, "cannot parsing stage 2 target domain")
 	}
@@ -133,9 +139,9 @@ func fetch(link, username, password string) (string, string, error) {
 		fmt.Sprintf(`{"stateToken":%s}`, string(s2jstok)),
 	))
 
-	s2req, err := http.NewRequest(
+	s2req, err := http.NewRequestWithContext(ctx,
 		"POST",
-		"https://portal.edpass.sa.edu.au/api/v1/authn/introspect",
+		p.portal+"/api/v1/authn/introspect",
 		s2data,
 	)
 	if err != nil {
@@ -144,10 +150,10 @@ func fetch(link, username, password string) (string, string, error) {
 
 	s2req.Header.Set("Accept", "application/json")
 	s2req.Header.Set("Content-Type", "application/json")
-	s2req.Header.Set("Origin", "https://portal.edpass.sa.edu.au")
+	s2req.Header.Set("Origin", p.portal)
 	s2req.Header.Set(
 		"Referer",
-		"https://portal.edpass.sa.edu.au/signin/refresh-auth-state/"+s2token,
+		p.portal+"/signin/refresh-auth-state/"+s2token,
 	)
 	s2req.Header.Set(
 		"X-Okta-User-Agent-Extended",
@@ -161,15 +167,15 @@ func fetch(link, username, password string) (string, string, error) {
 
 	// Stage 3 - Send POST request to HRD EdPass IDPDiscovery.
 
-	s3req, err := http.NewRequest(
-		"POST", "https://hrd.edpass.sa.edu.au/api/IDPDiscovery", nil,
+	s3req, err := http.NewRequestWithContext(ctx,
+		"POST", p.hrd+"/api/IDPDiscovery", nil,
 	)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 3 request")
 	}
 
-	s3req.Header.Set("Origin", "https://portal.edpass.sa.edu.au")
-	s3req.Header.Set("Referer", "https://portal.edpass.sa.edu.au/")
+	s3req.Header.Set("Origin", p.portal)
+	s3req.Header.Set("Referer", p.portal+"/")
 
 	_, err = client.Do(s3req)
 	if err != nil {
@@ -180,15 +186,15 @@ func fetch(link, username, password string) (string, string, error) {
 
 	s4form := url.Values{}
 	s4form.Add("fromURI", s2relay)
-	s4url := "https://portal.edpass.sa.edu.au/sso/saml2/0oamc0sv2IbQE6VD33l6/?" + s4form.Encode()
+	s4url := p.portal + "/sso/saml2/0oamc0sv2IbQE6VD33l6/?" + s4form.Encode()
 
-	s4req, err := http.NewRequest("GET", s4url, nil)
+	s4req, err := http.NewRequestWithContext(ctx, "GET", s4url, nil)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 4 request")
 	}
 
-	s4req.Header.Set("Origin", "https://portal.edpass.sa.edu.au")
-	s4req.Header.Set("Referer", "https://portal.edpass.sa.edu.au/")
+	s4req.Header.Set("Origin", p.portal)
+	s4req.Header.Set("Referer", p.portal+"/")
 
 	s4, err := client.Do(s4req)
 	if err != nil {
@@ -253,14 +259,14 @@ func fetch(link, username, password string) (string, string, error) {
 
 	// Send the POST request with the payload.
 
-	s5req, err := http.NewRequest("POST", s5url, s5data)
+	s5req, err := http.NewRequestWithContext(ctx, "POST", s5url, s5data)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 5 request")
 	}
 
 	s5req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	s5req.Header.Set("Origin", "https://portal.edpass.sa.edu.au")
-	s5req.Header.Set("Referer", "https://portal.edpass.sa.edu.au/")
+	s5req.Header.Set("Origin", p.portal)
+	s5req.Header.Set("Referer", p.portal+"/")
 
 	_, err = client.Do(s5req)
 	if err != nil {
@@ -269,14 +275,14 @@ func fetch(link, username, password string) (string, string, error) {
 
 	// Stage 6 - Request a nonce from EdPass.
 
-	s6url := "https://edpass-0927.okta.com/api/v1/internal/device/nonce"
-	s6req, err := http.NewRequest("POST", s6url, nil)
+	s6url := p.okta + "/api/v1/internal/device/nonce"
+	s6req, err := http.NewRequestWithContext(ctx, "POST", s6url, nil)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 6 request")
 	}
 
-	s6req.Header.Set("Origin", "https://edpass-0927.okta.com/api/v1/internal/device/nonce")
-	s6req.Header.Set("Referer", "https://edpass-0927.okta.com/auth/services/devicefingerprint")
+	s6req.Header.Set("Origin", p.okta+"/api/v1/internal/device/nonce")
+	s6req.Header.Set("Referer", p.okta+"/auth/services/devicefingerprint")
 
 	_, err = client.Do(s6req)
 	if err != nil {
@@ -298,15 +304,15 @@ func fetch(link, username, password string) (string, string, error) {
 		fmt.Sprintf(s7tmpl, string(s7pwd), string(s7usr)),
 	)
 
-	s7url := "https://edpass-0927.okta.com/api/v1/authn"
-	s7req, err := http.NewRequest("POST", s7url, s7data)
+	s7url := p.okta + "/api/v1/authn"
+	s7req, err := http.NewRequestWithContext(ctx, "POST", s7url, s7data)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 7 request")
 	}
 
 	s7req.Header.Set("Accept", "application/json")
 	s7req.Header.Set("Content-Type", "application/json")
-	s7req.Header.Set("Origin", "https://edpass-0927.okta.com")
+	s7req.Header.Set("Origin", p.okta)
 	s7req.Header.Set("Referer", s5url)
 
 	s7, err := client.Do(s7req)
@@ -314,6 +320,11 @@ func fetch(link, username, password string) (string, string, error) {
 		return "", "", errors.New(err, "cannot execute stage 7 request")
 	}
 
+	// Okta rejects incorrect credentials with status 401.
+	if s7.StatusCode == http.StatusUnauthorized {
+		return "", "", errors.New(errors.Raise(site.ErrUnauthenticated), "stage 7 request was rejected")
+	}
+
 	s7body, err := io.ReadAll(s7.Body)
 	if err != nil {
 		return "", "", errors.New(err, "cannot read stage 7 body")
@@ -326,7 +337,7 @@ func fetch(link, username, password string) (string, string, error) {
 
 	s8rdform := s5form
 	s8rdform.Add("OKTA_INVALID_SESSION_REPOST", "true")
-	s8redirect := strings.TrimPrefix(s5url, "https://edpass-0927.okta.com")
+	s8redirect := strings.TrimPrefix(s5url, p.okta)
 	s8redirect += "?" + s8rdform.Encode()
 
 	s8form := url.Values{}
@@ -336,14 +347,14 @@ func fetch(link, username, password string) (string, string, error) {
 	s8form.Add("redirectUrl", s8redirect)
 	s8data := strings.NewReader(s8form.Encode())
 
-	s8url := "https://edpass-0927.okta.com/login/sessionCookieRedirect"
-	s8req, err := http.NewRequest("POST", s8url, s8data)
+	s8url := p.okta + "/login/sessionCookieRedirect"
+	s8req, err := http.NewRequestWithContext(ctx, "POST", s8url, s8data)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 8 request")
 	}
 
 	s8req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	s8req.Header.Set("Origin", "https://edpass-0927.okta.com")
+	s8req.Header.Set("Origin", p.okta)
 	s8req.Header.Set("Referer", s5url)
 
 	s8, err := client.Do(s8req)
@@ -409,14 +420,14 @@ func fetch(link, username, password string) (string, string, error) {
 
 	// Send the POST request with the payload.
 
-	s9req, err := http.NewRequest("POST", s9url, s9data)
+	s9req, err := http.NewRequestWithContext(ctx, "POST", s9url, s9data)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 9 request")
 	}
 
 	s9req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	s9req.Header.Set("Origin", "https://edpass-0927.okta.com")
-	s9req.Header.Set("Referer", "https://edpass-0927.okta.com/")
+	s9req.Header.Set("Origin", p.okta)
+	s9req.Header.Set("Referer", p.okta+"/")
 
 	s9, err := client.Do(s9req)
 	if err != nil {
@@ -481,7 +492,7 @@ func fetch(link, username, password string) (string, string, error) {
 
 	// Send the POST request with the payload.
 
-	s10req, err := http.NewRequest("POST", s10url, s10data)
+	s10req, err := http.NewRequestWithContext(ctx, "POST", s10url, s10data)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 10 request")
 	}
@@ -543,8 +554,8 @@ func fetch(link, username, password string) (string, string, error) {
 
 	// Send the POST request with the payload.
 
-	s11url := "https://gihs.daymap.net/Daymap/"
-	s11req, err := http.NewRequest("POST", s11url, s11data)
+	s11url := p.base + "/Daymap/"
+	s11req, err := http.NewRequestWithContext(ctx, "POST", s11url, s11data)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 11 request")
 	}
@@ -565,12 +576,12 @@ func fetch(link, username, password string) (string, string, error) {
 
 	// Retrieve all cookies associated with Daymap from cookie jar.
 
-	daymapUrl := url.URL{
-		Scheme: "https",
-		Host:   "gihs.daymap.net",
+	daymapUrl, err := url.Parse(p.base)
+	if err != nil {
+		return "", "", errors.New(err, "cannot parse Daymap URL")
 	}
 
-	cookies := jar.Cookies(&daymapUrl)
+	cookies := jar.Cookies(daymapUrl)
 	authToken := ""
 
 	for i, cookie := range cookies {
@@ -583,10 +594,10 @@ func fetch(link, username, password string) (string, string, error) {
 	return s11page, authToken, nil
 }
 
-func Auth(user site.User, c chan site.Pair[[2]string, error]) {
+func (p platform) Auth(ctx context.Context, user site.User, c chan site.Pair[[2]string, error]) {
 	var result site.Pair[[2]string, error]
-	link := "https://gihs.daymap.net/daymap/student/dayplan.aspx"
-	_, token, err := fetch(link, user.Username, user.Password)
+	link := p.base + "/daymap/student/dayplan.aspx"
+	_, token, err := p.fetch(ctx, link, user.Username, user.Password)
 	if err != nil {
 		result.Second = errors.New(err, "daymap login failed")
 		c <- result
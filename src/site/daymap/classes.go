@@ -1,6 +1,7 @@
 package daymap
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"strings"
@@ -10,13 +11,13 @@ import (
 	"main/site"
 )
 
-func Classes(user site.User, c chan site.Pair[[]site.Class, error]) {
+func (p platform) Classes(ctx context.Context, user site.User, c chan site.Pair[[]site.Class, error]) {
 	var result site.Pair[[]site.Class, error]
 
-	homeUrl := "https://gihs.daymap.net/daymap/student/dayplan.aspx"
-	client := &http.Client{}
+	homeUrl := p.base + "/daymap/student/dayplan.aspx"
+	client := &http.Client{Timeout: site.RequestTimeout}
 
-	req, err := http.NewRequest("GET", homeUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", homeUrl, nil)
 	if err != nil {
 		result.Second = errors.New(err, "cannot create classes request")
 		c <- result
@@ -32,6 +33,13 @@ func Classes(user site.User, c chan site.Pair[[]site.Class, error]) {
 		return
 	}
 
+	err = p.checkResponse(resp)
+	if err != nil {
+		result.Second = err
+		c <- result
+		return
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		result.Second = errors.New(err, "cannot read classes response body")
@@ -39,41 +47,36 @@ func Classes(user site.User, c chan site.Pair[[]site.Class, error]) {
 		return
 	}
 
-	var classes []site.Class
-	page := string(body)
-	i := strings.Index(page, "plans/class.aspx?id=")
-
-	for i != -1 {
-		var class site.Class
-		page = page[i:]
-		i = len("plans/class.aspx?id=")
-		page = page[i:]
-		i = strings.Index(page, "'>")
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-
-		class.Id = page[:i]
-		page = page[i+2:]
-		i = strings.Index(page, "</a>")
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-
-		class.Name = page[:i]
-		page = page[i:]
-		class.Link = "https://gihs.daymap.net/daymap/student/plans/class.aspx?id=" + class.Id
-		class.Platform = "daymap"
-		classes = append(classes, class)
-		i = strings.Index(page, "plans/class.aspx?id=")
+	classes, err := p.parseClasses(string(body))
+	if err != nil {
+		result.Second = errors.New(site.Fail(site.ErrParse, err), "invalid HTML response")
+		c <- result
+		return
 	}
 
 	result.First = classes
 	c <- result
 }
+
+// parseClasses parses the classes linked from the Daymap day plan page.
+func (p platform) parseClasses(page string) ([]site.Class, error) {
+	doc, err := parsePage(page)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	var classes []site.Class
+	for _, a := range findAll(doc, `a[href*="plans/class.aspx?id="]`) {
+		_, id, _ := strings.Cut(attr(a, "href"), "plans/class.aspx?id=")
+		id, _, _ = strings.Cut(id, "&")
+		if id == "" {
+			return nil, errors.New(nil, "invalid class link %q", attr(a, "href"))
+		}
+		classes = append(classes, site.Class{
+			Name:     text(a),
+			Link:     p.base + "/daymap/student/plans/class.aspx?id=" + id,
+			Platform: "daymap",
+			Id:       id,
+		})
+	}
+	return classes, nil
+}
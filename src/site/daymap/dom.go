@@ -0,0 +1,429 @@
+package daymap
+
+import (
+	"fmt"
+	"strings"
+
+	htm "git.sr.ht/~kvo/go-format/html"
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// Daymap pages are parsed into HTML trees, in which elements are found with a
+// small subset of CSS selectors: type, #id and .class selectors, attribute
+// selectors ([attr], [attr=val], [attr^=val], [attr$=val] and [attr*=val]),
+// and the descendant and child (>) combinators. Selectors are written in the
+// parsers and must be valid; an invalid selector causes a panic.
+
+// attrMatch is an attribute selector.
+type attrMatch struct {
+	key string
+	// one of "", "=", "^=", "$=" or "*="
+	op  string
+	val string
+}
+
+// compound is a sequence of simple selectors matching a single element.
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrMatch
+	// whether the element must be a child, rather than any descendant, of the
+	// element matched by the previous compound
+	child bool
+}
+
+// selector is a sequence of compounds joined by combinators.
+type selector []compound
+
+// compile parses the selector s.
+func compile(s string) selector {
+	var sel selector
+	var cur *compound
+	child := false
+	start := func() {
+		if cur == nil {
+			sel = append(sel, compound{child: child})
+			cur = &sel[len(sel)-1]
+			child = false
+		}
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			cur = nil
+			i++
+		case c == '>':
+			if len(sel) == 0 {
+				panic(fmt.Sprintf("invalid selector %q", s))
+			}
+			cur = nil
+			child = true
+			i++
+		case c == '#' || c == '.':
+			start()
+			name, n := ident(s[i+1:])
+			if name == "" {
+				panic(fmt.Sprintf("invalid selector %q", s))
+			}
+			if c == '#' {
+				cur.id = name
+			} else {
+				cur.classes = append(cur.classes, name)
+			}
+			i += 1 + n
+		case c == '[':
+			start()
+			end := strings.IndexByte(s[i:], ']')
+			if end == -1 {
+				panic(fmt.Sprintf("invalid selector %q", s))
+			}
+			cur.attrs = append(cur.attrs, compileAttr(s[i+1:i+end]))
+			i += end + 1
+		default:
+			start()
+			name, n := ident(s[i:])
+			if name == "" {
+				panic(fmt.Sprintf("invalid selector %q", s))
+			}
+			cur.tag = strings.ToLower(name)
+			i += n
+		}
+	}
+	if len(sel) == 0 || child {
+		panic(fmt.Sprintf("invalid selector %q", s))
+	}
+	return sel
+}
+
+// ident returns the identifier at the start of s and its length.
+func ident(s string) (string, int) {
+	n := 0
+	for n < len(s) {
+		c := s[n]
+		if c != '-' && c != '_' && c != '*' && (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			break
+		}
+		n++
+	}
+	return s[:n], n
+}
+
+// compileAttr parses the attribute selector s, without its brackets.
+func compileAttr(s string) attrMatch {
+	i := strings.IndexByte(s, '=')
+	if i == -1 {
+		return attrMatch{key: strings.TrimSpace(s)}
+	}
+	m := attrMatch{op: "="}
+	key := s[:i]
+	if strings.HasSuffix(key, "^") || strings.HasSuffix(key, "$") || strings.HasSuffix(key, "*") {
+		m.op = key[len(key)-1:] + "="
+		key = key[:len(key)-1]
+	}
+	m.key = strings.ToLower(strings.TrimSpace(key))
+	val := strings.TrimSpace(s[i+1:])
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+		val = val[1 : len(val)-1]
+	}
+	m.val = val
+	return m
+}
+
+// match reports whether the element n matches c.
+func (c compound) match(n *htm.Node) bool {
+	if n.Type != htm.ElementNode {
+		return false
+	}
+	if c.tag != "" && c.tag != "*" && c.tag != n.Data {
+		return false
+	}
+	if c.id != "" && attr(n, "id") != c.id {
+		return false
+	}
+	for _, class := range c.classes {
+		if !hasClass(n, class) {
+			return false
+		}
+	}
+	for _, m := range c.attrs {
+		val, ok := lookupAttr(n, m.key)
+		if !ok {
+			return false
+		}
+		switch m.op {
+		case "=":
+			ok = val == m.val
+		case "^=":
+			ok = strings.HasPrefix(val, m.val)
+		case "$=":
+			ok = strings.HasSuffix(val, m.val)
+		case "*=":
+			ok = strings.Contains(val, m.val)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether n matches s, considering only ancestors of n up to and
+// including root.
+func (s selector) match(n, root *htm.Node) bool {
+	return s.matchFrom(n, len(s)-1, root)
+}
+
+func (s selector) matchFrom(n *htm.Node, i int, root *htm.Node) bool {
+	if !s[i].match(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if n == root {
+		return false
+	}
+	for p := n.Parent; p != nil; p = p.Parent {
+		if s.matchFrom(p, i-1, root) {
+			return true
+		}
+		if s[i].child || p == root {
+			break
+		}
+	}
+	return false
+}
+
+// walk calls f for each descendant of n in document order, stopping once f
+// returns false.
+func walk(n *htm.Node, f func(*htm.Node) bool) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !f(c) || !walk(c, f) {
+			return false
+		}
+	}
+	return true
+}
+
+// find returns the first descendant of n matching the selector sel, or nil.
+func find(n *htm.Node, sel string) *htm.Node {
+	s := compile(sel)
+	var found *htm.Node
+	walk(n, func(c *htm.Node) bool {
+		if s.match(c, n) {
+			found = c
+		}
+		return found == nil
+	})
+	return found
+}
+
+// findAll returns the descendants of n matching the selector sel. If n is nil,
+// there are none.
+func findAll(n *htm.Node, sel string) []*htm.Node {
+	if n == nil {
+		return nil
+	}
+	s := compile(sel)
+	var found []*htm.Node
+	walk(n, func(c *htm.Node) bool {
+		if s.match(c, n) {
+			found = append(found, c)
+		}
+		return true
+	})
+	return found
+}
+
+// need is like find, but returns an error naming sel if no element matches.
+func need(n *htm.Node, sel string) (*htm.Node, error) {
+	found := find(n, sel)
+	if found == nil {
+		return nil, errors.New(nil, "missing element %s", sel)
+	}
+	return found, nil
+}
+
+// following returns the first element after n in document order, excluding
+// the descendants of n, which matches the selector sel, or nil.
+func following(n *htm.Node, sel string) *htm.Node {
+	s := compile(sel)
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	for ; n != nil; n = n.Parent {
+		for c := n.NextSibling; c != nil; c = c.NextSibling {
+			if s.match(c, root) {
+				return c
+			}
+			if found := find(c, sel); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// closest returns the nearest ancestor of n which is an element with the given
+// tag, or nil.
+func closest(n *htm.Node, tag string) *htm.Node {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p.Type == htm.ElementNode && p.Data == tag {
+			return p
+		}
+	}
+	return nil
+}
+
+// contains reports whether n is an ancestor of, or is, c.
+func contains(n, c *htm.Node) bool {
+	for ; c != nil; c = c.Parent {
+		if c == n {
+			return true
+		}
+	}
+	return false
+}
+
+// children returns the child elements of n with the given tag.
+func children(n *htm.Node, tag string) []*htm.Node {
+	var found []*htm.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == htm.ElementNode && c.Data == tag {
+			found = append(found, c)
+		}
+	}
+	return found
+}
+
+// labelled returns the innermost element within n whose text is label, or nil.
+func labelled(n *htm.Node, label string) *htm.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := labelled(c, label); found != nil {
+			return found
+		}
+	}
+	if n.Type == htm.ElementNode && text(n) == label {
+		return n
+	}
+	return nil
+}
+
+func lookupAttr(n *htm.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Namespace == "" && a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// attr returns the value of the attribute of n with the given key.
+func attr(n *htm.Node, key string) string {
+	val, _ := lookupAttr(n, key)
+	return val
+}
+
+func hasClass(n *htm.Node, class string) bool {
+	for _, c := range strings.Fields(attr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// style returns the value of the property prop in the inline style of n, with
+// whitespace trimmed, or an empty string.
+func style(n *htm.Node, prop string) string {
+	for _, decl := range strings.Split(attr(n, "style"), ";") {
+		key, val, ok := strings.Cut(decl, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), prop) {
+			return strings.TrimSpace(val)
+		}
+	}
+	return ""
+}
+
+// text returns the text content of n with whitespace, including non-breaking
+// spaces, collapsed and trimmed.
+func text(n *htm.Node) string {
+	var b strings.Builder
+	var collect func(*htm.Node)
+	collect = func(n *htm.Node) {
+		if n.Type == htm.TextNode {
+			b.WriteString(n.Data)
+		}
+		if n.Type == htm.ElementNode && n.Data == "br" {
+			b.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// innerHTML returns the HTML of the children of n.
+func innerHTML(n *htm.Node) string {
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		htm.Render(&b, c)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// outerHTML returns the HTML of n.
+func outerHTML(n *htm.Node) string {
+	var b strings.Builder
+	htm.Render(&b, n)
+	return b.String()
+}
+
+// parsePage parses the HTML document page.
+func parsePage(page string) (*htm.Node, error) {
+	doc, err := htm.Parse(strings.NewReader(page))
+	if err != nil {
+		return nil, errors.New(err, "cannot parse HTML")
+	}
+	return doc, nil
+}
+
+// parseFragment parses the HTML fragment s, which is taken to be within a
+// table if it starts with a table row or cell, and returns the body element
+// containing it.
+func parseFragment(s string) (*htm.Node, error) {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "<tr") || strings.HasPrefix(trimmed, "<td") {
+		s = "<table>" + s + "</table>"
+	}
+	doc, err := parsePage("<!DOCTYPE html><html><body>" + s + "</body></html>")
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return find(doc, "body"), nil
+}
+
+// nextElement returns the element following n among its siblings, or nil.
+func nextElement(n *htm.Node) *htm.Node {
+	for c := n.NextSibling; c != nil; c = c.NextSibling {
+		if c.Type == htm.ElementNode {
+			return c
+		}
+	}
+	return nil
+}
+
+// prevElement returns the element preceding n among its siblings, or nil.
+func prevElement(n *htm.Node) *htm.Node {
+	for c := n.PrevSibling; c != nil; c = c.PrevSibling {
+		if c.Type == htm.ElementNode {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package daymap
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/site"
+)
+
+// Events retrieves the school calendar events from the Daymap diary, which are
+// the diary entries that are not lessons.
+func (p platform) Events(ctx context.Context, user site.User, c chan site.Pair[[]site.Event, error], start, end time.Time) {
+	var result site.Pair[[]site.Event, error]
+
+	// Events which start at the end of the range are not included, so the
+	// diary is only fetched up to the day before if the range ends at
+	// midnight. The diary entries are then shared with a call for the
+	// lessons of the same days.
+	last := end
+	if h, m, s := end.Clock(); h == 0 && m == 0 && s == 0 && end.After(start) {
+		last = end.AddDate(0, 0, -1)
+	}
+	fetched, err := p.diary(ctx, user, start, last)
+	if err != nil {
+		result.Second = errors.Wrap(err)
+		c <- result
+		return
+	}
+
+	var events []site.Event
+	for _, e := range fetched {
+		if e.Type == "Lesson" {
+			continue
+		}
+
+		event := site.Event{
+			Name:     strings.TrimSpace(e.Title),
+			Category: e.Type,
+			Platform: "daymap",
+		}
+		event.Start, err = diaryTime(e.Start, user.Timezone)
+		if err != nil {
+			result.Second = errors.New(site.Fail(site.ErrParse, err), "invalid events JSON")
+			c <- result
+			return
+		}
+		event.End, err = diaryTime(e.Finish, user.Timezone)
+		if err != nil {
+			result.Second = errors.New(site.Fail(site.ErrParse, err), "invalid events JSON")
+			c <- result
+			return
+		}
+
+		// Daymap represents all-day events as running from midnight to the
+		// last minute of the day.
+		event.Start = event.Start.In(user.Timezone)
+		event.End = event.End.In(user.Timezone)
+		first := time.Date(event.Start.Year(), event.Start.Month(), event.Start.Day(), 0, 0, 0, 0, user.Timezone)
+		if event.Start.Equal(first) && event.End.Sub(event.Start) >= 24*time.Hour-time.Minute {
+			event.AllDay = true
+			last := event.End.Add(-time.Minute)
+			event.End = time.Date(last.Year(), last.Month(), last.Day()+1, 0, 0, 0, 0, user.Timezone)
+		}
+
+		events = append(events, event)
+	}
+
+	result.First = events
+	c <- result
+}
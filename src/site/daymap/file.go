@@ -0,0 +1,101 @@
+package daymap
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/site"
+)
+
+// FileId returns the ID of the Daymap attachment at link.
+func (p platform) FileId(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", false
+	}
+	base, err := url.Parse(p.base)
+	if err != nil {
+		return "", false
+	}
+	if !strings.EqualFold(u.Host, base.Host) || !strings.EqualFold(u.Path, "/daymap/attachment.ashx") {
+		return "", false
+	}
+	for key, values := range u.Query() {
+		if strings.EqualFold(key, "id") && len(values) == 1 && values[0] != "" {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
+// File streams the Daymap attachment with the given ID, or the part of it given
+// by rng, if not empty.
+func (p platform) File(ctx context.Context, user site.User, id, rng string) (site.File, error) {
+	// The body is streamed after File returns, so the request is bounded by
+	// ctx rather than a client timeout.
+	client := &http.Client{}
+	req, err := http.NewRequestWithContext(ctx, "GET", p.base+"/daymap/attachment.ashx?ID="+url.QueryEscape(id), nil)
+	if err != nil {
+		return site.File{}, errors.New(err, "cannot create attachment request")
+	}
+
+	req.Header.Set("Cookie", user.SiteTokens["daymap"])
+	if rng != "" {
+		req.Header.Set("Range", rng)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return site.File{}, errors.New(err, "cannot execute attachment request")
+	}
+
+	err = p.checkResponse(resp)
+	if err != nil {
+		resp.Body.Close()
+		return site.File{}, err
+	}
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		// The length of the file is given as "bytes */length".
+		size := int64(-1)
+		if length, ok := strings.CutPrefix(resp.Header.Get("Content-Range"), "bytes */"); ok {
+			n, err := strconv.ParseInt(length, 10, 64)
+			if err == nil {
+				size = n
+			}
+		}
+		return site.File{}, &site.RangeError{Size: size}
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 206 {
+		resp.Body.Close()
+		return site.File{}, errors.New(nil, "attachment request returned status %d", resp.StatusCode)
+	}
+
+	if !strings.EqualFold(resp.Request.URL.Path, "/daymap/attachment.ashx") {
+		resp.Body.Close()
+		return site.File{}, errors.New(nil, "attachment request was redirected")
+	}
+
+	file := site.File{
+		Name:     id,
+		MimeType: resp.Header.Get("Content-Type"),
+		Size:     resp.ContentLength,
+		Ranges:   resp.Header.Get("Accept-Ranges") == "bytes",
+		Body:     resp.Body,
+	}
+	if resp.StatusCode == 206 {
+		file.Range = resp.Header.Get("Content-Range")
+	}
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+	if err == nil && params["filename"] != "" {
+		file.Name = params["filename"]
+	}
+	return file, nil
+}
@@ -1,8 +1,10 @@
 package daymap
 
 import (
-	"bufio"
+	"context"
+	"io"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -12,19 +14,18 @@ import (
 	"main/site"
 )
 
-func Graded(user site.User, c chan site.Pair[[]site.Task, error]) {
+func (p platform) Graded(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error]) {
 	var result site.Pair[[]site.Task, error]
 
-	client := &http.Client{}
-	taskUrl := "https://gihs.daymap.net/daymap/student/assignment.aspx?TaskID="
-	link := "https://gihs.daymap.net/daymap/student/portfolio.aspx/AssessmentReport"
-	referrer := "https://gihs.daymap.net/daymap/student/portfolio.aspx?tab=Assessment_Results"
+	client := &http.Client{Timeout: site.RequestTimeout}
+	link := p.base + "/daymap/student/portfolio.aspx/AssessmentReport"
+	referrer := p.base + "/daymap/student/portfolio.aspx?tab=Assessment_Results"
 	form := `{"id":5303,"classId":0,"viewMode":"tabular","allCompleted":false,"taskType":0,`
 	year := strconv.Itoa(time.Now().In(user.Timezone).Year())
 	times := strings.ReplaceAll(`"fromDate":"YYYY-01-01T00:00:00.000Z","toDate":"YYYY-12-31T23:59:59.999Z"}`, "YYYY", year)
 	data := strings.NewReader(form + times)
 
-	req, err := http.NewRequest("POST", link, data)
+	req, err := http.NewRequestWithContext(ctx, "POST", link, data)
 	if err != nil {
 		result.Second = errors.New(err, "cannot create grades request")
 		c <- result
@@ -33,7 +34,7 @@ func Graded(user site.User, c chan site.Pair[[]site.Task, error]) {
 
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 	req.Header.Set("Cookie", user.SiteTokens["daymap"])
-	req.Header.Set("Origin", "https://gihs.daymap.net")
+	req.Header.Set("Origin", p.base)
 	req.Header.Set("Referer", referrer)
 
 	resp, err := client.Do(req)
@@ -43,109 +44,86 @@ func Graded(user site.User, c chan site.Pair[[]site.Task, error]) {
 		return
 	}
 
-	scanner := bufio.NewScanner(resp.Body)
+	err = p.checkResponse(resp)
+	if err != nil {
+		result.Second = err
+		c <- result
+		return
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Second = errors.New(err, "cannot read grades response body")
+		c <- result
+		return
+	}
+
+	result.First, result.Second = p.parseGraded(string(body))
+	if result.Second != nil {
+		result.Second = errors.New(site.Fail(site.ErrParse, result.Second), "invalid HTML response")
+	}
+	c <- result
+}
+
+// openTaskPattern matches the links which open tasks in the Daymap assessment
+// report, capturing the task ID.
+var openTaskPattern = regexp.MustCompile(`^javascript:OpenTask\(([0-9]+)\)`)
+
+// parseGraded parses the Daymap assessment report, which lists graded tasks in
+// a table, in groups headed by their class.
+func (p platform) parseGraded(page string) ([]site.Task, error) {
+	doc, err := parsePage(page)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	var tasks []site.Task
 	class := ""
-	for scanner.Scan() {
-		line := scanner.Text()
-		i := strings.Index(line, `<tr><th colspan='5' align='left'>`)
-		if i != -1 {
-			i += len(`<tr><th colspan='5' align='left'>`)
-			line = line[i:]
-			i = strings.Index(line, " (")
-			if i == -1 {
-				result.Second = errors.New(nil, "invalid HTML response")
-				c <- result
-				return
-			}
-			class = line[:i]
+	for _, row := range findAll(doc, "tr") {
+		// Class headings span the table, and are followed by the class code
+		// in brackets.
+		if th := find(row, "th[colspan]"); th != nil {
+			class, _, _ = strings.Cut(text(th), " (")
 			continue
 		}
-
-		if !strings.HasPrefix(line, `<tr><td><a href="javascript:OpenTask(`) {
+		a := find(row, `a[href^="javascript:OpenTask("]`)
+		if a == nil {
 			continue
 		}
-		task := site.Task{Class: class, Platform: "daymap"}
-		i = len(`<tr><td><a href="javascript:OpenTask(`)
-		line = line[i:]
-
-		i = strings.Index(line, `);">`)
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-		task.Id = line[:i]
-		task.Link = taskUrl + task.Id
-		i += len(`);">`)
-		line = line[i:]
-
-		i = strings.Index(line, `</a>`)
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
+		m := openTaskPattern.FindStringSubmatch(attr(a, "href"))
+		if m == nil {
+			return nil, errors.New(nil, "invalid task link %q", attr(a, "href"))
 		}
-		task.Name = line[:i]
-
-		for j := 0; j < 2; j++ {
-			i = strings.Index(line, `<td nowrap>`)
-			if i == -1 {
-				result.Second = errors.New(nil, "invalid HTML response")
-				c <- result
-				return
-			}
-			i += len(`<td nowrap>`)
-			line = line[i:]
+		task := site.Task{
+			Name:     text(a),
+			Class:    class,
+			Link:     p.base + "/daymap/student/assignment.aspx?TaskID=" + m[1],
+			Platform: "daymap",
+			Id:       m[1],
 		}
 
-		i = strings.Index(line, `</td>`)
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
+		// The cells following the task name give the date, grade and mark.
+		cells := findAll(row, "td[nowrap]")
+		if len(cells) < 3 {
+			return nil, errors.New(nil, "missing element td[nowrap] for task %s", task.Id)
 		}
-		task.Grade = line[:i]
-
-		i = strings.Index(line, `<td nowrap>`)
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-		i += len(`<td nowrap>`)
-		line = line[i:]
-
-		i = strings.Index(line, `</td>`)
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-		mark := line[:i]
+		task.Grade = text(cells[1])
+		mark := text(cells[2])
 		marks := strings.Split(mark, "/")
 
 		if len(marks) == 2 {
-			top, err := strconv.ParseFloat(marks[0], 64)
+			top, err := strconv.ParseFloat(strings.TrimSpace(marks[0]), 64)
 			if err != nil {
-				result.Second = errors.New(err, `cannot convert "%s" to float64`, marks[0])
-				c <- result
-				return
+				return nil, errors.New(err, `cannot convert "%s" to float64`, marks[0])
 			}
-			bottom, err := strconv.ParseFloat(marks[1], 64)
+			bottom, err := strconv.ParseFloat(strings.TrimSpace(marks[1]), 64)
 			if err != nil {
-				result.Second = errors.New(err, `cannot convert "%s" to float64`, marks[1])
-				c <- result
-				return
+				return nil, errors.New(err, `cannot convert "%s" to float64`, marks[1])
 			}
 			task.Score = top / bottom * 100
 		}
 
-		result.First = append(result.First, task)
+		tasks = append(tasks, task)
 	}
-	if err := scanner.Err(); err != nil {
-		result.Second = errors.New(err, "cannot read grades response body")
-		c <- result
-		return
-	}
-	c <- result
+	return tasks, nil
 }
@@ -1,11 +1,14 @@
 package daymap
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"git.sr.ht/~kvo/go-std/errors"
@@ -22,74 +25,170 @@ type Lesson struct {
 	Title  string
 }
 
-func Lessons(user site.User, start, end time.Time) ([]site.Lesson, error) {
-	client := &http.Client{}
+// diaryReuse is how long the diary entries fetched for one call are reused by
+// other calls for the same user, such as when the timetable fetches a week's
+// lessons and then its events.
+const diaryReuse = 30 * time.Second
+
+// A diaryFetch is a request for a user's diary entries from one date to
+// another, inclusive, which may be shared by several calls.
+type diaryFetch struct {
+	uid      site.Uid
+	from, to string
+	done     chan struct{}
+	entries  []Lesson
+	err      error
+	fetched  time.Time
+}
+
+// diaryCache holds the diary fetches which are in progress or were recently
+// completed.
+type diaryCache struct {
+	mu      sync.Mutex
+	fetches []*diaryFetch
+}
+
+// diary returns the Daymap diary entries (lessons and calendar events) from
+// start to end. Entries fetched by another call for a range including both are
+// reused if they are recent enough.
+func (p platform) diary(ctx context.Context, user site.User, start, end time.Time) ([]Lesson, error) {
+	uid := site.Uid{School: user.School, Username: user.Username}
+	from, to := start.Format("2006-01-02"), end.Format("2006-01-02")
+
+	p.diaries.mu.Lock()
+	var shared *diaryFetch
+	fetches := p.diaries.fetches[:0]
+	for _, f := range p.diaries.fetches {
+		if !f.fetched.IsZero() && time.Since(f.fetched) >= diaryReuse {
+			continue
+		}
+		fetches = append(fetches, f)
+		if f.uid == uid && f.from <= from && to <= f.to {
+			shared = f
+		}
+	}
+	p.diaries.fetches = fetches
+	if shared == nil {
+		f := &diaryFetch{uid: uid, from: from, to: to, done: make(chan struct{})}
+		p.diaries.fetches = append(p.diaries.fetches, f)
+		p.diaries.mu.Unlock()
+		f.entries, f.err = p.fetchDiary(ctx, user, from, to)
+		p.diaries.mu.Lock()
+		f.fetched = time.Now()
+		if f.err != nil {
+			p.diaries.fetches = slices.DeleteFunc(p.diaries.fetches, func(g *diaryFetch) bool {
+				return g == f
+			})
+		}
+		p.diaries.mu.Unlock()
+		close(f.done)
+		return f.entries, f.err
+	}
+	p.diaries.mu.Unlock()
+
+	select {
+	case <-shared.done:
+	case <-ctx.Done():
+		return nil, errors.New(ctx.Err(), "diary request did not complete")
+	}
+	if shared.err != nil {
+		// The shared fetch may have failed because the call which made it
+		// ended.
+		return p.fetchDiary(ctx, user, from, to)
+	}
+	// Entries which span several days, such as overnight events, are kept
+	// if any part of them falls within the dates.
+	var entries []Lesson
+	for _, e := range shared.entries {
+		first, ferr := diaryTime(e.Start, user.Timezone)
+		last, lerr := diaryTime(e.Finish, user.Timezone)
+		if ferr == nil && first.In(user.Timezone).Format("2006-01-02") > to {
+			continue
+		}
+		if lerr == nil && last.In(user.Timezone).Format("2006-01-02") < from {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// fetchDiary requests the Daymap diary entries from one date to another.
+func (p platform) fetchDiary(ctx context.Context, user site.User, from, to string) ([]Lesson, error) {
+	client := &http.Client{Timeout: site.RequestTimeout}
 	var fetched []Lesson
-	var lessons []site.Lesson
 
-	lessonsUrl := "https://gihs.daymap.net/daymap/DWS/Diary.ashx?cmd=EventList&from="
-	lessonsUrl += start.Format("2006-01-02") + "&to=" + end.Format("2006-01-02")
+	diaryUrl := p.base + "/daymap/DWS/Diary.ashx?cmd=EventList&from=" + from + "&to=" + to
 
-	req, err := http.NewRequest("GET", lessonsUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", diaryUrl, nil)
 	if err != nil {
-		return nil, errors.New(err, "cannot create lessons request")
+		return nil, errors.New(err, "cannot create diary request")
 	}
 
 	req.Header.Set("Cookie", user.SiteTokens["daymap"])
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, errors.New(err, "cannot execute lessons request")
+		return nil, errors.New(err, "cannot execute diary request")
 	}
+	defer resp.Body.Close()
 
-	err = json.NewDecoder(resp.Body).Decode(&fetched)
+	err = p.checkResponse(resp)
 	if err != nil {
-		return nil, errors.New(err, "cannot decode lessons JSON")
+		return nil, err
 	}
 
-	for _, l := range fetched {
-		if l.Type != "Lesson" {
-			continue
-		}
-
-		lesson := site.Lesson{}
-		lesson.Start, err = time.ParseInLocation("2006-01-02T15:04:05.0000000", l.Start, user.Timezone)
+	err = json.NewDecoder(resp.Body).Decode(&fetched)
+	if err != nil {
+		return nil, errors.New(site.Fail(site.ErrParse, err), "cannot decode diary JSON")
+	}
+	return fetched, nil
+}
 
-		if err != nil {
-			startIdx := strings.Index(l.Start, "(") + 1
-			endIdx := strings.Index(l.Start, "000-")
+// diaryTime parses a time from a Daymap diary entry, which is either a local
+// time or a JSON date of the form "/Date(1700000000000-0000)/".
+func diaryTime(s string, loc *time.Location) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02T15:04:05.0000000", s, loc)
+	if err == nil {
+		return t, nil
+	}
 
-			if startIdx == 0 || endIdx == -1 {
-				return nil, errors.New(nil, "invalid lessons JSON")
-			}
+	startIdx := strings.Index(s, "(") + 1
+	endIdx := strings.Index(s, "000-")
 
-			startStr := l.Start[startIdx:endIdx]
-			startInt, err := strconv.Atoi(startStr)
-			if err != nil {
-				return nil, errors.New(err, `cannot convert "%s" to int`, startStr)
-			}
+	if startIdx == 0 || endIdx == -1 {
+		return time.Time{}, errors.New(nil, "invalid diary time: %s", s)
+	}
 
-			lesson.Start = time.Unix(int64(startInt), 0)
+	unixStr := s[startIdx:endIdx]
+	unix, err := strconv.Atoi(unixStr)
+	if err != nil {
+		return time.Time{}, errors.New(err, `cannot convert "%s" to int`, unixStr)
+	}
+	return time.Unix(int64(unix), 0), nil
+}
 
-			startIdx = strings.Index(l.Finish, "(") + 1
-			endIdx = strings.Index(l.Finish, "000-")
+func (p platform) lessons(ctx context.Context, user site.User, start, end time.Time) ([]site.Lesson, error) {
+	var lessons []site.Lesson
 
-			if startIdx == 0 || endIdx == -1 {
-				return nil, errors.New(nil, "invalid lessons JSON")
-			}
+	fetched, err := p.diary(ctx, user, start, end)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
 
-			finishStr := l.Finish[startIdx:endIdx]
-			finishInt, err := strconv.Atoi(finishStr)
-			if err != nil {
-				return nil, errors.New(err, `cannot convert "%s" to int`, finishStr)
-			}
+	for _, l := range fetched {
+		if l.Type != "Lesson" {
+			continue
+		}
 
-			lesson.End = time.Unix(int64(finishInt), 0)
-		} else {
-			lesson.End, err = time.ParseInLocation("2006-01-02T15:04:05.0000000", l.Finish, user.Timezone)
-			if err != nil {
-				return nil, errors.New(err, "cannot parse time")
-			}
+		lesson := site.Lesson{Platform: "daymap"}
+		lesson.Start, err = diaryTime(l.Start, user.Timezone)
+		if err != nil {
+			return nil, errors.New(site.Fail(site.ErrParse, err), "invalid lessons JSON")
+		}
+		lesson.End, err = diaryTime(l.Finish, user.Timezone)
+		if err != nil {
+			return nil, errors.New(site.Fail(site.ErrParse, err), "invalid lessons JSON")
 		}
 
 		class := l.Title
@@ -0,0 +1,175 @@
+package daymap
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/site"
+)
+
+// maxMessageFetches is the number of messages fetched from Daymap at once.
+const maxMessageFetches = 4
+
+// A message summary in the Daymap message centre inbox.
+type msgSummary struct {
+	ID      int
+	Subject string
+	From    string
+	Sent    string
+	Read    bool
+}
+
+// A message as shown in the Daymap message centre.
+type msgDetail struct {
+	Body string
+	To   []string
+	Cc   []string
+}
+
+// comsCall calls the Daymap message centre web method with the given name and
+// JSON form, and decodes the JSON result into v.
+func (p platform) comsCall(ctx context.Context, user site.User, method, form string, v any) error {
+	comsUrl := p.base + "/daymap/coms/Messaging.aspx"
+	client := &http.Client{Timeout: site.RequestTimeout}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", comsUrl+"/"+method, strings.NewReader(form))
+	if err != nil {
+		return errors.New(err, "cannot create %s request", method)
+	}
+
+	req.Header.Set("Accept", "application/json, text/javascript, */*; q=0.01")
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Cookie", user.SiteTokens["daymap"])
+	req.Header.Set("Referer", comsUrl)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.New(err, "cannot execute %s request", method)
+	}
+	defer resp.Body.Close()
+
+	err = p.checkResponse(resp)
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.New(err, "cannot read %s response body", method)
+	}
+
+	// Like other Daymap web methods, the result is a JSON string wrapped in
+	// a JSON object.
+	var wrapped resJson
+	err = json.Unmarshal(body, &wrapped)
+	if err != nil {
+		return errors.New(site.Fail(site.ErrParse, err), "cannot decode %s response", method)
+	}
+	err = json.Unmarshal([]byte(wrapped.D), v)
+	if err != nil {
+		return errors.New(site.Fail(site.ErrParse, err), "cannot decode %s JSON", method)
+	}
+	return nil
+}
+
+// addresses converts the names of message recipients into addresses. Daymap
+// does not reveal the email addresses of its users.
+func addresses(names []string) []mail.Address {
+	var addrs []mail.Address
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			addrs = append(addrs, mail.Address{Name: name})
+		}
+	}
+	return addrs
+}
+
+func (p platform) message(ctx context.Context, user site.User, summary msgSummary, c chan site.Pair[site.Message, error]) {
+	var result site.Pair[site.Message, error]
+
+	var detail msgDetail
+	form := fmt.Sprintf(`{"id":%d}`, summary.ID)
+	err := p.comsCall(ctx, user, "GetMessage", form, &detail)
+	if err != nil {
+		result.Second = errors.Wrap(err)
+		c <- result
+		return
+	}
+
+	sent, err := diaryTime(summary.Sent, user.Timezone)
+	if err != nil {
+		result.Second = errors.New(site.Fail(site.ErrParse, err), "invalid message JSON")
+		c <- result
+		return
+	}
+
+	result.First = site.Message{
+		From:     mail.Address{Name: strings.TrimSpace(summary.From)},
+		To:       addresses(detail.To),
+		Cc:       addresses(detail.Cc),
+		Sent:     sent,
+		Subject:  strings.TrimSpace(summary.Subject),
+		Body:     detail.Body,
+		Read:     summary.Read,
+		Platform: "daymap",
+		Id:       strconv.Itoa(summary.ID),
+	}
+	c <- result
+}
+
+// Messages retrieves the messages in the user's Daymap message centre inbox.
+// Each message is fetched separately; if only some can be fetched, they are
+// returned with a site.PlatformError reporting the inbox as incomplete.
+func (p platform) Messages(ctx context.Context, user site.User, c chan site.Pair[[]site.Message, error]) {
+	var result site.Pair[[]site.Message, error]
+
+	var summaries []msgSummary
+	err := p.comsCall(ctx, user, "GetMessageList", `{"folder":"Inbox"}`, &summaries)
+	if err != nil {
+		result.Second = errors.Wrap(err)
+		c <- result
+		return
+	}
+
+	ch := make(chan site.Pair[site.Message, error], len(summaries))
+	sem := make(chan struct{}, maxMessageFetches)
+	for _, summary := range summaries {
+		go func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			p.message(ctx, user, summary, ch)
+		}()
+	}
+
+	var messages []site.Message
+	var errs []error
+	for range summaries {
+		msg := <-ch
+		if msg.Second != nil {
+			errs = append(errs, msg.Second)
+			continue
+		}
+		messages = append(messages, msg.First)
+	}
+	if len(errs) > 0 && len(messages) == 0 {
+		result.Second = errors.New(errs[0], "cannot fetch any of %d messages", len(summaries))
+		c <- result
+		return
+	}
+	if len(errs) > 0 {
+		err = errors.New(errs[0], "cannot fetch %d of %d messages", len(errs), len(summaries))
+		result.Second = site.PlatformError{"daymap": err}
+	}
+
+	result.First = messages
+	c <- result
+}
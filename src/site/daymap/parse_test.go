@@ -0,0 +1,386 @@
+package daymap
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"main/site"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+var testUser = site.User{Timezone: time.FixedZone("ACDT", 630*60)}
+
+// testPlatform uses the default URLs, which links in the golden files are
+// resolved against.
+var testPlatform, _ = newPlatform(nil)
+
+// fixture returns the contents of the named file in testdata.
+func fixture(t *testing.T, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// golden compares got, encoded as indented JSON, against testdata/name.golden.
+// With -update, the golden file is rewritten instead.
+func golden(t *testing.T, name string, got any) {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	err := enc.Encode(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		err = os.WriteFile(path, b, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("%s does not match golden file %s:\n%s", name, path, b)
+	}
+}
+
+func TestParseTask(t *testing.T) {
+	for _, name := range []string{"task", "task_ungraded"} {
+		t.Run(name, func(t *testing.T) {
+			task, err := testPlatform.parseTask(fixture(t, name+".html"), "1234", testUser)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden(t, name, task)
+		})
+	}
+}
+
+func TestParseGraded(t *testing.T) {
+	tasks, err := testPlatform.parseGraded(fixture(t, "graded.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "graded", tasks)
+}
+
+func TestParseClassInfo(t *testing.T) {
+	name, id, err := parseClassInfo(fixture(t, "class.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "English 11A" || id != "8765" {
+		t.Errorf("got (%q, %q), want (%q, %q)", name, id, "English 11A", "8765")
+	}
+}
+
+func TestParseClassRes(t *testing.T) {
+	class := site.Class{Id: "4321", Platform: "daymap"}
+	resources, err := testPlatform.parseClassRes([]byte(fixture(t, "resources.json")), class, "English 11A", testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "resources", resources)
+}
+
+func TestParsePlan(t *testing.T) {
+	var resource site.Resource
+	err := testPlatform.parsePlan(fixture(t, "plan.html"), "7002", &resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "plan", resource)
+}
+
+func TestParseTasks(t *testing.T) {
+	tasks, err := testPlatform.parseTasks(fixture(t, "assignments.html"), testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "assignments", tasks)
+}
+
+func TestParseHiddenInputs(t *testing.T) {
+	form, err := parseHiddenInputs(fixture(t, "assignments.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "hidden", form)
+}
+
+func TestParseClasses(t *testing.T) {
+	classes, err := testPlatform.parseClasses(fixture(t, "dayplan.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "classes", classes)
+}
+
+func TestParseRemoveForm(t *testing.T) {
+	action, form, err := parseRemoveForm(fixture(t, "attachments.html"), []string{"essay-draft.docx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "remove", map[string]any{"action": action, "form": form})
+}
+
+// TestParseErrors checks that parsers fail on pages missing the elements they
+// depend on, and that the error names the missing element. Where kind is set,
+// the error must also be of that kind.
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		old     string
+		new     string
+		parse   func(page string) error
+		want    string
+		kind    error
+	}{
+		{
+			name:    "task name",
+			fixture: "task_ungraded.html",
+			old:     `class='SectionHeader'`,
+			new:     `class='Header'`,
+			parse: func(page string) error {
+				_, err := testPlatform.parseTask(page, "1234", testUser)
+				return err
+			},
+			want: "missing element .SectionHeader",
+		},
+		{
+			name:    "task results",
+			fixture: "task.html",
+			old:     `id="ctl00_ctl00_cp_cp_divResults"`,
+			new:     `id="results"`,
+			parse: func(page string) error {
+				_, err := testPlatform.parseTask(page, "1234", testUser)
+				return err
+			},
+			want: "missing element #ctl00_ctl00_cp_cp_divResults",
+		},
+		{
+			name:    "class name",
+			fixture: "class.html",
+			old:     `id="ctl00_ctl00_cp_cp_divHeader"`,
+			new:     `id="header"`,
+			parse: func(page string) error {
+				_, _, err := parseClassInfo(page)
+				return err
+			},
+			want: "missing element #ctl00_ctl00_cp_cp_divHeader",
+			kind: site.ErrParse,
+		},
+		{
+			name:    "course id",
+			fixture: "class.html",
+			old:     "new Classroom(",
+			new:     "new Room(",
+			parse: func(page string) error {
+				_, _, err := parseClassInfo(page)
+				return err
+			},
+			want: "missing secondary course ID",
+			kind: site.ErrParse,
+		},
+		{
+			name:    "plan note",
+			fixture: "plan.html",
+			old:     `id="Note7002"`,
+			new:     `id="Note"`,
+			parse: func(page string) error {
+				return testPlatform.parsePlan(page, "7002", &site.Resource{})
+			},
+			want: "missing element #Note7002",
+		},
+		{
+			name:    "plan title",
+			fixture: "resources.json",
+			old:     `<div class='lpTitle'>Week 2: Act One</div>`,
+			new:     `<div>Week 2: Act One</div>`,
+			parse: func(page string) error {
+				_, err := testPlatform.parseClassRes([]byte(page), site.Class{Id: "4321"}, "English 11A", testUser)
+				return err
+			},
+			want: "invalid lesson plan 7002: missing element .lpTitle",
+			kind: site.ErrParse,
+		},
+		{
+			name:    "task cells",
+			fixture: "assignments.html",
+			old:     "<td>Physics</td><td>Test</td>",
+			new:     "",
+			parse: func(page string) error {
+				_, err := testPlatform.parseTasks(page, testUser)
+				return err
+			},
+			want: "missing element td for task 1250",
+			kind: site.ErrParse,
+		},
+		{
+			name:    "task due date",
+			fixture: "assignments.html",
+			old:     "<td>28/03/24</td>",
+			new:     "<td>soon</td>",
+			parse: func(page string) error {
+				_, err := testPlatform.parseTasks(page, testUser)
+				return err
+			},
+			want: `invalid due date "soon" for task 1250`,
+			kind: site.ErrParse,
+		},
+		{
+			name:    "remove form",
+			fixture: "attachments.html",
+			old:     `action="./attachments.aspx?Type=1&amp;LinkID=1234"`,
+			new:     "",
+			parse: func(page string) error {
+				_, _, err := parseRemoveForm(page, nil)
+				return err
+			},
+			want: "missing element form[action]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := fixture(t, tt.fixture)
+			if !strings.Contains(page, tt.old) {
+				t.Fatalf("%s does not contain %q", tt.fixture, tt.old)
+			}
+			err := tt.parse(strings.Replace(page, tt.old, tt.new, 1))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+			if tt.kind != nil && site.Kind(err) != tt.kind {
+				t.Errorf("error %q is of kind %v, want %v", err, site.Kind(err), tt.kind)
+			}
+		})
+	}
+}
+
+func TestSelector(t *testing.T) {
+	root, err := parseFragment(`<div id="a" class="x y"><p><span name="f" data-v="one two">1</span></p><span>2</span></div><span class="y">3</span>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		sel  string
+		want string
+	}{
+		{"span", "1 2 3"},
+		{"#a span", "1 2"},
+		{"#a > span", "2"},
+		{"div.x.y p span", "1"},
+		{".y", "12 3"},
+		{"span.y", "3"},
+		{"[name]", "1"},
+		{"span[name=f]", "1"},
+		{`[data-v^="one"]`, "1"},
+		{`[data-v$=two]`, "1"},
+		{`[data-v*="e t"]`, "1"},
+		{"p > span[name=g]", ""},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, n := range findAll(root, tt.sel) {
+			got = append(got, text(n))
+		}
+		if s := strings.Join(got, " "); s != tt.want {
+			t.Errorf("findAll(%q) = %q, want %q", tt.sel, s, tt.want)
+		}
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	configured, err := testPlatform.Configure(map[string]string{"base": "http://127.0.0.1:8080/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := configured.(platform)
+	if p.base != "http://127.0.0.1:8080" || p.okta != defaultURLs["okta"] {
+		t.Errorf("configured URLs are %+v", p)
+	}
+	if id, ok := p.FileId("http://127.0.0.1:8080/daymap/attachment.ashx?ID=5551"); !ok || id != "5551" {
+		t.Errorf("FileId of configured attachment = (%q, %v)", id, ok)
+	}
+	if _, ok := p.FileId("https://gihs.daymap.net/daymap/attachment.ashx?ID=5551"); ok {
+		t.Error("FileId accepted attachment of default instance")
+	}
+	tasks, err := p.parseTasks(fixture(t, "assignments.html"), testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://127.0.0.1:8080/daymap/student/assignment.aspx?TaskID=1234"; tasks[0].Link != want {
+		t.Errorf("task link = %q, want %q", tasks[0].Link, want)
+	}
+
+	for _, urls := range []map[string]string{
+		{"daymap": "https://example.daymap.net"},
+		{"base": "example.daymap.net"},
+		{"base": "ftp://example.daymap.net"},
+	} {
+		if _, err := testPlatform.Configure(urls); err == nil {
+			t.Errorf("Configure(%v) succeeded", urls)
+		}
+	}
+}
+
+// unsafePattern matches markup which should never remain in a sanitised
+// description.
+var unsafePattern = regexp.MustCompile(`<(script|style|iframe)|\son[a-z]+=|javascript:|data:`)
+
+func TestSanitizeDescriptions(t *testing.T) {
+	var descs []struct{ Link, Desc string }
+	for _, name := range []string{"task", "task_ungraded"} {
+		task, err := testPlatform.parseTask(fixture(t, name+".html"), "1234", testUser)
+		if err != nil {
+			t.Fatal(err)
+		}
+		descs = append(descs, struct{ Link, Desc string }{task.Link, task.Desc})
+	}
+	var resource site.Resource
+	err := testPlatform.parsePlan(fixture(t, "plan.html"), "7002", &resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	descs = append(descs, struct{ Link, Desc string }{resource.Link, resource.Desc})
+
+	var got []map[string]string
+	for _, d := range descs {
+		s := site.Sanitizer{Base: d.Link, RemoteImages: true}
+		html := s.HTML(d.Desc)
+		if again := s.HTML(html); again != html {
+			t.Errorf("sanitising description of %s again changed it:\n%s\n%s", d.Link, html, again)
+		}
+		if m := unsafePattern.FindString(strings.ToLower(html)); m != "" {
+			t.Errorf("sanitised description of %s contains %q:\n%s", d.Link, m, html)
+		}
+		got = append(got, map[string]string{
+			"html":     html,
+			"text":     s.Text(d.Desc),
+			"markdown": s.Markdown(d.Desc),
+		})
+	}
+	golden(t, "descriptions", got)
+}
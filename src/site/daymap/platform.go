@@ -0,0 +1,110 @@
+package daymap
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/site"
+)
+
+// defaultURLs are the URLs of the Daymap instance of GIHS and of the EdPass
+// services through which its students sign in.
+var defaultURLs = map[string]string{
+	"base":   "https://gihs.daymap.net",
+	"portal": "https://portal.edpass.sa.edu.au",
+	"hrd":    "https://hrd.edpass.sa.edu.au",
+	"okta":   "https://edpass-0927.okta.com",
+	"blob":   "https://glenunga.blob.core.windows.net/daymap/up",
+}
+
+// platform registers Daymap with the site package. Its fields are the URLs of
+// the school's Daymap instance and of the services it depends on.
+type platform struct {
+	// the Daymap instance
+	base string
+	// the EdPass portal, identity provider discovery and Okta organisation
+	portal string
+	hrd    string
+	okta   string
+	// the Azure blob container to which work is uploaded
+	blob string
+	// diary entries shared between calls (see diary)
+	diaries *diaryCache
+}
+
+// newPlatform returns a platform using the given URLs in place of the
+// defaults.
+func newPlatform(urls map[string]string) (platform, error) {
+	merged, err := site.MergeURLs(defaultURLs, urls)
+	if err != nil {
+		return platform{}, err
+	}
+	return platform{
+		base:    merged["base"],
+		portal:  merged["portal"],
+		hrd:     merged["hrd"],
+		okta:    merged["okta"],
+		blob:    merged["blob"],
+		diaries: new(diaryCache),
+	}, nil
+}
+
+// checkResponse returns an error of the kind given by the status of resp, if
+// it reports a failure. In particular, it returns site.ErrExpired if resp shows
+// that the user's Daymap session has expired: Daymap then either responds with
+// status 401 or redirects to a login page, its own or that of the identity
+// provider.
+func (p platform) checkResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return errors.Raise(site.ErrExpired)
+	case resp.StatusCode == http.StatusNotFound:
+		return errors.Raise(site.ErrNotFound)
+	case resp.StatusCode >= 500:
+		return errors.New(errors.Raise(site.ErrUnavailable), "daymap returned status %d", resp.StatusCode)
+	}
+	base, err := url.Parse(p.base)
+	if err != nil {
+		return errors.New(err, "invalid Daymap URL")
+	}
+	u := resp.Request.URL
+	if !strings.EqualFold(u.Host, base.Host) || strings.Contains(strings.ToLower(u.Path), "login") {
+		return errors.Raise(site.ErrExpired)
+	}
+	return nil
+}
+
+func init() {
+	p, err := newPlatform(nil)
+	if err != nil {
+		panic(err)
+	}
+	site.Register(p)
+}
+
+func (platform) Name() string {
+	return "daymap"
+}
+
+func (platform) Configure(urls map[string]string) (site.Platform, error) {
+	p, err := newPlatform(urls)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p platform) Lessons(ctx context.Context, user site.User, c chan site.Pair[[]site.Lesson, error], start, end time.Time) {
+	lessons, err := p.lessons(ctx, user, start, end)
+	c <- site.Pair[[]site.Lesson, error]{First: lessons, Second: err}
+}
+
+func (p platform) Reports(ctx context.Context, user site.User, c chan site.Pair[[]site.Report, error]) {
+	reports, err := p.reports(ctx, user)
+	c <- site.Pair[[]site.Report, error]{First: reports, Second: err}
+}
@@ -0,0 +1,353 @@
+package daymap
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"main/site"
+	"main/site/replay"
+)
+
+// The tests below exercise each platform method against the recorded
+// exchanges in testdata/replay. Run them with -record, and with the
+// credentials of a Daymap user in the environment (see replay.Session.User),
+// to record them afresh; only the success of each method is checked then.
+
+// start starts a session using the named fixture in testdata/replay. It
+// returns the session, a platform configured with the URLs of the session and
+// a user of the platform, logged in only if recording.
+func start(t *testing.T, name string) (*replay.Session, platform, site.User) {
+	t.Helper()
+	s := replay.Start(t, filepath.Join("testdata", "replay", name+".json"), defaultURLs)
+	p, err := newPlatform(s.URLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := s.User("daymap")
+	user.Timezone = testUser.Timezone
+	if s.Recording() && name != "auth" {
+		c := make(chan site.Pair[[2]string, error])
+		go p.Auth(context.Background(), user, c)
+		result := <-c
+		if result.Second != nil {
+			t.Fatal(result.Second)
+		}
+		user.SiteTokens["daymap"] = result.First[1]
+	} else {
+		user.SiteTokens["daymap"] = "ASP.NET_SessionId=sessionid-1; .AspNet.Cookies=cookie-1"
+	}
+	return s, p, user
+}
+
+// received returns the body of the last request received by the session's
+// server with the given method and URL.
+func received(t *testing.T, s *replay.Session, method, link string) string {
+	t.Helper()
+	requests := s.Server.Requests()
+	for i := len(requests) - 1; i >= 0; i-- {
+		if requests[i].Method == method && requests[i].URL == link {
+			return string(requests[i].Body)
+		}
+	}
+	t.Fatalf("no %s request to %s", method, link)
+	return ""
+}
+
+func TestAuth(t *testing.T) {
+	s, p, user := start(t, "auth")
+	c := make(chan site.Pair[[2]string, error])
+	go p.Auth(context.Background(), user, c)
+	result := <-c
+	if result.Second != nil {
+		t.Fatal(result.Second)
+	}
+	if s.Recording() {
+		return
+	}
+	if result.First[0] != "daymap" {
+		t.Errorf("token is for %q, want daymap", result.First[0])
+	}
+	for _, cookie := range []string{"ASP.NET_SessionId=sessionid-1", ".AspNet.Cookies=cookie-1"} {
+		if !strings.Contains(result.First[1], cookie) {
+			t.Errorf("token %q does not contain %s", result.First[1], cookie)
+		}
+	}
+	body := received(t, s, "POST", "{{okta}}/login/sessionCookieRedirect")
+	if !strings.Contains(body, "token=sessionToken-1") {
+		t.Errorf("session token not sent: %s", body)
+	}
+	if unused := s.Server.Unused(); len(unused) != 0 {
+		t.Errorf("%d exchanges not replayed, first %s %s", len(unused), unused[0].Method, unused[0].URL)
+	}
+}
+
+func TestTasks(t *testing.T) {
+	s, p, user := start(t, "tasks")
+	classes := []site.Class{{Name: "English 11A"}, {Name: "Physics"}}
+	c := make(chan site.Pair[[]site.Task, error])
+	go p.Tasks(context.Background(), user, c, classes)
+	result := <-c
+	if result.Second != nil {
+		t.Fatal(result.Second)
+	}
+	if s.Recording() {
+		return
+	}
+	if len(result.First) != 2 {
+		t.Fatalf("got %d tasks, want 2", len(result.First))
+	}
+	task := result.First[0]
+	if task.Id != "1234" || task.Class != "English 11A" {
+		t.Errorf("first task is %s of %s, want 1234 of English 11A", task.Id, task.Class)
+	}
+	if want := s.URLs["base"] + "/daymap/student/assignment.aspx?TaskID=1234"; task.Link != want {
+		t.Errorf("task link is %s, want %s", task.Link, want)
+	}
+	body := received(t, s, "POST", "{{base}}/daymap/student/assignments.aspx?View=0")
+	if !strings.Contains(body, "__VIEWSTATE=") {
+		t.Errorf("page state not sent: %s", body)
+	}
+}
+
+func TestTask(t *testing.T) {
+	s, p, user := start(t, "task")
+	task, err := p.Task(context.Background(), user, "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Recording() {
+		return
+	}
+	if want := "Essay: The Visit & its characters"; task.Name != want {
+		t.Errorf("task name is %q, want %q", task.Name, want)
+	}
+	if len(task.WorkLinks) != 2 {
+		t.Fatalf("got %d work links, want 2", len(task.WorkLinks))
+	}
+	if !strings.HasPrefix(task.WorkLinks[0][0], s.URLs["base"]+"/") {
+		t.Errorf("work link %s is not of the configured Daymap", task.WorkLinks[0][0])
+	}
+}
+
+func TestResources(t *testing.T) {
+	s, p, user := start(t, "resources")
+	classes := []site.Class{{Name: "English 11A", Id: "4321"}}
+	c := make(chan site.Pair[[]site.Resource, error])
+	go p.Resources(context.Background(), user, c, classes)
+	result := <-c
+	if result.Second != nil {
+		t.Fatal(result.Second)
+	}
+	if s.Recording() {
+		return
+	}
+	if len(result.First) != 4 {
+		t.Fatalf("got %d resources, want 4", len(result.First))
+	}
+	if want := "Week 1: Introduction to The Visit"; result.First[0].Name != want {
+		t.Errorf("first resource is %q, want %q", result.First[0].Name, want)
+	}
+}
+
+func TestLessons(t *testing.T) {
+	s, p, user := start(t, "lessons")
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, user.Timezone)
+	c := make(chan site.Pair[[]site.Lesson, error])
+	go p.Lessons(context.Background(), user, c, start, start.AddDate(0, 0, 4))
+	result := <-c
+	if result.Second != nil {
+		t.Fatal(result.Second)
+	}
+	if s.Recording() {
+		return
+	}
+	if len(result.First) != 2 {
+		t.Fatalf("got %d lessons, want 2", len(result.First))
+	}
+	lesson := result.First[0]
+	if lesson.Class != "English 11A" || lesson.Room != "2B12" {
+		t.Errorf("first lesson is %s in %s, want English 11A in 2B12", lesson.Class, lesson.Room)
+	}
+	if want := start.Add(8*time.Hour + 45*time.Minute); !lesson.Start.Equal(want) {
+		t.Errorf("first lesson starts at %s, want %s", lesson.Start, want)
+	}
+	if want := "Room change"; result.First[1].Notice != want {
+		t.Errorf("second lesson has notice %q, want %q", result.First[1].Notice, want)
+	}
+
+	// The events of the same days are taken from the same diary entries.
+	ec := make(chan site.Pair[[]site.Event, error])
+	go p.Events(context.Background(), user, ec, start, start.AddDate(0, 0, 5))
+	events := <-ec
+	if events.Second != nil {
+		t.Fatal(events.Second)
+	}
+	if len(events.First) != 1 || events.First[0].Name != "Assembly" {
+		t.Errorf("got events %v, want Assembly", events.First)
+	}
+	if n := len(s.Server.Requests()); n != 1 {
+		t.Errorf("diary was requested %d times, want once", n)
+	}
+}
+
+func TestDiaryShared(t *testing.T) {
+	s, p, user := start(t, "diary")
+	week := time.Date(2024, 3, 4, 0, 0, 0, 0, user.Timezone)
+	_, err := p.diary(context.Background(), user, week, week.AddDate(0, 0, 4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries of part of the week are taken from those of the whole week,
+	// including those which started before it.
+	entries, err := p.diary(context.Background(), user, week.AddDate(0, 0, 2), week.AddDate(0, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Recording() {
+		return
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Title)
+	}
+	if want := []string{"Camp", "Physics 3S04"}; !slices.Equal(got, want) {
+		t.Errorf("got entries %q, want %q", got, want)
+	}
+	if n := len(s.Server.Requests()); n != 1 {
+		t.Errorf("diary was requested %d times, want once", n)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	s, p, user := start(t, "messages")
+	c := make(chan site.Pair[[]site.Message, error])
+	go p.Messages(context.Background(), user, c)
+	result := <-c
+	if !site.Usable(result.Second) {
+		t.Fatal(result.Second)
+	}
+	if s.Recording() {
+		return
+	}
+	// The message which could not be fetched is left out, and the inbox is
+	// reported as incomplete.
+	if failed, ok := site.Partial(result.Second); !ok || !slices.Equal(failed.Platforms(), []string{"daymap"}) {
+		t.Errorf("got error %v, want daymap reported as failed", result.Second)
+	}
+	var got []string
+	for _, msg := range result.First {
+		got = append(got, msg.Id+" "+msg.Subject)
+	}
+	slices.Sort(got)
+	want := []string{"101 Excursion reminder", "103 Essay feedback"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got messages %q, want %q", got, want)
+	}
+}
+
+func TestUploadWork(t *testing.T) {
+	s, p, user := start(t, "upload")
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	part, err := w.CreateFormFile("file", "essay.docx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("The Visit, by Friedrich Dürrenmatt"))
+	w.Close()
+
+	err = p.UploadWork(context.Background(), user, "1234", multipart.NewReader(&buf, w.Boundary()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Recording() {
+		return
+	}
+	for _, req := range s.Server.Requests() {
+		if req.Method == "PUT" && strings.HasSuffix(req.URL, "&comp=block&blockid=MDAw") {
+			if string(req.Body) != "The Visit, by Friedrich Dürrenmatt" {
+				t.Errorf("uploaded %q", req.Body)
+			}
+		}
+	}
+	body := received(t, s, "POST", "{{base}}/daymap/dws/uploadazure.ashx?cmd=UploadSuccess&taskId=1234")
+	if !strings.Contains(body, "name=essay.docx") {
+		t.Errorf("file name not sent: %s", body)
+	}
+	if unused := s.Server.Unused(); len(unused) != 0 {
+		t.Errorf("%d exchanges not replayed, first %s %s", len(unused), unused[0].Method, unused[0].URL)
+	}
+}
+
+func TestRemoveWork(t *testing.T) {
+	s, p, user := start(t, "remove")
+	err := p.RemoveWork(context.Background(), user, "1234", []string{"essay-draft.docx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Recording() {
+		return
+	}
+	body := received(t, s, "POST", "{{base}}/daymap/student/attachments.aspx?Type=1&LinkID=1234")
+	for _, field := range []string{"chk91011=del", "Cmd=delete"} {
+		if !strings.Contains(body, field) {
+			t.Errorf("%s not sent: %s", field, body)
+		}
+	}
+	if strings.Contains(body, "chk91012") {
+		t.Errorf("other file removed: %s", body)
+	}
+}
+
+func TestExpired(t *testing.T) {
+	if replay.Recording() {
+		t.Skip("expired sessions are not recorded")
+	}
+	_, p, user := start(t, "expired")
+	_, err := p.Task(context.Background(), user, "1234")
+	if !site.Expired(err) {
+		t.Errorf("error %v does not report an expired session", err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	if replay.Recording() {
+		t.Skip("failed file requests are not recorded")
+	}
+	_, p, user := start(t, "file")
+	ctx := context.Background()
+	file, err := p.File(ctx, user, "5551", "bytes=0-3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(file.Body)
+	file.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name != "rubric.pdf" || file.Range != "bytes 0-3/1024" || !file.Ranges || string(body) != "%PDF" {
+		t.Errorf("got file %q with range %q (%t) and body %q", file.Name, file.Range, file.Ranges, body)
+	}
+
+	// Missing files and unsatisfiable ranges are reported as such, so that
+	// they are passed on to the client.
+	_, err = p.File(ctx, user, "9999", "")
+	if kind := site.Kind(err); kind != site.ErrNotFound {
+		t.Errorf("missing file: got kind %v of %v, want %v", kind, err, site.ErrNotFound)
+	}
+	_, err = p.File(ctx, user, "5551", "bytes=2048-")
+	if kind := site.Kind(err); kind != site.ErrRange {
+		t.Errorf("unsatisfiable range: got kind %v of %v, want %v", kind, err, site.ErrRange)
+	}
+	if rangeErr, ok := site.OutOfRange(err); !ok || rangeErr.Size != 1024 {
+		t.Errorf("unsatisfiable range: got %v, want file length 1024", err)
+	}
+}
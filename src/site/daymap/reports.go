@@ -0,0 +1,119 @@
+package daymap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/site"
+)
+
+// A report card as listed in the Daymap portfolio.
+type reportJson struct {
+	ID       int
+	Title    string
+	Released string
+	Results  []struct {
+		Subject string
+		Grade   string
+		Mark    string
+	}
+}
+
+// markScore converts a Daymap mark of the form "17/20" into a percentage. An
+// empty mark has a score of zero.
+func markScore(mark string) (float64, error) {
+	marks := strings.Split(strings.TrimSpace(mark), "/")
+	if len(marks) != 2 {
+		return 0, nil
+	}
+	top, err := strconv.ParseFloat(marks[0], 64)
+	if err != nil {
+		return 0, errors.New(err, `cannot convert "%s" to float64`, marks[0])
+	}
+	bottom, err := strconv.ParseFloat(marks[1], 64)
+	if err != nil {
+		return 0, errors.New(err, `cannot convert "%s" to float64`, marks[1])
+	}
+	if bottom == 0 {
+		return 0, nil
+	}
+	return top / bottom * 100, nil
+}
+
+// reports retrieves the report cards released to the user in their Daymap
+// portfolio.
+func (p platform) reports(ctx context.Context, user site.User) ([]site.Report, error) {
+	client := &http.Client{Timeout: site.RequestTimeout}
+	link := p.base + "/daymap/student/portfolio.aspx/ReportList"
+	referrer := p.base + "/daymap/student/portfolio.aspx?tab=Reports"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", link, strings.NewReader("{}"))
+	if err != nil {
+		return nil, errors.New(err, "cannot create reports request")
+	}
+
+	req.Header.Set("Accept", "application/json, text/javascript, */*; q=0.01")
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Cookie", user.SiteTokens["daymap"])
+	req.Header.Set("Origin", p.base)
+	req.Header.Set("Referer", referrer)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.New(err, "cannot execute reports request")
+	}
+	defer resp.Body.Close()
+
+	err = p.checkResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New(err, "cannot read reports response body")
+	}
+
+	var wrapped resJson
+	err = json.Unmarshal(body, &wrapped)
+	if err != nil {
+		return nil, errors.New(site.Fail(site.ErrParse, err), "cannot decode reports response")
+	}
+	var fetched []reportJson
+	err = json.Unmarshal([]byte(wrapped.D), &fetched)
+	if err != nil {
+		return nil, errors.New(site.Fail(site.ErrParse, err), "cannot decode reports JSON")
+	}
+
+	var reports []site.Report
+	for _, r := range fetched {
+		report := site.Report{
+			Name:     strings.TrimSpace(r.Title),
+			Platform: "daymap",
+			Id:       strconv.Itoa(r.ID),
+		}
+		report.Released, err = diaryTime(r.Released, user.Timezone)
+		if err != nil {
+			return nil, errors.New(site.Fail(site.ErrParse, err), "invalid reports JSON")
+		}
+		for _, result := range r.Results {
+			score, err := markScore(result.Mark)
+			if err != nil {
+				return nil, errors.New(site.Fail(site.ErrParse, err), "invalid reports JSON")
+			}
+			report.Grades = append(report.Grades, site.Grade{
+				Class: strings.TrimSpace(result.Subject),
+				Grade: strings.TrimSpace(result.Grade),
+				Score: score,
+			})
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
@@ -1,7 +1,7 @@
 package daymap
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"net/http"
 	"strings"
@@ -12,14 +12,14 @@ import (
 	"main/site"
 )
 
-func fileRes(user site.User, id string, class site.Class) (site.Resource, error) {
+func (p platform) fileRes(ctx context.Context, user site.User, id string, class site.Class) (site.Resource, error) {
 	ch := make(chan site.Pair[[]site.Resource, error])
 	resource := site.Resource{
-		Link:     "https://gihs.daymap.net/daymap/attachment.ashx?ID=" + id,
+		Link:     p.base + "/daymap/attachment.ashx?ID=" + id,
 		Platform: "daymap",
 		Id:       class.Id + "-f" + id,
 	}
-	go classRes(user, ch, class)
+	go p.classRes(ctx, user, ch, class)
 	sent := <-ch
 	resources, err := sent.First, sent.Second
 	if err != nil {
@@ -37,16 +37,16 @@ func fileRes(user site.User, id string, class site.Class) (site.Resource, error)
 	return resource, nil
 }
 
-func planRes(user site.User, id string, class site.Class) (site.Resource, error) {
+func (p platform) planRes(ctx context.Context, user site.User, id string, class site.Class) (site.Resource, error) {
 	ch := make(chan site.Pair[[]site.Resource, error])
 	resource := site.Resource{
-		Link:     "https://gihs.daymap.net/DayMap/curriculum/plan.aspx?id=" + id,
+		Link:     p.base + "/DayMap/curriculum/plan.aspx?id=" + id,
 		Platform: "daymap",
 		Id:       class.Id + "-" + id,
 	}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", resource.Link, nil)
+	client := &http.Client{Timeout: site.RequestTimeout}
+	req, err := http.NewRequestWithContext(ctx, "GET", resource.Link, nil)
 	if err != nil {
 		return site.Resource{}, errors.New(err, "cannot create resource request")
 	}
@@ -58,83 +58,22 @@ func planRes(user site.User, id string, class site.Class) (site.Resource, error)
 		return site.Resource{}, errors.New(err, "cannot execute resource request")
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	err = p.checkResponse(resp)
 	if err != nil {
-		return site.Resource{}, errors.New(err, "cannot read resource response body")
-	}
-
-	page := string(body)
-	nameDiv := `<div id="ctl00_cp_divPlan"><div><h3>`
-	i := strings.Index(page, nameDiv)
-
-	if i == -1 {
-		return site.Resource{}, errors.New(nil, "invalid HTML response")
-	}
-
-	i += len(nameDiv)
-	page = page[i:]
-	fileDiv := `</h3></div><br>`
-	i = strings.Index(page, fileDiv)
-
-	if i == -1 {
-		return site.Resource{}, errors.New(nil, "invalid HTML response")
-	}
-
-	resource.Name = page[:i]
-	i += len(fileDiv)
-	page = page[i:]
-	descDiv := fmt.Sprintf(`<div  ><div class="lpAll" id="Note%s">`, id)
-	i = strings.Index(page, descDiv)
-
-	if i == -1 {
-		return site.Resource{}, errors.New(nil, "invalid HTML response")
+		return site.Resource{}, err
 	}
 
-	fileSect := page[:i]
-	i += len(descDiv)
-	page = page[i:]
-
-	for i = strings.Index(fileSect, "DMU.OpenAttachment("); i != -1; {
-		i += len("DMU.OpenAttachment(")
-		fileSect = fileSect[i:]
-		i = strings.Index(fileSect, ");")
-
-		if i == -1 {
-			return site.Resource{}, errors.New(nil, "invalid HTML response")
-		}
-
-		rlLink := "https://gihs.daymap.net/daymap/attachment.ashx?ID=" + fileSect[:i]
-		fileSect = fileSect[i:]
-		i = strings.Index(fileSect, "&nbsp;")
-
-		if i == -1 {
-			return site.Resource{}, errors.New(nil, "invalid HTML response")
-		}
-
-		i += len("&nbsp;")
-		fileSect = fileSect[i:]
-		i = strings.Index(fileSect, "</a>")
-
-		if i == -1 {
-			return site.Resource{}, errors.New(nil, "invalid HTML response")
-		}
-
-		rlName := fileSect[:i]
-		fileSect = fileSect[i:]
-		resource.ResLinks = append(resource.ResLinks, [2]string{rlLink, rlName})
-
-		i = strings.Index(fileSect, "DMU.OpenAttachment(")
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return site.Resource{}, errors.New(err, "cannot read resource response body")
 	}
 
-	endDiv := "</div></div></div>\r\n" + ` <div style="margin: 25px 0px; width:25%;">`
-	endDiv += "\r\n    \r\n </div>\r\n\r\n    </form>\r\n\r\n    <script>\r\n"
-	i = strings.Index(page, endDiv)
-	if i == -1 {
-		return site.Resource{}, errors.New(nil, "invalid HTML response")
+	err = p.parsePlan(string(body), id, &resource)
+	if err != nil {
+		return site.Resource{}, errors.New(site.Fail(site.ErrParse, err), "invalid HTML response")
 	}
 
-	resource.Desc = page[:i]
-	go classRes(user, ch, class)
+	go p.classRes(ctx, user, ch, class)
 	sent := <-ch
 	resources, err := sent.First, sent.Second
 	if err != nil {
@@ -152,7 +91,41 @@ func planRes(user site.User, id string, class site.Class) (site.Resource, error)
 	return resource, nil
 }
 
-func Resource(user site.User, id string) (site.Resource, error) {
+// parsePlan parses the Daymap page of the lesson plan with the given id into
+// resource.
+func (p platform) parsePlan(page, id string, resource *site.Resource) error {
+	doc, err := parsePage(page)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	plan, err := need(doc, "#ctl00_cp_divPlan")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	name, err := need(plan, "h3")
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	resource.Name = text(name)
+	note, err := need(plan, "#Note"+id)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	resource.Desc = innerHTML(note)
+
+	// Attachments are listed between the name and the plan's note.
+	for _, a := range findAll(plan, "a") {
+		rlId, ok := attachmentId(a)
+		if !ok || contains(note, a) {
+			continue
+		}
+		link := p.base + "/daymap/attachment.ashx?ID=" + rlId
+		resource.ResLinks = append(resource.ResLinks, [2]string{link, text(a)})
+	}
+	return nil
+}
+
+func (p platform) Resource(ctx context.Context, user site.User, id string) (site.Resource, error) {
 	var res site.Resource
 	var err error
 	ids := strings.Split(id, "-")
@@ -161,17 +134,17 @@ func Resource(user site.User, id string) (site.Resource, error) {
 	}
 	class.Id, err = slices.Get(ids, 0)
 	if err != nil {
-		return site.Resource{}, errors.New(err, "invalid resource ID")
+		return site.Resource{}, errors.New(site.Fail(site.ErrNotFound, err), "invalid resource ID")
 	}
-	class.Link = "https://gihs.daymap.net/daymap/student/plans/class.aspx?id=" + class.Id
+	class.Link = p.base + "/daymap/student/plans/class.aspx?id=" + class.Id
 	resId, err := slices.Get(ids, 1)
 	if err != nil {
-		return site.Resource{}, errors.New(err, "invalid resource ID")
+		return site.Resource{}, errors.New(site.Fail(site.ErrNotFound, err), "invalid resource ID")
 	}
 	if strings.HasPrefix(resId, "f") {
-		res, err = fileRes(user, resId[1:], class)
+		res, err = p.fileRes(ctx, user, resId[1:], class)
 	} else {
-		res, err = planRes(user, resId, class)
+		res, err = p.planRes(ctx, user, resId, class)
 	}
 	return res, err
 }
@@ -1,6 +1,7 @@
 package daymap
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,8 +10,8 @@ import (
 	"strings"
 	"time"
 
+	htm "git.sr.ht/~kvo/go-format/html"
 	"git.sr.ht/~kvo/go-std/errors"
-	"git.sr.ht/~kvo/go-std/slices"
 
 	"main/site"
 )
@@ -19,27 +20,10 @@ type resJson struct {
 	D string
 }
 
-// Returns the index of the next school resource type, as well as a corresponding planDiv/fileDiv/linkDiv.
-func nextRes(buf, planDiv, fileDiv, linkDiv string) (int, string) {
-	planIdx := strings.Index(buf, planDiv)
-	fileIdx := strings.Index(buf, fileDiv)
-	linkIdx := strings.Index(buf, linkDiv)
-
-	isFile := (fileIdx != -1) && (fileIdx < planIdx || planIdx == -1)
-
-	if planIdx == -1 && fileIdx == -1 && linkIdx == -1 {
-		return -1, ""
-	} else if isFile {
-		return fileIdx, fileDiv
-	} else {
-		return planIdx, planDiv
-	}
-}
-
 // Return class name and secondary "courseId" from specified link to Daymap class page.
-func auxClassInfo(user site.User, link string) (string, string, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", link, nil)
+func (p platform) auxClassInfo(ctx context.Context, user site.User, link string) (string, string, error) {
+	client := &http.Client{Timeout: site.RequestTimeout}
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create aux class request")
 	}
@@ -50,45 +34,53 @@ func auxClassInfo(user site.User, link string) (string, string, error) {
 	if err != nil {
 		return "", "", errors.New(err, "cannot execute aux class request")
 	}
+	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	err = p.checkResponse(resp)
 	if err != nil {
-		return "", "", errors.New(err, "cannot read aux class response body")
+		return "", "", err
 	}
 
-	page := string(body)
-	exp, err := regexp.Compile(`new Classroom\([0-9]+,null,[0-9]+,`)
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", "", errors.New(err, "cannot compile regex")
+		return "", "", errors.New(err, "cannot read aux class response body")
 	}
-	courseId, err := slices.Get(strings.Split(exp.FindString(page), ","), 2)
+
+	name, id, err := parseClassInfo(string(body))
 	if err != nil {
-		return "", "", errors.New(err, "missing secondary course ID")
+		return "", "", errors.New(err, "invalid HTML response")
 	}
+	return name, id, nil
+}
 
-	classDiv := `<td><span id="ctl00_ctl00_cp_cp_divHeader" class="Header14" style="padding-left: 20px">`
-	i := strings.Index(page, classDiv)
-	if i == -1 {
-		return "", "", errors.New(nil, "missing class name")
+// classroomPattern matches the script which sets up a Daymap class page,
+// capturing the secondary course ID.
+var classroomPattern = regexp.MustCompile(`new Classroom\([0-9]+,null,([0-9]+),`)
+
+// parseClassInfo parses a Daymap class page, returning the class name and
+// secondary course ID.
+func parseClassInfo(page string) (string, string, error) {
+	m := classroomPattern.FindStringSubmatch(page)
+	if m == nil {
+		return "", "", errors.New(errors.Raise(site.ErrParse), "missing secondary course ID")
+	}
+	doc, err := parsePage(page)
+	if err != nil {
+		return "", "", site.Fail(site.ErrParse, err)
 	}
-	i += len(classDiv)
-	page = page[i:]
-	i = strings.Index(page, "</span>")
-	if i == -1 {
-		return "", "", errors.New(nil, "unterminated class name")
+	header, err := need(doc, "#ctl00_ctl00_cp_cp_divHeader")
+	if err != nil {
+		return "", "", errors.New(site.Fail(site.ErrParse, err), "missing class name")
 	}
-	class := page[:i]
-
-	return class, courseId, nil
+	return text(header), m[1], nil
 }
 
-func classRes(user site.User, c chan site.Pair[[]site.Resource, error], class site.Class) {
+func (p platform) classRes(ctx context.Context, user site.User, c chan site.Pair[[]site.Resource, error], class site.Class) {
 	var result site.Pair[[]site.Resource, error]
-	resUrl := "https://gihs.daymap.net/daymap/student/plans/class.aspx/InitialiseResources"
-	classUrl := "https://gihs.daymap.net/daymap/student/plans/class.aspx?id=" + class.Id
-	var resources []site.Resource
+	resUrl := p.base + "/daymap/student/plans/class.aspx/InitialiseResources"
+	classUrl := p.base + "/daymap/student/plans/class.aspx?id=" + class.Id
 
-	className, courseId, err := auxClassInfo(user, classUrl)
+	className, courseId, err := p.auxClassInfo(ctx, user, classUrl)
 	if err != nil {
 		result.Second = errors.New(err, "cannot fetch secondary class ID")
 		c <- result
@@ -96,9 +88,9 @@ func classRes(user site.User, c chan site.Pair[[]site.Resource, error], class si
 	}
 
 	form := fmt.Sprintf(`{"classId":%s,"courseId":%s}`, class.Id, courseId)
-	client := &http.Client{}
+	client := &http.Client{Timeout: site.RequestTimeout}
 
-	req, err := http.NewRequest("POST", resUrl, strings.NewReader(form))
+	req, err := http.NewRequestWithContext(ctx, "POST", resUrl, strings.NewReader(form))
 	if err != nil {
 		result.Second = errors.New(err, "cannot create resources request")
 		c <- result
@@ -117,146 +109,114 @@ func classRes(user site.User, c chan site.Pair[[]site.Resource, error], class si
 		return
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	err = p.checkResponse(resp)
 	if err != nil {
-		result.Second = errors.New(err, "cannot read resources response body")
+		result.Second = err
 		c <- result
 		return
 	}
 
-	exp, err := regexp.Compile("[0-9]+/[0-9]+/[0-9]+")
+	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		result.Second = errors.New(err, "cannot compile regex")
+		result.Second = errors.New(err, "cannot read resources response body")
 		c <- result
 		return
 	}
 
+	result.First, result.Second = p.parseClassRes(body, class, className, user)
+	if result.Second != nil {
+		result.Second = errors.New(result.Second, "invalid HTML response")
+	}
+	c <- result
+}
+
+// datePattern matches the dates on which resources are posted.
+var datePattern = regexp.MustCompile("[0-9]+/[0-9]+/[0-9]+")
+
+// planPattern matches the calls which open Daymap lesson plans, capturing the
+// plan ID.
+var planPattern = regexp.MustCompile(`DMU\.ViewPlan\(([0-9]+)\)`)
+
+// parseClassRes parses the resources of the given class, which is named
+// className, from the response to a Daymap InitialiseResources request. This is
+// a table of lesson plans and files, each posted on the last date preceding it;
+// resources without any such date are skipped.
+func (p platform) parseClassRes(body []byte, class site.Class, className string, user site.User) ([]site.Resource, error) {
 	var data resJson
-	err = json.Unmarshal(body, &data)
+	err := json.Unmarshal(body, &data)
 	if err != nil {
-		result.Second = errors.New(err, "cannot unmarshal JSON")
-		c <- result
-		return
+		return nil, errors.New(site.Fail(site.ErrParse, err), "cannot unmarshal JSON")
+	}
+	root, err := parseFragment(data.D)
+	if err != nil {
+		return nil, site.Fail(site.ErrParse, err)
 	}
 
-	page := data.D
-	planDiv := `</td><td class='active itm' onclick="DMU.ViewPlan(`
-	fileDiv := `<div class='fLinkDiv'><a href='#' onclick="DMU.OpenAttachment(`
-	linkDiv := `<a href='javascript:DMU.OpenNewWindow("`
-	i, div := nextRes(page, planDiv, fileDiv, linkDiv)
-
-	for i != -1 {
+	plan := compile(`td[onclick*="DMU.ViewPlan("]`)
+	file := compile(`.fLinkDiv a`)
+	var resources []site.Resource
+	var posted time.Time
+	walk(root, func(n *htm.Node) bool {
+		if n.Type == htm.TextNode {
+			dates := datePattern.FindAllString(n.Data, -1)
+			if len(dates) > 0 {
+				posted, err = time.ParseInLocation("2/01/2006", dates[len(dates)-1], user.Timezone)
+				if err != nil {
+					err = errors.New(err, "cannot parse time")
+					return false
+				}
+			}
+			return true
+		}
 		resource := site.Resource{
 			Class:    className,
+			Posted:   posted,
 			Platform: "daymap",
 		}
-		dateRegion := page[:i]
-		page = page[i:]
-		dates := exp.FindAllString(dateRegion, -1)
-
-		if len(dates) == 0 && strings.Index(page, planDiv) == -1 && strings.Index(page, fileDiv) == -1 {
-			result.Second = errors.New(nil, "resource has no post date")
-			c <- result
-			return
-		} else if len(dates) > 0 {
-			postStr := dates[len(dates)-1]
-			resource.Posted, err = time.ParseInLocation("2/01/2006", postStr, user.Timezone)
-			if err != nil {
-				result.Second = errors.New(err, "cannot parse time")
-				c <- result
-				return
-			}
-		} else {
-			if _, err = slices.Get([]byte(page), len(div)); err != nil {
-				result.Second = errors.New(err, "invalid HTML response")
-				c <- result
-				return
-			}
-			page = page[len(div):]
-			i, div = nextRes(page, planDiv, fileDiv, linkDiv)
-			continue
-		}
-
-		i = len(div)
-		if _, err = slices.Get([]byte(page), i); err != nil {
-			result.Second = errors.New(err, "invalid HTML response")
-			c <- result
-			return
-		}
-		page = page[i:]
-
-		i = strings.Index(page, ");")
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-
-		resource.Id = page[:i]
-
-		if div == fileDiv {
-			i = strings.Index(page, "&nbsp;")
-			if i == -1 {
-				result.Second = errors.New(nil, "invalid HTML response")
-				c <- result
-				return
+		switch {
+		case plan.match(n, root):
+			m := planPattern.FindStringSubmatch(attr(n, "onclick"))
+			if m == nil {
+				err = errors.New(nil, "invalid lesson plan link %q", attr(n, "onclick"))
+				return false
 			}
-
-			i += len("&nbsp;")
-			page = page[i:]
-			i = strings.Index(page, "</a>")
-			if i == -1 {
-				result.Second = errors.New(nil, "invalid HTML response")
-				c <- result
-				return
+			var title *htm.Node
+			title, err = need(n, ".lpTitle")
+			if err != nil {
+				err = errors.New(err, "invalid lesson plan %s", m[1])
+				return false
 			}
-		} else {
-			i += len(`);;"><div class='lpTitle'>`)
-			page = page[i:]
-			i = strings.Index(page, "</div>")
-			if i == -1 {
-				result.Second = errors.New(nil, "invalid HTML response")
-				c <- result
-				return
+			resource.Name = text(title)
+			resource.Link = p.base + "/DayMap/curriculum/plan.aspx?id=" + m[1]
+			resource.Id = class.Id + "-" + m[1]
+		case file.match(n, root):
+			id, ok := attachmentId(n)
+			if !ok {
+				return true
 			}
+			resource.Name = text(n)
+			resource.Link = p.base + "/daymap/attachment.ashx?ID=" + id
+			resource.Id = class.Id + "-f" + id
+		default:
+			return true
 		}
-
-		if _, err = slices.Get([]byte(page), i); err != nil {
-			result.Second = errors.New(err, "invalid HTML response")
-			c <- result
-			return
-		}
-		resource.Name = page[:i]
-
-		if div == fileDiv {
-			resource.Link = "https://gihs.daymap.net/daymap/attachment.ashx?ID=" + resource.Id
-			resource.Id = "f" + resource.Id
-		} else {
-			resource.Link = "https://gihs.daymap.net/DayMap/curriculum/plan.aspx?id=" + resource.Id
+		if !posted.IsZero() {
+			resources = append(resources, resource)
 		}
-
-		resource.Id = class.Id + "-" + resource.Id
-		resources = append(resources, resource)
-
-		if _, err = slices.Get([]byte(page), i); err != nil {
-			result.Second = errors.New(err, "invalid HTML response")
-			c <- result
-			return
-		}
-		page = page[i:]
-		i, div = nextRes(page, planDiv, fileDiv, linkDiv)
+		return true
+	})
+	if err != nil {
+		return nil, site.Fail(site.ErrParse, err)
 	}
-	result.First = resources
-	c <- result
+	return resources, nil
 }
 
-func Resources(user site.User, c chan site.Pair[[]site.Resource, error], classes []site.Class) {
+func (p platform) Resources(ctx context.Context, user site.User, c chan site.Pair[[]site.Resource, error], classes []site.Class) {
 	var result site.Pair[[]site.Resource, error]
 	var resources []site.Resource
 	ch := make(chan site.Pair[[]site.Resource, error])
 	for _, class := range classes {
-		go classRes(user, ch, class)
+		go p.classRes(ctx, user, ch, class)
 	}
 	for range classes {
 		sent := <-ch
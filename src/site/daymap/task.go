@@ -2,37 +2,46 @@ package daymap
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"html"
 	"io"
 	"math/rand"
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
+	htm "git.sr.ht/~kvo/go-format/html"
 	"git.sr.ht/~kvo/go-std/errors"
 	"git.sr.ht/~kvo/go-std/slices"
 
 	"main/site"
 )
 
-func Task(user site.User, id string) (site.Task, error) {
-	taskUrl := "https://gihs.daymap.net/daymap/student/assignment.aspx?TaskID=" + id
+// attachmentPattern matches the calls which open Daymap attachments, capturing
+// the attachment ID.
+var attachmentPattern = regexp.MustCompile(`DMU\.OpenAttachment\(([0-9]+)\)`)
 
-	task := site.Task{
-		Link:     taskUrl,
-		Platform: "daymap",
-		Id:       id,
+// attachmentId returns the ID of the attachment opened by the link a.
+func attachmentId(a *htm.Node) (string, bool) {
+	for _, key := range []string{"onclick", "href"} {
+		if m := attachmentPattern.FindStringSubmatch(attr(a, key)); m != nil {
+			return m[1], true
+		}
 	}
+	return "", false
+}
 
-	client := &http.Client{}
+func (p platform) Task(ctx context.Context, user site.User, id string) (site.Task, error) {
+	taskUrl := p.base + "/daymap/student/assignment.aspx?TaskID=" + id
+	client := &http.Client{Timeout: site.RequestTimeout}
 
-	req, err := http.NewRequest("GET", taskUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", taskUrl, nil)
 	if err != nil {
 		return site.Task{}, errors.New(err, "cannot create task request")
 	}
@@ -44,310 +53,150 @@ func Task(user site.User, id string) (site.Task, error) {
 		return site.Task{}, errors.New(err, "cannot execute task request")
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	err = p.checkResponse(resp)
 	if err != nil {
-		return site.Task{}, errors.New(err, "cannot read task response body")
+		return site.Task{}, err
 	}
 
-	page := string(body)
-	if strings.Contains(page, "My&nbsp;Work") || strings.Contains(page, "My Work</div>") {
-		task.Upload = true
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return site.Task{}, errors.New(err, "cannot read task response body")
 	}
 
-	i := strings.Index(page, "ctl00_ctl00_cp_cp_divResults")
-	if i == -1 {
-		return site.Task{}, errors.New(nil, "invalid task HTML response")
+	task, err := p.parseTask(string(body), id, user)
+	if err != nil {
+		return site.Task{}, errors.New(site.Fail(site.ErrParse, err), "invalid task HTML response")
 	}
+	return task, nil
+}
 
-	page = page[i:]
-	i = strings.Index(page, "SectionHeader")
-
-	if i == -1 {
-		return site.Task{}, errors.New(nil, "invalid task HTML response")
+// parseTask parses the Daymap page of the task with the given id.
+func (p platform) parseTask(page, id string, user site.User) (site.Task, error) {
+	task := site.Task{
+		Link:     p.base + "/daymap/student/assignment.aspx?TaskID=" + id,
+		Platform: "daymap",
+		Id:       id,
 	}
 
-	page = page[i:]
-	i = len("SectionHeader") + 2
-	page = page[i:]
-	i = strings.Index(page, "</div>")
-
-	if i == -1 {
-		return site.Task{}, errors.New(nil, "invalid task HTML response")
+	doc, err := parsePage(page)
+	if err != nil {
+		return site.Task{}, errors.Wrap(err)
 	}
-
-	task.Name = page[:i]
-	page = page[i:]
-	i = strings.Index(page, "<div style='padding:6px'>")
-
-	if i == -1 {
-		return site.Task{}, errors.New(nil, "invalid task HTML response")
+	results, err := need(doc, "#ctl00_ctl00_cp_cp_divResults")
+	if err != nil {
+		return site.Task{}, errors.Wrap(err)
 	}
-
-	page = page[i:]
-	i = len("<div style='padding:6px'>")
-	page = page[i:]
-	i = strings.Index(page, "</div>")
-
-	if i == -1 {
-		return site.Task{}, errors.New(nil, "invalid task HTML response")
+	header, err := need(results, ".SectionHeader")
+	if err != nil {
+		return site.Task{}, errors.Wrap(err)
 	}
+	task.Name = text(header)
 
-	task.Class = page[:i]
-	page = page[i:]
-	i = strings.Index(page, "<div style='padding:6px'>")
-
-	if i == -1 {
-		return site.Task{}, errors.New(nil, "invalid task HTML response")
+	// The class, task type and due date are given in consecutive boxes.
+	var details []*htm.Node
+	for _, div := range findAll(results, "div[style]") {
+		if style(div, "padding") == "6px" {
+			details = append(details, div)
+		}
 	}
-
-	page = page[i:]
-	i = len("<div style='padding:6px'>")
-	page = page[i:]
-	i = strings.Index(page, "</div>")
-
-	if i == -1 {
-		return site.Task{}, errors.New(nil, "invalid task HTML response")
+	if len(details) == 0 {
+		return site.Task{}, errors.New(nil, "missing element div with padding 6px")
 	}
-
-	page = page[i:]
-	i = strings.Index(page, "Due on ")
-
-	if i != -1 {
-		page = page[i:]
-		i = len("Due on ")
-		page = page[i:]
-		i = strings.Index(page, "</div>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
+	task.Class = text(details[0])
+	for _, detail := range details[1:] {
+		dueStr, ok := strings.CutPrefix(text(detail), "Due on ")
+		if !ok {
+			continue
 		}
-
-		dueStr := page[:i]
-		page = page[i:]
-
 		if !strings.Contains(dueStr, ":") {
 			task.Due, err = time.ParseInLocation("2/01/2006", dueStr, user.Timezone)
 		} else {
 			task.Due, err = time.ParseInLocation("2/01/2006 3:04 PM", dueStr, user.Timezone)
 		}
-
 		if err != nil {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
+			return site.Task{}, errors.New(err, "invalid due date %q", dueStr)
 		}
+		break
 	}
 
-	i = strings.Index(page, "My Work</div>")
-
-	if i != -1 {
-		page = page[i:]
-		i = strings.Index(page, "<div><div>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
-		}
-
-		page = page[i:]
-		i = strings.Index(page, "</div></div></div></div>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
+	// Work and attachments are listed in the element following their
+	// headings.
+	if work := labelled(doc, "My Work"); work != nil {
+		task.Upload = true
+		for _, a := range findAll(nextElement(work), "a[href]") {
+			link := p.base + attr(a, "href")
+			task.WorkLinks = append(task.WorkLinks, [2]string{link, text(a)})
 		}
+	}
 
-		wlHtml := page[:i]
-		page = page[i:]
-		j := strings.Index(wlHtml, `<a href="`)
-
-		for j != -1 {
-			j += len(`<a href="`)
-			wlHtml = wlHtml[j:]
-			j = strings.Index(wlHtml, `"`)
-
-			if j == -1 {
-				return site.Task{}, errors.New(nil, "invalid task HTML response")
+	for _, grade := range findAll(results, ".TaskGrade") {
+		label := ""
+		if prev := prevElement(grade); prev != nil {
+			label = text(prev)
+		}
+		switch {
+		case strings.HasPrefix(label, "Grade:"):
+			task.Grade = text(grade)
+		case strings.HasPrefix(label, "Mark:"):
+			markStr := text(grade)
+			marks := strings.Split(markStr, "/")
+			if len(marks) != 2 {
+				return site.Task{}, errors.New(nil, "invalid mark %q", markStr)
 			}
-
-			wlPath := wlHtml[:j]
-			wlHtml = wlHtml[j:]
-			link := "https://gihs.daymap.net" + wlPath
-			j = strings.Index(wlHtml, "&nbsp;")
-
-			if j == -1 {
-				return site.Task{}, errors.New(nil, "invalid task HTML response")
+			for i := range marks {
+				marks[i] = strings.TrimSpace(marks[i])
 			}
-
-			j += len("&nbsp;")
-			wlHtml = wlHtml[j:]
-			j = strings.Index(wlHtml, "</a>")
-
-			if j == -1 {
-				return site.Task{}, errors.New(nil, "invalid task HTML response")
+			top, err := strconv.ParseFloat(marks[0], 64)
+			if err != nil {
+				return site.Task{}, errors.New(err, "cannot convert %s to float64", marks[0])
 			}
-
-			name := wlHtml[:j]
-			wlHtml = wlHtml[j:]
-			task.WorkLinks = append(task.WorkLinks, [2]string{link, name})
-			j = strings.Index(wlHtml, `<a href="`)
+			bottom, err := strconv.ParseFloat(marks[1], 64)
+			if err != nil {
+				return site.Task{}, errors.New(err, "cannot convert %s to float64", marks[1])
+			}
+			task.Score = top / bottom * 100
 		}
 	}
+	task.Graded = true
 
-	i = strings.Index(page, "Grade:")
-
-	if i != -1 {
-		i = strings.Index(page, "TaskGrade'>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
-		}
-
-		page = page[i:]
-		i = len("TaskGrade'>")
-		page = page[i:]
-		i = strings.Index(page, "</div>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
+	// The teacher's comment and the task description are both in white
+	// boxes; only that of the description is padded.
+	var desc *htm.Node
+	boxes := findAll(results, "div.WhiteBox")
+	for _, box := range boxes {
+		if style(box, "padding") == "5px" {
+			desc = box
+			break
 		}
-
-		task.Grade = page[:i]
-		page = page[i:]
 	}
-
-	i = strings.Index(page, "Mark:")
-
-	if i != -1 {
-		i = strings.Index(page, "TaskGrade'>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
+	for _, box := range boxes {
+		if box != desc && !contains(box, desc) {
+			task.Comment = site.Sanitizer{}.Text(innerHTML(box))
+			break
 		}
-
-		page = page[i:]
-		i = len("TaskGrade'>")
-		page = page[i:]
-		i = strings.Index(page, "</div>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
-		}
-
-		markStr := page[:i]
-		page = page[i:]
-
-		i := strings.Index(markStr, " / ")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
-		}
-
-		var marks [2]string
-		marks[0] = markStr[:i]
-		marks[1] = markStr[i+3:]
-
-		top, err := strconv.ParseFloat(marks[0], 64)
-		if err != nil {
-			return site.Task{}, errors.New(err, "cannot convert %s to float64", marks[0])
-		}
-
-		bottom, err := strconv.ParseFloat(marks[1], 64)
-		if err != nil {
-			return site.Task{}, errors.New(err, "cannot convert %s to float64", marks[1])
-		}
-
-		task.Score = top / bottom * 100
 	}
-
-	task.Graded = true
-	i = strings.Index(page, `class="WhiteBox">`)
-
-	if i != -1 {
-		page = page[i:]
-		i = len(`class="WhiteBox">`)
-		page = page[i:]
-		i = strings.Index(page, "</div>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
-		}
-
-		task.Comment = page[:i]
-		page = page[i:]
+	if desc != nil {
+		task.Desc = innerHTML(desc)
 	}
 
-	i = strings.Index(page, "Attachments</div>")
-
-	if i != -1 {
-		page = page[i:]
-		i = len("Attachments</div>")
-		page = page[i:]
-		i = strings.Index(page, `class='WhiteBox' style='padding:5px;margin:2px'>`)
-
-		if i == -1 {
-			i = strings.Index(page, "\n")
-		}
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
-		}
-
-		rlHtml := page[:i]
-		page = page[i:]
-		j := strings.Index(rlHtml, "DMU.OpenAttachment(")
-
-		for j != -1 {
-			j += len("DMU.OpenAttachment(")
-			rlHtml = rlHtml[j:]
-			j = strings.Index(rlHtml, ")")
-
-			if j == -1 {
-				return site.Task{}, errors.New(nil, "invalid task HTML response")
-			}
-
-			rlId := rlHtml[:j]
-			rlHtml = rlHtml[j:]
-			link := "https://gihs.daymap.net/daymap/attachment.ashx?ID=" + rlId
-			j = strings.Index(rlHtml, "&nbsp;")
-
-			if j == -1 {
-				return site.Task{}, errors.New(nil, "invalid task HTML response")
-			}
-
-			j += len("&nbsp;")
-			rlHtml = rlHtml[j:]
-			j = strings.Index(rlHtml, "</a>")
-
-			if j == -1 {
-				return site.Task{}, errors.New(nil, "invalid task HTML response")
+	if attachments := labelled(results, "Attachments"); attachments != nil {
+		for _, a := range findAll(nextElement(attachments), "a") {
+			rlId, ok := attachmentId(a)
+			if !ok || desc != nil && contains(desc, a) {
+				continue
 			}
-
-			name := rlHtml[:j]
-			rlHtml = rlHtml[j:]
-			task.ResLinks = append(task.ResLinks, [2]string{link, name})
-			j = strings.Index(rlHtml, "DMU.OpenAttachment(")
+			link := p.base + "/daymap/attachment.ashx?ID=" + rlId
+			task.ResLinks = append(task.ResLinks, [2]string{link, text(a)})
 		}
 	}
 
-	i = strings.Index(page, `class='WhiteBox' style='padding:5px;margin:2px'>`)
-
-	if i != -1 {
-		page = page[i:]
-		i = len(`class='WhiteBox' style='padding:5px;margin:2px'>`)
-		page = page[i:]
-		i = strings.Index(page, "</div>")
-
-		if i == -1 {
-			return site.Task{}, errors.New(nil, "invalid task HTML response")
-		}
-
-		task.Desc = page[:i]
-	}
-
 	// Hide submission option as Daymap has no concept of task submission.
 	task.Submitted = true
 	return task, nil
 }
 
-func Submit(user site.User, id string) error {
-	return errors.New(nil, "daymap does not support task submission")
+func (p platform) Submit(ctx context.Context, user site.User, id string) error {
+	return errors.New(errors.Raise(site.ErrUnsupported), "daymap does not support task submission")
 }
 
 type chkJson struct {
@@ -377,10 +226,10 @@ func randStr(n int) string {
 	return fmt.Sprintf("%x", randBytes)[:n]
 }
 
-func UploadWork(user site.User, id string, files *multipart.Reader) error {
-	selectUrl := "https://gihs.daymap.net/daymap/Resources/AttachmentAdd.aspx?t=2&LinkID="
+func (p platform) UploadWork(ctx context.Context, user site.User, id string, files *multipart.Reader) error {
+	selectUrl := p.base + "/daymap/Resources/AttachmentAdd.aspx?t=2&LinkID="
 	selectUrl += id
-	client := &http.Client{}
+	client := &http.Client{Timeout: site.RequestTimeout}
 
 	file, mimeErr := files.NextPart()
 	for mimeErr == nil {
@@ -390,7 +239,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 		if dotIndex != -1 {
 			fileExt = fileName[dotIndex:]
 		}
-		blobUrl := "https://glenunga.blob.core.windows.net/daymap/up/%s%s"
+		blobUrl := p.blob + "/%s%s"
 		blobId := fmt.Sprintf(
 			"%s-%s-%s-%s-%s",
 			randStr(8), randStr(4),
@@ -424,7 +273,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 
 			// Stage 1: Retrieve a DayMap upload URL.
 
-			s1url := "https://gihs.daymap.net/daymap/dws/uploadazure.ashx"
+			s1url := p.base + "/daymap/dws/uploadazure.ashx"
 			timestamp := fmt.Sprintf("%d", time.Now().In(time.UTC).UnixMilli())
 
 			s1form := url.Values{}
@@ -435,7 +284,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 			s1form.Set("qqtimestamp", timestamp)
 
 			s1url += "?" + s1form.Encode()
-			s1req, err := http.NewRequest("GET", s1url, nil)
+			s1req, err := http.NewRequestWithContext(ctx, "GET", s1url, nil)
 			if err != nil {
 				return errors.New(err, "cannot create stage 1 request")
 			}
@@ -449,6 +298,11 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 				return errors.New(err, "cannot execute stage 1 request")
 			}
 
+			err = p.checkResponse(s1)
+			if err != nil {
+				return err
+			}
+
 			s1body, err = io.ReadAll(s1.Body)
 			if err != nil {
 				return errors.New(err, "cannot read stage 1 body")
@@ -464,7 +318,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 			blocks = append(blocks, block)
 			s2url := string(s1body) + `&comp=block&blockid=` + blocks[i]
 
-			s2req, err := http.NewRequest("OPTIONS", s2url, nil)
+			s2req, err := http.NewRequestWithContext(ctx, "OPTIONS", s2url, nil)
 			if err != nil {
 				return errors.New(err, "cannot create stage 2 request")
 			}
@@ -472,7 +326,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 			s2req.Header.Set("Accept", "*/*")
 			s2req.Header.Set("Access-Control-Request-Method", "PUT")
 			s2req.Header.Set("Cookie", user.SiteTokens["daymap"])
-			s2req.Header.Set("Origin", "https://gihs.daymap.net")
+			s2req.Header.Set("Origin", p.base)
 
 			_, err = client.Do(s2req)
 			if err != nil {
@@ -481,14 +335,14 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 
 			// Stage 3: Send file contents and metadata to the DayMap file upload server.
 
-			s3req, err := http.NewRequest("PUT", s2url, chunk)
+			s3req, err := http.NewRequestWithContext(ctx, "PUT", s2url, chunk)
 			if err != nil {
 				return errors.New(err, "cannot create stage 3 request")
 			}
 
 			s3req.Header.Set("Accept", "*/*")
 			s3req.Header.Set("Content-Length", fmt.Sprint(buflen))
-			s3req.Header.Set("Origin", "https://gihs.daymap.net")
+			s3req.Header.Set("Origin", p.base)
 			s3req.Header.Set("x-ms-blob-type", "BlockBlob")
 			s3req.Header.Set("x-ms-meta-LinkID", id)
 			s3req.Header.Set("x-ms-meta-qqfilename", fileName)
@@ -507,7 +361,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 		// Stage 4: Send final OPTIONS request to Daymap file upload server.
 
 		s4url := string(s1body) + `&comp=blocklist`
-		s4req, err := http.NewRequest("OPTIONS", s4url, nil)
+		s4req, err := http.NewRequestWithContext(ctx, "OPTIONS", s4url, nil)
 		if err != nil {
 			return errors.New(err, "cannot create stage 4 request")
 		}
@@ -517,7 +371,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 		s4req.Header.Set("Access-Control-Request-Headers", accHeaders)
 		s4req.Header.Set("Access-Control-Request-Method", "PUT")
 		s4req.Header.Set("Cookie", user.SiteTokens["daymap"])
-		s4req.Header.Set("Origin", "https://gihs.daymap.net")
+		s4req.Header.Set("Origin", p.base)
 
 		_, err = client.Do(s4req)
 		if err != nil {
@@ -534,7 +388,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 		s5data := strings.NewReader(s5form)
 		s5len := fmt.Sprint(len([]byte(s5form)))
 
-		s5req, err := http.NewRequest("PUT", s4url, s5data)
+		s5req, err := http.NewRequestWithContext(ctx, "PUT", s4url, s5data)
 		if err != nil {
 			return errors.New(err, "cannot create stage 5 request")
 		}
@@ -542,7 +396,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 		s5req.Header.Set("Accept", "*/*")
 		s5req.Header.Set("Content-Length", s5len)
 		s5req.Header.Set("Content-Type", "text/plain")
-		s5req.Header.Set("Origin", "https://gihs.daymap.net")
+		s5req.Header.Set("Origin", p.base)
 		s5req.Header.Set("x-ms-blob-content-type", "")
 		s5req.Header.Set("x-ms-meta-LinkID", id)
 		s5req.Header.Set("x-ms-meta-qqfilename", fileName)
@@ -559,14 +413,14 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 		s6form.Set("blob", blobId+fileExt)
 		s6form.Set("uuid", blobId)
 		s6form.Set("name", fileName)
-		s6form.Set("container", "https://glenunga.blob.core.windows.net/daymap/up")
+		s6form.Set("container", p.blob)
 		s6form.Set("t", "2")
 		s6form.Set("LinkID", id)
 
 		s6data := strings.NewReader(s6form.Encode())
-		s6url := "https://gihs.daymap.net/daymap/dws/uploadazure.ashx?cmd=UploadSuccess&taskId=" + id
+		s6url := p.base + "/daymap/dws/uploadazure.ashx?cmd=UploadSuccess&taskId=" + id
 
-		s6req, err := http.NewRequest("POST", s6url, s6data)
+		s6req, err := http.NewRequestWithContext(ctx, "POST", s6url, s6data)
 		if err != nil {
 			return errors.New(err, "cannot create stage 6 request")
 		}
@@ -574,7 +428,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 		s6req.Header.Set("Accept", "application/json")
 		s6req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		s6req.Header.Set("Cookie", user.SiteTokens["daymap"])
-		s6req.Header.Set("Origin", "https://gihs.daymap.net")
+		s6req.Header.Set("Origin", p.base)
 		s6req.Header.Set("Referer", selectUrl)
 		s6req.Header.Set("X-Requested-With", "XMLHttpRequest")
 
@@ -583,6 +437,11 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 			return errors.New(err, "cannot execute stage 6 request")
 		}
 
+		err = p.checkResponse(s6)
+		if err != nil {
+			return err
+		}
+
 		s6body, err := io.ReadAll(s6.Body)
 		if err != nil {
 			return errors.New(err, "cannot read stage 6 body")
@@ -591,11 +450,11 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 		jresp := chkJson{}
 		err = json.Unmarshal(s6body, &jresp)
 		if err != nil {
-			return errors.New(err, "cannot unmarshal JSON")
+			return errors.New(site.Fail(site.ErrParse, err), "cannot unmarshal JSON")
 		}
 
 		if !jresp.Success || jresp.Error != "" {
-			return errors.New(errors.New(nil, jresp.Error), "daymap returned error")
+			return errors.New(site.Fail(site.ErrRejected, errors.New(nil, jresp.Error)), "daymap returned error")
 		}
 
 		file, mimeErr = files.NextPart()
@@ -609,12 +468,12 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 	}
 }
 
-func RemoveWork(user site.User, id string, filenames []string) error {
-	removeUrl := "https://gihs.daymap.net/daymap/student/attachments.aspx?Type=1&LinkID="
+func (p platform) RemoveWork(ctx context.Context, user site.User, id string, filenames []string) error {
+	removeUrl := p.base + "/daymap/student/attachments.aspx?Type=1&LinkID="
 	removeUrl += id
-	client := &http.Client{}
+	client := &http.Client{Timeout: site.RequestTimeout}
 
-	s1req, err := http.NewRequest("GET", removeUrl, nil)
+	s1req, err := http.NewRequestWithContext(ctx, "GET", removeUrl, nil)
 	if err != nil {
 		return errors.New(err, "cannot create stage 1 request")
 	}
@@ -626,130 +485,19 @@ func RemoveWork(user site.User, id string, filenames []string) error {
 		return errors.New(err, "cannot execute stage 1 request")
 	}
 
-	s1body, err := io.ReadAll(s1.Body)
+	err = p.checkResponse(s1)
 	if err != nil {
-		return errors.New(err, "cannot read stage 1 body")
+		return err
 	}
 
-	page := string(s1body)
-	i := strings.Index(page, "<form")
-
-	if i == -1 {
-		return errors.New(nil, "invalid task HTML response")
-	}
-
-	page = page[i:]
-	i = strings.Index(page, ` action="`)
-
-	if i == -1 {
-		return errors.New(nil, "invalid task HTML response")
-	}
-
-	page = page[i:]
-	i = len(` action="`)
-	page = page[i:]
-	i = strings.Index(page, `"`)
-
-	if i == -1 {
-		return errors.New(nil, "invalid task HTML response")
+	s1body, err := io.ReadAll(s1.Body)
+	if err != nil {
+		return errors.New(err, "cannot read stage 1 body")
 	}
 
-	rwUrl := page[:i]
-	rwUrl = html.UnescapeString(rwUrl)
-	page = page[i:]
-	s2form := url.Values{}
-	i = strings.Index(page, "<input ")
-
-	for i != -1 {
-		var name, value string
-		page = page[i:]
-		i = strings.Index(page, ` type=`)
-
-		if i == -1 {
-			return errors.New(nil, "invalid task HTML response")
-		}
-
-		page = page[i:]
-		i = len(` type=`)
-		page = page[i:]
-		i = strings.Index(page, ` `)
-
-		if i == -1 {
-			return errors.New(nil, "invalid task HTML response")
-		}
-
-		inputType := page[:i]
-		page = page[i:]
-		i = strings.Index(page, `name="`)
-
-		if i == -1 {
-			return errors.New(nil, "invalid task HTML response")
-		}
-
-		page = page[i:]
-		i = len(`name="`)
-		page = page[i:]
-		i = strings.Index(page, `"`)
-
-		if i == -1 {
-			return errors.New(nil, "invalid task HTML response")
-		}
-
-		name = page[:i]
-		page = page[i:]
-
-		i = strings.Index(page, "\n")
-
-		if i == -1 {
-			return errors.New(nil, "invalid task HTML response")
-		}
-
-		valTest := page[:i]
-		i = strings.Index(valTest, ` value="`)
-
-		if i != -1 {
-			page = page[i:]
-			i = len(` value="`)
-			page = page[i:]
-			i = strings.Index(page, `"`)
-
-			if i == -1 {
-				return errors.New(nil, "invalid task HTML response")
-			}
-
-			value = page[:i]
-			page = page[i:]
-		}
-
-		if inputType != "checkbox" {
-			s2form.Set(name, value)
-			i = strings.Index(page, "<input ")
-			continue
-		}
-
-		i = strings.Index(page, `<span name=filename>`)
-
-		if i == -1 {
-			return errors.New(nil, "invalid task HTML response")
-		}
-
-		page = page[i:]
-		i = len(`<span name=filename>`)
-		page = page[i:]
-		i = strings.Index(page, `</span>`)
-
-		if i == -1 {
-			return errors.New(nil, "invalid task HTML response")
-		}
-
-		fname := page[:i]
-		page = page[i:]
-
-		if slices.Has(filenames, fname) {
-			s2form.Set(name, "del")
-		}
-
-		i = strings.Index(page, "<input ")
+	rwUrl, s2form, err := parseRemoveForm(string(s1body), filenames)
+	if err != nil {
+		return errors.New(site.Fail(site.ErrParse, err), "invalid task HTML response")
 	}
 
 	s2form.Set("Cmd", "delete")
@@ -758,10 +506,10 @@ func RemoveWork(user site.User, id string, filenames []string) error {
 
 	s2data := strings.NewReader(s2form.Encode())
 	if _, err := slices.Get([]byte(rwUrl), 1); err != nil {
-		return errors.New(err, "invalid task HTML response")
+		return errors.New(site.Fail(site.ErrParse, err), "invalid task HTML response")
 	}
-	s2url := "https://gihs.daymap.net/daymap/student" + rwUrl[1:]
-	s2req, err := http.NewRequest("POST", s2url, s2data)
+	s2url := p.base + "/daymap/student" + rwUrl[1:]
+	s2req, err := http.NewRequestWithContext(ctx, "POST", s2url, s2data)
 	if err != nil {
 		return errors.New(err, "cannot create stage 2 request")
 	}
@@ -776,3 +524,34 @@ func RemoveWork(user site.User, id string, filenames []string) error {
 
 	return nil
 }
+
+// parseRemoveForm parses the Daymap page listing the work uploaded to a task,
+// returning the action of its form and the form values which remove the files
+// with the given names.
+func parseRemoveForm(page string, filenames []string) (string, url.Values, error) {
+	doc, err := parsePage(page)
+	if err != nil {
+		return "", nil, errors.Wrap(err)
+	}
+	form, err := need(doc, "form[action]")
+	if err != nil {
+		return "", nil, errors.Wrap(err)
+	}
+	values := url.Values{}
+	for _, input := range findAll(form, "input[name]") {
+		name := attr(input, "name")
+		if attr(input, "type") != "checkbox" {
+			values.Set(name, attr(input, "value"))
+			continue
+		}
+		// Each file's checkbox is followed by its name.
+		fname := following(input, "span[name=filename]")
+		if fname == nil {
+			return "", nil, errors.New(nil, "missing element span[name=filename]")
+		}
+		if slices.Has(filenames, text(fname)) {
+			values.Set(name, "del")
+		}
+	}
+	return attr(form, "action"), values, nil
+}
@@ -1,9 +1,11 @@
 package daymap
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"time"
 
@@ -12,11 +14,11 @@ import (
 	"main/site"
 )
 
-func tasksPage(user site.User) (string, error) {
-	link := "https://gihs.daymap.net/daymap/student/assignments.aspx?View=0"
-	client := &http.Client{}
+func (p platform) tasksPage(ctx context.Context, user site.User) (string, error) {
+	link := p.base + "/daymap/student/assignments.aspx?View=0"
+	client := &http.Client{Timeout: site.RequestTimeout}
 
-	s1req, err := http.NewRequest("GET", link, nil)
+	s1req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
 	if err != nil {
 		return "", errors.New(err, "cannot create stage 1 request")
 	}
@@ -28,78 +30,19 @@ func tasksPage(user site.User) (string, error) {
 		return "", errors.New(err, "cannot execute stage 1 request")
 	}
 
+	err = p.checkResponse(s1)
+	if err != nil {
+		return "", err
+	}
+
 	s1body, err := io.ReadAll(s1.Body)
 	if err != nil {
 		return "", errors.New(err, "cannot read stage 1 body")
 	}
 
-	form := url.Values{}
-	s1page := string(s1body)
-	i := strings.Index(s1page, "<input ")
-
-	for i != -1 {
-		var value string
-		s1page = s1page[i:]
-		i = strings.Index(s1page, ">")
-
-		if i == -1 {
-			return "", errors.New(nil, "invalid HTML response")
-		}
-
-		inputTag := s1page[:i]
-		s1page = s1page[i:]
-		i = strings.Index(inputTag, `type="`)
-
-		if i == -1 {
-			return "", errors.New(nil, "invalid HTML response")
-		}
-
-		i += len(`type="`)
-		inputType := inputTag[i:]
-		i = strings.Index(inputType, `"`)
-
-		if i == -1 {
-			return "", errors.New(nil, "invalid HTML response")
-		}
-
-		inputType = inputType[:i]
-
-		if inputType != "hidden" {
-			i = strings.Index(s1page, "<input ")
-			continue
-		}
-
-		i = strings.Index(inputTag, `name="`)
-
-		if i == -1 {
-			return "", errors.New(nil, "invalid HTML response")
-		}
-
-		i += len(`name="`)
-		name := inputTag[i:]
-		i = strings.Index(name, `"`)
-
-		if i == -1 {
-			return "", errors.New(nil, "invalid HTML response")
-		}
-
-		name = name[:i]
-		i = strings.Index(inputTag, `value="`)
-
-		if i != -1 {
-			i += len(`value="`)
-			value = inputTag[i:]
-			i = strings.Index(value, `"`)
-
-			if i == -1 {
-				return "", errors.New(nil, "invalid HTML response")
-			}
-
-			value = value[:i]
-		}
-
-		form.Set(name, value)
-		i = strings.Index(s1page, "<input ")
+	form, err := parseHiddenInputs(string(s1body))
+	if err != nil {
+		return "", errors.New(err, "invalid HTML response")
 	}
 
 	for k, v := range auxValues {
@@ -108,7 +51,7 @@ func tasksPage(user site.User) (string, error) {
 
 	data := strings.NewReader(form.Encode())
 
-	s2req, err := http.NewRequest("POST", link, data)
+	s2req, err := http.NewRequestWithContext(ctx, "POST", link, data)
 	if err != nil {
 		return "", errors.New(err, "cannot create stage 2 request")
 	}
@@ -121,6 +64,11 @@ func tasksPage(user site.User) (string, error) {
 		return "", errors.New(err, "cannot execute stage 2 request")
 	}
 
+	err = p.checkResponse(s2)
+	if err != nil {
+		return "", err
+	}
+
 	s2body, err := io.ReadAll(s2.Body)
 	if err != nil {
 		return "", errors.New(err, "cannot read stage 2 body")
@@ -129,114 +77,66 @@ func tasksPage(user site.User) (string, error) {
 	return string(s2body), nil
 }
 
-func Tasks(user site.User, c chan site.Pair[[]site.Task, error], classes []site.Class) {
-	var result site.Pair[[]site.Task, error]
-	var tasks []site.Task
-
-	page, err := tasksPage(user)
+// parseHiddenInputs returns the values of the hidden inputs in page, which
+// hold the state of a Daymap form.
+func parseHiddenInputs(page string) (url.Values, error) {
+	doc, err := parsePage(page)
 	if err != nil {
-		result.Second = errors.New(err, "cannot fetch tasks page")
-		c <- result
-		return
+		return nil, site.Fail(site.ErrParse, err)
 	}
+	form := url.Values{}
+	for _, input := range findAll(doc, "input[type=hidden][name]") {
+		form.Set(attr(input, "name"), attr(input, "value"))
+	}
+	return form, nil
+}
 
-	var unsorted []site.Task
-	i := strings.Index(page, `href="javascript:ViewAssignment(`)
-
-	for i != -1 {
-		task := site.Task{
-			Platform: "daymap",
-		}
-
-		i += len(`href="javascript:ViewAssignment(`)
-		page = page[i:]
-		i = strings.Index(page, `)">`)
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-
-		task.Id = page[:i]
-		page = page[i:]
-		task.Link = "https://gihs.daymap.net/daymap/student/assignment.aspx?TaskID=" + task.Id
-		i = strings.Index(page, `<td>`)
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
+// viewAssignmentPattern matches the links to tasks in the Daymap assignments
+// page, capturing the task ID.
+var viewAssignmentPattern = regexp.MustCompile(`^javascript:ViewAssignment\(([0-9]+)\)`)
 
-		i += len(`<td>`)
-		page = page[i:]
-		i = strings.Index(page, `</td>`)
+// parseTasks parses the tasks listed in a Daymap assignments page. Each task
+// is a row of the assignments table, whose cells following that of the link
+// give the class, task type, name, date posted, due date and status.
+func (p platform) parseTasks(page string, user site.User) ([]site.Task, error) {
+	doc, err := parsePage(page)
+	if err != nil {
+		return nil, site.Fail(site.ErrParse, err)
+	}
 
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
+	var tasks []site.Task
+	for _, a := range findAll(doc, `a[href^="javascript:ViewAssignment("]`) {
+		m := viewAssignmentPattern.FindStringSubmatch(attr(a, "href"))
+		if m == nil {
+			return nil, errors.New(errors.Raise(site.ErrParse), "invalid task link %q", attr(a, "href"))
 		}
-
-		task.Class = page[:i]
-		i += len(`</td>`)
-		page = page[i:]
-		i = strings.Index(page, `</td><td>`)
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
+		task := site.Task{
+			Link:     p.base + "/daymap/student/assignment.aspx?TaskID=" + m[1],
+			Platform: "daymap",
+			Id:       m[1],
 		}
 
-		i += len(`</td><td>`)
-		page = page[i:]
-		i = strings.Index(page, `</td><td>`)
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
+		row := closest(a, "tr")
+		if row == nil {
+			return nil, errors.New(errors.Raise(site.ErrParse), "missing element tr for task %s", task.Id)
 		}
-
-		task.Name = page[:i]
-		i += len(`</td><td>`)
-		page = page[i:]
-		i = strings.Index(page, `</td><td>`)
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
+		cells := children(row, "td")
+		if len(cells) < 6 {
+			return nil, errors.New(errors.Raise(site.ErrParse), "missing element td for task %s", task.Id)
 		}
+		task.Class = text(cells[1])
+		task.Name = text(cells[3])
 
-		postedStr := page[:i]
-
+		postedStr := text(cells[4])
 		task.Posted, err = time.ParseInLocation("2/01/06", postedStr, user.Timezone)
 		if err != nil {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-
-		i += len(`</td><td>`)
-		page = page[i:]
-		i = strings.Index(page, `</td><td>`)
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
+			return nil, errors.New(site.Fail(site.ErrParse, err), "invalid post date %q for task %s", postedStr, task.Id)
 		}
 
-		dueStr := page[:i]
-
+		dueStr := text(cells[5])
 		task.Due, err = time.ParseInLocation("2/01/06", dueStr, user.Timezone)
 		if err != nil {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
+			return nil, errors.New(site.Fail(site.ErrParse, err), "invalid due date %q for task %s", dueStr, task.Id)
 		}
 
 		// Due time might not be 23:59:59, but if it is 00:00:00, the task will
@@ -249,30 +149,38 @@ func Tasks(user site.User, c chan site.Pair[[]site.Task, error], classes []site.
 			user.Timezone,
 		)
 
-		i = strings.Index(page, "\n")
-
-		if i == -1 {
-			result.Second = errors.New(nil, "invalid HTML response")
-			c <- result
-			return
-		}
-
-		taskLine := page[:i]
-		i = strings.Index(taskLine, `Results have been published`)
-
-		if i != -1 {
+		// The status may be given by the text or the title of an icon.
+		status := outerHTML(row)
+		if strings.Contains(status, `Results have been published`) {
 			task.Submitted = true
 			task.Graded = true
 		}
-
-		i = strings.Index(taskLine, `Your work has been received`)
-
-		if i != -1 && !task.Submitted {
+		if strings.Contains(status, `Your work has been received`) {
 			task.Submitted = true
 		}
 
-		unsorted = append(unsorted, task)
-		i = strings.Index(page, `href="javascript:ViewAssignment(`)
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+func (p platform) Tasks(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error], classes []site.Class) {
+	var result site.Pair[[]site.Task, error]
+	var tasks []site.Task
+
+	page, err := p.tasksPage(ctx, user)
+	if err != nil {
+		result.Second = errors.New(err, "cannot fetch tasks page")
+		c <- result
+		return
+	}
+
+	unsorted, err := p.parseTasks(page, user)
+	if err != nil {
+		result.Second = errors.New(err, "invalid HTML response")
+		c <- result
+		return
 	}
 
 	// BUG: matching tasks to classes by class name may lead to collisions if
@@ -289,3 +197,58 @@ func Tasks(user site.User, c chan site.Pair[[]site.Task, error], classes []site.
 	result.First = tasks
 	c <- result
 }
+
+// DueTasks retrieves the user's active tasks, that is, those which have a due
+// date and have not yet been submitted. Unlike Tasks, only the current tasks
+// view of the assignments page is fetched, which takes a single request and
+// does not need the page size to be increased.
+func (p platform) DueTasks(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error]) {
+	var result site.Pair[[]site.Task, error]
+
+	link := p.base + "/daymap/student/assignments.aspx?View=1"
+	client := &http.Client{Timeout: site.RequestTimeout}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		result.Second = errors.New(err, "cannot create current tasks request")
+		c <- result
+		return
+	}
+
+	req.Header.Set("Cookie", user.SiteTokens["daymap"])
+
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Second = errors.New(err, "cannot execute current tasks request")
+		c <- result
+		return
+	}
+
+	err = p.checkResponse(resp)
+	if err != nil {
+		result.Second = err
+		c <- result
+		return
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		result.Second = errors.New(err, "cannot read current tasks body")
+		c <- result
+		return
+	}
+
+	tasks, err := p.parseTasks(string(body), user)
+	if err != nil {
+		result.Second = errors.New(err, "invalid HTML response")
+		c <- result
+		return
+	}
+
+	for _, task := range tasks {
+		if !task.Submitted && !task.Due.IsZero() {
+			result.First = append(result.First, task)
+		}
+	}
+	c <- result
+}
@@ -0,0 +1,274 @@
+package site
+
+import (
+	"context"
+	stderrors "errors"
+	"maps"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// PlatformError records the platforms which failed during a multiplexed
+// function call, along with the error returned by each. It is returned by the
+// aggregate methods of Mux, together with the results from every platform
+// which did not fail.
+//
+// A platform function which could fetch only some of its results returns them
+// with a PlatformError naming its own platform, so that they are kept but
+// reported as incomplete.
+type PlatformError map[string]error
+
+func (e PlatformError) Error() string {
+	var errs []string
+	for _, platform := range e.Platforms() {
+		errs = append(errs, platform+": "+e[platform].Error())
+	}
+	return strings.Join(errs, "; ")
+}
+
+// Platforms returns the names of the failed platforms in lexical order.
+func (e PlatformError) Platforms() []string {
+	var platforms []string
+	for platform := range e {
+		platforms = append(platforms, platform)
+	}
+	sort.Strings(platforms)
+	return platforms
+}
+
+// kind returns the kind of failure shared by every failed platform, or nil if
+// they failed in different ways.
+func (e PlatformError) kind() error {
+	var kind error
+	for _, err := range e {
+		k := Kind(err)
+		if k == nil || (kind != nil && k != kind) {
+			return nil
+		}
+		kind = k
+	}
+	return kind
+}
+
+// Partial reports whether err was caused by the failure of only some of the
+// platforms in a multiplexed function call, in which case the results returned
+// alongside err are usable, if incomplete. If so, the PlatformError naming the
+// failed platforms is returned.
+func Partial(err error) (PlatformError, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case PlatformError:
+			return e, true
+		case interface{ Parent() error }:
+			err = e.Parent()
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// failureKind is the type of the kinds of failure below. Kinds are compared by
+// identity, so that an error is not mistaken for one of them merely because it
+// has the same text.
+type failureKind struct {
+	text string
+}
+
+func (k *failureKind) Error() string {
+	return k.text
+}
+
+// The kinds of failure of platform functions. Platforms return them either
+// directly, optionally as the parent of a more specific error, or with Fail,
+// which keeps the error that caused the failure. Callers find the kind of a
+// failure with Kind.
+var (
+	// ErrNotFound reports that the requested item does not exist, or is not
+	// visible to the user.
+	ErrNotFound error = &failureKind{"cannot find resource"}
+	// ErrUnauthenticated reports that the platform rejected the user's
+	// credentials.
+	ErrUnauthenticated error = &failureKind{"platform rejected credentials"}
+	// ErrExpired reports that the user's session with the platform has
+	// expired, such as when the platform redirects to its login page or
+	// rejects the user's token. Mux then authenticates the user with the
+	// platform again and retries the call once.
+	ErrExpired error = &failureKind{"platform session has expired"}
+	// ErrUnavailable reports that the platform could not be reached, did not
+	// respond in time, or responded with a server error.
+	ErrUnavailable error = &failureKind{"platform is unavailable"}
+	// ErrParse reports that the platform's response could not be understood,
+	// which usually means that the platform has changed.
+	ErrParse error = &failureKind{"cannot parse platform response"}
+	// ErrUnsupported reports that the platform does not exist, or does not
+	// support the requested function.
+	ErrUnsupported error = &failureKind{"unsupported platform"}
+	// ErrRejected reports that the platform refused work uploaded to it.
+	ErrRejected error = &failureKind{"upload rejected by platform"}
+	// ErrRange reports that the requested part of a file does not exist,
+	// such as when the range requested is beyond the end of the file.
+	ErrRange error = &failureKind{"requested range not satisfiable"}
+)
+
+// KindError reports that a platform function failed with Err because of the
+// kind of failure Kind, one of the errors above. It is returned by Fail.
+type KindError struct {
+	Kind error
+	Err  error
+}
+
+func (e *KindError) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *KindError) Parent() error {
+	return e.Err
+}
+
+// RangeError reports that the requested part of a file does not exist. Size is
+// the length of the whole file in bytes, or -1 if the platform did not give it.
+// It is of kind ErrRange.
+type RangeError struct {
+	Size int64
+}
+
+func (e *RangeError) Error() string {
+	if e.Size < 0 {
+		return ErrRange.Error()
+	}
+	return ErrRange.Error() + ": file is " + strconv.FormatInt(e.Size, 10) + " bytes long"
+}
+
+func (e *RangeError) Parent() error {
+	return ErrRange
+}
+
+// OutOfRange reports whether err was caused by a request for part of a file
+// which does not exist. If so, the RangeError giving the file's length is
+// returned.
+func OutOfRange(err error) (*RangeError, bool) {
+	for err != nil {
+		switch e := err.(type) {
+		case *RangeError:
+			return e, true
+		case interface{ Parent() error }:
+			err = e.Parent()
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Fail returns an error reporting that a platform function failed with err
+// because of the given kind of failure, one of the errors above.
+func Fail(kind, err error) error {
+	return &KindError{Kind: kind, Err: err}
+}
+
+// Kind returns the kind of failure reported by err, which is the first of the
+// errors above found among err and its parents. Errors from the network and
+// from expired contexts are reported as ErrUnavailable, unless a platform has
+// given them another kind. A PlatformError is of the kind of its failures if
+// every failed platform failed in the same way. Kind returns nil if err is nil
+// or of no known kind.
+func Kind(err error) error {
+	for err != nil {
+		switch e := err.(type) {
+		case *failureKind:
+			return e
+		case *KindError:
+			return e.Kind
+		case PlatformError:
+			return e.kind()
+		case interface{ Parent() error }:
+			err = e.Parent()
+		default:
+			var netErr net.Error
+			if stderrors.As(err, &netErr) || stderrors.Is(err, context.DeadlineExceeded) {
+				return ErrUnavailable
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Expired reports whether err was caused by the expiry of the user's session
+// with a platform.
+func Expired(err error) bool {
+	return Kind(err) == ErrExpired
+}
+
+// A platformResult is the result of a platform function called by gather.
+type platformResult[T any] struct {
+	platform string
+	list     []T
+	err      error
+}
+
+// gather calls each platform function in calls concurrently and collects their
+// results. Each function is called with a context derived from ctx, which is
+// cancelled once the time given by timeout for its platform has passed; a
+// platform which does not respond by then is treated as having failed. Every
+// function is waited for, regardless of whether any others fail; if some do
+// fail, a PlatformError naming them is returned along with the results of the
+// remainder. The partial results of a platform which returned a PlatformError
+// are kept, and the platforms it names are added to those which failed. If
+// every platform fails outright, there are no results to return, so a plain
+// error is returned instead, which is of the kind shared by the failures if
+// there is one (see Kind).
+func gather[T any](ctx context.Context, timeout func(string) time.Duration, calls map[string]func(context.Context, chan Pair[[]T, error])) ([]T, error) {
+	// The channels are buffered so that no platform function is left blocked
+	// if its result is not received.
+	ch := make(chan platformResult[T], len(calls))
+	for platform, call := range calls {
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, timeout(platform))
+			defer cancel()
+			c := make(chan Pair[[]T, error], 1)
+			go call(ctx, c)
+			select {
+			case result := <-c:
+				ch <- platformResult[T]{platform, result.First, result.Second}
+			case <-ctx.Done():
+				err := errors.New(ctx.Err(), "platform did not respond")
+				ch <- platformResult[T]{platform, nil, err}
+			}
+		}()
+	}
+	var list []T
+	failed := PlatformError{}
+	failures := 0
+	for range calls {
+		result := <-ch
+		if partial, ok := Partial(result.err); ok {
+			maps.Copy(failed, partial)
+		} else if result.err != nil {
+			failed[result.platform] = result.err
+			failures++
+			continue
+		}
+		list = append(list, result.list...)
+	}
+	if len(failed) == 0 {
+		return list, nil
+	}
+	if failures == len(calls) {
+		err := errors.New(nil, "every platform failed: %s", failed)
+		if kind := failed.kind(); kind != nil {
+			return nil, Fail(kind, err)
+		}
+		return nil, err
+	}
+	return list, failed
+}
@@ -0,0 +1,108 @@
+package site
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+func TestKind(t *testing.T) {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New(nil, "connection refused")}
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"nil", nil, nil},
+		{"unknown", errors.New(nil, "cannot read body"), nil},
+		{"raised", errors.Raise(ErrNotFound), ErrNotFound},
+		{"parent", errors.New(errors.Raise(ErrExpired), "redirected to login"), ErrExpired},
+		{"fail", errors.New(Fail(ErrParse, errors.New(nil, "missing element")), "invalid HTML"), ErrParse},
+		{"stale", &StaleError{Err: errors.Raise(ErrRejected)}, ErrRejected},
+		{"range", errors.New(&RangeError{Size: 1024}, "cannot fetch file"), ErrRange},
+		{"network", errors.New(netErr, "cannot execute request"), ErrUnavailable},
+		{"deadline", errors.New(context.DeadlineExceeded, "platform did not respond"), ErrUnavailable},
+		{"outermost", Fail(ErrParse, errors.Raise(ErrNotFound)), ErrParse},
+		{"text", errors.New(nil, "unsupported platform"), nil},
+		{"platforms", PlatformError{"daymap": errors.Raise(ErrParse), "saml": errors.Raise(ErrUnavailable)}, nil},
+		{"platforms same", PlatformError{"daymap": errors.Raise(ErrExpired), "saml": errors.New(errors.Raise(ErrExpired), "redirected to login")}, ErrExpired},
+		{"platforms unknown", PlatformError{"daymap": errors.Raise(ErrParse), "saml": errors.New(nil, "cannot read body")}, nil},
+	}
+	for _, test := range tests {
+		if got := Kind(test.err); got != test.want {
+			t.Errorf("%s: Kind(%v) = %v, want %v", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestAuthError(t *testing.T) {
+	unavailable := errors.New(context.DeadlineExceeded, "authentication did not complete")
+	rejected := errors.Raise(ErrUnauthenticated)
+	if got := Kind(authError([]error{unavailable, unavailable})); got != ErrUnavailable {
+		t.Errorf("kind is %v, want %v", got, ErrUnavailable)
+	}
+	if got := Kind(authError([]error{unavailable, rejected})); got != ErrUnauthenticated {
+		t.Errorf("kind is %v, want %v", got, ErrUnauthenticated)
+	}
+	if got := Kind(authError([]error{unavailable, errors.New(nil, "cannot read body")})); got != nil {
+		t.Errorf("kind is %v, want nil", got)
+	}
+}
+
+// result returns a platform function for gather which returns the given
+// results and error.
+func result(list []string, err error) func(context.Context, chan Pair[[]string, error]) {
+	return func(ctx context.Context, c chan Pair[[]string, error]) {
+		c <- Pair[[]string, error]{list, err}
+	}
+}
+
+func TestGather(t *testing.T) {
+	timeout := func(string) time.Duration { return time.Second }
+	failure := errors.Raise(ErrUnavailable)
+
+	list, err := gather(context.Background(), timeout, map[string]func(context.Context, chan Pair[[]string, error]){
+		"a": result([]string{"a"}, nil),
+		"b": result(nil, failure),
+	})
+	failed, partial := Partial(err)
+	if !partial || len(list) != 1 {
+		t.Errorf("one failure: got %v, %v, want partial results", list, err)
+	} else if platforms := failed.Platforms(); len(platforms) != 1 || platforms[0] != "b" {
+		t.Errorf("one failure: failed platforms are %v, want [b]", platforms)
+	}
+
+	list, err = gather(context.Background(), timeout, map[string]func(context.Context, chan Pair[[]string, error]){
+		"a": result(nil, failure),
+		"b": result(nil, failure),
+	})
+	if err == nil || Usable(err) || list != nil {
+		t.Errorf("every failure: got %v, %v, want unusable error", list, err)
+	}
+	if got := Kind(err); got != ErrUnavailable {
+		t.Errorf("every failure: kind is %v, want %v", got, ErrUnavailable)
+	}
+
+	// A platform which returns only some of its results is reported as
+	// having failed, but its results are kept.
+	incomplete := PlatformError{"a": failure}
+	list, err = gather(context.Background(), timeout, map[string]func(context.Context, chan Pair[[]string, error]){
+		"a": result([]string{"a"}, incomplete),
+	})
+	failed, partial = Partial(err)
+	if !partial || len(list) != 1 {
+		t.Errorf("incomplete results: got %v, %v, want partial results", list, err)
+	} else if platforms := failed.Platforms(); len(platforms) != 1 || platforms[0] != "a" {
+		t.Errorf("incomplete results: failed platforms are %v, want [a]", platforms)
+	}
+
+	list, err = gather(context.Background(), timeout, map[string]func(context.Context, chan Pair[[]string, error]){
+		"a": result([]string{"a"}, nil),
+	})
+	if err != nil || len(list) != 1 {
+		t.Errorf("no failure: got %v, %v, want [a], nil", list, err)
+	}
+}
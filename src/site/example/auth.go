@@ -1,6 +1,7 @@
 package example
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 
@@ -20,8 +21,15 @@ import (
 // fetch may be written by using browser devtools to reverse engineer the
 // process behind retrieving a certain webpage, which means reverse engineering
 // the auth process as well.
+//
+// If the platform rejects the credentials, fetch returns an error of kind
+// site.ErrUnauthenticated, so that the user is told their login failed rather
+// than that the server did.
 func fetch(link, email, username, password string) (string, string, error) {
 	page := ""
+	if username == "" || password == "" {
+		return page, "", errors.New(errors.Raise(site.ErrUnauthenticated), "missing username or password")
+	}
 	buf := make([]byte, 32)
 	_, err := rand.Read(buf)
 	if err != nil {
@@ -32,7 +40,7 @@ func fetch(link, email, username, password string) (string, string, error) {
 }
 
 // Example auth function
-func Auth(user site.User, c chan site.Pair[[2]string, error]) {
+func Auth(ctx context.Context, user site.User, c chan site.Pair[[2]string, error]) {
 	var result site.Pair[[2]string, error]
 
 	link := "https://example.com"
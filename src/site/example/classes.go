@@ -1,10 +1,11 @@
 package example
 
 import (
+	"context"
 	"main/site"
 )
 
-func Classes(user site.User, c chan site.Pair[[]site.Class, error]) {
+func Classes(ctx context.Context, user site.User, c chan site.Pair[[]site.Class, error]) {
 	var result site.Pair[[]site.Class, error]
 	classes := []site.Class{
 		{"Biology", "https://example.com", "example", "346756"},
@@ -0,0 +1,50 @@
+package example
+
+import (
+	"context"
+	"image/color"
+	"time"
+
+	"main/site"
+)
+
+func Events(ctx context.Context, user site.User, c chan site.Pair[[]site.Event, error], start, end time.Time) {
+	var result site.Pair[[]site.Event, error]
+	week := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, user.Timezone)
+	events := []site.Event{
+		{
+			Name:     "Sports day",
+			Start:    week.AddDate(0, 0, 2),
+			End:      week.AddDate(0, 0, 3),
+			AllDay:   true,
+			Location: "Oval",
+			Category: "School event",
+			Color:    color.RGBA{0xd9, 0x6b, 0x0a, 0xff},
+			Platform: "example",
+		},
+		{
+			Name:     "Chemistry excursion",
+			Start:    week.AddDate(0, 0, 1).Add(9*time.Hour + 30*time.Minute),
+			End:      week.AddDate(0, 0, 1).Add(12*time.Hour + 30*time.Minute),
+			Location: "University of Adelaide",
+			Category: "Excursion",
+			Color:    color.RGBA{0x03, 0x6e, 0x05, 0xff},
+			Platform: "example",
+		},
+		{
+			Name:     "Parent-teacher interviews",
+			Start:    week.AddDate(0, 0, 3).Add(15*time.Hour + 30*time.Minute),
+			End:      week.AddDate(0, 0, 3).Add(17*time.Hour + 30*time.Minute),
+			Location: "Library",
+			Category: "School event",
+			Platform: "example",
+		},
+	}
+	// Only return events which overlap the requested period.
+	for _, event := range events {
+		if event.End.After(start) && event.Start.Before(end) {
+			result.First = append(result.First, event)
+		}
+	}
+	c <- result
+}
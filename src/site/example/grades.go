@@ -1,12 +1,13 @@
 package example
 
 import (
+	"context"
 	"time"
 
 	"main/site"
 )
 
-func Graded(user site.User, c chan site.Pair[[]site.Task, error]) {
+func Graded(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error]) {
 	var result site.Pair[[]site.Task, error]
 	// The grades tab does not use the following fields:
 	//   - Desc
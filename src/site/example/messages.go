@@ -0,0 +1,52 @@
+package example
+
+import (
+	"context"
+	"net/mail"
+	"time"
+
+	"main/site"
+)
+
+func Messages(ctx context.Context, user site.User, c chan site.Pair[[]site.Message, error]) {
+	var result site.Pair[[]site.Message, error]
+	student := mail.Address{Name: user.DispName, Address: user.Email}
+	messages := []site.Message{
+		{
+			From:     mail.Address{Name: "Ms Jane Smith", Address: "jane.smith@example.com"},
+			To:       []mail.Address{student},
+			Sent:     time.Date(2023, 3, 14, 8, 12, 0, 0, user.Timezone),
+			Subject:  "Chemistry excursion permission forms",
+			Body:     "<p>Hi all,</p><p>Please return your signed permission forms for the excursion by <b>Friday</b>. Forms are available from the <a href=\"https://example.com\">school website</a>.</p><p>Thanks,<br>Ms Smith</p>",
+			Read:     false,
+			Platform: "example",
+			Id:       "184302",
+		},
+		{
+			From: mail.Address{Name: "Mr John Doe", Address: "john.doe@example.com"},
+			To:   []mail.Address{student},
+			Cc: []mail.Address{
+				{Name: "Ms Jane Smith", Address: "jane.smith@example.com"},
+			},
+			Sent:     time.Date(2023, 3, 9, 15, 47, 0, 0, user.Timezone),
+			Subject:  "Feedback on your history essay draft",
+			Body:     "Your draft is a good start. Focus on linking each paragraph back to the question, and make sure every claim is supported by a source.\n\nSee me on Thursday if you have any questions.",
+			Read:     true,
+			Platform: "example",
+			Id:       "183977",
+		},
+		{
+			From:     mail.Address{Name: "Student Services", Address: "services@example.com"},
+			To:       []mail.Address{student},
+			Sent:     time.Date(2023, 2, 27, 11, 5, 0, 0, user.Timezone),
+			Subject:  "Timetable changes for week 6",
+			Body:     "Periods 3 and 4 on Wednesday will be replaced by the whole school assembly in the gymnasium.",
+			Read:     true,
+			Platform: "example",
+			Id:       "183514",
+		},
+	}
+	// If an error occurs, set result.Second to err instead.
+	result.First = messages
+	c <- result
+}
@@ -0,0 +1,82 @@
+package example
+
+import (
+	"context"
+	"mime/multipart"
+	"time"
+
+	"main/site"
+)
+
+// platform registers the example platform with the site package.
+type platform struct{}
+
+func init() {
+	site.Register(platform{})
+}
+
+func (platform) Name() string {
+	return "example"
+}
+
+func (platform) Auth(ctx context.Context, user site.User, c chan site.Pair[[2]string, error]) {
+	Auth(ctx, user, c)
+}
+
+func (platform) Classes(ctx context.Context, user site.User, c chan site.Pair[[]site.Class, error]) {
+	Classes(ctx, user, c)
+}
+
+func (platform) DueTasks(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error]) {
+	DueTasks(ctx, user, c)
+}
+
+func (platform) Events(ctx context.Context, user site.User, c chan site.Pair[[]site.Event, error], start, end time.Time) {
+	Events(ctx, user, c, start, end)
+}
+
+func (platform) Graded(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error]) {
+	Graded(ctx, user, c)
+}
+
+func (platform) Lessons(ctx context.Context, user site.User, c chan site.Pair[[]site.Lesson, error], start, end time.Time) {
+	lessons, err := Lessons(ctx, user, start, end)
+	c <- site.Pair[[]site.Lesson, error]{First: lessons, Second: err}
+}
+
+func (platform) Messages(ctx context.Context, user site.User, c chan site.Pair[[]site.Message, error]) {
+	Messages(ctx, user, c)
+}
+
+func (platform) RemoveWork(ctx context.Context, user site.User, id string, filenames []string) error {
+	return RemoveWork(ctx, user, id, filenames)
+}
+
+func (platform) Reports(ctx context.Context, user site.User, c chan site.Pair[[]site.Report, error]) {
+	reports, err := Reports(ctx, user)
+	c <- site.Pair[[]site.Report, error]{First: reports, Second: err}
+}
+
+func (platform) Resource(ctx context.Context, user site.User, id string) (site.Resource, error) {
+	return Resource(ctx, user, id)
+}
+
+func (platform) Resources(ctx context.Context, user site.User, c chan site.Pair[[]site.Resource, error], classes []site.Class) {
+	Resources(ctx, user, c, classes)
+}
+
+func (platform) Submit(ctx context.Context, user site.User, id string) error {
+	return Submit(ctx, user, id)
+}
+
+func (platform) Task(ctx context.Context, user site.User, id string) (site.Task, error) {
+	return Task(ctx, user, id)
+}
+
+func (platform) Tasks(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error], classes []site.Class) {
+	Tasks(ctx, user, c, classes)
+}
+
+func (platform) UploadWork(ctx context.Context, user site.User, id string, files *multipart.Reader) error {
+	return UploadWork(ctx, user, id, files)
+}
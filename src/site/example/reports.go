@@ -0,0 +1,44 @@
+package example
+
+import (
+	"context"
+	"time"
+
+	"main/site"
+)
+
+func Reports(ctx context.Context, user site.User) ([]site.Report, error) {
+	reports := []site.Report{
+		{
+			Name: "Semester 2 2022",
+			Grades: []site.Grade{
+				{Class: "Biology", Grade: "A-", Score: 86.5},
+				{Class: "Chemistry", Grade: "B+", Score: 79},
+				{Class: "English", Grade: "A", Score: 91},
+				{Class: "French", Grade: "B", Score: 74.5},
+				{Class: "History", Grade: "A-", Score: 85},
+				{Class: "Mathematics", Grade: "A+", Score: 97},
+				{Class: "Physics", Grade: "B+", Score: 80.5},
+			},
+			Released: time.Date(2022, 12, 9, 15, 0, 0, 0, user.Timezone),
+			Platform: "example",
+			Id:       "22s2",
+		},
+		{
+			Name: "Semester 1 2022",
+			Grades: []site.Grade{
+				{Class: "Biology", Grade: "B+", Score: 81},
+				{Class: "Chemistry", Grade: "B", Score: 75.5},
+				{Class: "English", Grade: "A-", Score: 88},
+				{Class: "French", Grade: "B-", Score: 70},
+				{Class: "History", Grade: "A-", Score: 84},
+				{Class: "Mathematics", Grade: "A", Score: 93.5},
+				{Class: "Physics", Grade: "B", Score: 76},
+			},
+			Released: time.Date(2022, 7, 1, 15, 0, 0, 0, user.Timezone),
+			Platform: "example",
+			Id:       "22s1",
+		},
+	}
+	return reports, nil
+}
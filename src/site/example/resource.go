@@ -1,12 +1,13 @@
 package example
 
 import (
+	"context"
 	"main/site"
 
 	"git.sr.ht/~kvo/go-std/errors"
 )
 
-func Resource(user site.User, id string) (site.Resource, error) {
+func Resource(ctx context.Context, user site.User, id string) (site.Resource, error) {
 	resources := map[string]site.Resource{
 		core[0].Id:    core[0],
 		core[1].Id:    core[1],
@@ -17,7 +18,7 @@ func Resource(user site.User, id string) (site.Resource, error) {
 	}
 	resource, exists := resources[id]
 	if !exists {
-		return resource, errors.New(nil, "no resource with ID %s exists", id)
+		return resource, errors.New(errors.Raise(site.ErrNotFound), "no resource with ID %s exists", id)
 	}
 	return resource, nil
 }
@@ -1,6 +1,7 @@
 package example
 
 import (
+	"context"
 	"main/site"
 	"time"
 )
@@ -74,7 +75,7 @@ var physics = []site.Resource{
 	},
 }
 
-func Resources(user site.User, c chan site.Pair[[]site.Resource, error], classes []site.Class) {
+func Resources(ctx context.Context, user site.User, c chan site.Pair[[]site.Resource, error], classes []site.Class) {
 	var result site.Pair[[]site.Resource, error]
 	var resources []site.Resource
 	for _, class := range classes {
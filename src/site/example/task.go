@@ -1,6 +1,7 @@
 package example
 
 import (
+	"context"
 	"io"
 	"main/site"
 	"mime/multipart"
@@ -11,7 +12,7 @@ import (
 	"git.sr.ht/~kvo/go-std/errors"
 )
 
-func Task(user site.User, id string) (site.Task, error) {
+func Task(ctx context.Context, user site.User, id string) (site.Task, error) {
 	tasks := map[string]site.Task{
 		bio[0].Id:     bio[0],
 		bio[1].Id:     bio[1],
@@ -25,12 +26,12 @@ func Task(user site.User, id string) (site.Task, error) {
 	}
 	task, exists := tasks[id]
 	if !exists {
-		return task, errors.New(nil, "no task with ID %s exists", id)
+		return task, errors.New(errors.Raise(site.ErrNotFound), "no task with ID %s exists", id)
 	}
 	return task, nil
 }
 
-func Submit(user site.User, id string) error {
+func Submit(ctx context.Context, user site.User, id string) error {
 	tasks := map[string]*site.Task{
 		bio[0].Id:     &(bio[0]),
 		bio[1].Id:     &(bio[1]),
@@ -44,13 +45,13 @@ func Submit(user site.User, id string) error {
 	}
 	_, exists := tasks[id]
 	if !exists {
-		return errors.New(nil, "no task with ID %s exists", id)
+		return errors.New(errors.Raise(site.ErrNotFound), "no task with ID %s exists", id)
 	}
 	tasks[id].Submitted = true
 	return nil
 }
 
-func UploadWork(user site.User, id string, files *multipart.Reader) error {
+func UploadWork(ctx context.Context, user site.User, id string, files *multipart.Reader) error {
 	tasks := map[string]*site.Task{
 		"783663248": &(bio[0]),
 		"873468673": &(bio[1]),
@@ -64,7 +65,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 	}
 	task, exists := tasks[id]
 	if !exists {
-		return errors.New(nil, "no task with ID %s exists", id)
+		return errors.New(errors.Raise(site.ErrNotFound), "no task with ID %s exists", id)
 	}
 	sort.SliceStable(task.WorkLinks, func(i, j int) bool {
 		id1, _ := strconv.Atoi(strings.TrimPrefix(task.WorkLinks[i][0], "https://example.com/"))
@@ -94,7 +95,7 @@ func UploadWork(user site.User, id string, files *multipart.Reader) error {
 	return nil
 }
 
-func RemoveWork(user site.User, id string, filenames []string) error {
+func RemoveWork(ctx context.Context, user site.User, id string, filenames []string) error {
 	tasks := map[string]*site.Task{
 		"783663248": &(bio[0]),
 		"873468673": &(bio[1]),
@@ -108,7 +109,7 @@ func RemoveWork(user site.User, id string, filenames []string) error {
 	}
 	task, exists := tasks[id]
 	if !exists {
-		return errors.New(nil, "no task with ID %s exists", id)
+		return errors.New(errors.Raise(site.ErrNotFound), "no task with ID %s exists", id)
 	}
 	var cleaned [][2]string
 	for _, worklink := range task.WorkLinks {
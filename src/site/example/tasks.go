@@ -1,6 +1,7 @@
 package example
 
 import (
+	"context"
 	"main/site"
 	"time"
 )
@@ -182,7 +183,7 @@ var maths = []site.Task{
 	},
 }
 
-func Tasks(user site.User, c chan site.Pair[[]site.Task, error], classes []site.Class) {
+func Tasks(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error], classes []site.Class) {
 	var result site.Pair[[]site.Task, error]
 	var tasks []site.Task
 	for _, class := range classes {
@@ -203,3 +204,15 @@ func Tasks(user site.User, c chan site.Pair[[]site.Task, error], classes []site.
 	result.First = tasks
 	c <- result
 }
+
+func DueTasks(ctx context.Context, user site.User, c chan site.Pair[[]site.Task, error]) {
+	var result site.Pair[[]site.Task, error]
+	for _, list := range [][]site.Task{bio, chem, english, history, maths} {
+		for _, task := range list {
+			if !task.Submitted && !task.Due.IsZero() {
+				result.First = append(result.First, task)
+			}
+		}
+	}
+	c <- result
+}
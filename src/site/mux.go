@@ -1,9 +1,15 @@
 package site
 
 import (
+	"context"
+	"io"
+	"mime"
 	"mime/multipart"
 	"net/http"
+	"os"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"git.sr.ht/~kvo/go-std/errors"
@@ -14,135 +20,236 @@ import (
 // Mux is a platform multiplexer. Methods can be invoked on it to select the
 // platform functions to multiplex, and alternatively to create a multi-platform
 // function call.
+//
+// Methods which aggregate results from several platforms wait for every
+// platform, even if some of them fail. The results from the platforms which
+// succeeded are returned together with an error wrapping a PlatformError,
+// which names the failed platforms; see Partial. If every platform fails, an
+// error which is not partial is returned.
+//
+// If a platform function fails because the user's session with the platform
+// has expired (see ErrExpired), the user is authenticated with the platform
+// again and the function is called once more with the new token.
 type Mux struct {
-	auth      []func(User, chan Pair[[2]string, error])
-	classes   []func(User, chan Pair[[]Class, error])
-	duetasks  []func(User, chan Pair[[]Task, error])
-	events    []func(User, chan Pair[[]Event, error])
-	graded    []func(User, chan Pair[[]Task, error])
-	lessons   func(User, time.Time, time.Time) ([]Lesson, error)
-	messages  []func(User, chan Pair[[]Message, error])
-	remove    map[string]func(User, string, []string) error
-	reports   func(User) ([]Report, error)
-	resource  map[string]func(User, string) (Resource, error)
-	resources map[string]func(User, chan Pair[[]Resource, error], []Class)
-	submit    map[string]func(User, string) error
-	task      map[string]func(User, string) (Task, error)
-	tasks     map[string]func(User, chan Pair[[]Task, error], []Class)
-	upload    map[string]func(User, string, *multipart.Reader) error
-}
+	auth      map[string]func(context.Context, User, chan Pair[[2]string, error])
+	classes   map[string]func(context.Context, User, chan Pair[[]Class, error])
+	duetasks  map[string]func(context.Context, User, chan Pair[[]Task, error])
+	events    map[string]func(context.Context, User, chan Pair[[]Event, error], time.Time, time.Time)
+	file      map[string]func(context.Context, User, string, string) (File, error)
+	fileid    map[string]func(string) (string, bool)
+	graded    map[string]func(context.Context, User, chan Pair[[]Task, error])
+	lessons   map[string]func(context.Context, User, chan Pair[[]Lesson, error], time.Time, time.Time)
+	messages  map[string]func(context.Context, User, chan Pair[[]Message, error])
+	remove    map[string]func(context.Context, User, string, []string) error
+	reports   map[string]func(context.Context, User, chan Pair[[]Report, error])
+	resource  map[string]func(context.Context, User, string) (Resource, error)
+	resources map[string]func(context.Context, User, chan Pair[[]Resource, error], []Class)
+	submit    map[string]func(context.Context, User, string) error
+	task      map[string]func(context.Context, User, string) (Task, error)
+	tasks     map[string]func(context.Context, User, chan Pair[[]Task, error], []Class)
+	upload    map[string]func(context.Context, User, string, *multipart.Reader) error
+
+	// the time allowed for each platform to respond, unless set otherwise for
+	// a particular platform in timeouts
+	deadline time.Duration
+	timeouts map[string]time.Duration
+
+	// called with each token obtained by authenticating a user again
+	onReauth func(Uid, string, string) error
+	reauthMu sync.Mutex
+	reauths  map[reauthKey]*reauthState
+}
+
+// DefaultTimeout is the time allowed for each platform to respond to a
+// multiplexed function call, unless set otherwise with SetTimeout or
+// SetPlatformTimeout.
+const DefaultTimeout = 30 * time.Second
+
+// RequestTimeout is the maximum duration of any single HTTP request made by a
+// platform function. It is a safeguard for requests made with a context that
+// has no deadline; see Mux.SetTimeout.
+const RequestTimeout = 2 * time.Minute
 
 // Return a new instance of Mux.
 func NewMux() *Mux {
 	m := new(Mux)
-	m.remove = make(map[string]func(User, string, []string) error)
-	m.resource = make(map[string]func(User, string) (Resource, error))
-	m.resources = make(map[string]func(User, chan Pair[[]Resource, error], []Class))
-	m.submit = make(map[string]func(User, string) error)
-	m.task = make(map[string]func(User, string) (Task, error))
-	m.tasks = make(map[string]func(User, chan Pair[[]Task, error], []Class))
-	m.upload = make(map[string]func(User, string, *multipart.Reader) error)
+	m.auth = make(map[string]func(context.Context, User, chan Pair[[2]string, error]))
+	m.classes = make(map[string]func(context.Context, User, chan Pair[[]Class, error]))
+	m.duetasks = make(map[string]func(context.Context, User, chan Pair[[]Task, error]))
+	m.events = make(map[string]func(context.Context, User, chan Pair[[]Event, error], time.Time, time.Time))
+	m.file = make(map[string]func(context.Context, User, string, string) (File, error))
+	m.fileid = make(map[string]func(string) (string, bool))
+	m.graded = make(map[string]func(context.Context, User, chan Pair[[]Task, error]))
+	m.lessons = make(map[string]func(context.Context, User, chan Pair[[]Lesson, error], time.Time, time.Time))
+	m.messages = make(map[string]func(context.Context, User, chan Pair[[]Message, error]))
+	m.remove = make(map[string]func(context.Context, User, string, []string) error)
+	m.reports = make(map[string]func(context.Context, User, chan Pair[[]Report, error]))
+	m.resource = make(map[string]func(context.Context, User, string) (Resource, error))
+	m.resources = make(map[string]func(context.Context, User, chan Pair[[]Resource, error], []Class))
+	m.submit = make(map[string]func(context.Context, User, string) error)
+	m.task = make(map[string]func(context.Context, User, string) (Task, error))
+	m.tasks = make(map[string]func(context.Context, User, chan Pair[[]Task, error], []Class))
+	m.upload = make(map[string]func(context.Context, User, string, *multipart.Reader) error)
+	m.deadline = DefaultTimeout
+	m.timeouts = make(map[string]time.Duration)
+	m.reauths = make(map[reauthKey]*reauthState)
 	return m
 }
 
 // AddAuth adds the authentication function f to m for platform authentication
 // multiplexing.
-func (m *Mux) AddAuth(f func(User, chan Pair[[2]string, error])) {
-	m.auth = append(m.auth, f)
+func (m *Mux) AddAuth(platform string, f func(context.Context, User, chan Pair[[2]string, error])) {
+	m.auth[platform] = f
 }
 
 // AddClasses adds the class list retrieval function f to m for platform
 // multiplexing.
-func (m *Mux) AddClasses(f func(User, chan Pair[[]Class, error])) {
-	m.classes = append(m.classes, f)
+func (m *Mux) AddClasses(platform string, f func(context.Context, User, chan Pair[[]Class, error])) {
+	m.classes[platform] = f
 }
 
 // AddDueTasks adds the active tasks retrieval function f to m for platform
 // multiplexing.
-func (m *Mux) AddDueTasks(f func(User, chan Pair[[]Task, error])) {
-	m.duetasks = append(m.duetasks, f)
+func (m *Mux) AddDueTasks(platform string, f func(context.Context, User, chan Pair[[]Task, error])) {
+	m.duetasks[platform] = f
 }
 
 // AddEvents adds the calendar events retrieval function f to m for platform
 // multiplexing.
-func (m *Mux) AddEvents(f func(User, chan Pair[[]Event, error])) {
-	m.events = append(m.events, f)
+func (m *Mux) AddEvents(platform string, f func(context.Context, User, chan Pair[[]Event, error], time.Time, time.Time)) {
+	m.events[platform] = f
+}
+
+// AddFile adds the attachment streaming function f to m for platform
+// multiplexing.
+func (m *Mux) AddFile(platform string, f func(context.Context, User, string, string) (File, error)) {
+	m.file[platform] = f
+}
+
+// AddFileId adds the attachment link parsing function f to m for platform
+// multiplexing.
+func (m *Mux) AddFileId(platform string, f func(string) (string, bool)) {
+	m.fileid[platform] = f
 }
 
 // AddGraded adds the graded tasks retrieval function f to m for platform
 // mulitplexing.
-func (m *Mux) AddGraded(f func(User, chan Pair[[]Task, error])) {
-	m.graded = append(m.graded, f)
+func (m *Mux) AddGraded(platform string, f func(context.Context, User, chan Pair[[]Task, error])) {
+	m.graded[platform] = f
+}
+
+// AddLessons adds the lessons retrieval function f to m for platform
+// multiplexing.
+func (m *Mux) AddLessons(platform string, f func(context.Context, User, chan Pair[[]Lesson, error], time.Time, time.Time)) {
+	m.lessons[platform] = f
 }
 
-// AddMessages adds the unread messages retrieval function f to m for platform
+// AddMessages adds the messages retrieval function f to m for platform
 // multiplexing.
-func (m *Mux) AddMessages(f func(User, chan Pair[[]Message, error])) {
-	m.messages = append(m.messages, f)
+func (m *Mux) AddMessages(platform string, f func(context.Context, User, chan Pair[[]Message, error])) {
+	m.messages[platform] = f
 }
 
 // AddRemoveWork adds the work submission removal function f to m for platform
 // multiplexing.
-func (m *Mux) AddRemoveWork(platform string, f func(User, string, []string) error) {
+func (m *Mux) AddRemoveWork(platform string, f func(context.Context, User, string, []string) error) {
 	m.remove[platform] = f
 }
 
+// AddReports adds the report card retrieval function f to m for platform
+// multiplexing.
+func (m *Mux) AddReports(platform string, f func(context.Context, User, chan Pair[[]Report, error])) {
+	m.reports[platform] = f
+}
+
 // AddResource adds the resource information retrieval function f to m for
 // platform multiplexing.
-func (m *Mux) AddResource(platform string, f func(User, string) (Resource, error)) {
+func (m *Mux) AddResource(platform string, f func(context.Context, User, string) (Resource, error)) {
 	m.resource[platform] = f
 }
 
 // AddResources adds the class resources retrieval function f to m for platform
 // multiplexing.
-func (m *Mux) AddResources(platform string, f func(User, chan Pair[[]Resource, error], []Class)) {
+func (m *Mux) AddResources(platform string, f func(context.Context, User, chan Pair[[]Resource, error], []Class)) {
 	m.resources[platform] = f
 }
 
 // AddSubmit adds the task submission function f to m for platform multiplexing.
-func (m *Mux) AddSubmit(platform string, f func(User, string) error) {
+func (m *Mux) AddSubmit(platform string, f func(context.Context, User, string) error) {
 	m.submit[platform] = f
 }
 
 // AddTask adds the task information retrieval function f to m for platform
 // multiplexing.
-func (m *Mux) AddTask(platform string, f func(User, string) (Task, error)) {
+func (m *Mux) AddTask(platform string, f func(context.Context, User, string) (Task, error)) {
 	m.task[platform] = f
 }
 
 // AddTasks adds the class tasks retrieval function f to m for platform
 // multiplexing.
-func (m *Mux) AddTasks(platform string, f func(User, chan Pair[[]Task, error], []Class)) {
+func (m *Mux) AddTasks(platform string, f func(context.Context, User, chan Pair[[]Task, error], []Class)) {
 	m.tasks[platform] = f
 }
 
 // AddUploadWork adds the work submission upload function f to m for platform
 // multiplexing.
-func (m *Mux) AddUploadWork(platform string, f func(User, string, *multipart.Reader) error) {
+func (m *Mux) AddUploadWork(platform string, f func(context.Context, User, string, *multipart.Reader) error) {
 	m.upload[platform] = f
 }
 
-// SetLessons sets the lessons retrieval function for m as f for platform
-// multiplexing.
-func (m *Mux) SetLessons(f func(User, time.Time, time.Time) ([]Lesson, error)) {
-	m.lessons = f
+// SetTimeout sets the time allowed for each platform multiplexed by m to
+// respond to a function call. Platform functions are called with a context
+// which is cancelled once this time has passed, or when the context passed to
+// the method of m is cancelled, whichever is first.
+func (m *Mux) SetTimeout(d time.Duration) {
+	m.deadline = d
 }
 
-// SetReports sets the report card retrieval function for *m as f for platform
-// multiplexing.
-func (m *Mux) SetReports(f func(User) ([]Report, error)) {
-	m.reports = f
+// SetPlatformTimeout sets the time allowed for the given platform to respond to
+// a function call, overriding the time set with SetTimeout.
+func (m *Mux) SetPlatformTimeout(platform string, d time.Duration) {
+	m.timeouts[platform] = d
+}
+
+// OnReauth sets the function with which m saves the tokens it obtains when it
+// authenticates a user again after their session with a platform has expired.
+// f is called with the user's uid, the platform and the sealed token, and
+// should update the user held by the caller, so that later calls use the new
+// token.
+func (m *Mux) OnReauth(f func(uid Uid, platform, token string) error) {
+	m.onReauth = f
+}
+
+// timeout returns the time allowed for the given platform to respond to a
+// function call. Functions which are not registered for a particular platform
+// use the default time.
+func (m *Mux) timeout(platform string) time.Duration {
+	d, ok := m.timeouts[platform]
+	if !ok {
+		return m.deadline
+	}
+	return d
 }
 
 // Auth attempts to authenticate to all platforms multiplexed by m using the
 // provided *user. Each new platform authentication token returned by each
 // successful authentication attempt is added to *user.SiteTokens
 //
+// Each platform is allowed the time set for it with SetTimeout or
+// SetPlatformTimeout to authenticate the user; a platform which does not
+// respond by then is treated as having failed.
+//
 // An error is returned if no platform multiplexed by m can verify the
-// authenticity of the provided *user. Each platform authentication attempt that
-// fails is logged at debug level.
-func (m *Mux) Auth(user *User) error {
-	ch := make(chan Pair[[2]string, error])
+// authenticity of the provided *user; it is of kind ErrUnauthenticated if any
+// platform rejected the user's credentials. Each platform authentication
+// attempt that fails is logged at debug level.
+//
+// The provided *user must not be sealed. Once authentication is complete, *user
+// is sealed so that it can be held by the caller; all other methods of m expect
+// a sealed user, which is unsealed only for the duration of the platform
+// function call.
+func (m *Mux) Auth(ctx context.Context, user *User) error {
+	ch := make(chan Pair[[2]string, error], len(m.auth))
 	if user == nil {
 		return errors.New(nil, "user is nil")
 	}
@@ -150,269 +257,507 @@ func (m *Mux) Auth(user *User) error {
 	if err != nil {
 		return errors.Wrap(err)
 	}
-	for _, f := range m.auth {
-		go f(*user, ch)
+	// Each platform is given its own copy of the user's tokens, since those
+	// which have not responded in time are left running.
+	for platform, f := range m.auth {
+		u := *user
+		u.SiteTokens = cloneTokens(user.SiteTokens)
+		go func() {
+			ctx, cancel := context.WithTimeout(ctx, m.timeout(platform))
+			defer cancel()
+			c := make(chan Pair[[2]string, error], 1)
+			go f(ctx, u, c)
+			select {
+			case result := <-c:
+				ch <- result
+			case <-ctx.Done():
+				ch <- Pair[[2]string, error]{Second: errors.New(ctx.Err(), "authentication did not complete")}
+			}
+		}()
 	}
-	var errs error
+	tokens := cloneTokens(user.SiteTokens)
+	var errs []error
 	valid := false
 	for range m.auth {
 		result := <-ch
 		token, err := result.First, result.Second
 		if err != nil {
 			logger.Debug(err)
-			errors.Join(errs, err)
+			errs = append(errs, err)
 		} else if !valid {
 			valid = true
 		}
 		if err == nil {
-			user.SiteTokens[token[0]] = token[1]
+			tokens[token[0]] = token[1]
 		}
 	}
+	user.SiteTokens = tokens
+	if !valid && len(errs) > 0 {
+		return authError(errs)
+	}
+	sealed, err := user.Seal()
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	*user = sealed
 	return nil
 }
 
+// authError returns the error with which Auth fails when every platform failed
+// to authenticate the user, with errs. The failure is of the kind shared by
+// errs, or of kind ErrUnauthenticated if any platform rejected the user's
+// credentials.
+func authError(errs []error) error {
+	kind := Kind(errs[0])
+	for _, err := range errs {
+		if Kind(err) == ErrUnauthenticated {
+			kind = ErrUnauthenticated
+			break
+		} else if Kind(err) != kind {
+			kind = nil
+		}
+	}
+	err := errors.New(errors.Join(errs...), "cannot authenticate with any platform")
+	if kind == nil {
+		return err
+	}
+	return Fail(kind, err)
+}
+
 // Classes returns a list of classes from all platforms multiplexed by m.
-func (m *Mux) Classes(user User) ([]Class, error) {
-	var classes []Class
-	ch := make(chan Pair[[]Class, error])
-	for _, f := range m.classes {
-		go f(user, ch)
+func (m *Mux) Classes(ctx context.Context, user User) ([]Class, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
 	}
-	for range m.classes {
-		result := <-ch
-		list, err := result.First, result.Second
-		if err != nil {
-			return nil, errors.New(err, "cannot get class list")
+	calls := make(map[string]func(context.Context, chan Pair[[]Class, error]))
+	for platform, f := range m.classes {
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Class, error]) {
+			c <- call(m, ctx, user, platform, func(user User, c chan Pair[[]Class, error]) {
+				f(ctx, user, c)
+			})
 		}
-		classes = append(classes, list...)
+	}
+	classes, err := gather(ctx, m.timeout, calls)
+	if err != nil {
+		err = errors.New(err, "cannot get class list")
 	}
 	sort.SliceStable(classes, func(i, j int) bool {
 		return classes[i].Name < classes[j].Name
 	})
-	return classes, nil
+	return classes, err
 }
 
 // DueTasks returns a list of active tasks from all platforms multiplexed by m.
-func (m *Mux) DueTasks(user User) ([]Task, error) {
-	var active []Task
-	ch := make(chan Pair[[]Task, error])
-	for _, f := range m.duetasks {
-		go f(user, ch)
+func (m *Mux) DueTasks(ctx context.Context, user User) ([]Task, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
 	}
-	for range m.duetasks {
-		result := <-ch
-		list, err := result.First, result.Second
-		if err != nil {
-			return nil, errors.New(err, "cannot get active task list")
+	calls := make(map[string]func(context.Context, chan Pair[[]Task, error]))
+	for platform, f := range m.duetasks {
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Task, error]) {
+			c <- call(m, ctx, user, platform, func(user User, c chan Pair[[]Task, error]) {
+				f(ctx, user, c)
+			})
 		}
-		active = append(active, list...)
 	}
+	active, err := gather(ctx, m.timeout, calls)
+	if err != nil {
+		err = errors.New(err, "cannot get active task list")
+	}
+	sanitizeTasks(active)
 	sort.SliceStable(active, func(i, j int) bool {
-		return active[i].Due.Before(active[j].Posted)
+		return active[i].Due.Before(active[j].Due)
 	})
-	return active, nil
+	return active, err
 }
 
-// Events returns a list of calendar events from all platforms multiplexed by m.
-func (m *Mux) Events(user User) ([]Event, error) {
-	var events []Event
-	ch := make(chan Pair[[]Event, error])
-	for _, f := range m.events {
-		go f(user, ch)
+// Events returns a list of calendar events occurring from start to end from all
+// platforms multiplexed by m.
+func (m *Mux) Events(ctx context.Context, user User, start, end time.Time) ([]Event, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
 	}
-	for range m.events {
-		result := <-ch
-		list, err := result.First, result.Second
-		if err != nil {
-			return nil, errors.New(err, "cannot get event list")
+	calls := make(map[string]func(context.Context, chan Pair[[]Event, error]))
+	for platform, f := range m.events {
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Event, error]) {
+			c <- call(m, ctx, user, platform, func(user User, c chan Pair[[]Event, error]) {
+				f(ctx, user, c, start, end)
+			})
 		}
-		events = append(events, list...)
+	}
+	events, err := gather(ctx, m.timeout, calls)
+	if err != nil {
+		err = errors.New(err, "cannot get event list")
 	}
 	sort.SliceStable(events, func(i, j int) bool {
 		return events[i].Start.Before(events[j].Start)
 	})
-	return events, nil
+	return events, err
+}
+
+// File returns the attachment specified by id from the given platform, or the
+// part of it given by rng, the Range header of the request for the file, if
+// the platform supports it. The time allowed for the platform to respond only
+// applies until the file is returned; the caller must close its Body, which is
+// streamed from the platform. An error is returned if either the file could
+// not be retrieved or the platform is not supported by the platform
+// multiplexer m.
+func (m *Mux) File(ctx context.Context, user User, platform, id, rng string) (File, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return File{}, errors.Wrap(err)
+	}
+	f, ok := m.file[platform]
+	if !ok {
+		return File{}, errors.Raise(ErrUnsupported)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(m.timeout(platform), cancel)
+	file, err := retry(m, ctx, user, platform, func(user User) (File, error) {
+		return f(ctx, user, id, rng)
+	})
+	if !timer.Stop() && err == nil {
+		file.Body.Close()
+		err = errors.New(context.DeadlineExceeded, "platform did not respond in time")
+	}
+	if err != nil {
+		cancel()
+		return File{}, err
+	}
+	file.Body = &cancelBody{file.Body, cancel}
+	return file, nil
+}
+
+// cancelBody is the body of a file, whose context is cancelled once closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// FileId returns the ID of the attachment of the given platform at link, with
+// which it can be retrieved with File. The result is false if the link is not
+// to an attachment, or the platform cannot stream attachments.
+func (m *Mux) FileId(platform, link string) (string, bool) {
+	f, ok := m.fileid[platform]
+	if !ok {
+		return "", false
+	}
+	if _, ok := m.file[platform]; !ok {
+		return "", false
+	}
+	return f(link)
 }
 
 // Graded returns a list of graded tasks from all platforms multiplexed by m.
-func (m *Mux) Graded(user User) ([]Task, error) {
-	var graded []Task
-	ch := make(chan Pair[[]Task, error])
-	for _, f := range m.graded {
-		go f(user, ch)
+func (m *Mux) Graded(ctx context.Context, user User) ([]Task, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
 	}
-	for range m.graded {
-		result := <-ch
-		list, err := result.First, result.Second
-		if err != nil {
-			return nil, errors.New(err, "cannot get graded task list")
+	calls := make(map[string]func(context.Context, chan Pair[[]Task, error]))
+	for platform, f := range m.graded {
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Task, error]) {
+			c <- call(m, ctx, user, platform, func(user User, c chan Pair[[]Task, error]) {
+				f(ctx, user, c)
+			})
 		}
-		graded = append(graded, list...)
 	}
+	graded, err := gather(ctx, m.timeout, calls)
+	if err != nil {
+		err = errors.New(err, "cannot get graded task list")
+	}
+	sanitizeTasks(graded)
 	sort.SliceStable(graded, func(i, j int) bool {
 		return graded[i].Posted.After(graded[j].Posted)
 	})
-	return graded, nil
+	return graded, err
 }
 
-// Lessons returns a list of lessons occuring from start to end.
-func (m *Mux) Lessons(user User, start, end time.Time) ([]Lesson, error) {
-	if m.lessons == nil {
-		return nil, errors.New(nil, "lessons function not set")
+// Lessons returns a list of lessons occurring from start to end from all
+// platforms multiplexed by m. Each lesson is tagged with the platform it was
+// retrieved from.
+func (m *Mux) Lessons(ctx context.Context, user User, start, end time.Time) ([]Lesson, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	calls := make(map[string]func(context.Context, chan Pair[[]Lesson, error]))
+	for platform, f := range m.lessons {
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Lesson, error]) {
+			result := call(m, ctx, user, platform, func(user User, c chan Pair[[]Lesson, error]) {
+				f(ctx, user, c, start, end)
+			})
+			for i := range result.First {
+				result.First[i].Platform = platform
+			}
+			c <- result
+		}
 	}
-	lessons, err := m.lessons(user, start, end)
+	lessons, err := gather(ctx, m.timeout, calls)
 	if err != nil {
-		return nil, err
+		err = errors.New(err, "cannot get lesson list")
 	}
 	sort.SliceStable(lessons, func(i, j int) bool {
 		return lessons[i].Start.Before(lessons[j].Start)
 	})
-	return lessons, nil
+	return lessons, err
 }
 
-// Messages returns all unread messages from all platforms multiplexed by m.
-func (m *Mux) Messages(user User) ([]Message, error) {
-	var messages []Message
-	ch := make(chan Pair[[]Message, error])
-	for _, f := range m.messages {
-		go f(user, ch)
+// Messages returns all messages, both read and unread, from all platforms
+// multiplexed by m.
+func (m *Mux) Messages(ctx context.Context, user User) ([]Message, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
 	}
-	for range m.messages {
-		result := <-ch
-		list, err := result.First, result.Second
-		if err != nil {
-			return nil, errors.New(err, "cannot get unread message list")
+	calls := make(map[string]func(context.Context, chan Pair[[]Message, error]))
+	for platform, f := range m.messages {
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Message, error]) {
+			c <- call(m, ctx, user, platform, func(user User, c chan Pair[[]Message, error]) {
+				f(ctx, user, c)
+			})
 		}
-		messages = append(messages, list...)
+	}
+	messages, err := gather(ctx, m.timeout, calls)
+	if err != nil {
+		err = errors.New(err, "cannot get message list")
 	}
 	sort.SliceStable(messages, func(i, j int) bool {
 		return messages[i].Sent.After(messages[j].Sent)
 	})
-	return messages, nil
+	return messages, err
 }
 
 // RemoveWork removes the work submissions specified by filenames from the task
 // with given id from the specified platform. An error is returned if either the
 // removal process fails or the platform is not supported by the platform
 // multiplexer m.
-func (m *Mux) RemoveWork(user User, platform, id string, filenames []string) error {
+func (m *Mux) RemoveWork(ctx context.Context, user User, platform, id string, filenames []string) error {
+	user, err := user.Unseal()
+	if err != nil {
+		return errors.Wrap(err)
+	}
 	f, ok := m.remove[platform]
 	if !ok {
-		return errors.New(nil, "unsupported platform")
+		return errors.Raise(ErrUnsupported)
 	}
-	return f(user, id, filenames)
+	ctx, cancel := context.WithTimeout(ctx, m.timeout(platform))
+	defer cancel()
+	_, err = retry(m, ctx, user, platform, func(user User) (struct{}, error) {
+		return struct{}{}, f(ctx, user, id, filenames)
+	})
+	return err
 }
 
-// Reports returns a series of report cards.
-func (m *Mux) Reports(user User) ([]Report, error) {
-	if m.reports == nil {
-		return nil, errors.New(nil, "reports function not set")
+// Reports returns a list of report cards from all platforms multiplexed by m.
+func (m *Mux) Reports(ctx context.Context, user User) ([]Report, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	calls := make(map[string]func(context.Context, chan Pair[[]Report, error]))
+	for platform, f := range m.reports {
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Report, error]) {
+			c <- call(m, ctx, user, platform, func(user User, c chan Pair[[]Report, error]) {
+				f(ctx, user, c)
+			})
+		}
 	}
-	reports, err := m.reports(user)
+	reports, err := gather(ctx, m.timeout, calls)
 	if err != nil {
-		return nil, err
+		err = errors.New(err, "cannot get report list")
 	}
 	sort.SliceStable(reports, func(i, j int) bool {
 		return reports[i].Released.After(reports[j].Released)
 	})
-	return reports, nil
+	return reports, err
 }
 
 // Resource returns the resource specified by id from the given platform. An
 // error is returned if either the task information could not be retrieved or
 // the platform is not supported by the platform multiplexer m.
-func (m *Mux) Resource(user User, platform, id string) (Resource, error) {
+func (m *Mux) Resource(ctx context.Context, user User, platform, id string) (Resource, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return Resource{}, errors.Wrap(err)
+	}
 	f, ok := m.resource[platform]
 	if !ok {
-		return Resource{}, errors.New(nil, "unsupported platform")
+		return Resource{}, errors.Raise(ErrUnsupported)
 	}
-	return f(user, id)
+	ctx, cancel := context.WithTimeout(ctx, m.timeout(platform))
+	defer cancel()
+	res, err := retry(m, ctx, user, platform, func(user User) (Resource, error) {
+		return f(ctx, user, id)
+	})
+	sanitizeResource(&res)
+	return res, err
 }
 
 // Resources returns a list of resources for all specified classes.
-func (m *Mux) Resources(user User, classes ...Class) ([]Resource, error) {
-	var resources []Resource
-	ch := make(chan Pair[[]Resource, error])
+func (m *Mux) Resources(ctx context.Context, user User, classes ...Class) ([]Resource, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
 	classMap := make(map[string][]Class)
 	for _, class := range classes {
 		classMap[class.Platform] = append(classMap[class.Platform], class)
 	}
+	calls := make(map[string]func(context.Context, chan Pair[[]Resource, error]))
 	for platform, courses := range classMap {
-		go m.resources[platform](user, ch, courses)
-	}
-	for range m.tasks {
-		result := <-ch
-		list, err := result.First, result.Second
-		if err != nil {
-			return nil, errors.New(err, "cannot get resources list")
+		f, ok := m.resources[platform]
+		if !ok {
+			calls[platform] = func(ctx context.Context, c chan Pair[[]Resource, error]) {
+				c <- Pair[[]Resource, error]{Second: errors.Raise(ErrUnsupported)}
+			}
+			continue
 		}
-		resources = append(resources, list...)
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Resource, error]) {
+			c <- call(m, ctx, user, platform, func(user User, c chan Pair[[]Resource, error]) {
+				f(ctx, user, c, courses)
+			})
+		}
+	}
+	resources, err := gather(ctx, m.timeout, calls)
+	if err != nil {
+		err = errors.New(err, "cannot get resources list")
 	}
+	sanitizeResources(resources)
 	sort.SliceStable(resources, func(i, j int) bool {
 		return resources[i].Posted.After(resources[j].Posted)
 	})
-	return resources, nil
+	return resources, err
 }
 
 // Submit submits the task with given id from the specified platform. An error
 // is returned if either the submission process fails or the platform is not
 // supported by the platform multiplexer m.
-func (m *Mux) Submit(user User, platform, id string) error {
+func (m *Mux) Submit(ctx context.Context, user User, platform, id string) error {
+	user, err := user.Unseal()
+	if err != nil {
+		return errors.Wrap(err)
+	}
 	f, ok := m.submit[platform]
 	if !ok {
-		return errors.New(nil, "unsupported platform")
+		return errors.Raise(ErrUnsupported)
 	}
-	return f(user, id)
+	ctx, cancel := context.WithTimeout(ctx, m.timeout(platform))
+	defer cancel()
+	_, err = retry(m, ctx, user, platform, func(user User) (struct{}, error) {
+		return struct{}{}, f(ctx, user, id)
+	})
+	return err
 }
 
 // Task returns the task specified by id from the given platform. An error is
 // returned if either the task could not be retrieved or the platform is not
 // supported by the platform multiplexer m.
-func (m *Mux) Task(user User, platform, id string) (Task, error) {
+func (m *Mux) Task(ctx context.Context, user User, platform, id string) (Task, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return Task{}, errors.Wrap(err)
+	}
 	f, ok := m.task[platform]
 	if !ok {
-		return Task{}, errors.New(nil, "unsupported platform")
+		return Task{}, errors.Raise(ErrUnsupported)
 	}
-	return f(user, id)
+	ctx, cancel := context.WithTimeout(ctx, m.timeout(platform))
+	defer cancel()
+	task, err := retry(m, ctx, user, platform, func(user User) (Task, error) {
+		return f(ctx, user, id)
+	})
+	sanitizeTask(&task)
+	return task, err
 }
 
 // Tasks returns a list of tasks for all specified classes.
-func (m *Mux) Tasks(user User, classes ...Class) ([]Task, error) {
-	var tasks []Task
-	ch := make(chan Pair[[]Task, error])
+func (m *Mux) Tasks(ctx context.Context, user User, classes ...Class) ([]Task, error) {
+	user, err := user.Unseal()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
 	classMap := make(map[string][]Class)
 	for _, class := range classes {
 		classMap[class.Platform] = append(classMap[class.Platform], class)
 	}
+	calls := make(map[string]func(context.Context, chan Pair[[]Task, error]))
 	for platform, courses := range classMap {
-		go m.tasks[platform](user, ch, courses)
-	}
-	for range m.tasks {
-		result := <-ch
-		list, err := result.First, result.Second
-		if err != nil {
-			return nil, errors.New(err, "cannot get tasks list")
+		f, ok := m.tasks[platform]
+		if !ok {
+			calls[platform] = func(ctx context.Context, c chan Pair[[]Task, error]) {
+				c <- Pair[[]Task, error]{Second: errors.Raise(ErrUnsupported)}
+			}
+			continue
+		}
+		calls[platform] = func(ctx context.Context, c chan Pair[[]Task, error]) {
+			c <- call(m, ctx, user, platform, func(user User, c chan Pair[[]Task, error]) {
+				f(ctx, user, c, courses)
+			})
 		}
-		tasks = append(tasks, list...)
 	}
+	tasks, err := gather(ctx, m.timeout, calls)
+	if err != nil {
+		err = errors.New(err, "cannot get tasks list")
+	}
+	sanitizeTasks(tasks)
 	sort.SliceStable(tasks, func(i, j int) bool {
 		return tasks[i].Posted.After(tasks[j].Posted)
 	})
-	return tasks, nil
+	return tasks, err
 }
 
 // UploadWork uploads all files in request r as work submissions for the task
 // with given id for the specified platform. An error is returned if either the
 // upload process fails or the platform is not supported by the platform
 // multiplexer m.
-func (m *Mux) UploadWork(user User, platform, id string, r *http.Request) error {
+//
+// Since the body of r can only be read once, the part of it read by the upload
+// is copied to a temporary file, so that the upload can be retried if the
+// user's session with the platform has expired.
+func (m *Mux) UploadWork(ctx context.Context, user User, platform, id string, r *http.Request) error {
+	user, err := user.Unseal()
+	if err != nil {
+		return errors.Wrap(err)
+	}
 	f, ok := m.upload[platform]
 	if !ok {
-		return errors.New(nil, "unsupported platform")
+		return errors.Raise(ErrUnsupported)
 	}
-	files, err := r.MultipartReader()
-	if err != nil {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
 		return errors.New(err, "cannot parse multipart MIME")
 	}
-	return f(user, id, files)
+	spool, err := os.CreateTemp("", "taskcollect-upload-*")
+	if err != nil {
+		return errors.New(err, "cannot create upload spool file")
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout(platform))
+	defer cancel()
+	retried := false
+	_, err = retry(m, ctx, user, platform, func(user User) (struct{}, error) {
+		body := io.TeeReader(r.Body, spool)
+		if retried {
+			_, err := spool.Seek(0, io.SeekStart)
+			if err != nil {
+				return struct{}{}, errors.New(err, "cannot replay upload")
+			}
+			body = io.MultiReader(spool, r.Body)
+		}
+		retried = true
+		return struct{}{}, f(ctx, user, id, multipart.NewReader(body, params["boundary"]))
+	})
+	return err
 }
@@ -0,0 +1,275 @@
+package site
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// expiring is a platform whose sessions expire until the user authenticates
+// again.
+type expiring struct {
+	logins *atomic.Int32
+	// the files uploaded with the renewed token
+	uploads *[]string
+}
+
+func (expiring) Name() string {
+	return "expiring"
+}
+
+func (p expiring) Auth(ctx context.Context, user User, c chan Pair[[2]string, error]) {
+	p.logins.Add(1)
+	c <- Pair[[2]string, error]{First: [2]string{"expiring", "renewed"}}
+}
+
+func (expiring) Task(ctx context.Context, user User, id string) (Task, error) {
+	if user.SiteTokens["expiring"] != "renewed" {
+		return Task{}, errors.New(errors.Raise(ErrExpired), "cannot get task")
+	}
+	return Task{Id: id}, nil
+}
+
+// UploadWork reads the first file before finding that the session has expired,
+// as a platform which streams uploads would.
+func (p expiring) UploadWork(ctx context.Context, user User, id string, files *multipart.Reader) error {
+	for {
+		part, err := files.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b, err := io.ReadAll(part)
+		if err != nil {
+			return err
+		}
+		if user.SiteTokens["expiring"] != "renewed" {
+			return errors.New(errors.Raise(ErrExpired), "cannot upload work")
+		}
+		*p.uploads = append(*p.uploads, part.FileName()+": "+string(b))
+	}
+}
+
+func TestReauth(t *testing.T) {
+	err := LoadKey(filepath.Join(t.TempDir(), "secret.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := expiring{new(atomic.Int32), new([]string)}
+	m := NewMux()
+	m.Add(p)
+	var saved []string
+	m.OnReauth(func(uid Uid, platform, token string) error {
+		saved = append(saved, platform)
+		plain, err := unseal(token)
+		if err != nil || plain != "renewed" {
+			t.Errorf("saved token %q, want sealed token", token)
+		}
+		return nil
+	})
+
+	user, err := User{
+		School:     "school",
+		Username:   "student",
+		SiteTokens: map[string]string{"expiring": "expired"},
+	}.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := m.Task(context.Background(), user, "expiring", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Id != "1234" {
+		t.Errorf("got task %q, want 1234", task.Id)
+	}
+	if len(saved) != 1 || saved[0] != "expiring" {
+		t.Errorf("saved tokens for %v, want [expiring]", saved)
+	}
+
+	// A second call with the expired token reuses the renewed token.
+	_, err = m.Task(context.Background(), user, "expiring", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.logins.Load(); n != 1 {
+		t.Errorf("authenticated %d times, want 1", n)
+	}
+
+	// The state of the authentication is removed once its token is too
+	// old to be reused, when another authentication finishes.
+	m.reauthMu.Lock()
+	for _, state := range m.reauths {
+		state.renewed = time.Now().Add(-reuseWindow)
+	}
+	m.reauthMu.Unlock()
+	other := user
+	other.Username = "other"
+	_, err = m.Task(context.Background(), other, "expiring", "1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.reauthMu.Lock()
+	defer m.reauthMu.Unlock()
+	if _, ok := m.reauths[reauthKey{Uid{"school", "student"}, "expiring"}]; ok || len(m.reauths) != 1 {
+		t.Errorf("kept %d authentication states, want only the other user's", len(m.reauths))
+	}
+}
+
+func TestUploadWorkRetry(t *testing.T) {
+	err := LoadKey(filepath.Join(t.TempDir(), "secret.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := expiring{new(atomic.Int32), new([]string)}
+	m := NewMux()
+	m.Add(p)
+	user, err := User{
+		School:     "school",
+		Username:   "student",
+		SiteTokens: map[string]string{"expiring": "expired"},
+	}.Seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, name := range []string{"essay.docx", "notes.txt"} {
+		part, err := w.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(strings.Repeat(name, 1000)))
+	}
+	w.Close()
+	r := httptest.NewRequest("POST", "/upload", &body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+
+	// The upload is sent again once the user has authenticated again.
+	err = m.UploadWork(context.Background(), user, "expiring", "1234", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := p.logins.Load(); n != 1 {
+		t.Errorf("authenticated %d times, want 1", n)
+	}
+	want := []string{
+		"essay.docx: " + strings.Repeat("essay.docx", 1000),
+		"notes.txt: " + strings.Repeat("notes.txt", 1000),
+	}
+	if len(*p.uploads) != len(want) || (*p.uploads)[0] != want[0] || (*p.uploads)[1] != want[1] {
+		t.Errorf("uploaded %d files, want essay.docx and notes.txt in full", len(*p.uploads))
+	}
+}
+
+// lingering is a platform which keeps using the user's tokens after failing to
+// authenticate them in time.
+type lingering struct {
+	done chan struct{}
+}
+
+func (lingering) Name() string {
+	return "lingering"
+}
+
+func (p lingering) Auth(ctx context.Context, user User, c chan Pair[[2]string, error]) {
+	defer close(p.done)
+	<-ctx.Done()
+	for i := 0; i < 100; i++ {
+		user.SiteTokens["lingering"] = user.SiteTokens["expiring"]
+	}
+	c <- Pair[[2]string, error]{Second: ctx.Err()}
+}
+
+func TestAuthDeadline(t *testing.T) {
+	err := LoadKey(filepath.Join(t.TempDir(), "secret.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slow := lingering{make(chan struct{})}
+	m := NewMux()
+	m.Add(expiring{new(atomic.Int32), new([]string)})
+	m.Add(slow)
+	m.SetTimeout(10 * time.Millisecond)
+
+	user := User{
+		School:     "school",
+		Username:   "student",
+		SiteTokens: map[string]string{},
+	}
+	err = m.Auth(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-slow.done
+	user, err = user.Unseal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.SiteTokens["expiring"] != "renewed" {
+		t.Errorf("got token %q, want renewed", user.SiteTokens["expiring"])
+	}
+	if _, ok := user.SiteTokens["lingering"]; ok {
+		t.Errorf("token written after the deadline was kept")
+	}
+}
+
+// slow is a platform which takes some time to authenticate the user.
+type slow struct {
+	delay time.Duration
+}
+
+func (slow) Name() string {
+	return "slow"
+}
+
+func (p slow) Auth(ctx context.Context, user User, c chan Pair[[2]string, error]) {
+	select {
+	case <-time.After(p.delay):
+		c <- Pair[[2]string, error]{First: [2]string{"slow", "token"}}
+	case <-ctx.Done():
+		c <- Pair[[2]string, error]{Second: ctx.Err()}
+	}
+}
+
+func TestAuthPlatformTimeout(t *testing.T) {
+	err := LoadKey(filepath.Join(t.TempDir(), "secret.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewMux()
+	m.Add(expiring{new(atomic.Int32), new([]string)})
+	m.Add(slow{50 * time.Millisecond})
+	m.SetTimeout(10 * time.Millisecond)
+	m.SetPlatformTimeout("slow", time.Second)
+
+	// A platform given longer than the others to respond is waited for.
+	user := User{
+		School:     "school",
+		Username:   "student",
+		SiteTokens: map[string]string{},
+	}
+	err = m.Auth(context.Background(), &user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err = user.Unseal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.SiteTokens["slow"] != "token" || user.SiteTokens["expiring"] != "renewed" {
+		t.Errorf("got tokens %v, want those of both platforms", user.SiteTokens)
+	}
+}
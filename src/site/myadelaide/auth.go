@@ -2,6 +2,7 @@ package myadelaide
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
@@ -120,7 +121,7 @@ func mkchallenge(verifier string) (string, error) {
 // fetch is vulnerable to obsoletion due to changes in the MyAdelaide interface.
 // More importantly fetch should NOT be run more frequently than once in 300s or
 // errors may be encountered.
-func fetch(link, username, password, key string) (string, string, error) {
+func (p platform) fetch(ctx context.Context, link, username, password, key string) (string, string, error) {
 	// Stage 1 - Request redirect info from MyAdelaide.
 
 	// A persistent cookie jar is required for the entire process.
@@ -128,9 +129,9 @@ func fetch(link, username, password, key string) (string, string, error) {
 	// TODO: Fetch a random valid user agent from a curated list.
 	browser := "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/127.0"
 
-	myadelaideUrl := url.URL{
-		Scheme: "https",
-		Host:   "myadelaide.uni.adelaide.edu.au",
+	myadelaideUrl, err := url.Parse(p.base)
+	if err != nil {
+		return "", "", errors.New(err, "cannot parse MyAdelaide URL")
 	}
 
 	jar, err := cookiejar.New(nil)
@@ -138,9 +139,14 @@ func fetch(link, username, password, key string) (string, string, error) {
 		return "", "", errors.New(err, "cannot create stage 1 cookie jar")
 	}
 
-	client := &http.Client{Jar: jar}
+	client := &http.Client{Jar: jar, Timeout: site.RequestTimeout}
+
+	s1req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
+	if err != nil {
+		return "", "", errors.New(err, "cannot create stage 1 request")
+	}
 
-	_, err = client.Get(link)
+	_, err = client.Do(s1req)
 	if err != nil {
 		return "", "", errors.New(err, "stage 1 request failed")
 	}
@@ -165,23 +171,23 @@ func fetch(link, username, password, key string) (string, string, error) {
 		return "", "", errors.New(nil, "cannot make stage 2 state token")
 	}
 
-	s2url := "https://id.adelaide.edu.au/oauth2/default/v1/authorize?"
+	s2url := p.id + "/oauth2/default/v1/authorize?"
 	s2url += "client_id=0oaiku3xxvUYEFpAR3l6&"
 	s2url += fmt.Sprintf("code_challenge=%s&", s2challenge)
 	s2url += "code_challenge_method=S256&"
 	s2url += fmt.Sprintf("nonce=%s&", s2nonce)
-	s2url += "redirect_uri=https%3A%2F%2Fmyadelaide.uni.adelaide.edu.au&"
+	s2url += "redirect_uri=" + url.QueryEscape(p.base) + "&"
 	s2url += "response_mode=fragment&"
 	s2url += "response_type=code&"
 	s2url += fmt.Sprintf("state=%s&", s2state)
 	s2url += "scope=openid+email+profile"
 
-	s2req, err := http.NewRequest("GET", s2url, nil)
+	s2req, err := http.NewRequestWithContext(ctx, "GET", s2url, nil)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 2 request")
 	}
 
-	s2req.Header.Set("Referer", "https://myadelaide.uni.adelaide.edu.au/")
+	s2req.Header.Set("Referer", p.base+"/")
 	s2req.Header.Set("User-Agent", browser)
 
 	s2, err := client.Do(s2req)
@@ -208,7 +214,7 @@ func fetch(link, username, password, key string) (string, string, error) {
 		return "", "", errors.New(err, "cannot unquote stage 3 state token")
 	}
 
-	s3url := "https://id.adelaide.edu.au/idp/idx/introspect"
+	s3url := p.id + "/idp/idx/introspect"
 	s3tmpl := `{"stateToken":%s}`
 	s3jstate, err := json.Marshal(s3state)
 	if err != nil {
@@ -216,14 +222,14 @@ func fetch(link, username, password, key string) (string, string, error) {
 	}
 	s3data := strings.NewReader(fmt.Sprintf(s3tmpl, string(s3jstate)))
 
-	s3req, err := http.NewRequest("POST", s3url, s3data)
+	s3req, err := http.NewRequestWithContext(ctx, "POST", s3url, s3data)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 3 request")
 	}
 
 	s3req.Header.Set("Accept", `application/ion+json; okta-version=1.0.0`)
 	s3req.Header.Set("Content-Type", `application/ion+json; okta-version=1.0.0`)
-	s3req.Header.Set("Origin", "https://id.adelaide.edu.au")
+	s3req.Header.Set("Origin", p.id)
 	s3req.Header.Set("User-Agent", browser)
 	s3req.Header.Set("X-Okta-User-Agent-Extended", "okta-auth-js/7.7.0 okta-signin-widget-7.20.1")
 
@@ -234,16 +240,16 @@ func fetch(link, username, password, key string) (string, string, error) {
 
 	// Stage 4 - POST to Okta nonce.
 
-	s4url := "https://id.adelaide.edu.au/api/v1/internal/device/nonce"
-	s4req, err := http.NewRequest("POST", s4url, nil)
+	s4url := p.id + "/api/v1/internal/device/nonce"
+	s4req, err := http.NewRequestWithContext(ctx, "POST", s4url, nil)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 4 request")
 	}
 
 	s4req.Header.Set("Accept", `*/*`)
 	s4req.Header.Set("Content-Type", `application/json`)
-	s4req.Header.Set("Origin", `https://id.adelaide.edu.au`)
-	s4req.Header.Set("Referer", `https://id.adelaide.edu.au/auth/services/devicefingerprint`)
+	s4req.Header.Set("Origin", p.id)
+	s4req.Header.Set("Referer", p.id+"/auth/services/devicefingerprint")
 	s4req.Header.Set("User-Agent", browser)
 	s4req.Header.Set("X-Requested-With", `XMLHttpRequest`)
 
@@ -280,16 +286,16 @@ func fetch(link, username, password, key string) (string, string, error) {
 		return "", "", errors.New(err, "cannot marshal stage 5 form")
 	}
 
-	s5url := "https://id.adelaide.edu.au/idp/idx/identify"
-	s5req, err := http.NewRequest("POST", s5url, bytes.NewReader(s5data))
+	s5url := p.id + "/idp/idx/identify"
+	s5req, err := http.NewRequestWithContext(ctx, "POST", s5url, bytes.NewReader(s5data))
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 5 request")
 	}
 
 	s5req.Header.Set("Accept", `application/json; okta-version=1.0.0`)
 	s5req.Header.Set("Content-Type", `application/json`)
-	s5req.Header.Set("Origin", `https://id.adelaide.edu.au`)
-	s5req.Header.Set("Referer", `https://id.adelaide.edu.au/auth/services/devicefingerprint`)
+	s5req.Header.Set("Origin", p.id)
+	s5req.Header.Set("Referer", p.id+"/auth/services/devicefingerprint")
 	s5req.Header.Set("User-Agent", browser)
 	s5req.Header.Set("X-Device-Fingerprint", s5finger)
 	s5req.Header.Set("X-Okta-User-Agent-Extended", `okta-auth-js/7.7.0 okta-signin-widget-7.20.1`)
@@ -317,24 +323,29 @@ func fetch(link, username, password, key string) (string, string, error) {
 		return "", "", errors.New(err, "cannot marshal stage 6 form")
 	}
 
-	s6url := "https://id.adelaide.edu.au/idp/idx/challenge/answer"
-	s6req, err := http.NewRequest("POST", s6url, bytes.NewReader(s6data))
+	s6url := p.id + "/idp/idx/challenge/answer"
+	s6req, err := http.NewRequestWithContext(ctx, "POST", s6url, bytes.NewReader(s6data))
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 6 request")
 	}
 
 	s6req.Header.Set("Accept", `application/json; okta-version=1.0.0`)
 	s6req.Header.Set("Content-Type", `application/json`)
-	s6req.Header.Set("Origin", `https://id.adelaide.edu.au`)
+	s6req.Header.Set("Origin", p.id)
 	s6req.Header.Set("User-Agent", browser)
 	s6req.Header.Set("X-Device-Fingerprint", s5finger)
 	s6req.Header.Set("X-Okta-User-Agent-Extended", `okta-auth-js/7.7.0 okta-signin-widget-7.20.1`)
 
-	_, err = client.Do(s6req)
+	s6, err := client.Do(s6req)
 	if err != nil {
 		return "", "", errors.New(err, "cannot execute stage 6 request")
 	}
 
+	// Okta rejects an incorrect password with status 401.
+	if s6.StatusCode == http.StatusUnauthorized {
+		return "", "", errors.New(errors.Raise(site.ErrUnauthenticated), "stage 6 request was rejected")
+	}
+
 	// Stage 7 - POST to Okta answer (again).
 
 	s7mfa, err := mkcode(key)
@@ -347,15 +358,15 @@ func fetch(link, username, password, key string) (string, string, error) {
 		return "", "", errors.New(err, "cannot marshal stage 7 form")
 	}
 
-	s7url := "https://id.adelaide.edu.au/idp/idx/challenge/answer"
-	s7req, err := http.NewRequest("POST", s7url, bytes.NewReader(s7data))
+	s7url := p.id + "/idp/idx/challenge/answer"
+	s7req, err := http.NewRequestWithContext(ctx, "POST", s7url, bytes.NewReader(s7data))
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 7 request")
 	}
 
 	s7req.Header.Set("Accept", `application/json; okta-version=1.0.0`)
 	s7req.Header.Set("Content-Type", `application/json`)
-	s7req.Header.Set("Origin", `https://id.adelaide.edu.au`)
+	s7req.Header.Set("Origin", p.id)
 	s7req.Header.Set("User-Agent", browser)
 	s7req.Header.Set("X-Device-Fingerprint", s5finger)
 	s7req.Header.Set("X-Okta-User-Agent-Extended", `okta-auth-js/7.7.0 okta-signin-widget-7.20.1`)
@@ -379,22 +390,23 @@ func fetch(link, username, password, key string) (string, string, error) {
 	s8params.Value += s2state + `%22%2C%22nonce%22:%22` + s2nonce
 	s8params.Value += `%22%2C%22scopes%22:[%22openid%22%2C%22email%22%2C%22profile%22]%2C%22clientId`
 	s8params.Value += `%22:%220oaiku3xxvUYEFpAR3l6%22%2C%22urls%22:{%22issuer%22:%22`
-	s8params.Value += `https://adelaide.okta.com/oauth2/default%22%2C%22authorizeUrl%22:%22`
-	s8params.Value += `https://id.adelaide.edu.au/oauth2/default/v1/authorize%22%2C%22userinfoUrl%22`
-	s8params.Value += `:%22https://id.adelaide.edu.au/oauth2/default/v1/userinfo%22%2C%22tokenUrl%22:`
-	s8params.Value += `%22https://adelaide.okta.com/oauth2/default/v1/token%22%2C%22revokeUrl%22:%22`
-	s8params.Value += `https://adelaide.okta.com/oauth2/default/v1/revoke%22%2C%22logoutUrl%22:%22`
-	s8params.Value += `https://adelaide.okta.com/oauth2/default/v1/logout%22}%2C%22ignoreSignature%22:false}`
+	s8params.Value += p.okta + `/oauth2/default%22%2C%22authorizeUrl%22:%22`
+	s8params.Value += p.id + `/oauth2/default/v1/authorize%22%2C%22userinfoUrl%22`
+	s8params.Value += `:%22` + p.id + `/oauth2/default/v1/userinfo%22%2C%22tokenUrl%22:`
+	s8params.Value += `%22` + p.okta + `/oauth2/default/v1/token%22%2C%22revokeUrl%22:%22`
+	s8params.Value += p.okta + `/oauth2/default/v1/revoke%22%2C%22logoutUrl%22:%22`
+	s8params.Value += p.okta + `/oauth2/default/v1/logout%22}%2C%22ignoreSignature%22:false}`
 	s8nonce.Name, s8nonce.Value = `okta-oauth-nonce`, s2nonce
 	s8state.Name, s8state.Value = `okta-oauth-state`, s2state
 	s8cookies = append(s8cookies, s8params, s8nonce, s8state)
-	jar.SetCookies(&myadelaideUrl, s8cookies)
+	jar.SetCookies(myadelaideUrl, s8cookies)
 
 	noredirect := &http.Client{
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
-		Jar: jar,
+		Jar:     jar,
+		Timeout: site.RequestTimeout,
 	}
 
 	s8url := s8json{}
@@ -403,7 +415,7 @@ func fetch(link, username, password, key string) (string, string, error) {
 		return "", "", errors.New(err, "cannot unmarshal stage 8 url")
 	}
 
-	s8req, err := http.NewRequest("GET", s8url.Success.Href, nil)
+	s8req, err := http.NewRequestWithContext(ctx, "GET", s8url.Success.Href, nil)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 8 request")
 	}
@@ -416,7 +428,7 @@ func fetch(link, username, password, key string) (string, string, error) {
 	}
 	s8loc := s8.Header.Get("location")
 
-	s8req, err = http.NewRequest("GET", link, nil)
+	s8req, err = http.NewRequestWithContext(ctx, "GET", link, nil)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create redirected stage 8 request")
 	}
@@ -430,8 +442,8 @@ func fetch(link, username, password, key string) (string, string, error) {
 
 	// Stage 9 - Request token options from Adelaide Okta.
 
-	s9url := "https://adelaide.okta.com/oauth2/default/v1/token"
-	s9req, err := http.NewRequest("OPTIONS", s9url, nil)
+	s9url := p.okta + "/oauth2/default/v1/token"
+	s9req, err := http.NewRequestWithContext(ctx, "OPTIONS", s9url, nil)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 9 request")
 	}
@@ -439,8 +451,8 @@ func fetch(link, username, password, key string) (string, string, error) {
 	s9req.Header.Set("Accept", `*/*`)
 	s9req.Header.Set("Access-Control-Request-Headers", `x-okta-user-agent-extended`)
 	s9req.Header.Set("Access-Control-Request-Method", `POST`)
-	s9req.Header.Set("Origin", `https://myadelaide.uni.adelaide.edu.au`)
-	s9req.Header.Set("Referer", `https://myadelaide.uni.adelaide.edu.au/`)
+	s9req.Header.Set("Origin", p.base)
+	s9req.Header.Set("Referer", p.base+"/")
 	s9req.Header.Set("User-Agent", browser)
 
 	_, err = client.Do(s9req)
@@ -465,15 +477,15 @@ func fetch(link, username, password, key string) (string, string, error) {
 	s10form.Add("code", s10loc)
 	s10data := strings.NewReader(s10form.Encode())
 
-	s10req, err := http.NewRequest("POST", s9url, s10data)
+	s10req, err := http.NewRequestWithContext(ctx, "POST", s9url, s10data)
 	if err != nil {
 		return "", "", errors.New(err, "cannot create stage 10 request")
 	}
 
 	s10req.Header.Set("Accept", `application/json`)
 	s10req.Header.Set("Content-Type", `application/x-www-form-urlencoded`)
-	s10req.Header.Set("Origin", `https://myadelaide.uni.adelaide.edu.au`)
-	s10req.Header.Set("Referer", `https://myadelaide.uni.adelaide.edu.au/`)
+	s10req.Header.Set("Origin", p.base)
+	s10req.Header.Set("Referer", p.base+"/")
 	s10req.Header.Set("User-Agent", browser)
 	s10req.Header.Set("X-Okta-User-Agent-Extended", `@okta/okta-vue/3.1.0 okta-auth-js/4.9.2`)
 
@@ -500,7 +512,7 @@ func fetch(link, username, password, key string) (string, string, error) {
 	return s10page, s10bearer.Token, nil
 }
 
-func Auth(user site.User, c chan site.Pair[[2]string, error]) {
+func (p platform) Auth(ctx context.Context, user site.User, c chan site.Pair[[2]string, error]) {
 	var result site.Pair[[2]string, error]
 	cfg, ok := user.Config["myadelaide"]
 	if !ok {
@@ -508,8 +520,8 @@ func Auth(user site.User, c chan site.Pair[[2]string, error]) {
 		c <- result
 		return
 	}
-	link := "https://myadelaide.uni.adelaide.edu.au"
-	_, token, err := fetch(link, user.Username, user.Password, cfg.HotpKey)
+	link := p.base
+	_, token, err := p.fetch(ctx, link, user.Username, user.Password, cfg.HotpKey)
 	if err != nil {
 		result.Second = errors.New(err, "myadelaide login failed")
 		c <- result
@@ -1,6 +1,7 @@
 package myadelaide
 
 import (
+	"context"
 	"encoding/json"
 	"main/site"
 	"math"
@@ -65,12 +66,12 @@ type s2struct struct {
 	} `json:"data"`
 }
 
-func semester(user site.User) ([]site.Lesson, error) {
+func (p platform) semester(ctx context.Context, user site.User) ([]site.Lesson, error) {
 	var lessons []site.Lesson
-	client := &http.Client{}
-	s1link := "https://api.adelaide.edu.au/api/generic-query-structured/v1/?target=/system/TIMETABLE_TERMS/queryx/" + user.Username[1:] + "&MaxRows=9999"
+	client := &http.Client{Timeout: site.RequestTimeout}
+	s1link := p.api + "/api/generic-query-structured/v1/?target=/system/TIMETABLE_TERMS/queryx/" + user.Username[1:] + "&MaxRows=9999"
 
-	s1req, err := http.NewRequest("GET", s1link, nil)
+	s1req, err := http.NewRequestWithContext(ctx, "GET", s1link, nil)
 	if err != nil {
 		return nil, errors.New(err, "cannot create stage 1 request")
 	}
@@ -79,24 +80,28 @@ func semester(user site.User) ([]site.Lesson, error) {
 	s1req.Header.Set("Accept-Encoding", "gzip, deflate, br")
 	s1req.Header.Set("Authorization", "Bearer "+user.SiteTokens["myadelaide"])
 	s1req.Header.Set("Connection", "keep-alive")
-	s1req.Header.Set("Host", "api.adelaide.edu.au")
-	s1req.Header.Set("Referer", "https://myadelaide.uni.adelaide.edu.au/")
+	s1req.Header.Set("Referer", p.base+"/")
 
 	s1, err := client.Do(s1req)
 	if err != nil {
 		return nil, errors.New(err, "cannot execute stage 1 request")
 	}
 
+	err = checkResponse(s1)
+	if err != nil {
+		return nil, err
+	}
+
 	s1json := s1struct{}
 	err = json.NewDecoder(s1.Body).Decode(&s1json)
 	if err != nil {
-		return nil, errors.New(err, "cannot decode stage 1 json")
+		return nil, errors.New(site.Fail(site.ErrParse, err), "cannot decode stage 1 json")
 	}
 
 	s1strm := s1json.Data.Query.Rows[0].Strm
 
-	s2link := "https://api.adelaide.edu.au/api/generic-query-structured/v1/?target=/system/TIMETABLE_LIST/queryx/" + user.Username[1:] + "," + s1strm + "&MaxRows=9999"
-	s2req, err := http.NewRequest("GET", s2link, nil)
+	s2link := p.api + "/api/generic-query-structured/v1/?target=/system/TIMETABLE_LIST/queryx/" + user.Username[1:] + "," + s1strm + "&MaxRows=9999"
+	s2req, err := http.NewRequestWithContext(ctx, "GET", s2link, nil)
 	if err != nil {
 		return nil, errors.New(err, "cannot create stage 2 request")
 	}
@@ -105,18 +110,22 @@ func semester(user site.User) ([]site.Lesson, error) {
 	s2req.Header.Set("Accept-Encoding", "gzip, deflate, br")
 	s2req.Header.Set("Authorization", "Bearer "+user.SiteTokens["myadelaide"])
 	s2req.Header.Set("Connection", "keep-alive")
-	s2req.Header.Set("Host", "api.adelaide.edu.au")
-	s2req.Header.Set("Referer", "https://myadelaide.uni.adelaide.edu.au/")
+	s2req.Header.Set("Referer", p.base+"/")
 
 	s2, err := client.Do(s2req)
 	if err != nil {
 		return nil, errors.New(err, "cannot execute stage 2 request")
 	}
 
+	err = checkResponse(s2)
+	if err != nil {
+		return nil, err
+	}
+
 	s2lessons := s2struct{}
 	err = json.NewDecoder(s2.Body).Decode(&s2lessons)
 	if err != nil {
-		return nil, errors.New(err, "cannot decode stage 2 json")
+		return nil, errors.New(site.Fail(site.ErrParse, err), "cannot decode stage 2 json")
 	}
 
 	for _, lesson := range s2lessons.Data.Query.Rows {
@@ -124,16 +133,16 @@ func semester(user site.User) ([]site.Lesson, error) {
 		startStr := lesson.StartDate + " " + lesson.StartTime
 		start, err := time.ParseInLocation("2006-01-02 3:04 PM", startStr, user.Timezone)
 		if err != nil {
-			return nil, errors.New(err, "cannot parse time")
+			return nil, errors.New(site.Fail(site.ErrParse, err), "cannot parse time")
 		}
 		endStr := lesson.StartDate + " " + lesson.EndTime
 		end, err := time.ParseInLocation("2006-01-02 3:04 PM", endStr, user.Timezone)
 		if err != nil {
-			return nil, errors.New(err, "cannot parse time")
+			return nil, errors.New(site.Fail(site.ErrParse, err), "cannot parse time")
 		}
 		finalDate, err := time.ParseInLocation("2006-01-02", lesson.EndDate, user.Timezone)
 		if err != nil {
-			return nil, errors.New(err, "failed to parse date")
+			return nil, errors.New(site.Fail(site.ErrParse, err), "failed to parse date")
 		}
 		if today.After(finalDate) {
 			continue
@@ -141,12 +150,13 @@ func semester(user site.User) ([]site.Lesson, error) {
 		numLessons := int(finalDate.UnixMilli()-midnight(start).UnixMilli())/(7*24*60*60*1000) + 1
 		for i := 0; i < numLessons; i++ {
 			lessons = append(lessons, site.Lesson{
-				Start:   start.AddDate(0, 0, 7*i),
-				End:     end.AddDate(0, 0, 7*i),
-				Class:   lesson.SubjectDescription,
-				Teacher: lesson.Type,
-				Notice:  "",
-				Room:    lesson.Location + " " + lesson.Room + " " + lesson.RoomDescription,
+				Start:    start.AddDate(0, 0, 7*i),
+				End:      end.AddDate(0, 0, 7*i),
+				Class:    lesson.SubjectDescription,
+				Teacher:  lesson.Type,
+				Notice:   "",
+				Room:     lesson.Location + " " + lesson.Room + " " + lesson.RoomDescription,
+				Platform: "myadelaide",
 			})
 		}
 	}
@@ -186,17 +196,17 @@ type Week struct {
 // weeks returns all lessons for user that occur on the weeks corresponding to
 // each delta. A delta is an offset (in days) that points to the start of the
 // required week (Monday). An error is returned instead if one occurs.
-func weeks(user site.User, deltas ...int) ([]site.Lesson, error) {
+func (p platform) weeks(ctx context.Context, user site.User, deltas ...int) ([]site.Lesson, error) {
 	var lessons []site.Lesson
 
 	for i, value := range deltas {
-		client := &http.Client{}
+		client := &http.Client{Timeout: site.RequestTimeout}
 		if i != 0 && deltas[i] <= deltas[i-1] {
 			break
 		}
 
-		link := "https://api.adelaide.edu.au/api/generic-query-structured/v1/?target=/system/TIMETABLE_WEEKLY/queryx/" + user.Username[1:] + "," + strconv.Itoa(value) + "&MaxRows=9999"
-		req, err := http.NewRequest("GET", link, nil)
+		link := p.api + "/api/generic-query-structured/v1/?target=/system/TIMETABLE_WEEKLY/queryx/" + user.Username[1:] + "," + strconv.Itoa(value) + "&MaxRows=9999"
+		req, err := http.NewRequestWithContext(ctx, "GET", link, nil)
 		if err != nil {
 			return nil, errors.New(err, "cannot create week lessons request")
 		}
@@ -205,38 +215,43 @@ func weeks(user site.User, deltas ...int) ([]site.Lesson, error) {
 		req.Header.Set("Accept-Encoding", "gzip, deflate, br")
 		req.Header.Set("Authorization", "Bearer "+user.SiteTokens["myadelaide"])
 		req.Header.Set("Connection", "keep-alive")
-		req.Header.Set("Host", "api.adelaide.edu.au")
-		req.Header.Set("Referer", "https://myadelaide.uni.adelaide.edu.au/")
+		req.Header.Set("Referer", p.base+"/")
 
 		resp, err := client.Do(req)
 		if err != nil {
 			return nil, errors.New(err, "cannot execute week lessons request")
 		}
 
+		err = checkResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+
 		var week Week
 		err = json.NewDecoder(resp.Body).Decode(&week)
 		if err != nil {
-			return nil, errors.New(err, "cannot decode json")
+			return nil, errors.New(site.Fail(site.ErrParse, err), "cannot decode json")
 		}
 
 		for _, lesson := range week.Data.Query.Rows {
 			startStr := lesson.Date + lesson.StartTime
 			start, err := time.ParseInLocation("02 Jan 2006 15.04", startStr, user.Timezone)
 			if err != nil {
-				return nil, errors.New(err, "cannot parse date")
+				return nil, errors.New(site.Fail(site.ErrParse, err), "cannot parse date")
 			}
 			endStr := lesson.Date + lesson.EndTime
 			end, err := time.ParseInLocation("02 Jan 2006 15.04", endStr, user.Timezone)
 			if err != nil {
-				return nil, errors.New(err, "cannot parse date")
+				return nil, errors.New(site.Fail(site.ErrParse, err), "cannot parse date")
 			}
 			lessons = append(lessons, site.Lesson{
-				Start:   start,
-				End:     end,
-				Class:   lesson.SubjectDescription,
-				Teacher: lesson.Type,
-				Notice:  "",
-				Room:    lesson.Location + " " + lesson.Room + " " + lesson.RoomDescription,
+				Start:    start,
+				End:      end,
+				Class:    lesson.SubjectDescription,
+				Teacher:  lesson.Type,
+				Notice:   "",
+				Room:     lesson.Location + " " + lesson.Room + " " + lesson.RoomDescription,
+				Platform: "myadelaide",
 			})
 		}
 	}
@@ -244,7 +259,7 @@ func weeks(user site.User, deltas ...int) ([]site.Lesson, error) {
 	return lessons, nil
 }
 
-func Lessons(user site.User, start, end time.Time) ([]site.Lesson, error) {
+func (p platform) lessons(ctx context.Context, user site.User, start, end time.Time) ([]site.Lesson, error) {
 	var lessons []site.Lesson
 
 	var err error
@@ -261,7 +276,7 @@ func Lessons(user site.User, start, end time.Time) ([]site.Lesson, error) {
 	numWeeks := int(float64((endWeek.Unix()-startWeek.Unix())/(60*60*24*7))) + 1
 
 	if numWeeks > 2 {
-		lessons, err = semester(user)
+		lessons, err = p.semester(ctx, user)
 		if err != nil {
 			return nil, errors.New(err, "cannot fetch semester lessons")
 		}
@@ -276,7 +291,7 @@ func Lessons(user site.User, start, end time.Time) ([]site.Lesson, error) {
 		for i := 1; i < numWeeks; i++ {
 			deltas[i] = deltas[0] + i*7
 		}
-		lessons, err = weeks(user, deltas...)
+		lessons, err = p.weeks(ctx, user, deltas...)
 		if err != nil {
 			return nil, errors.New(err, "cannot fetch lessons")
 		}
@@ -0,0 +1,89 @@
+package myadelaide
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/site"
+)
+
+// defaultURLs are the URLs of MyAdelaide and of the University of Adelaide
+// services it depends on.
+var defaultURLs = map[string]string{
+	"base": "https://myadelaide.uni.adelaide.edu.au",
+	"id":   "https://id.adelaide.edu.au",
+	"okta": "https://adelaide.okta.com",
+	"api":  "https://api.adelaide.edu.au",
+}
+
+// platform registers MyAdelaide with the site package. Only authentication
+// and lessons are supported. Its fields are the URLs of MyAdelaide and of the
+// services it depends on.
+type platform struct {
+	// the MyAdelaide portal
+	base string
+	// the identity provider and Okta organisation through which students
+	// sign in
+	id   string
+	okta string
+	// the API from which timetables are fetched
+	api string
+}
+
+// newPlatform returns a platform using the given URLs in place of the
+// defaults.
+func newPlatform(urls map[string]string) (platform, error) {
+	merged, err := site.MergeURLs(defaultURLs, urls)
+	if err != nil {
+		return platform{}, err
+	}
+	return platform{
+		base: merged["base"],
+		id:   merged["id"],
+		okta: merged["okta"],
+		api:  merged["api"],
+	}, nil
+}
+
+// checkResponse returns an error of the kind given by the status of resp, if
+// it reports a failure. In particular, it returns site.ErrExpired if the API
+// rejected the user's access token, which it does once the token has expired.
+func checkResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return errors.Raise(site.ErrExpired)
+	case resp.StatusCode == http.StatusNotFound:
+		return errors.Raise(site.ErrNotFound)
+	case resp.StatusCode >= 500:
+		return errors.New(errors.Raise(site.ErrUnavailable), "api returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func init() {
+	p, err := newPlatform(nil)
+	if err != nil {
+		panic(err)
+	}
+	site.Register(p)
+}
+
+func (platform) Name() string {
+	return "myadelaide"
+}
+
+func (platform) Configure(urls map[string]string) (site.Platform, error) {
+	p, err := newPlatform(urls)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p platform) Lessons(ctx context.Context, user site.User, c chan site.Pair[[]site.Lesson, error], start, end time.Time) {
+	lessons, err := p.lessons(ctx, user, start, end)
+	c <- site.Pair[[]site.Lesson, error]{First: lessons, Second: err}
+}
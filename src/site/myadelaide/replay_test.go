@@ -0,0 +1,84 @@
+package myadelaide
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"main/site"
+	"main/site/replay"
+)
+
+// start starts a session using the named fixture in testdata/replay. It
+// returns the session, a platform configured with the URLs of the session and
+// a user of the platform, logged in only if recording.
+func start(t *testing.T, name string) (*replay.Session, platform, site.User) {
+	t.Helper()
+	s := replay.Start(t, filepath.Join("testdata", "replay", name+".json"), defaultURLs)
+	p, err := newPlatform(s.URLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := s.User("myadelaide")
+	user.Timezone = time.FixedZone("ACDT", 630*60)
+	// Timetables are fetched by student ID, the username without its
+	// leading letter.
+	s.Secret(user.Username[1:], replay.Username[1:])
+	if s.Recording() && name != "auth" {
+		c := make(chan site.Pair[[2]string, error])
+		go p.Auth(context.Background(), user, c)
+		result := <-c
+		if result.Second != nil {
+			t.Fatal(result.Second)
+		}
+		user.SiteTokens["myadelaide"] = result.First[1]
+	} else {
+		user.SiteTokens["myadelaide"] = "access_token-1"
+	}
+	return s, p, user
+}
+
+func TestAuth(t *testing.T) {
+	s, p, user := start(t, "auth")
+	c := make(chan site.Pair[[2]string, error])
+	go p.Auth(context.Background(), user, c)
+	result := <-c
+	if result.Second != nil {
+		t.Fatal(result.Second)
+	}
+	if s.Recording() {
+		return
+	}
+	if want := [2]string{"myadelaide", "access_token-1"}; result.First != want {
+		t.Errorf("token is %q, want %q", result.First, want)
+	}
+	if unused := s.Server.Unused(); len(unused) != 0 {
+		t.Errorf("%d exchanges not replayed, first %s %s", len(unused), unused[0].Method, unused[0].URL)
+	}
+}
+
+func TestLessons(t *testing.T) {
+	s, p, user := start(t, "lessons")
+	// Over more than two weeks, lessons are fetched for the whole semester.
+	start := time.Date(2024, 3, 4, 0, 0, 0, 0, user.Timezone)
+	c := make(chan site.Pair[[]site.Lesson, error])
+	go p.Lessons(context.Background(), user, c, start, start.AddDate(0, 0, 20))
+	result := <-c
+	if result.Second != nil {
+		t.Fatal(result.Second)
+	}
+	if s.Recording() {
+		return
+	}
+	// The tutorials have ended, so only the lectures remain.
+	if len(result.First) != 3 {
+		t.Fatalf("got %d lessons, want 3", len(result.First))
+	}
+	for i, lesson := range result.First {
+		want := start.AddDate(0, 0, 7*i).Add(9 * time.Hour)
+		if lesson.Class != "Mathematics IB" || !lesson.Start.Equal(want) {
+			t.Errorf("lesson %d is %s at %s, want Mathematics IB at %s", i, lesson.Class, lesson.Start, want)
+		}
+	}
+}
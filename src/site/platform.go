@@ -0,0 +1,238 @@
+package site
+
+import (
+	"context"
+	"mime/multipart"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// Platform is a web platform used by schools, such as a learning management
+// system. Besides Name, a platform provides the functionality it supports by
+// implementing any of the capability interfaces below; Mux.Add registers each
+// capability implemented by a platform.
+type Platform interface {
+	// Name returns the name by which the platform is registered and
+	// referred to in school definitions, such as "daymap".
+	Name() string
+}
+
+// Authenticator is implemented by platforms which can authenticate users.
+type Authenticator interface {
+	Auth(context.Context, User, chan Pair[[2]string, error])
+}
+
+// ClassLister is implemented by platforms which can list a user's classes.
+type ClassLister interface {
+	Classes(context.Context, User, chan Pair[[]Class, error])
+}
+
+// Configurer is implemented by platforms whose upstream URLs can be set by
+// school definitions. Configure returns a copy of the platform which uses the
+// given URLs, keyed by name, in place of its defaults.
+type Configurer interface {
+	Configure(urls map[string]string) (Platform, error)
+}
+
+// DueTaskLister is implemented by platforms which can list a user's active
+// tasks without fetching every task.
+type DueTaskLister interface {
+	DueTasks(context.Context, User, chan Pair[[]Task, error])
+}
+
+// EventLister is implemented by platforms which can list calendar events.
+type EventLister interface {
+	Events(context.Context, User, chan Pair[[]Event, error], time.Time, time.Time)
+}
+
+// FileFetcher is implemented by platforms which can stream attachments. The
+// string arguments are the ID of the file and the Range header of the request
+// for it, if any. Platforms may ignore the range and return the whole file.
+type FileFetcher interface {
+	File(context.Context, User, string, string) (File, error)
+}
+
+// FileLinker is implemented by platforms whose attachments can be streamed
+// with FileFetcher. FileId returns the ID of the file at the given link, if it
+// is an attachment of the platform.
+type FileLinker interface {
+	FileId(string) (string, bool)
+}
+
+// GradedLister is implemented by platforms which can list graded tasks.
+type GradedLister interface {
+	Graded(context.Context, User, chan Pair[[]Task, error])
+}
+
+// LessonLister is implemented by platforms which can list lessons.
+type LessonLister interface {
+	Lessons(context.Context, User, chan Pair[[]Lesson, error], time.Time, time.Time)
+}
+
+// MessageLister is implemented by platforms which can list messages.
+type MessageLister interface {
+	Messages(context.Context, User, chan Pair[[]Message, error])
+}
+
+// ReportLister is implemented by platforms which can list report cards.
+type ReportLister interface {
+	Reports(context.Context, User, chan Pair[[]Report, error])
+}
+
+// ResourceFetcher is implemented by platforms which can retrieve a single
+// resource.
+type ResourceFetcher interface {
+	Resource(context.Context, User, string) (Resource, error)
+}
+
+// ResourceLister is implemented by platforms which can list class resources.
+type ResourceLister interface {
+	Resources(context.Context, User, chan Pair[[]Resource, error], []Class)
+}
+
+// TaskFetcher is implemented by platforms which can retrieve a single task.
+type TaskFetcher interface {
+	Task(context.Context, User, string) (Task, error)
+}
+
+// TaskLister is implemented by platforms which can list class tasks.
+type TaskLister interface {
+	Tasks(context.Context, User, chan Pair[[]Task, error], []Class)
+}
+
+// Submitter is implemented by platforms which can submit tasks.
+type Submitter interface {
+	Submit(context.Context, User, string) error
+}
+
+// Uploader is implemented by platforms which can upload work submissions.
+type Uploader interface {
+	UploadWork(context.Context, User, string, *multipart.Reader) error
+}
+
+// WorkRemover is implemented by platforms which can remove work submissions.
+type WorkRemover interface {
+	RemoveWork(context.Context, User, string, []string) error
+}
+
+var (
+	platformsMu sync.RWMutex
+	platforms   = make(map[string]Platform)
+)
+
+// Register makes platform p available by name to school definitions. Platform
+// packages register themselves when initialised. Register panics if a platform
+// with the same name has already been registered.
+func Register(p Platform) {
+	platformsMu.Lock()
+	defer platformsMu.Unlock()
+	if _, dup := platforms[p.Name()]; dup {
+		panic("site: platform registered twice: " + p.Name())
+	}
+	platforms[p.Name()] = p
+}
+
+// Lookup returns the registered platform with the given name.
+func Lookup(name string) (Platform, error) {
+	platformsMu.RLock()
+	defer platformsMu.RUnlock()
+	p, ok := platforms[name]
+	if !ok {
+		return nil, errors.New(nil, "unknown platform: %s", name)
+	}
+	return p, nil
+}
+
+// Platforms returns the names of all registered platforms in lexical order.
+func Platforms() []string {
+	platformsMu.RLock()
+	defer platformsMu.RUnlock()
+	var names []string
+	for name := range platforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MergeURLs returns the platform URLs in defaults, with any also named in urls
+// replaced by those. Each URL must be absolute; trailing slashes are removed.
+// An error is returned for URLs with names not in defaults.
+func MergeURLs(defaults, urls map[string]string) (map[string]string, error) {
+	merged := make(map[string]string, len(defaults))
+	for name, link := range defaults {
+		merged[name] = link
+	}
+	for name, link := range urls {
+		if _, ok := defaults[name]; !ok {
+			return nil, errors.New(nil, "unknown URL %s", name)
+		}
+		u, err := url.Parse(link)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, errors.New(err, "invalid URL for %s: %q", name, link)
+		}
+		merged[name] = strings.TrimRight(link, "/")
+	}
+	return merged, nil
+}
+
+// Add registers each capability implemented by platform p with m.
+func (m *Mux) Add(p Platform) {
+	name := p.Name()
+	if f, ok := p.(Authenticator); ok {
+		m.AddAuth(name, f.Auth)
+	}
+	if f, ok := p.(ClassLister); ok {
+		m.AddClasses(name, f.Classes)
+	}
+	if f, ok := p.(DueTaskLister); ok {
+		m.AddDueTasks(name, f.DueTasks)
+	}
+	if f, ok := p.(EventLister); ok {
+		m.AddEvents(name, f.Events)
+	}
+	if f, ok := p.(FileFetcher); ok {
+		m.AddFile(name, f.File)
+	}
+	if f, ok := p.(FileLinker); ok {
+		m.AddFileId(name, f.FileId)
+	}
+	if f, ok := p.(GradedLister); ok {
+		m.AddGraded(name, f.Graded)
+	}
+	if f, ok := p.(LessonLister); ok {
+		m.AddLessons(name, f.Lessons)
+	}
+	if f, ok := p.(MessageLister); ok {
+		m.AddMessages(name, f.Messages)
+	}
+	if f, ok := p.(ReportLister); ok {
+		m.AddReports(name, f.Reports)
+	}
+	if f, ok := p.(ResourceFetcher); ok {
+		m.AddResource(name, f.Resource)
+	}
+	if f, ok := p.(ResourceLister); ok {
+		m.AddResources(name, f.Resources)
+	}
+	if f, ok := p.(TaskFetcher); ok {
+		m.AddTask(name, f.Task)
+	}
+	if f, ok := p.(TaskLister); ok {
+		m.AddTasks(name, f.Tasks)
+	}
+	if f, ok := p.(Submitter); ok {
+		m.AddSubmit(name, f.Submit)
+	}
+	if f, ok := p.(Uploader); ok {
+		m.AddUploadWork(name, f.UploadWork)
+	}
+	if f, ok := p.(WorkRemover); ok {
+		m.AddRemoveWork(name, f.RemoveWork)
+	}
+}
@@ -0,0 +1,150 @@
+package site
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+
+	"main/logger"
+)
+
+// reuseWindow is how long a token obtained by authenticating a user again is
+// reused by other calls whose session with the platform expired, rather than
+// authenticating the user once more.
+const reuseWindow = time.Minute
+
+// A reauthKey identifies a user's session with a platform.
+type reauthKey struct {
+	uid      Uid
+	platform string
+}
+
+// A reauthState serialises the authentication of a user with a platform after
+// their session has expired, and records the token it last obtained. States
+// are removed once no call is using them and their token is too old to reuse.
+type reauthState struct {
+	mu      sync.Mutex
+	token   string // sealed
+	renewed time.Time
+	// the number of calls using the state, guarded by Mux.reauthMu
+	refs int
+}
+
+// reauth authenticates the unsealed user with platform again, after their
+// session with it has expired, and returns a copy of user with the new token.
+// Concurrent calls for the same user and platform authenticate the user only
+// once: if the token has been renewed in the meantime, it is reused.
+func (m *Mux) reauth(ctx context.Context, user User, platform string) (User, error) {
+	f, ok := m.auth[platform]
+	if !ok {
+		return User{}, errors.New(nil, "cannot authenticate with %s", platform)
+	}
+	key := reauthKey{Uid{user.School, user.Username}, platform}
+	m.reauthMu.Lock()
+	state, ok := m.reauths[key]
+	if !ok {
+		state = new(reauthState)
+		m.reauths[key] = state
+	}
+	state.refs++
+	m.reauthMu.Unlock()
+	defer m.release(state)
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if time.Since(state.renewed) < reuseWindow {
+		token, err := unseal(state.token)
+		if err == nil && token != user.SiteTokens[platform] {
+			return withToken(user, platform, token), nil
+		}
+	}
+
+	c := make(chan Pair[[2]string, error], 1)
+	go f(ctx, user, c)
+	var result Pair[[2]string, error]
+	select {
+	case result = <-c:
+	case <-ctx.Done():
+		result.Second = errors.New(ctx.Err(), "authentication did not complete")
+	}
+	if result.Second != nil {
+		return User{}, errors.New(result.Second, "cannot authenticate with %s again", platform)
+	}
+	token := result.First[1]
+	sealed, err := seal(token)
+	if err != nil {
+		return User{}, errors.Wrap(err)
+	}
+	state.token = sealed
+	state.renewed = time.Now()
+	if m.onReauth != nil {
+		err = m.onReauth(key.uid, platform, sealed)
+		if err != nil {
+			logger.Error(errors.New(err, "cannot save %s token", platform))
+		}
+	}
+	return withToken(user, platform, token), nil
+}
+
+// release records that a call to reauth has finished using state, and removes
+// the states which are no longer needed.
+func (m *Mux) release(state *reauthState) {
+	m.reauthMu.Lock()
+	defer m.reauthMu.Unlock()
+	state.refs--
+	for key, state := range m.reauths {
+		// No other call holds state.mu while refs is zero.
+		if state.refs == 0 && time.Since(state.renewed) >= reuseWindow {
+			delete(m.reauths, key)
+		}
+	}
+}
+
+// withToken returns a copy of user with the given token for platform. The
+// platform tokens of the copy are not shared with user.
+func withToken(user User, platform, token string) User {
+	user.SiteTokens = cloneTokens(user.SiteTokens)
+	user.SiteTokens[platform] = token
+	return user
+}
+
+// cloneTokens returns a copy of the platform tokens of a user, which is never
+// nil.
+func cloneTokens(tokens map[string]string) map[string]string {
+	clone := make(map[string]string, len(tokens))
+	for k, v := range tokens {
+		clone[k] = v
+	}
+	return clone
+}
+
+// retry calls f with the unsealed user. If f fails because the user's session
+// with platform has expired, the user is authenticated with platform again and
+// f is called once more with the new token. If the user cannot be
+// authenticated again, the error from the first call is returned.
+func retry[T any](m *Mux, ctx context.Context, user User, platform string, f func(User) (T, error)) (T, error) {
+	value, err := f(user)
+	if !Expired(err) {
+		return value, err
+	}
+	renewed, rerr := m.reauth(ctx, user, platform)
+	if rerr != nil {
+		logger.Debug(rerr)
+		return value, err
+	}
+	return f(renewed)
+}
+
+// call is like retry, for platform functions which send their result on a
+// channel.
+func call[T any](m *Mux, ctx context.Context, user User, platform string, f func(User, chan Pair[T, error])) Pair[T, error] {
+	value, err := retry(m, ctx, user, platform, func(user User) (T, error) {
+		c := make(chan Pair[T, error], 1)
+		f(user, c)
+		result := <-c
+		return result.First, result.Second
+	})
+	return Pair[T, error]{value, err}
+}
@@ -0,0 +1,216 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// recordedHeaders are the response headers kept in recordings. Others, such
+// as those of caching and tracing, are of no use to platforms.
+var recordedHeaders = []string{
+	"Accept-Ranges",
+	"Content-Disposition",
+	"Content-Range",
+	"Content-Type",
+	"Location",
+	"Set-Cookie",
+	"X-Frame-Options",
+}
+
+// sensitiveKeys are the names of JSON keys, form fields and HTML inputs whose
+// values are scrubbed from recordings.
+var sensitiveKeys = []string{
+	"access_token",
+	"code",
+	"code_verifier",
+	"id_token",
+	"passcode",
+	"password",
+	"refresh_token",
+	"RelayState",
+	"SAMLRequest",
+	"SAMLResponse",
+	"sessionToken",
+	"stateHandle",
+	"stateToken",
+	"token",
+}
+
+// sensitivePatterns match the values of sensitive keys in JSON, in forms and
+// in HTML inputs.
+var sensitivePatterns = func() []*regexp.Regexp {
+	keys := strings.Join(sensitiveKeys, "|")
+	return []*regexp.Regexp{
+		regexp.MustCompile(`"(` + keys + `)"\s*:\s*"((?:[^"\\]|\\.)*)"`),
+		regexp.MustCompile(`(?:^|[?&#])(` + keys + `)=([^&#\s"']*)`),
+		regexp.MustCompile(`name=["'](` + keys + `)["'][^>]*?value=["']([^"']*)["']`),
+	}
+}()
+
+// Recorder is an http.RoundTripper which records the exchanges made through
+// Transport. Recordings are scrubbed of credentials when saved.
+type Recorder struct {
+	Transport http.RoundTripper
+	// the upstream URLs, keyed by name, replaced by placeholders; URLs of
+	// other hosts are added as they are contacted
+	URLs map[string]string
+	// values replaced throughout recordings, such as the user's username
+	// and password, keyed by their replacements
+	Secrets map[string]string
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// RoundTrip makes the request with r.Transport and records the exchange.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		reqBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, errors.New(err, "cannot read request body")
+		}
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	resp, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, errors.New(err, "cannot read response body")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	// Test servers receive requests with empty paths as requests for "/".
+	link := *req.URL
+	if link.Path == "" {
+		link.Path = "/"
+	}
+	ex := Exchange{
+		Method:   req.Method,
+		URL:      link.String(),
+		Body:     reqBody,
+		Status:   resp.StatusCode,
+		Header:   make(http.Header),
+		Response: respBody,
+	}
+	for _, key := range recordedHeaders {
+		if values := resp.Header.Values(key); len(values) > 0 {
+			ex.Header[key] = values
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.URLs == nil {
+		r.URLs = make(map[string]string)
+	}
+	known := false
+	for _, link := range r.URLs {
+		if strings.HasPrefix(ex.URL, link) {
+			known = true
+			break
+		}
+	}
+	if !known {
+		r.URLs[req.URL.Host] = req.URL.Scheme + "://" + req.URL.Host
+	}
+	r.exchanges = append(r.exchanges, ex)
+	return resp, nil
+}
+
+// Exchanges returns the exchanges recorded so far, scrubbed of credentials
+// and with upstream URLs replaced by placeholders.
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Replacements of secrets are collected first, so that each is
+	// replaced everywhere it occurs.
+	subs := make(map[string]string)
+	for replacement, secret := range r.Secrets {
+		subs[secret] = replacement
+	}
+	counts := make(map[string]int)
+	learn := func(key, value string) {
+		if len(value) < 4 {
+			return
+		}
+		if _, ok := subs[value]; ok {
+			return
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil && unescaped != value {
+			if _, ok := subs[unescaped]; ok {
+				return
+			}
+			value = unescaped
+		}
+		counts[key]++
+		subs[value] = fmt.Sprintf("%s-%d", key, counts[key])
+	}
+	scan := func(s string) {
+		for _, re := range sensitivePatterns {
+			for _, m := range re.FindAllStringSubmatch(s, -1) {
+				learn(m[1], m[2])
+			}
+		}
+	}
+
+	exchanges := make([]Exchange, len(r.exchanges))
+	for i, ex := range r.exchanges {
+		scan(ex.URL)
+		scan(string(ex.Body))
+		scan(string(ex.Response))
+		ex.Header = ex.Header.Clone()
+		for j, line := range ex.Header.Values("Set-Cookie") {
+			cookie, err := http.ParseSetCookie(line)
+			if err != nil {
+				continue
+			}
+			learn(cookie.Name, cookie.Value)
+			// Cookies must be accepted from the test servers.
+			cookie.Domain = ""
+			ex.Header["Set-Cookie"][j] = cookie.String()
+		}
+		for _, link := range ex.Header.Values("Location") {
+			scan(link)
+		}
+		exchanges[i] = ex
+	}
+
+	scrub := replacer(subs)
+	tmpl := templater(r.URLs)
+	fix := func(s string) string {
+		return tmpl.Replace(scrub.Replace(s))
+	}
+	for i := range exchanges {
+		ex := &exchanges[i]
+		ex.URL = fix(ex.URL)
+		ex.Body = Body(fix(string(ex.Body)))
+		ex.Response = Body(fix(string(ex.Response)))
+		for _, values := range ex.Header {
+			for j := range values {
+				values[j] = fix(values[j])
+			}
+		}
+	}
+	return exchanges
+}
+
+// Save writes the exchanges recorded so far to the fixture at path.
+func (r *Recorder) Save(path string) error {
+	return Save(path, r.Exchanges())
+}
@@ -0,0 +1,187 @@
+// Package replay records exchanges between platform packages and their
+// upstream services into fixture files, and serves them back from local test
+// servers, so that platforms can be tested without a network.
+//
+// A fixture is a JSON array of exchanges. The upstream URLs in an exchange
+// are replaced by placeholders of the form {{name}}, where name is the name of
+// the URL in the platform's configuration (such as "base"), or the host of a
+// service reached without being configured. When replaying, each placeholder
+// is replaced by the URL of a test server standing in for that service, and
+// platforms are configured with those URLs (see site.Configurer).
+//
+// Credentials are scrubbed from recordings: the user's own are replaced with
+// those of the test user, and cookies, tokens and other sensitive values are
+// replaced with placeholders consistently throughout the fixture, so that a
+// value received in one exchange and sent in another still matches.
+package replay
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// Exchange is a request made to an upstream service and the response to it.
+type Exchange struct {
+	Method string `json:"method"`
+	// with upstream URLs replaced by placeholders
+	URL  string `json:"url"`
+	Body Body   `json:"body,omitempty"`
+	// the status, header and body of the response
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header,omitempty"`
+	Response Body        `json:"response,omitempty"`
+	// if set, the file, relative to the fixture, containing the response
+	// body; for fixtures written by hand
+	ResponseFile string `json:"responseFile,omitempty"`
+}
+
+// Body is the body of a request or response. It is encoded in JSON as a
+// string if it is valid UTF-8, and otherwise as an object with its base64
+// encoding.
+type Body []byte
+
+type body64 struct {
+	Base64 []byte `json:"base64"`
+}
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(body64{b})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*b = Body(s)
+		return nil
+	}
+	var v body64
+	err := json.Unmarshal(data, &v)
+	if err != nil {
+		return errors.New(err, "invalid body")
+	}
+	*b = v.Base64
+	return nil
+}
+
+// Load reads the fixture at path.
+func Load(path string) ([]Exchange, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(err, "cannot read fixture")
+	}
+	var exchanges []Exchange
+	err = json.Unmarshal(data, &exchanges)
+	if err != nil {
+		return nil, errors.New(err, "cannot parse fixture %s", path)
+	}
+	for i, ex := range exchanges {
+		if ex.ResponseFile == "" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(filepath.Dir(path), ex.ResponseFile))
+		if err != nil {
+			return nil, errors.New(err, "cannot read response of exchange %d", i)
+		}
+		exchanges[i].Response = data
+	}
+	return exchanges, nil
+}
+
+// Save writes exchanges to the fixture at path.
+func Save(path string, exchanges []Exchange) error {
+	data, err := json.MarshalIndent(exchanges, "", "\t")
+	if err != nil {
+		return errors.New(err, "cannot encode fixture")
+	}
+	err = os.WriteFile(path, append(data, '\n'), 0644)
+	if err != nil {
+		return errors.New(err, "cannot write fixture")
+	}
+	return nil
+}
+
+// placeholderPattern matches the placeholders of URLs in fixtures.
+var placeholderPattern = regexp.MustCompile(`\{\{([^{}]+)\}\}`)
+
+// Names returns the names of the URLs with placeholders in exchanges.
+func Names(exchanges []Exchange) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(s string) {
+		for _, m := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				names = append(names, m[1])
+			}
+		}
+	}
+	for _, ex := range exchanges {
+		add(ex.URL)
+		add(string(ex.Body))
+		add(string(ex.Response))
+		for _, values := range ex.Header {
+			for _, v := range values {
+				add(v)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// replacer returns a replacer of each key of subs with its value, in both its
+// plain and query-escaped forms. Longer keys are replaced first.
+func replacer(subs map[string]string) *strings.Replacer {
+	var keys []string
+	for k := range subs {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	var pairs []string
+	for _, k := range keys {
+		pairs = append(pairs, k, subs[k])
+		if esc := url.QueryEscape(k); esc != k {
+			pairs = append(pairs, esc, url.QueryEscape(subs[k]))
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// templater returns a replacer of the given URLs, keyed by name, with their
+// placeholders.
+func templater(urls map[string]string) *strings.Replacer {
+	subs := make(map[string]string)
+	for name, link := range urls {
+		subs[link] = "{{" + name + "}}"
+	}
+	return replacer(subs)
+}
+
+// expander returns a replacer of the placeholders of the given URLs, keyed by
+// name, with the URLs.
+func expander(urls map[string]string) *strings.Replacer {
+	subs := make(map[string]string)
+	for name, link := range urls {
+		subs["{{"+name+"}}"] = link
+	}
+	return replacer(subs)
+}
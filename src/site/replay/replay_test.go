@@ -0,0 +1,129 @@
+package replay
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// upstream is a service which logs in users and greets them by name.
+func upstream(t *testing.T) *httptest.Server {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			r.ParseForm()
+			if r.PostForm.Get("username") != "alice" || r.PostForm.Get("password") != "hunter22" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t-cookie", Domain: "127.0.0.1"})
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"sessionToken":"tok-abcdef","next":"`+"https://"+r.Host+`/hello"}`)
+		case "/hello":
+			c, err := r.Cookie("session")
+			if err != nil || c.Value != "s3cr3t-cookie" {
+				http.Error(w, "unauthorised", http.StatusUnauthorized)
+				return
+			}
+			io.WriteString(w, "hello alice")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// session logs in to the service at base and returns its greeting.
+func session(t *testing.T, client *http.Client, base, username, password string) string {
+	form := url.Values{"username": {username}, "password": {password}}
+	resp, err := client.PostForm(base+"/login", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("login returned status %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest("GET", base+"/hello", nil)
+	for _, c := range resp.Cookies() {
+		req.AddCookie(c)
+	}
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestRoundTrip(t *testing.T) {
+	srv := upstream(t)
+	rec := &Recorder{
+		Transport: srv.Client().Transport,
+		URLs:      map[string]string{"base": srv.URL},
+		Secrets:   map[string]string{Username: "alice", Password: "hunter22"},
+	}
+	got := session(t, &http.Client{Transport: rec}, srv.URL, "alice", "hunter22")
+	if got != "hello alice" {
+		t.Fatalf("recorded greeting %q", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+	err := rec.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchanges, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture := ""
+	for _, ex := range exchanges {
+		fixture += ex.URL + string(ex.Body) + string(ex.Response) + strings.Join(ex.Header.Values("Set-Cookie"), "")
+	}
+	for _, secret := range []string{"alice", "hunter22", "s3cr3t-cookie", "tok-abcdef", srv.URL, "Domain"} {
+		if strings.Contains(fixture, secret) {
+			t.Errorf("fixture contains %q", secret)
+		}
+	}
+	if names := Names(exchanges); !reflect.DeepEqual(names, []string{"base"}) {
+		t.Errorf("names are %q, want [base]", names)
+	}
+	if want := "password=" + Password + "&username=" + Username; string(exchanges[0].Body) != want {
+		t.Errorf("login body is %q, want %q", exchanges[0].Body, want)
+	}
+
+	replay := NewServer(t, exchanges)
+	got = session(t, replay.Client(), replay.URLs["base"], Username, Password)
+	if got != "hello "+Username {
+		t.Errorf("replayed greeting %q", got)
+	}
+	if unused := replay.Unused(); len(unused) != 0 {
+		t.Errorf("%d exchanges not replayed", len(unused))
+	}
+}
+
+func TestBody(t *testing.T) {
+	for _, body := range []Body{Body("text"), Body{0xff, 0x00, 0xfe}} {
+		data, err := body.MarshalJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Body
+		err = got.UnmarshalJSON(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(body) {
+			t.Errorf("body %q decoded as %q", body, got)
+		}
+	}
+}
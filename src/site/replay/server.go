@@ -0,0 +1,180 @@
+package replay
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Server serves the responses of a fixture's exchanges from local test
+// servers, one for each named URL.
+type Server struct {
+	// the URLs of the test servers, keyed by name
+	URLs map[string]string
+
+	t         testing.TB
+	servers   []*httptest.Server
+	exchanges []Exchange
+	tmpl      *strings.Replacer
+	expand    *strings.Replacer
+
+	mu       sync.Mutex
+	used     []bool
+	requests []Exchange
+}
+
+// NewServer starts a test server for each of the given names and each name
+// used in exchanges. Unexpected requests are reported as errors of t. The
+// servers are closed when the test finishes.
+func NewServer(t testing.TB, exchanges []Exchange, names ...string) *Server {
+	s := &Server{
+		URLs:      make(map[string]string),
+		t:         t,
+		exchanges: exchanges,
+		used:      make([]bool, len(exchanges)),
+	}
+	seen := make(map[string]bool)
+	for _, name := range append(append([]string(nil), names...), Names(exchanges)...) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		srv := httptest.NewTLSServer(s.handler(name))
+		s.servers = append(s.servers, srv)
+		s.URLs[name] = srv.URL
+	}
+	s.tmpl = templater(s.URLs)
+	s.expand = expander(s.URLs)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Client returns a client which trusts the test servers. All test servers
+// share a certificate, so its transport may be used to reach any of them.
+func (s *Server) Client() *http.Client {
+	return s.servers[0].Client()
+}
+
+// Close shuts down the test servers.
+func (s *Server) Close() {
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+}
+
+// Requests returns the requests received so far, with the URLs of the test
+// servers replaced by placeholders.
+func (s *Server) Requests() []Exchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Exchange(nil), s.requests...)
+}
+
+// Unused returns the exchanges whose requests have not been received.
+func (s *Server) Unused() []Exchange {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var unused []Exchange
+	for i, ex := range s.exchanges {
+		if !s.used[i] {
+			unused = append(unused, ex)
+		}
+	}
+	return unused
+}
+
+// handler returns the handler of the test server for the URL with the given
+// name.
+func (s *Server) handler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.t.Errorf("replay: cannot read body of %s %s: %v", r.Method, r.URL, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		req := Exchange{
+			Method: r.Method,
+			URL:    "{{" + name + "}}" + s.tmpl.Replace(r.URL.RequestURI()),
+			Body:   Body(s.tmpl.Replace(string(body))),
+		}
+
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		i := s.match(req)
+		if i >= 0 {
+			s.used[i] = true
+		}
+		s.mu.Unlock()
+
+		if i < 0 {
+			s.t.Errorf("replay: unexpected request %s %s", req.Method, req.URL)
+			http.NotFound(w, r)
+			return
+		}
+		ex := s.exchanges[i]
+		for key, values := range ex.Header {
+			for _, v := range values {
+				w.Header().Add(key, s.expand.Replace(v))
+			}
+		}
+		status := ex.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(s.expand.Replace(string(ex.Response))))
+	})
+}
+
+// match returns the index of the exchange which best matches req, or -1 if
+// none does. Exchanges whose requests have not been received are preferred,
+// first those with the same method, URL and body, then those with the same
+// method and URL, then those with the same method and path. If none remain,
+// the last exchange with the same method and URL is served again.
+func (s *Server) match(req Exchange) int {
+	path := func(link string) string {
+		path, _, _ := strings.Cut(link, "?")
+		return path
+	}
+	tiers := []func(Exchange) bool{
+		func(ex Exchange) bool {
+			return ex.URL == req.URL && bytes.Equal(ex.Body, req.Body)
+		},
+		func(ex Exchange) bool {
+			return ex.URL == req.URL
+		},
+		func(ex Exchange) bool {
+			return path(ex.URL) == path(req.URL)
+		},
+	}
+	for _, tier := range tiers {
+		for i, ex := range s.exchanges {
+			if !s.used[i] && ex.Method == req.Method && tier(ex) {
+				return i
+			}
+		}
+	}
+	for i := len(s.exchanges) - 1; i >= 0; i-- {
+		ex := s.exchanges[i]
+		if ex.Method == req.Method && ex.URL == req.URL {
+			return i
+		}
+	}
+	return -1
+}
+
+// sortedNames returns the keys of urls in order.
+func sortedNames(urls map[string]string) []string {
+	names := make([]string, 0, len(urls))
+	for name := range urls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
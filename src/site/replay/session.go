@@ -0,0 +1,132 @@
+package replay
+
+import (
+	"flag"
+	"net/http"
+	"os"
+	"testing"
+
+	"main/site"
+)
+
+var record = flag.Bool("record", false, "record fixtures from upstream services")
+
+// The credentials of the test user, which replace those of the real user in
+// recordings.
+const (
+	Username = "student"
+	Password = "password"
+	HotpKey  = "JBSWY3DPEHPK3PXP"
+)
+
+// Session is a test's use of a fixture. Normally, the fixture is replayed by a
+// Server. When tests are run with the -record flag, requests are instead made
+// to the upstream services and recorded into the fixture, which is written
+// when the test finishes, unless it fails.
+//
+// A session replaces http.DefaultTransport for the duration of the test, so
+// tests using sessions must not be run in parallel.
+type Session struct {
+	// the URLs with which platforms should be configured, keyed by name
+	URLs map[string]string
+	// the server replaying the fixture; nil when recording
+	Server *Server
+
+	t        testing.TB
+	recorder *Recorder
+}
+
+// Start starts a session using the fixture at path, for a platform whose
+// default URLs are given by defaults.
+func Start(t testing.TB, path string, defaults map[string]string) *Session {
+	t.Helper()
+	s := &Session{t: t}
+	transport := http.DefaultTransport
+	t.Cleanup(func() {
+		http.DefaultTransport = transport
+	})
+
+	if *record {
+		s.URLs = defaults
+		s.recorder = &Recorder{
+			Transport: transport,
+			URLs:      make(map[string]string),
+			Secrets:   make(map[string]string),
+		}
+		for name, link := range defaults {
+			s.recorder.URLs[name] = link
+		}
+		http.DefaultTransport = s.recorder
+		t.Cleanup(func() {
+			if t.Failed() {
+				return
+			}
+			err := s.recorder.Save(path)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+		return s
+	}
+
+	exchanges, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Server = NewServer(t, exchanges, sortedNames(defaults)...)
+	s.URLs = s.Server.URLs
+	http.DefaultTransport = s.Server.Client().Transport
+	return s
+}
+
+// Recording reports whether tests are recording fixtures rather than replaying
+// them.
+func Recording() bool {
+	return *record
+}
+
+// Recording reports whether the session is recording.
+func (s *Session) Recording() bool {
+	return s.recorder != nil
+}
+
+// User returns a user of the given platform. When replaying, this is the test
+// user. When recording, the user's credentials are taken from the environment
+// variables TASKCOLLECT_USERNAME, TASKCOLLECT_PASSWORD and, if needed by the
+// platform, TASKCOLLECT_HOTP_KEY; the test is skipped if they are unset.
+func (s *Session) User(platform string) site.User {
+	user := site.User{
+		Username:   Username,
+		Password:   Password,
+		SiteTokens: make(map[string]string),
+		Config: map[string]site.UserConfig{
+			platform: {HotpKey: HotpKey},
+		},
+	}
+	if !s.Recording() {
+		return user
+	}
+
+	username := os.Getenv("TASKCOLLECT_USERNAME")
+	password := os.Getenv("TASKCOLLECT_PASSWORD")
+	if username == "" || password == "" {
+		s.t.Skip("TASKCOLLECT_USERNAME and TASKCOLLECT_PASSWORD must be set to record")
+	}
+	user.Username = username
+	user.Password = password
+	s.Secret(username, Username)
+	s.Secret(password, Password)
+	if key := os.Getenv("TASKCOLLECT_HOTP_KEY"); key != "" {
+		user.Config[platform] = site.UserConfig{HotpKey: key}
+		s.Secret(key, HotpKey)
+	}
+	return user
+}
+
+// Secret replaces value with replacement throughout the recording, if
+// recording. It is used for values derived from the user's credentials.
+func (s *Session) Secret(value, replacement string) {
+	if s.Recording() && value != "" {
+		s.recorder.Secrets[replacement] = value
+	}
+}
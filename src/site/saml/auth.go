@@ -1,6 +1,7 @@
 package saml
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
@@ -12,9 +13,9 @@ import (
 	"main/site"
 )
 
-// Attempt to get GIHS Daily Access home page using a username and password.
+// Attempt to get the Daily Access home page using a username and password.
 // Used for authenticating GIHS students.
-func fetch(username, password string) error {
+func (p platform) fetch(ctx context.Context, username, password string) error {
 	// Stage 1 - Get a Daily Access redirect to SAML.
 
 	// A persistent cookie jar is required for the entire process.
@@ -24,12 +25,18 @@ func fetch(username, password string) error {
 		return errors.New(err, "cannot create cookiejar")
 	}
 
-	client := &http.Client{Jar: jar}
+	client := &http.Client{Jar: jar, Timeout: site.RequestTimeout}
 
-	s1, err := client.Get("https://da.gihs.sa.edu.au")
+	s1req, err := http.NewRequestWithContext(ctx, "GET", p.base, nil)
+	if err != nil {
+		return errors.New(err, "cannot create stage 1 request")
+	}
+
+	s1, err := client.Do(s1req)
 	if err != nil {
 		return errors.New(err, "stage 1 request failed")
 	}
+	defer s1.Body.Close()
 
 	s1body, err := io.ReadAll(s1.Body)
 	if err != nil {
@@ -52,24 +59,23 @@ func fetch(username, password string) error {
 
 	idIndex := strings.Index(s1page, "&client-request-id=")
 	if idIndex == -1 {
-		err := errors.New(nil, "missing client request ID")
+		err := errors.New(errors.Raise(site.ErrParse), "missing client request ID")
 		return err
 	}
 
 	idEnd := strings.Index(s1page[idIndex:], `"`)
-	idEnd += idIndex
-
 	if idEnd == -1 {
-		err := errors.New(nil, "unterminated client request ID")
+		err := errors.New(errors.Raise(site.ErrParse), "unterminated client request ID")
 		return err
 	}
+	idEnd += idIndex
 
 	s2id := s1page[idIndex:idEnd]
 	s2url := s1.Request.URL.String() + s2id
 
 	// Send the POST request with the generated form data.
 
-	s2req, err := http.NewRequest("POST", s2url, s2data)
+	s2req, err := http.NewRequestWithContext(ctx, "POST", s2url, s2data)
 	if err != nil {
 		return errors.New(err, "cannot create stage 2 request")
 	}
@@ -80,18 +86,19 @@ func fetch(username, password string) error {
 	if err != nil {
 		return errors.New(err, "cannot execute stage 2 request")
 	}
+	defer s2.Body.Close()
 
 	// Check if authentication was successful.
 
 	if s2.StatusCode == 200 && s2.Header.Get("X-Frame-Options") == "" {
 		return nil
 	}
-	return errors.New(nil, "saml returned non-200 response")
+	return errors.New(errors.Raise(site.ErrUnauthenticated), "saml returned non-200 response")
 }
 
-func Auth(user site.User, c chan site.Pair[[2]string, error]) {
+func (p platform) Auth(ctx context.Context, user site.User, c chan site.Pair[[2]string, error]) {
 	var result site.Pair[[2]string, error]
-	err := fetch(user.Username, user.Password)
+	err := p.fetch(ctx, user.Username, user.Password)
 	if err != nil {
 		result.Second = errors.New(err, "saml login failed")
 		c <- result
@@ -0,0 +1,46 @@
+package saml
+
+import (
+	"main/site"
+)
+
+// defaultURLs are the URLs of the Daily Access instance of GIHS.
+var defaultURLs = map[string]string{
+	"base": "https://da.gihs.sa.edu.au",
+}
+
+// platform registers SAML single sign-on with the site package. It only
+// authenticates users, by signing in to the Daily Access instance at base.
+type platform struct {
+	base string
+}
+
+// newPlatform returns a platform using the given URLs in place of the
+// defaults.
+func newPlatform(urls map[string]string) (platform, error) {
+	merged, err := site.MergeURLs(defaultURLs, urls)
+	if err != nil {
+		return platform{}, err
+	}
+	return platform{base: merged["base"]}, nil
+}
+
+func init() {
+	p, err := newPlatform(nil)
+	if err != nil {
+		panic(err)
+	}
+	site.Register(p)
+}
+
+func (platform) Name() string {
+	return "saml"
+}
+
+func (platform) Configure(urls map[string]string) (site.Platform, error) {
+	p, err := newPlatform(urls)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
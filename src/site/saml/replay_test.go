@@ -0,0 +1,57 @@
+package saml
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/site"
+	"main/site/replay"
+)
+
+// auth authenticates the user of a session using the named fixture in
+// testdata/replay, returning the session and the result.
+func auth(t *testing.T, name string) (*replay.Session, site.Pair[[2]string, error]) {
+	t.Helper()
+	s := replay.Start(t, filepath.Join("testdata", "replay", name+".json"), defaultURLs)
+	p, err := newPlatform(s.URLs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := make(chan site.Pair[[2]string, error])
+	go p.Auth(context.Background(), s.User("saml"), c)
+	return s, <-c
+}
+
+func TestAuth(t *testing.T) {
+	s, result := auth(t, "auth")
+	if result.Second != nil {
+		t.Fatal(result.Second)
+	}
+	if s.Recording() {
+		return
+	}
+	if result.First[0] != "saml" {
+		t.Errorf("token is for %q, want saml", result.First[0])
+	}
+	if unused := s.Server.Unused(); len(unused) != 0 {
+		t.Errorf("%d exchanges not replayed, first %s %s", len(unused), unused[0].Method, unused[0].URL)
+	}
+}
+
+func TestAuthFailed(t *testing.T) {
+	if replay.Recording() {
+		t.Skip("failed logins are not recorded")
+	}
+	_, result := auth(t, "auth_failed")
+	if result.Second == nil {
+		t.Fatal("login succeeded")
+	}
+	if !strings.Contains(result.Second.Error(), "non-200 response") {
+		t.Errorf("unexpected error: %v", result.Second)
+	}
+	if kind := site.Kind(result.Second); kind != site.ErrUnauthenticated {
+		t.Errorf("error is of kind %v, want %v", kind, site.ErrUnauthenticated)
+	}
+}
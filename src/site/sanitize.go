@@ -0,0 +1,761 @@
+package site
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	htm "git.sr.ht/~kvo/go-format/html"
+	"git.sr.ht/~kvo/go-std/slices"
+)
+
+// Sanitizer converts HTML supplied by platforms, such as the descriptions of
+// tasks and resources, into a safe subset of HTML, plain text or Markdown.
+// Input without any tags is treated as plain text, whose line breaks are kept.
+//
+// Only the elements and attributes in allowedTags are kept. Scripts, styles,
+// embedded content and forms are removed with their content; other elements
+// are replaced by their content. Links are kept only if they use the http,
+// https or mailto schemes. Unless RemoteImages is set, images are kept only if
+// they are served by TaskCollect; other images are replaced by links to them,
+// so that they are not loaded from elsewhere when a page is viewed.
+type Sanitizer struct {
+	// the URL of the page from which the HTML was taken, against which
+	// relative links are resolved
+	Base string
+	// if not nil, called with each resolved link, returning the link to use
+	// instead, such as one streaming an attachment through TaskCollect; links
+	// rewritten to "" are removed
+	Rewrite func(string) string
+	// if true, images served from elsewhere are kept rather than replaced by
+	// links to them
+	RemoteImages bool
+}
+
+// allowedTags maps the elements kept by a Sanitizer to their allowed
+// attributes.
+var allowedTags = map[string][]string{
+	"a":          {"href", "title"},
+	"b":          nil,
+	"blockquote": nil,
+	"br":         nil,
+	"caption":    nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "title", "width", "height"},
+	"ins":        nil,
+	"li":         nil,
+	"mark":       nil,
+	"ol":         {"start"},
+	"p":          nil,
+	"pre":        nil,
+	"s":          nil,
+	"small":      nil,
+	"span":       nil,
+	"strong":     nil,
+	"sub":        nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"colspan", "rowspan"},
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+// renamedTags maps elements to the allowed elements which replace them. Page
+// headings are demoted so as not to compete with those of TaskCollect.
+var renamedTags = map[string]string{
+	"article": "div",
+	"aside":   "div",
+	"center":  "div",
+	"footer":  "div",
+	"h1":      "h3",
+	"h2":      "h3",
+	"header":  "div",
+	"section": "div",
+	"strike":  "s",
+	"tt":      "code",
+}
+
+// droppedTags are the elements which are removed with their content.
+var droppedTags = map[string]bool{
+	"audio":    true,
+	"button":   true,
+	"embed":    true,
+	"form":     true,
+	"frame":    true,
+	"frameset": true,
+	"head":     true,
+	"iframe":   true,
+	"input":    true,
+	"link":     true,
+	"math":     true,
+	"meta":     true,
+	"noscript": true,
+	"object":   true,
+	"script":   true,
+	"select":   true,
+	"style":    true,
+	"svg":      true,
+	"template": true,
+	"textarea": true,
+	"title":    true,
+	"video":    true,
+}
+
+// numericAttrs are the attributes whose values must be positive integers.
+var numericAttrs = map[string]bool{
+	"colspan": true,
+	"height":  true,
+	"rowspan": true,
+	"start":   true,
+	"width":   true,
+}
+
+// htmlPattern matches the tags and character references which mark input as
+// HTML.
+var htmlPattern = regexp.MustCompile(`</?[a-zA-Z][^>]*>|<!--|&(#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`)
+
+// HTML returns src reduced to the safe subset of HTML described above.
+func (s Sanitizer) HTML(src string) string {
+	var b strings.Builder
+	for n := s.clean(src).FirstChild; n != nil; n = n.NextSibling {
+		htm.Render(&b, n)
+	}
+	return b.String()
+}
+
+// Text returns the text content of src. Paragraphs are separated by blank
+// lines, list items are marked, and links are followed by their URL.
+func (s Sanitizer) Text(src string) string {
+	r := newRenderer(false)
+	r.children(s.clean(src))
+	return r.String()
+}
+
+// Markdown returns src converted to Markdown.
+func (s Sanitizer) Markdown(src string) string {
+	r := newRenderer(true)
+	r.children(s.clean(src))
+	return r.String()
+}
+
+// clean returns a node containing the sanitised content of src.
+func (s Sanitizer) clean(src string) *htm.Node {
+	out := &htm.Node{Type: htm.ElementNode, Data: "div"}
+	if !htmlPattern.MatchString(src) {
+		src = strings.ReplaceAll(src, "\r\n", "\n")
+		src = strings.ReplaceAll(htm.EscapeString(src), "\n", "<br>")
+	}
+	doc, err := htm.Parse(strings.NewReader(src))
+	if err != nil {
+		out.AppendChild(&htm.Node{Type: htm.TextNode, Data: src})
+		return out
+	}
+	s.children(body(doc), out)
+	return out
+}
+
+// body returns the body element of the document doc.
+func body(doc *htm.Node) *htm.Node {
+	if doc.Type == htm.ElementNode && doc.Data == "body" {
+		return doc
+	}
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if b := body(n); b != nil {
+			return b
+		}
+	}
+	return nil
+}
+
+// children appends the sanitised children of in to out.
+func (s Sanitizer) children(in, out *htm.Node) {
+	if in == nil {
+		return
+	}
+	for n := in.FirstChild; n != nil; n = n.NextSibling {
+		switch n.Type {
+		case htm.TextNode:
+			out.AppendChild(&htm.Node{Type: htm.TextNode, Data: n.Data})
+		case htm.ElementNode:
+			s.element(n, out)
+		}
+	}
+}
+
+// element appends the sanitised element n to out.
+func (s Sanitizer) element(n, out *htm.Node) {
+	tag := strings.ToLower(n.Data)
+	if droppedTags[tag] {
+		return
+	}
+	if renamed, ok := renamedTags[tag]; ok {
+		tag = renamed
+	}
+	allowed, ok := allowedTags[tag]
+	if !ok {
+		s.children(n, out)
+		return
+	}
+
+	el := &htm.Node{Type: htm.ElementNode, Data: tag}
+	for _, attr := range n.Attr {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || !slices.Has(allowed, key) {
+			continue
+		}
+		val := attr.Val
+		switch {
+		case key == "href" || key == "src":
+			link, ok := s.link(val, key == "href")
+			if !ok {
+				continue
+			}
+			val = link
+		case numericAttrs[key]:
+			i, err := strconv.Atoi(strings.TrimSpace(val))
+			if err != nil || i <= 0 {
+				continue
+			}
+			val = strconv.Itoa(i)
+		}
+		el.Attr = append(el.Attr, htm.Attribute{Key: key, Val: val})
+	}
+
+	switch tag {
+	case "a":
+		href := attrValue(el, "href")
+		if href == "" {
+			s.children(n, out)
+			return
+		}
+		if !strings.HasPrefix(href, "/") {
+			el.Attr = append(el.Attr, htm.Attribute{Key: "rel", Val: "noopener noreferrer"})
+		}
+	case "img":
+		src := attrValue(el, "src")
+		local := strings.HasPrefix(src, "/") && !strings.HasPrefix(src, "//")
+		if src == "" || !local && !s.RemoteImages {
+			s.imageLink(el, out)
+			return
+		}
+	}
+	out.AppendChild(el)
+	s.children(n, el)
+}
+
+// imageLink appends a link to the image img to out, in place of the image.
+func (s Sanitizer) imageLink(img, out *htm.Node) {
+	src := attrValue(img, "src")
+	alt := strings.TrimSpace(attrValue(img, "alt"))
+	if alt == "" {
+		alt = "Image"
+	}
+	if src == "" {
+		out.AppendChild(&htm.Node{Type: htm.TextNode, Data: "[" + alt + "]"})
+		return
+	}
+	a := &htm.Node{Type: htm.ElementNode, Data: "a", Attr: []htm.Attribute{
+		{Key: "href", Val: src},
+		{Key: "rel", Val: "noopener noreferrer"},
+	}}
+	a.AppendChild(&htm.Node{Type: htm.TextNode, Data: "[" + alt + "]"})
+	out.AppendChild(a)
+}
+
+// link returns the link val resolved against s.Base and rewritten by
+// s.Rewrite, and whether it may be kept. The mailto scheme is only allowed for
+// links, rather than images.
+func (s Sanitizer) link(val string, mailto bool) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(val))
+	if err != nil {
+		return "", false
+	}
+	if u.Scheme == "" && s.Base != "" {
+		base, err := url.Parse(s.Base)
+		if err != nil {
+			return "", false
+		}
+		u = base.ResolveReference(u)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	case "mailto":
+		if !mailto {
+			return "", false
+		}
+	case "":
+		// Links within TaskCollect, such as those already rewritten.
+		if !strings.HasPrefix(u.Path, "/") || u.Host != "" {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	link := u.String()
+	if s.Rewrite != nil {
+		link = s.Rewrite(link)
+	}
+	return link, link != ""
+}
+
+// attrValue returns the value of the attribute of n with the given key.
+func attrValue(n *htm.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// renderer renders sanitised HTML as plain text or Markdown. Whitespace in text
+// is collapsed as a browser would, and blocks are separated by line breaks,
+// which are only written once the content following them is.
+type renderer struct {
+	b        strings.Builder
+	markdown bool
+	// written at the start of each line, such as to indent list items
+	prefix string
+	// the prefix of the last line written
+	last string
+	// the number of line breaks due before the next content
+	breaks int
+	// whether a space is due before the next content
+	space bool
+	// whether the line breaks due are from line break elements, rather than
+	// blocks, so must be marked in Markdown
+	hard bool
+	// whether the next content starts a line, so must be prefixed
+	bol     bool
+	started bool
+	// whether a list marker has just been written, so that no line breaks
+	// may follow it
+	marker bool
+	// the depth of nested lists
+	lists int
+}
+
+func newRenderer(markdown bool) *renderer {
+	return &renderer{markdown: markdown, bol: true}
+}
+
+func (r *renderer) String() string {
+	return r.b.String()
+}
+
+// brk requests at least n line breaks before the next content.
+func (r *renderer) brk(n int) {
+	r.breaks = max(r.breaks, n)
+	r.space = false
+	r.hard = false
+}
+
+// flush writes the line breaks, prefix or space due before the next content.
+func (r *renderer) flush() {
+	if r.marker {
+		r.breaks, r.space, r.hard, r.marker = 0, false, false, false
+		return
+	}
+	if r.started && r.breaks > 0 {
+		// Markdown joins the lines of a paragraph, unless they end with a
+		// backslash.
+		if r.markdown && r.hard && r.breaks == 1 {
+			r.b.WriteString("\\")
+		}
+		// Blank lines belong to the blocks enclosing both lines.
+		blank := r.prefix
+		for !strings.HasPrefix(r.last, blank) {
+			blank = blank[:len(blank)-1]
+		}
+		for i := 0; i < r.breaks; i++ {
+			if i > 0 {
+				r.b.WriteString(strings.TrimRight(blank, " "))
+			}
+			r.b.WriteString("\n")
+		}
+		r.bol = true
+	} else if r.space && !r.bol {
+		r.b.WriteString(" ")
+	}
+	if r.bol {
+		r.b.WriteString(r.prefix)
+		r.last = r.prefix
+		r.bol = false
+	}
+	r.breaks, r.space, r.hard = 0, false, false
+	r.started = true
+}
+
+// markdownEscaper escapes the characters of text which Markdown would
+// otherwise interpret.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "&", `\&`,
+)
+
+// text writes the text s, collapsing whitespace.
+func (r *renderer) text(s string) {
+	if s == "" {
+		return
+	}
+	words := strings.Fields(s)
+	if strings.TrimLeft(s, " \t\n\r\f") != s {
+		r.space = true
+	}
+	for i, word := range words {
+		if i > 0 {
+			r.space = true
+		}
+		r.flush()
+		if r.markdown {
+			word = markdownEscaper.Replace(word)
+		}
+		r.b.WriteString(word)
+	}
+	if len(words) > 0 && strings.TrimRight(s, " \t\n\r\f") != s {
+		r.space = true
+	}
+}
+
+// raw writes s, which may contain line breaks, as is.
+func (r *renderer) raw(s string) {
+	r.flush()
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			r.b.WriteString("\n")
+			if line == "" {
+				r.b.WriteString(strings.TrimRight(r.prefix, " "))
+				continue
+			}
+			r.b.WriteString(r.prefix)
+		}
+		r.b.WriteString(line)
+	}
+}
+
+// inline renders the content of n on a single line, reporting whether it is
+// preceded or followed by whitespace.
+func (r *renderer) inline(n *htm.Node) (s string, lead, trail bool) {
+	sub := newRenderer(r.markdown)
+	sub.children(n)
+	s = strings.Join(strings.Fields(sub.String()), " ")
+	first := n.FirstChild
+	last := n.LastChild
+	lead = first != nil && first.Type == htm.TextNode && strings.TrimLeft(first.Data, " \t\n\r\f") != first.Data
+	trail = last != nil && last.Type == htm.TextNode && strings.TrimRight(last.Data, " \t\n\r\f") != last.Data
+	return s, lead, trail
+}
+
+// wrap writes the content of n on a single line, between the given markers.
+func (r *renderer) wrap(n *htm.Node, open, close string) {
+	s, lead, trail := r.inline(n)
+	if lead {
+		r.space = true
+	}
+	if s != "" {
+		r.raw(open + s + close)
+	}
+	if trail {
+		r.space = true
+	}
+}
+
+// children renders the children of n.
+func (r *renderer) children(n *htm.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		r.node(c)
+	}
+}
+
+// node renders n.
+func (r *renderer) node(n *htm.Node) {
+	if n.Type == htm.TextNode {
+		r.text(n.Data)
+		return
+	}
+	if n.Type != htm.ElementNode {
+		return
+	}
+	switch n.Data {
+	case "br":
+		r.breaks++
+		r.space = false
+		r.hard = true
+	case "div", "dt", "caption":
+		r.brk(1)
+		r.children(n)
+		r.brk(1)
+	case "p":
+		r.brk(2)
+		r.children(n)
+		r.brk(2)
+	case "dd":
+		r.brk(1)
+		prefix := r.prefix
+		r.prefix += "    "
+		r.children(n)
+		r.prefix = prefix
+		r.brk(1)
+	case "h3", "h4", "h5", "h6":
+		r.brk(2)
+		if r.markdown {
+			level := int(n.Data[1] - '0')
+			s, _, _ := r.inline(n)
+			r.raw(strings.Repeat("#", level) + " " + s)
+		} else {
+			r.children(n)
+		}
+		r.brk(2)
+	case "hr":
+		r.brk(2)
+		r.raw("---")
+		r.brk(2)
+	case "blockquote":
+		r.brk(2)
+		prefix := r.prefix
+		if r.markdown {
+			r.prefix += "> "
+		} else {
+			r.prefix += "    "
+		}
+		r.children(n)
+		r.prefix = prefix
+		r.brk(2)
+	case "pre":
+		r.brk(2)
+		content := strings.Trim(textContent(n), "\n")
+		if r.markdown {
+			fence := "```"
+			for strings.Contains(content, fence) {
+				fence += "`"
+			}
+			content = fence + "\n" + content + "\n" + fence
+		}
+		r.raw(content)
+		r.brk(2)
+	case "ul", "ol":
+		r.list(n)
+	case "li":
+		r.brk(1)
+		r.children(n)
+		r.brk(1)
+	case "table":
+		r.table(n)
+	case "b", "strong":
+		r.emphasis(n, "**")
+	case "i", "em":
+		r.emphasis(n, "*")
+	case "s", "del":
+		r.emphasis(n, "~~")
+	case "code":
+		if r.markdown {
+			s := textContent(n)
+			fence := "`"
+			for strings.Contains(s, fence) {
+				fence += "`"
+			}
+			r.raw(fence + s + fence)
+		} else {
+			r.text(textContent(n))
+		}
+	case "a":
+		r.anchor(n)
+	case "img":
+		alt := attrValue(n, "alt")
+		if r.markdown {
+			r.raw("![" + markdownEscaper.Replace(alt) + "](" + markdownURL(attrValue(n, "src")) + ")")
+		} else if alt != "" {
+			r.text("[" + alt + "]")
+		}
+	default:
+		r.children(n)
+	}
+}
+
+// emphasis renders n, marked in Markdown with marker.
+func (r *renderer) emphasis(n *htm.Node, marker string) {
+	if r.markdown {
+		r.wrap(n, marker, marker)
+	} else {
+		r.children(n)
+	}
+}
+
+// anchor renders the link n.
+func (r *renderer) anchor(n *htm.Node) {
+	href := attrValue(n, "href")
+	s, lead, trail := r.inline(n)
+	if lead {
+		r.space = true
+	}
+	switch {
+	case r.markdown:
+		if s == "" {
+			s = markdownEscaper.Replace(href)
+		}
+		r.raw("[" + s + "](" + markdownURL(href) + ")")
+	case s == "":
+		r.text(href)
+	case s == href || "mailto:"+s == href:
+		r.text(s)
+	default:
+		r.raw(s + " (" + href + ")")
+	}
+	if trail {
+		r.space = true
+	}
+}
+
+// list renders the list n, marking its items with bullets or numbers.
+func (r *renderer) list(n *htm.Node) {
+	if r.lists > 0 {
+		r.brk(1)
+	} else {
+		r.brk(2)
+	}
+	r.lists++
+	i := 1
+	if start, err := strconv.Atoi(attrValue(n, "start")); err == nil {
+		i = start
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != htm.ElementNode || c.Data != "li" {
+			r.node(c)
+			continue
+		}
+		marker := "- "
+		if n.Data == "ol" {
+			marker = fmt.Sprintf("%d. ", i)
+			i++
+		}
+		r.brk(1)
+		r.raw(marker)
+		r.marker = true
+		prefix := r.prefix
+		r.prefix += strings.Repeat(" ", len(marker))
+		r.children(c)
+		r.prefix = prefix
+		r.marker = false
+	}
+	r.lists--
+	if r.lists > 0 {
+		r.brk(1)
+	} else {
+		r.brk(2)
+	}
+}
+
+// table renders the table n, one row per line, with cells separated by bars.
+// In Markdown, the first row is the table's header.
+func (r *renderer) table(n *htm.Node) {
+	r.brk(2)
+	var rows [][]string
+	var walk func(*htm.Node)
+	walk = func(n *htm.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != htm.ElementNode {
+				continue
+			}
+			switch c.Data {
+			case "caption":
+				s, _, _ := r.inline(c)
+				r.raw(s)
+				r.brk(2)
+			case "tr":
+				var cells []string
+				for cell := c.FirstChild; cell != nil; cell = cell.NextSibling {
+					if cell.Type == htm.ElementNode && (cell.Data == "td" || cell.Data == "th") {
+						s, _, _ := r.inline(cell)
+						cells = append(cells, s)
+					}
+				}
+				rows = append(rows, cells)
+			default:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	for i, cells := range rows {
+		r.brk(1)
+		if !r.markdown {
+			r.raw(strings.Join(cells, " | "))
+			continue
+		}
+		r.raw("| " + strings.Join(cells, " | ") + " |")
+		if i == 0 {
+			r.brk(1)
+			r.raw("|" + strings.Repeat(" --- |", max(len(cells), 1)))
+		}
+	}
+	r.brk(2)
+}
+
+// textContent returns the text of n and its descendants, as is.
+func textContent(n *htm.Node) string {
+	if n.Type == htm.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == htm.ElementNode && c.Data == "br" {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(textContent(c))
+		}
+	}
+	return b.String()
+}
+
+// markdownURL escapes the characters of a URL which would end a Markdown link.
+func markdownURL(link string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(link)
+}
+
+// sanitizeTask replaces the description of task with sanitised HTML, whose
+// relative links are resolved against the task's link. Images are kept, so that
+// those which are attachments may later be served by TaskCollect.
+func sanitizeTask(task *Task) {
+	if task.Desc != "" {
+		task.Desc = Sanitizer{Base: task.Link, RemoteImages: true}.HTML(task.Desc)
+	}
+}
+
+// sanitizeResource replaces the description of res with sanitised HTML, as for
+// sanitizeTask.
+func sanitizeResource(res *Resource) {
+	if res.Desc != "" {
+		res.Desc = Sanitizer{Base: res.Link, RemoteImages: true}.HTML(res.Desc)
+	}
+}
+
+func sanitizeTasks(tasks []Task) {
+	for i := range tasks {
+		sanitizeTask(&tasks[i])
+	}
+}
+
+func sanitizeResources(resources []Resource) {
+	for i := range resources {
+		sanitizeResource(&resources[i])
+	}
+}
@@ -0,0 +1,142 @@
+package site
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	s := Sanitizer{Base: "https://gihs.daymap.net/daymap/student/plans/class.aspx"}
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"script", `<p>Read<script>alert(1)</script> this</p>`, `<p>Read this</p>`},
+		{"style", `<style>p { display: none }</style><p>Shown</p>`, `<p>Shown</p>`},
+		{"iframe", `<iframe src="https://example.com"><p>Fallback</p></iframe>Text`, `Text`},
+		{"nested", `<div><form><input name="x"><b>Inside</b></form>Outside</div>`, `<div>Outside</div>`},
+		{"unknown element", `<font color="red">Red</font>`, `Red`},
+		{"renamed", `<h1>Title</h1><center>Middle</center>`, `<h3>Title</h3><div>Middle</div>`},
+		{"event handlers", `<p onclick="steal()" OnMouseOver="steal()">Hi</p>`, `<p>Hi</p>`},
+		{"disallowed attributes", `<p style="color: red" class="x" id="y">Hi</p>`, `<p>Hi</p>`},
+		{"link handlers", `<a href="https://example.com" onclick="steal()">Link</a>`, `<a href="https://example.com" rel="noopener noreferrer">Link</a>`},
+		{"javascript", `<a href="javascript:alert(1)">Link</a>`, `Link`},
+		{"javascript case", `<a href="JaVaScRiPt:alert(1)">Link</a>`, `Link`},
+		{"javascript space", `<a href=" javascript:alert(1)">Link</a>`, `Link`},
+		{"javascript entity", `<a href="java&#115;cript:alert(1)">Link</a>`, `Link`},
+		{"vbscript", `<a href="vbscript:msgbox(1)">Link</a>`, `Link`},
+		{"data link", `<a href="data:text/html,<script>alert(1)</script>">Link</a>`, `Link`},
+		{"data image", `<img src="data:image/png;base64,AAAA" alt="Dot">`, `[Dot]`},
+		{"mailto", `<a href="mailto:teacher@example.com">Email</a>`, `<a href="mailto:teacher@example.com" rel="noopener noreferrer">Email</a>`},
+		{"mailto image", `<img src="mailto:teacher@example.com">`, `[Image]`},
+		{"relative", `<a href="../notes.pdf">Notes</a>`, `<a href="https://gihs.daymap.net/daymap/student/notes.pdf" rel="noopener noreferrer">Notes</a>`},
+		{"fragment", `<a href="#" onclick="DMU.OpenAttachment(1)">Notes</a>`, `<a href="https://gihs.daymap.net/daymap/student/plans/class.aspx" rel="noopener noreferrer">Notes</a>`},
+		{"remote image", `<img src="https://tracker.example.com/pixel.png" alt="Pixel">`, `<a href="https://tracker.example.com/pixel.png" rel="noopener noreferrer">[Pixel]</a>`},
+		{"relative image", `<img src="/daymap/images/map.png">`, `<a href="https://gihs.daymap.net/daymap/images/map.png" rel="noopener noreferrer">[Image]</a>`},
+		{"numeric attributes", `<td colspan="2" rowspan="-1">x</td>`, `x`},
+		{"list start", `<ol start="3" reversed><li>Three</li></ol>`, `<ol start="3"><li>Three</li></ol>`},
+		{"plain text", "Line one\nLine 2 < 3 & three", "Line one<br/>Line 2 &lt; 3 &amp; three"},
+		{"comment", `<!-- <script>alert(1)</script> --><p>Hi</p>`, `<p>Hi</p>`},
+	}
+	for _, test := range tests {
+		if got := s.HTML(test.src); got != test.want {
+			t.Errorf("%s: HTML(%q) = %q, want %q", test.name, test.src, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeImages(t *testing.T) {
+	rewrite := func(link string) string {
+		if strings.HasPrefix(link, "https://gihs.daymap.net/") {
+			return "/files/daymap/1"
+		}
+		return link
+	}
+	src := `<img src="/daymap/attachment.ashx?ID=1" alt="Diagram"><img src="https://example.com/a.png">`
+
+	// Images served by TaskCollect are kept; others are blocked.
+	s := Sanitizer{Base: "https://gihs.daymap.net/", Rewrite: rewrite}
+	want := `<img src="/files/daymap/1" alt="Diagram"/><a href="https://example.com/a.png" rel="noopener noreferrer">[Image]</a>`
+	if got := s.HTML(src); got != want {
+		t.Errorf("HTML = %q, want %q", got, want)
+	}
+	s.RemoteImages = true
+	want = `<img src="/files/daymap/1" alt="Diagram"/><img src="https://example.com/a.png"/>`
+	if got := s.HTML(src); got != want {
+		t.Errorf("with remote images, HTML = %q, want %q", got, want)
+	}
+
+	// Links rewritten to nothing are removed.
+	s = Sanitizer{Rewrite: func(string) string { return "" }}
+	if got := s.HTML(`<a href="https://example.com">Link</a>`); got != "Link" {
+		t.Errorf("removed link: HTML = %q, want %q", got, "Link")
+	}
+}
+
+func TestSanitizeText(t *testing.T) {
+	var s Sanitizer
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"paragraphs", `<p>One</p><p>Two</p>`, "One\n\nTwo"},
+		{"whitespace", "<p>  Lots \n of\tspace  </p>", "Lots of space"},
+		{"line breaks", `One<br>Two`, "One\nTwo"},
+		{"entities", `<p>1 &lt; 2 &amp;&amp; <b>x</b> &gt; 0</p>`, "1 < 2 && x > 0"},
+		{"no markup", `<p>*not* _emphasis_ [x](y) # h</p>`, "*not* _emphasis_ [x](y) # h"},
+		{"links", `<a href="https://example.com/a">Page</a> and <a href="https://example.com/b">https://example.com/b</a>`, "Page (https://example.com/a) and https://example.com/b"},
+		{"lists", `<ul><li>A</li><li>B<ol><li>C</li></ol></li></ul>`, "- A\n- B\n  1. C"},
+		{"table", `<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>`, "A | B\n1 | 2"},
+		{"dropped", `<p>Hi<script>alert("x")</script></p>`, "Hi"},
+		{"plain text", "One\r\nTwo & 3 < 4", "One\nTwo & 3 < 4"},
+	}
+	for _, test := range tests {
+		if got := s.Text(test.src); got != test.want {
+			t.Errorf("%s: Text(%q) = %q, want %q", test.name, test.src, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeMarkdown(t *testing.T) {
+	var s Sanitizer
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"emphasis", `<p><b>Bold</b>, <i>italic</i> and <s>struck</s></p>`, "**Bold**, *italic* and ~~struck~~"},
+		{"escaped", `<p>*not* _emphasis_ [x](y) # h | \ ` + "`x`" + `</p>`, `\*not\* \_emphasis\_ \[x\](y) \# h \| \\ ` + "\\`x\\`"},
+		{"escaped tags", `<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>`, `\<script\>alert(1)\</script\>`},
+		{"escaped entities", `<p>&amp;lt; &amp;copy;</p>`, `\&lt; \&copy;`},
+		{"heading", `<h2>Task</h2><p>Text</p>`, "### Task\n\nText"},
+		{"link", `<a href="https://example.com/a (1).pdf">The [notes]</a>`, `[The \[notes\]](https://example.com/a%20%281%29.pdf)`},
+		{"image", `<img src="/files/daymap/1" alt="A *map*">`, `![A \*map\*](/files/daymap/1)`},
+		{"code", "<code>a*b</code> and <pre>x ``` y</pre>", "`a*b` and\n\n````\nx ``` y\n````"},
+		{"line break", `One<br>Two`, "One\\\nTwo"},
+		{"quote", `<blockquote><p>One</p><p>Two</p></blockquote>`, "> One\n>\n> Two"},
+		{"table", `<table><tr><th>A</th><th>B</th></tr><tr><td>1</td><td>2</td></tr></table>`, "| A | B |\n| --- | --- |\n| 1 | 2 |"},
+		{"dropped", `<p>Hi<style>p {}</style></p>`, "Hi"},
+	}
+	for _, test := range tests {
+		if got := s.Markdown(test.src); got != test.want {
+			t.Errorf("%s: Markdown(%q) = %q, want %q", test.name, test.src, got, test.want)
+		}
+	}
+}
+
+func TestSanitizeIdempotent(t *testing.T) {
+	s := Sanitizer{Base: "https://gihs.daymap.net/daymap/", RemoteImages: true}
+	for _, src := range []string{
+		`<p onclick="x()">Read <b>this</b><script>alert(1)</script></p><ul><li>One</li></ul>`,
+		`<a href="javascript:alert(1)">x</a><a href="notes.pdf">Notes</a>`,
+		"Plain\ntext & 1 < 2",
+		`<table><tr><td colspan="2">1 &lt; 2</td></tr></table>`,
+	} {
+		once := s.HTML(src)
+		if twice := s.HTML(once); twice != once {
+			t.Errorf("sanitising %q again changed it from %q to %q", src, once, twice)
+		}
+	}
+}
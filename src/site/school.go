@@ -0,0 +1,177 @@
+package site
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"git.sr.ht/~kvo/go-std/errors"
+	"git.sr.ht/~kvo/go-std/slices"
+	"github.com/BurntSushi/toml"
+)
+
+// School is a school whose students may use TaskCollect. A school multiplexes
+// the platforms it uses through the Mux of its embedded Cache.
+type School struct {
+	*Cache
+	Id        string
+	Name      string
+	Timezone  *time.Location
+	Platforms []string
+	// prepended to the usernames of students if missing, and omitted from
+	// their display names
+	prefix string
+}
+
+// A school definition, as written in the school definitions file.
+type schoolConfig struct {
+	Name           string   `toml:"name"`
+	Timezone       string   `toml:"timezone"`
+	UsernamePrefix string   `toml:"username-prefix"`
+	Platforms      []string `toml:"platforms"`
+	// the upstream URLs of each platform, where they differ from the
+	// platform's defaults
+	URLs map[string]map[string]string `toml:"urls"`
+}
+
+// DefaultSchools is the content of the school definitions file used when none
+// exists.
+const DefaultSchools = `# TaskCollect school definitions. Each table defines a school by its ID, which
+# is used in URLs and session data and so should not be changed.
+
+[example]
+name = "Example School"
+timezone = "UTC"
+platforms = ["example"]
+
+[gihs]
+name = "Glenunga International High School"
+timezone = "Australia/Adelaide"
+username-prefix = 'CURRIC\'
+platforms = ["saml", "daymap"]
+
+# Platforms connect to the URLs of the instances used by GIHS unless others are
+# given, such as those of another Daymap school.
+#
+# [gihs.urls.daymap]
+# base = "https://gihs.daymap.net"
+# portal = "https://portal.edpass.sa.edu.au"
+# hrd = "https://hrd.edpass.sa.edu.au"
+# okta = "https://edpass-0927.okta.com"
+# blob = "https://glenunga.blob.core.windows.net/daymap/up"
+#
+# [gihs.urls.saml]
+# base = "https://da.gihs.sa.edu.au"
+
+[uofa]
+name = "University of Adelaide"
+timezone = "Australia/Adelaide"
+platforms = ["myadelaide"]
+`
+
+// Username returns the normalised form of a username given by a student of s.
+func (s *School) Username(username string) string {
+	username = strings.TrimSpace(username)
+	if s.prefix == "" || username == "" {
+		return username
+	}
+	if len(username) >= len(s.prefix) && strings.EqualFold(username[:len(s.prefix)], s.prefix) {
+		username = username[len(s.prefix):]
+	}
+	return s.prefix + username
+}
+
+// DispName returns the name displayed for the student of s with the given
+// normalised username.
+func (s *School) DispName(username string) string {
+	return strings.TrimPrefix(username, s.prefix)
+}
+
+// ParseSchools parses school definitions in TOML, returning the schools keyed
+// by their IDs. Each school's Mux is set up with the registered platforms it
+// uses, configured with the school's URLs for them if given.
+func ParseSchools(data string) (map[string]*School, error) {
+	var configs map[string]schoolConfig
+	_, err := toml.Decode(data, &configs)
+	if err != nil {
+		return nil, errors.New(err, "cannot parse school definitions")
+	}
+	schools := make(map[string]*School)
+	for id, cfg := range configs {
+		if cfg.Name == "" {
+			return nil, errors.New(nil, "school %s has no name", id)
+		}
+		if len(cfg.Platforms) == 0 {
+			return nil, errors.New(nil, "school %s has no platforms", id)
+		}
+		loc, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, errors.New(err, "invalid timezone for school %s", id)
+		}
+		school := &School{
+			Cache:     NewCache(NewMux()),
+			Id:        id,
+			Name:      cfg.Name,
+			Timezone:  loc,
+			Platforms: cfg.Platforms,
+			prefix:    cfg.UsernamePrefix,
+		}
+		for name := range cfg.URLs {
+			if !slices.Has(cfg.Platforms, name) {
+				return nil, errors.New(nil, "school %s has URLs for unused platform %s", id, name)
+			}
+		}
+		for _, name := range cfg.Platforms {
+			p, err := Lookup(name)
+			if err != nil {
+				return nil, errors.New(err, "invalid platform for school %s", id)
+			}
+			if urls, ok := cfg.URLs[name]; ok {
+				c, ok := p.(Configurer)
+				if !ok {
+					return nil, errors.New(nil, "platform %s of school %s has no configurable URLs", name, id)
+				}
+				p, err = c.Configure(urls)
+				if err != nil {
+					return nil, errors.New(err, "invalid %s URLs for school %s", name, id)
+				}
+			}
+			school.Add(p)
+		}
+		schools[id] = school
+	}
+	return schools, nil
+}
+
+// LoadSchools reads the school definitions file at path, which is created with
+// the default definitions if it does not exist.
+func LoadSchools(path string) (map[string]*School, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = os.WriteFile(path, []byte(DefaultSchools), 0644)
+		if err != nil {
+			return nil, errors.New(err, "cannot write default school definitions")
+		}
+		data = []byte(DefaultSchools)
+	} else if err != nil {
+		return nil, errors.New(err, "cannot read school definitions")
+	}
+	schools, err := ParseSchools(string(data))
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return schools, nil
+}
+
+// SortSchools returns the given schools sorted by name.
+func SortSchools(schools map[string]*School) []*School {
+	var sorted []*School
+	for _, school := range schools {
+		sorted = append(sorted, school)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
@@ -0,0 +1,47 @@
+package site
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// stub is a platform with no capabilities, registered under its own name.
+type stub string
+
+func (p stub) Name() string {
+	return string(p)
+}
+
+var registerStubs sync.Once
+
+// registerDefaults registers a stub for each platform used by DefaultSchools.
+func registerDefaults() {
+	registerStubs.Do(func() {
+		for _, name := range []string{"daymap", "example", "myadelaide", "saml"} {
+			Register(stub(name))
+		}
+	})
+}
+
+func TestLoadSchoolsDefault(t *testing.T) {
+	registerDefaults()
+	path := filepath.Join(t.TempDir(), "schools.toml")
+	schools, err := LoadSchools(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"example", "gihs", "uofa"} {
+		if schools[id] == nil {
+			t.Errorf("default school %s is missing", id)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("default definitions were not written: %v", err)
+	}
+	if string(data) != DefaultSchools {
+		t.Errorf("written definitions differ from DefaultSchools")
+	}
+}
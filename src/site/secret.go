@@ -0,0 +1,146 @@
+package site
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	path "path/filepath"
+
+	"git.sr.ht/~kvo/go-std/errors"
+)
+
+// keySize is the size of the server key in bytes, selecting AES-256.
+const keySize = 32
+
+var aead cipher.AEAD
+
+// LoadKey loads the server key used to seal user secrets from the file at the
+// given path. If the file does not exist, a new random key is generated and
+// written to it.
+func LoadKey(keypath string) error {
+	key, err := os.ReadFile(keypath)
+	if os.IsNotExist(err) {
+		key = make([]byte, keySize)
+		_, err = rand.Read(key)
+		if err != nil {
+			return errors.New(err, "cannot generate server key")
+		}
+		err = os.MkdirAll(path.Dir(keypath), os.ModePerm)
+		if err != nil {
+			return errors.New(err, "cannot create server key directory")
+		}
+		err = os.WriteFile(keypath, key, 0600)
+		if err != nil {
+			return errors.New(err, "cannot write server key")
+		}
+	} else if err != nil {
+		return errors.New(err, "cannot read server key")
+	}
+	if len(key) != keySize {
+		return errors.New(nil, "server key must be %d bytes long", keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return errors.New(err, "cannot create server key cipher")
+	}
+	aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return errors.New(err, "cannot create server key cipher")
+	}
+	return nil
+}
+
+// seal encrypts and authenticates plaintext with the server key. The empty
+// string is returned as is.
+func seal(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	if aead == nil {
+		return "", errors.New(nil, "server key not loaded")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", errors.New(err, "cannot generate nonce")
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// unseal reverses seal.
+func unseal(sealed string) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	if aead == nil {
+		return "", errors.New(nil, "server key not loaded")
+	}
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", errors.New(err, "cannot decode sealed secret")
+	}
+	if len(b) < aead.NonceSize() {
+		return "", errors.New(nil, "sealed secret is too short")
+	}
+	nonce, ciphertext := b[:aead.NonceSize()], b[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New(err, "cannot open sealed secret")
+	}
+	return string(plaintext), nil
+}
+
+// transform returns a copy of user whose password, platform tokens, HOTP keys
+// and webhook secrets have been passed through f. The maps and slices in the
+// copy are not shared with user.
+func (user User) transform(f func(string) (string, error)) (User, error) {
+	var err error
+	user.Password, err = f(user.Password)
+	if err != nil {
+		return User{}, errors.New(err, "cannot transform password")
+	}
+	tokens := make(map[string]string, len(user.SiteTokens))
+	for platform, token := range user.SiteTokens {
+		tokens[platform], err = f(token)
+		if err != nil {
+			return User{}, errors.New(err, "cannot transform %s token", platform)
+		}
+	}
+	user.SiteTokens = tokens
+	config := make(map[string]UserConfig, len(user.Config))
+	for platform, cfg := range user.Config {
+		cfg.HotpKey, err = f(cfg.HotpKey)
+		if err != nil {
+			return User{}, errors.New(err, "cannot transform %s HOTP key", platform)
+		}
+		config[platform] = cfg
+	}
+	user.Config = config
+	webhooks := make([]WebhookConfig, len(user.Notify.Webhooks))
+	for i, hook := range user.Notify.Webhooks {
+		hook.Secret, err = f(hook.Secret)
+		if err != nil {
+			return User{}, errors.New(err, "cannot transform webhook secret")
+		}
+		webhooks[i] = hook
+	}
+	user.Notify.Webhooks = webhooks
+	return user, nil
+}
+
+// Seal returns a copy of user whose password, platform tokens, HOTP keys and
+// webhook secrets are sealed with the server key. Users are kept sealed for as
+// long as they are held by the server, and are only unsealed by Mux immediately
+// before a platform function is called.
+func (user User) Seal() (User, error) {
+	return user.transform(seal)
+}
+
+// Unseal returns a copy of the sealed user with all of its secrets in
+// plaintext.
+func (user User) Unseal() (User, error) {
+	return user.transform(unseal)
+}
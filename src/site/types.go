@@ -2,6 +2,7 @@ package site
 
 import (
 	"image/color"
+	"io"
 	"net/mail"
 	"time"
 )
@@ -20,11 +21,13 @@ type Class struct {
 	Id       string
 }
 
-// Event represents a calendar event.
+// Event represents a calendar event. All-day events start at midnight on their
+// first day and end at midnight after their last day.
 type Event struct {
 	Name     string
 	Start    time.Time
 	End      time.Time
+	AllDay   bool
 	Location string
 	Category string
 	Color    color.Color
@@ -40,36 +43,58 @@ type Grade struct {
 
 // Lesson represents a lesson.
 type Lesson struct {
-	Start   time.Time
-	End     time.Time
-	Class   string
-	Room    string
-	Teacher string
-	Notice  string
+	Start    time.Time
+	End      time.Time
+	Class    string
+	Room     string
+	Teacher  string
+	Notice   string
+	Platform string
 }
 
 // Message represents a parsed email-like message of a proprietary format.
 type Message struct {
-	From    mail.Address
-	To      []mail.Address
-	Cc      []mail.Address
-	Sent    time.Time
-	Subject string
-	Body    string
+	From     mail.Address
+	To       []mail.Address
+	Cc       []mail.Address
+	Sent     time.Time
+	Subject  string
+	Body     string
+	Read     bool
+	Platform string
+	Id       string
 }
 
 // Report represents a report card.
 type Report struct {
+	Name     string
 	Grades   []Grade
 	Released time.Time
+	Platform string
+	Id       string
+}
+
+// File represents an attachment, such as a resource link or a work submission,
+// streamed from a platform.
+type File struct {
+	Name     string
+	MimeType string
+	// the length of Body in bytes, or -1 if unknown
+	Size int64
+	// whether the platform accepts Range requests for the file
+	Ranges bool
+	// if Body is only part of the file, its Content-Range
+	Range string
+	Body  io.ReadCloser
 }
 
 // Resource represents an educational resource provided by a teacher for a
 // class.
 type Resource struct {
-	Name     string
-	Class    string
-	Link     string
+	Name  string
+	Class string
+	Link  string
+	// HTML or plain text, sanitised by Mux (see Sanitizer)
 	Desc     string
 	Posted   time.Time
 	ResLinks [][2]string
@@ -79,9 +104,10 @@ type Resource struct {
 
 // Task represents a task assigned to the user.
 type Task struct {
-	Name      string
-	Class     string
-	Link      string
+	Name  string
+	Class string
+	Link  string
+	// HTML or plain text, sanitised by Mux (see Sanitizer)
 	Desc      string
 	Due       time.Time
 	Posted    time.Time
@@ -113,6 +139,7 @@ type User struct {
 	Password   string
 	SiteTokens map[string]string
 	Config     map[string]UserConfig
+	Notify     NotifyConfig
 }
 
 // UserConfig represents an individual user's TaskCollect configuration for a
@@ -120,3 +147,31 @@ type User struct {
 type UserConfig struct {
 	HotpKey string `toml:"hotp-key"`
 }
+
+// NotifyConfig represents an individual user's notification preferences, set
+// in the [notify] table of their TaskCollect configuration.
+type NotifyConfig struct {
+	// the address to which notifications are emailed, if not the user's
+	// email address
+	Email    string          `toml:"email"`
+	Webhooks []WebhookConfig `toml:"webhook"`
+	Rules    []NotifyRule    `toml:"rule"`
+}
+
+// WebhookConfig represents an HTTP webhook to which a user's notifications are
+// sent. If Secret is set, it is used to sign each notification.
+type WebhookConfig struct {
+	URL    string `toml:"url"`
+	Secret string `toml:"secret"`
+}
+
+// NotifyRule represents a type of event of which a user wishes to be notified.
+type NotifyRule struct {
+	// the type of event, such as "graded" or "due"
+	Event string `toml:"event"`
+	// for "due" events, the number of hours before an unsubmitted task is
+	// due at which to notify the user
+	Within int `toml:"within"`
+	// the transports by which to notify the user; all if empty
+	Via []string `toml:"via"`
+}